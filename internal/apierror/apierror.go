@@ -0,0 +1,66 @@
+// Package apierror writes the JSON error body shared by every endpoint, so
+// clients can branch on a stable code instead of matching message text.
+package apierror
+
+import (
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes. Codes are part of the API: messages may be reworded, codes
+// may not.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeInvalidURLID       = "invalid_url_id"
+	CodeInvalidShortPath   = "invalid_short_path"
+	CodeInvalidDestination = "invalid_destination"
+	CodeURLNotFound        = "url_not_found"
+	CodeURLNotActive       = "url_not_active"
+	CodeURLExpired         = "url_expired"
+	CodeURLRemoved         = "url_removed"
+	CodeShortPathTaken     = "short_path_taken"
+	CodeDestinationTaken   = "destination_taken"
+	CodeRegionBlocked      = "region_blocked"
+	CodeRedirectLoop       = "redirect_loop"
+	CodePasswordRequired   = "password_required"
+	CodeIncorrectPassword  = "incorrect_password"
+	CodeUnauthorized       = "unauthorized"
+	CodeAdminDisabled      = "admin_disabled"
+	CodeBodyTooLarge       = "body_too_large"
+	CodeRateLimited        = "rate_limited"
+	CodeOverloaded         = "overloaded"
+	CodeDatabaseTimeout    = "database_timeout"
+	CodeInternal           = "internal_error"
+)
+
+// Error describes what went wrong with a request
+type Error struct {
+	Code      string            `json:"code" example:"url_not_found"`
+	Message   string            `json:"message" example:"URL not found"`
+	RequestID string            `json:"request_id,omitempty" example:"5f0c6a3e-8f8e-4a57-9d0c-3f1b2d8e7a10"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Response is the body of every error response
+type Response struct {
+	Error Error `json:"error"`
+}
+
+// Write responds with status and err, filling in the request ID assigned by
+// the logging middleware
+func Write(c *gin.Context, status int, err Error) {
+	c.JSON(status, body(c, err))
+}
+
+// Abort is Write for middleware: it also stops the handler chain
+func Abort(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, body(c, Error{Code: code, Message: message}))
+}
+
+func body(c *gin.Context, err Error) Response {
+	if err.RequestID == "" {
+		err.RequestID = telemetry.RequestIDFromContext(c.Request.Context())
+	}
+	return Response{Error: err}
+}
@@ -0,0 +1,69 @@
+package apierror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(telemetry.ContextWithRequestID(c.Request.Context(), "req-123"))
+	})
+	router.GET("/missing", func(c *gin.Context) {
+		Write(c, http.StatusNotFound, Error{Code: CodeURLNotFound, Message: "URL not found"})
+	})
+	router.GET("/taken", func(c *gin.Context) {
+		Write(c, http.StatusConflict, Error{
+			Code:    CodeDestinationTaken,
+			Message: "a short link to this destination already exists",
+			Details: map[string]string{"short_path": "abc"},
+		})
+	})
+
+	t.Run("IncludesRequestID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/missing", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":{"code":"url_not_found","message":"URL not found","request_id":"req-123"}}`, w.Body.String())
+	})
+
+	t.Run("IncludesDetails", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/taken", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.JSONEq(t, `{"error":{"code":"destination_taken","message":"a short link to this destination already exists","request_id":"req-123","details":{"short_path":"abc"}}}`, w.Body.String())
+	})
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	reached := false
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		Abort(c, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded, retry later")
+	})
+	router.GET("/", func(c *gin.Context) { reached = true })
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.False(t, reached)
+	// Without the logging middleware there is no request ID to report
+	assert.JSONEq(t, `{"error":{"code":"rate_limited","message":"rate limit exceeded, retry later"}}`, w.Body.String())
+}
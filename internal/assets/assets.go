@@ -0,0 +1,10 @@
+// Package assets embeds the default QR code logo, so the binary can composite
+// it without the file on disk.
+package assets
+
+import _ "embed"
+
+// Logo is the default QR code logo, a PNG
+//
+//go:embed logo.png
+var Logo []byte
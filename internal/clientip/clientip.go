@@ -0,0 +1,85 @@
+// Package clientip identifies the client behind a request, believing
+// forwarding headers only when they come from a trusted proxy.
+package clientip
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ipv6KeyBits is the prefix IPv6 clients are grouped by for rate limiting.
+// A single subscriber is usually handed a whole /64, so counting addresses
+// one by one would let them rotate past any limit.
+const ipv6KeyBits = 64
+
+// Configure makes router take the client address from X-Forwarded-For or
+// X-Real-IP only when the connection comes from one of proxies (IPs or
+// CIDRs). With no proxies the headers are ignored and the connecting address
+// is the client, since anyone can send them.
+func Configure(router *gin.Engine, proxies []string) error {
+	router.ForwardedByClientIP = true
+	router.RemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+	return router.SetTrustedProxies(proxies)
+}
+
+// IP returns the client address of c in canonical form: IPv4-mapped IPv6
+// addresses as plain IPv4 and without an IPv6 zone, so the same client always
+// yields the same string
+func IP(c *gin.Context) string {
+	ip := c.ClientIP()
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	return addr.Unmap().WithZone("").String()
+}
+
+// Key returns the rate limit key of c's client: the IPv4 address, or the /64
+// network of an IPv6 address
+func Key(c *gin.Context) string {
+	ip := IP(c)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() {
+		return ip
+	}
+	prefix, err := addr.Prefix(ipv6KeyBits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+// Scheme returns the scheme the client used: X-Forwarded-Proto when the
+// request comes from one of proxies (IPs or CIDRs), and otherwise https or
+// http by whether the connection itself is TLS, since anyone can send the
+// header
+func Scheme(c *gin.Context, proxies []string) string {
+	if proto := strings.ToLower(c.GetHeader("X-Forwarded-Proto")); (proto == "https" || proto == "http") && fromProxy(c, proxies) {
+		return proto
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// fromProxy reports whether the connecting peer of c is one of proxies
+func fromProxy(c *gin.Context, proxies []string) bool {
+	peer, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	peer = peer.Unmap()
+	for _, proxy := range proxies {
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			if prefix.Contains(peer) {
+				return true
+			}
+		} else if addr, err := netip.ParseAddr(proxy); err == nil && addr.Unmap() == peer {
+			return true
+		}
+	}
+	return false
+}
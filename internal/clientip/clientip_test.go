@@ -0,0 +1,117 @@
+package clientip
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	lookup := func(t *testing.T, proxies []string, remoteAddr string, headers map[string]string) (string, string) {
+		router := gin.New()
+		require.NoError(t, Configure(router, proxies))
+
+		var ip, key string
+		router.GET("/", func(c *gin.Context) {
+			ip, key = IP(c), Key(c)
+		})
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		return ip, key
+	}
+
+	t.Run("IgnoresHeadersWithoutTrustedProxies", func(t *testing.T) {
+		ip, _ := lookup(t, nil, "203.0.113.7:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"})
+		assert.Equal(t, "203.0.113.7", ip)
+	})
+
+	t.Run("HonorsForwardedForFromTrustedProxy", func(t *testing.T) {
+		ip, _ := lookup(t, []string{"10.0.0.0/8"}, "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.5"})
+		assert.Equal(t, "198.51.100.1", ip)
+	})
+
+	t.Run("HonorsRealIPFromTrustedProxy", func(t *testing.T) {
+		ip, _ := lookup(t, []string{"10.1.2.3"}, "10.1.2.3:4000", map[string]string{"X-Real-IP": "198.51.100.2"})
+		assert.Equal(t, "198.51.100.2", ip)
+	})
+
+	t.Run("IgnoresHeadersFromUntrustedPeer", func(t *testing.T) {
+		ip, _ := lookup(t, []string{"10.0.0.0/8"}, "203.0.113.7:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"})
+		assert.Equal(t, "203.0.113.7", ip)
+	})
+
+	t.Run("IPv4Key", func(t *testing.T) {
+		ip, key := lookup(t, nil, "[::ffff:203.0.113.7]:4000", nil)
+		assert.Equal(t, "203.0.113.7", ip)
+		assert.Equal(t, "203.0.113.7", key)
+	})
+
+	t.Run("IPv6KeyIsSlash64", func(t *testing.T) {
+		ip, key := lookup(t, []string{"fd00::/8"}, "[fd00::1]:4000", map[string]string{"X-Forwarded-For": "2001:DB8:0:1:aaaa::1"})
+		assert.Equal(t, "2001:db8:0:1:aaaa::1", ip)
+		assert.Equal(t, "2001:db8:0:1::/64", key)
+
+		_, other := lookup(t, nil, "[2001:db8:0:1:bbbb::2]:4000", nil)
+		assert.Equal(t, key, other)
+	})
+
+	t.Run("InvalidProxy", func(t *testing.T) {
+		assert.Error(t, Configure(gin.New(), []string{"not-an-ip"}))
+	})
+}
+
+func TestScheme(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	scheme := func(proxies []string, remoteAddr, proto string, overTLS bool) string {
+		router := gin.New()
+		var got string
+		router.GET("/", func(c *gin.Context) {
+			got = Scheme(c, proxies)
+		})
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if overTLS {
+			req.TLS = &tls.ConnectionState{}
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	t.Run("ConnectionWithoutProxies", func(t *testing.T) {
+		assert.Equal(t, "http", scheme(nil, "203.0.113.7:4000", "", false))
+		assert.Equal(t, "https", scheme(nil, "203.0.113.7:4000", "", true))
+	})
+
+	t.Run("IgnoresHeaderFromUntrustedPeer", func(t *testing.T) {
+		assert.Equal(t, "http", scheme(nil, "203.0.113.7:4000", "https", false))
+		assert.Equal(t, "http", scheme([]string{"10.0.0.0/8"}, "203.0.113.7:4000", "https", false))
+		assert.Equal(t, "https", scheme([]string{"10.0.0.0/8"}, "203.0.113.7:4000", "http", true))
+	})
+
+	t.Run("HonorsHeaderFromTrustedProxy", func(t *testing.T) {
+		assert.Equal(t, "https", scheme([]string{"10.0.0.0/8"}, "10.1.2.3:4000", "https", false))
+		assert.Equal(t, "https", scheme([]string{"10.1.2.3"}, "[::ffff:10.1.2.3]:4000", "HTTPS", false))
+		assert.Equal(t, "http", scheme([]string{"10.0.0.0/8"}, "10.1.2.3:4000", "http", true))
+	})
+
+	t.Run("IgnoresOtherValues", func(t *testing.T) {
+		assert.Equal(t, "http", scheme([]string{"10.0.0.0/8"}, "10.1.2.3:4000", "javascript", false))
+	})
+}
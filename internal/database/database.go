@@ -1,33 +1,285 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"time"
+
+	"url_shortener/internal/config"
 
 	_ "github.com/lib/pq"
 )
 
 type DB struct {
 	*sql.DB
+
+	// replica serves read-only queries when a read replica is configured
+	replica *sql.DB
+
+	// shortPathLength and shortPathAlphabet configure generated short paths;
+	// zero values fall back to the package defaults
+	shortPathLength   int
+	shortPathAlphabet string
+
+	// pathGenerator produces the paths of links created without one; nil
+	// means random paths of shortPathLength from shortPathAlphabet
+	pathGenerator ShortPathGenerator
+
+	// reservedPaths holds the lowercased paths generation must skip, as
+	// sequential strategies would otherwise reach words such as "api"
+	reservedPaths map[string]bool
+
+	// queryTimeout bounds each operation so a stalled database cannot hold
+	// requests forever; zero means no limit
+	queryTimeout time.Duration
+}
+
+// NewWithReplica wraps existing connection pools, sending writes to primary and
+// reads to replica. A nil replica makes reads use the primary.
+func NewWithReplica(primary, replica *sql.DB) *DB {
+	return &DB{DB: primary, replica: replica}
 }
 
-func Init(databaseURL string) (*DB, error) {
-	db, err := sql.Open("postgres", databaseURL)
+// Init connects to the primary (and optional replica) database. With
+// DBAutoMigrate off the schema is assumed to be managed externally and is only
+// checked for presence.
+func Init(cfg *config.Config) (*DB, error) {
+	if err := validateShortPathAlphabet(cfg.ShortPathLength, cfg.ShortPathAlphabet); err != nil {
+		return nil, err
+	}
+	pathGenerator, err := newShortPathGenerator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	configurePool(db, cfg)
 
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	if err := createTables(db); err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+	if err := prepareSchema(db, cfg.DBAutoMigrate); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if cfg.DBAutoMigrate {
+		ctx, cancel := context.WithTimeout(context.Background(), backfillTimeout)
+		n, err := backfillDestinationHashes(ctx, db)
+		cancel()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if n > 0 {
+			log.Printf("Hashed the destinations of %d existing links", n)
+		}
+		if err := indexUniqueDestinations(db, cfg.UniqueDestinations); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if cfg.DBAutoMigrate && cfg.CaseInsensitivePaths {
+		if err := foldShortPaths(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	var replica *sql.DB
+	if cfg.DatabaseReplicaURL != "" {
+		replica, err = sql.Open("postgres", cfg.DatabaseReplicaURL)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open replica database: %w", err)
+		}
+		configurePool(replica, cfg)
+
+		if err := replica.Ping(); err != nil {
+			db.Close()
+			replica.Close()
+			return nil, fmt.Errorf("failed to ping replica database: %w", err)
+		}
+
+		log.Println("Read replica initialized successfully")
 	}
 
 	log.Println("Database initialized successfully")
-	return &DB{db}, nil
+	result := NewWithReplica(db, replica)
+	result.shortPathLength = cfg.ShortPathLength
+	result.shortPathAlphabet = cfg.ShortPathAlphabet
+	result.pathGenerator = pathGenerator
+	result.reservedPaths = reservedPathSet(cfg)
+	result.queryTimeout = cfg.DBQueryTimeout
+	return result, nil
+}
+
+// withQueryTimeout derives the context an operation runs its queries under
+func (db *DB) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// configurePool sizes a connection pool. Without a cap the pool grows with
+// load until Postgres refuses connections.
+func configurePool(db *sql.DB, cfg *config.Config) {
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+}
+
+// reader returns the pool used for read-only queries
+func (db *DB) reader() *sql.DB {
+	if db.replica != nil {
+		return db.replica
+	}
+	return db.DB
+}
+
+// Close closes the primary pool and the replica pool, if any
+func (db *DB) Close() error {
+	if db.replica != nil {
+		if err := db.replica.Close(); err != nil {
+			db.DB.Close()
+			return err
+		}
+	}
+	return db.DB.Close()
+}
+
+// prepareSchema creates or migrates the schema, or with autoMigrate off verifies
+// that the externally managed schema exists without running any DDL
+func prepareSchema(db *sql.DB, autoMigrate bool) error {
+	if autoMigrate {
+		if err := createTables(db); err != nil {
+			return fmt.Errorf("failed to create tables: %w", err)
+		}
+		return nil
+	}
+
+	if err := checkSchema(db); err != nil {
+		return err
+	}
+	log.Println("Auto-migration disabled, using existing schema")
+	return nil
+}
+
+// foldShortPaths prepares the schema for CASE_INSENSITIVE_PATHS: a unique index
+// on the lowercase short path keeps Foo and foo from both existing, and
+// existing paths are lowercased so lowercased lookups find them. It changes
+// nothing when two links already differ only in case.
+func foldShortPaths(db *sql.DB) error {
+	_, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_short_path_lower ON urls (LOWER(short_path))`)
+	if IsUniqueViolation(err) {
+		return fmt.Errorf("some short paths differ only in case; rename them before enabling CASE_INSENSITIVE_PATHS: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to index lowercase short paths: %w", err)
+	}
+
+	result, err := db.Exec(`UPDATE urls SET short_path = LOWER(short_path) WHERE short_path <> LOWER(short_path)`)
+	if err != nil {
+		return fmt.Errorf("failed to lowercase short paths: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("Lowercased %d short paths for CASE_INSENSITIVE_PATHS", n)
+	}
+	return nil
+}
+
+// backfillTimeout bounds the destination hash backfill run at startup
+const backfillTimeout = 5 * time.Minute
+
+// uniqueDestinationIndex allows one live link per normalized destination and
+// owner under UNIQUE_DESTINATIONS. Expiry cannot be part of a partial index,
+// so an expired link keeps its destination until another link claims it.
+const uniqueDestinationIndex = "idx_urls_unique_destination"
+
+const createUniqueDestinationIndex = `CREATE UNIQUE INDEX IF NOT EXISTS ` + uniqueDestinationIndex + `
+	ON urls (destination_hash, COALESCE(owner, '')) WHERE deleted_at IS NULL`
+
+// indexUniqueDestinations adds the unique destination index when
+// UNIQUE_DESTINATIONS is on, or drops it when off. It changes nothing when
+// live links already share a destination.
+func indexUniqueDestinations(db *sql.DB, enabled bool) error {
+	if !enabled {
+		if _, err := db.Exec(`DROP INDEX IF EXISTS ` + uniqueDestinationIndex); err != nil {
+			return fmt.Errorf("failed to drop unique destination index: %w", err)
+		}
+		return nil
+	}
+
+	_, err := db.Exec(createUniqueDestinationIndex)
+	if IsUniqueViolation(err) {
+		return fmt.Errorf("some links of the same owner share a destination; delete or change them before enabling UNIQUE_DESTINATIONS: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to index unique destinations: %w", err)
+	}
+	return nil
+}
+
+// backfillDestinationHashes sets destination_hash where it is missing, one
+// set-based UPDATE per batch, and returns how many links it hashed. The hash
+// is computed in Go because it covers the normalized destination.
+func backfillDestinationHashes(ctx context.Context, db *sql.DB) (int, error) {
+	const batchSize = 500
+
+	total := 0
+	for {
+		rows, err := db.QueryContext(ctx, `SELECT id, destination FROM urls WHERE destination_hash IS NULL AND destination <> '' LIMIT $1`, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to read unhashed destinations: %w", err)
+		}
+		var args []interface{}
+		for rows.Next() {
+			var id, destination string
+			if err := rows.Scan(&id, &destination); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("failed to read unhashed destinations: %w", err)
+			}
+			args = append(args, id, destinationHash(destination))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, fmt.Errorf("failed to read unhashed destinations: %w", err)
+		}
+		if len(args) == 0 {
+			return total, nil
+		}
+
+		// Arguments alternate id, hash, so $2i+1 is an id and $2i+2 its hash
+		cases := make([]string, 0, len(args)/2)
+		ids := make([]string, 0, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			cases = append(cases, fmt.Sprintf("WHEN $%d THEN $%d", i, i+1))
+			ids = append(ids, fmt.Sprintf("$%d", i))
+		}
+		query := `UPDATE urls SET destination_hash = CASE id ` + strings.Join(cases, " ") +
+			` END WHERE id IN (` + strings.Join(ids, ", ") + `)`
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return total, fmt.Errorf("failed to hash destinations: %w", err)
+		}
+		total += len(ids)
+	}
+}
+
+// checkSchema verifies the urls table exists
+func checkSchema(db *sql.DB) error {
+	var one int
+	err := db.QueryRow(`SELECT 1 FROM urls LIMIT 1`).Scan(&one)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("urls table not found; DB_AUTO_MIGRATE is disabled so the schema must be created externally: %w", err)
+	}
+	return nil
 }
 
 func createTables(db *sql.DB) error {
@@ -44,9 +296,49 @@ func createTables(db *sql.DB) error {
 		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 	);
 
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS destination_hash CHAR(64);
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS instant_referrers TEXT;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS blocked_countries TEXT;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS allowed_countries TEXT;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner VARCHAR(255);
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS campaign VARCHAR(255);
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_uses INTEGER;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS use_count INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS activate_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS reserved_until TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS last_check_status INTEGER;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS seq BIGINT UNIQUE;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS show_qr BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS internal_note TEXT;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules JSONB;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_query BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS static_params JSONB;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash TEXT;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS tags TEXT;
+	ALTER TABLE urls ADD COLUMN IF NOT EXISTS hide_social_tags BOOLEAN NOT NULL DEFAULT FALSE;
+
 	CREATE INDEX IF NOT EXISTS idx_urls_short_path ON urls(short_path);
 	CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls(expires_at);
 	CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls(created_at);
+	CREATE INDEX IF NOT EXISTS idx_urls_destination_hash ON urls(destination_hash);
+	CREATE INDEX IF NOT EXISTS idx_urls_last_checked_at ON urls(last_checked_at);
+
+	CREATE TABLE IF NOT EXISTS clicks (
+		id BIGSERIAL PRIMARY KEY,
+		url_id UUID NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	ALTER TABLE clicks ADD COLUMN IF NOT EXISTS referrer TEXT;
+	ALTER TABLE clicks ADD COLUMN IF NOT EXISTS user_agent TEXT;
+
+	CREATE INDEX IF NOT EXISTS idx_clicks_url_id_created_at ON clicks(url_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS short_path_seq (
+		id BIGSERIAL PRIMARY KEY
+	);
 	`
 
 	_, err := db.Exec(query)
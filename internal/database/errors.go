@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrURLNotFound is returned by operations targeting a URL that does not exist
+var ErrURLNotFound = errors.New("URL not found")
+
+// pqUniqueViolation is the Postgres SQLSTATE for unique_violation
+const pqUniqueViolation = "23505"
+
+// pqQueryCanceled is the Postgres SQLSTATE for query_canceled, which lib/pq
+// returns when a query is cancelled through its context
+const pqQueryCanceled = "57014"
+
+// IsUniqueViolation reports whether err (or an error it wraps) is a unique
+// constraint violation from Postgres or SQLite
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqUniqueViolation
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	return false
+}
+
+// IsDestinationTaken reports whether err is a unique violation of the
+// UNIQUE_DESTINATIONS index, rather than of the short path
+func IsDestinationTaken(err error) bool {
+	if !IsUniqueViolation(err) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Constraint == uniqueDestinationIndex
+	}
+
+	// SQLite has no constraint field; the detail of its own error names an
+	// index on an expression as "index '<name>'"
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique &&
+		sqliteErr.Error() == sqliteUniqueFailed+"index '"+uniqueDestinationIndex+"'"
+}
+
+// sqliteUniqueFailed prefixes the detail of a SQLite unique violation
+const sqliteUniqueFailed = "UNIQUE constraint failed: "
+
+// IsTimeout reports whether err (or an error it wraps) means a query ran out
+// of time, either from DB_QUERY_TIMEOUT or the caller's own deadline
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqQueryCanceled
+}
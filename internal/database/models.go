@@ -1,6 +1,11 @@
 package database
 
 import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,8 +20,81 @@ type URL struct {
 	Description *string    `json:"description,omitempty" db:"description" example:"A great website"`
 	ImageURL    *string    `json:"image_url,omitempty" db:"image_url" example:"https://example.com/image.jpg"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at" example:"2024-12-31T23:59:59Z"`
+	ActivateAt  *time.Time `json:"activate_at,omitempty" db:"activate_at" example:"2024-06-01T09:00:00Z"`
+
+	// ReservedUntil is set while the short path is held without a destination
+	ReservedUntil *time.Time `json:"reserved_until,omitempty" db:"reserved_until" example:"2024-01-08T12:00:00Z"`
+
 	CreatedAt   time.Time  `json:"created_at" db:"created_at" example:"2024-01-01T12:00:00Z"`
 	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at" example:"2024-01-01T12:00:00Z"`
+
+	InstantReferrers StringList `json:"instant_referrers,omitempty" db:"instant_referrers" example:"app.example.com"`
+	BlockedCountries StringList `json:"blocked_countries,omitempty" db:"blocked_countries" example:"US"`
+	AllowedCountries StringList `json:"allowed_countries,omitempty" db:"allowed_countries" example:"BR"`
+
+	// Rules send matching visitors somewhere other than Destination
+	Rules RedirectRules `json:"rules,omitempty" db:"rules"`
+
+	// ForwardQuery passes the short link's query string on to the destination;
+	// StaticParams are added to the destination's query on every redirect
+	ForwardQuery bool      `json:"forward_query" db:"forward_query" example:"false"`
+	StaticParams StringMap `json:"static_params,omitempty" db:"static_params"`
+
+	Owner    *string `json:"owner,omitempty" db:"owner" example:"marketing"`
+	Campaign *string `json:"campaign,omitempty" db:"campaign" example:"carnaval-2025"`
+
+	// Tags group links for listing, e.g. by campaign or team
+	Tags StringList `json:"tags,omitempty" db:"tags" example:"carnaval"`
+
+	// InternalNote is for operators only; handlers strip it from responses
+	// to requests without the admin token
+	InternalNote *string `json:"internal_note,omitempty" db:"internal_note" example:"ticket #123"`
+
+	// PasswordHash is the bcrypt hash of the link's password. It is kept in the
+	// JSON form so cached URLs stay protected; handlers strip it from every response.
+	PasswordHash *string `json:"password_hash,omitempty" db:"password_hash" swaggerignore:"true"`
+
+	MaxUses  *int `json:"max_uses,omitempty" db:"max_uses" example:"1"`
+	UseCount int  `json:"use_count" db:"use_count" example:"0"`
+
+	// ShowQR displays a QR code of the destination on the redirect page
+	ShowQR bool `json:"show_qr" db:"show_qr" example:"false"`
+
+	// HideSocialTags leaves the Open Graph and Twitter tags, title and
+	// description off the redirect page, so the link does not unfurl
+	HideSocialTags bool `json:"hide_social_tags" db:"hide_social_tags" example:"false"`
+
+	// LastCheckStatus is the HTTP status the destination answered at the last
+	// health check, 0 if it could not be reached
+	LastCheckStatus *int       `json:"last_check_status,omitempty" db:"last_check_status" example:"200"`
+	LastCheckedAt   *time.Time `json:"last_checked_at,omitempty" db:"last_checked_at" example:"2024-01-01T12:00:00Z"`
+
+	// Seq is the sequence number a sqids-generated short path encodes
+	Seq *int64 `json:"seq,omitempty" db:"seq" example:"42"`
+}
+
+// MarshalJSON writes every timestamp in UTC. Postgres returns them in the
+// session time zone and SQLite as naive UTC, so without this the same URL
+// would serialize with different offsets depending on where it was read.
+func (u URL) MarshalJSON() ([]byte, error) {
+	type plain URL
+	p := plain(u)
+	p.ExpiresAt = InUTC(p.ExpiresAt)
+	p.ActivateAt = InUTC(p.ActivateAt)
+	p.ReservedUntil = InUTC(p.ReservedUntil)
+	p.CreatedAt = p.CreatedAt.UTC()
+	p.UpdatedAt = p.UpdatedAt.UTC()
+	p.LastCheckedAt = InUTC(p.LastCheckedAt)
+	return json.Marshal(p)
+}
+
+// InUTC returns a copy of an optional timestamp converted to UTC
+func InUTC(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
 }
 
 // CreateURLRequest represents the request body for creating a new URL
@@ -27,16 +105,132 @@ type CreateURLRequest struct {
 	Description *string    `json:"description,omitempty" example:"A great website" description:"Description for metadata (optional)"`
 	ImageURL    *string    `json:"image_url,omitempty" example:"https://example.com/image.jpg" description:"Image URL for metadata (optional)"`
 	ExpiresAt   *time.Time `json:"expires_at,omitempty" example:"2024-12-31T23:59:59Z" description:"Expiration date (optional)"`
+	ActivateAt  *time.Time `json:"activate_at,omitempty" example:"2024-06-01T09:00:00Z" description:"Moment the link starts resolving; before it the link 404s (optional)"`
+
+	InstantReferrers []string `json:"instant_referrers,omitempty" example:"app.example.com" description:"Referrer hosts that skip the preview page and redirect instantly (optional)"`
+	BlockedCountries []string `json:"blocked_countries,omitempty" example:"US" description:"ISO 3166-1 alpha-2 countries the link is unavailable in (optional)"`
+	AllowedCountries []string `json:"allowed_countries,omitempty" example:"BR" description:"ISO 3166-1 alpha-2 countries the link is restricted to (optional)"`
+
+	Rules []RedirectRule `json:"rules,omitempty" description:"Conditional destinations, checked in order; the first matching rule wins and destination is the default (optional)"`
+
+	ForwardQuery bool              `json:"forward_query,omitempty" example:"true" description:"Forward the short link's query parameters to the destination (optional)"`
+	StaticParams map[string]string `json:"static_params,omitempty" description:"Query parameters always added to the destination, e.g. utm_source (optional)"`
+
+	Owner    *string `json:"owner,omitempty" example:"marketing" description:"Team or person responsible for the link, recorded on redirect traces (optional)"`
+	Campaign *string `json:"campaign,omitempty" example:"carnaval-2025" description:"Campaign the link belongs to, recorded on redirect traces (optional)"`
+
+	Tags []string `json:"tags,omitempty" example:"carnaval" description:"Labels to group the link by; lowercase letters, digits, '-' and '_' (optional)"`
+
+	InternalNote *string `json:"internal_note,omitempty" example:"ticket #123" description:"Operator note, only returned to requests with the admin token (optional)"`
+
+	Password *string `json:"password,omitempty" example:"s3cret" description:"Password visitors must enter before being redirected; stored hashed and never returned (optional)"`
+
+	MaxUses *int `json:"max_uses,omitempty" example:"1" description:"Number of redirects after which the link expires; 0 or omitted means unlimited (optional)"`
+
+	ShowQR bool `json:"show_qr,omitempty" example:"true" description:"Show a QR code of the destination on the redirect page, when REDIRECT_PAGE_QR is enabled (optional)"`
+
+	HideSocialTags bool `json:"hide_social_tags,omitempty" example:"true" description:"Leave link previews (Open Graph and Twitter tags, title, description) off the redirect page, e.g. for private links (optional)"`
+
+	AutoMetadata bool `json:"auto_metadata,omitempty" example:"true" description:"Fill title, description and image_url left unset from the destination's Open Graph tags; a destination that cannot be fetched is skipped (optional)"`
+}
+
+// ReserveURLRequest represents the request body for holding a short path
+// before its destination is known
+type ReserveURLRequest struct {
+	ShortPath string `json:"short_path" binding:"required" example:"summer-sale" description:"Short path to hold (required)"`
 }
 
 // UpdateURLRequest represents the request body for updating a URL
 type UpdateURLRequest struct {
 	ShortPath   *string     `json:"short_path,omitempty" example:"new-custom-path" description:"New custom short path (optional)"`
 	Destination *string     `json:"destination,omitempty" example:"https://new-example.com" description:"New destination URL (optional)"`
-	Title       *string     `json:"title,omitempty" example:"Updated Title" description:"New title for metadata (optional)"`
-	Description *string     `json:"description,omitempty" example:"Updated description" description:"New description for metadata (optional)"`
-	ImageURL    *string     `json:"image_url,omitempty" example:"https://new-example.com/image.jpg" description:"New image URL for metadata (optional)"`
+	Title       **string    `json:"title,omitempty" example:"Updated Title" description:"New title for metadata (null to remove, omit to keep unchanged)"`
+	Description **string    `json:"description,omitempty" example:"Updated description" description:"New description for metadata (null to remove, omit to keep unchanged)"`
+	ImageURL    **string    `json:"image_url,omitempty" example:"https://new-example.com/image.jpg" description:"New image URL for metadata (null to remove, omit to keep unchanged)"`
 	ExpiresAt   **time.Time `json:"expires_at,omitempty" example:"2024-12-31T23:59:59Z" description:"New expiration date (null to remove expiration, omit to keep unchanged)"`
+	ActivateAt  **time.Time `json:"activate_at,omitempty" example:"2024-06-01T09:00:00Z" description:"New activation date (null to activate immediately, omit to keep unchanged)"`
+
+	InstantReferrers *[]string `json:"instant_referrers,omitempty" example:"app.example.com" description:"New list of referrer hosts that redirect instantly (empty list to clear)"`
+	BlockedCountries *[]string `json:"blocked_countries,omitempty" example:"US" description:"New list of blocked countries (empty list to clear)"`
+	AllowedCountries *[]string `json:"allowed_countries,omitempty" example:"BR" description:"New list of allowed countries (empty list to clear)"`
+
+	Rules *[]RedirectRule `json:"rules,omitempty" description:"New conditional destinations (empty list to clear)"`
+
+	ForwardQuery *bool              `json:"forward_query,omitempty" example:"true" description:"Whether the short link's query parameters are forwarded (optional)"`
+	StaticParams *map[string]string `json:"static_params,omitempty" description:"New query parameters added to the destination (empty object to clear)"`
+
+	Owner    *string `json:"owner,omitempty" example:"marketing" description:"New owner (optional)"`
+	Campaign *string `json:"campaign,omitempty" example:"carnaval-2025" description:"New campaign (optional)"`
+
+	Tags *[]string `json:"tags,omitempty" example:"carnaval" description:"New list of tags (empty list to clear)"`
+
+	InternalNote *string `json:"internal_note,omitempty" example:"ticket #123" description:"New operator note (optional)"`
+
+	Password **string `json:"password,omitempty" example:"s3cret" description:"New link password (null to remove protection, omit to keep unchanged)"`
+
+	ShowQR *bool `json:"show_qr,omitempty" example:"true" description:"Whether the redirect page shows a QR code of the destination (optional)"`
+
+	HideSocialTags *bool `json:"hide_social_tags,omitempty" example:"true" description:"Whether link previews are left off the redirect page (optional)"`
+}
+
+// UnmarshalJSON decodes an update while keeping explicit nulls apart from
+// omitted fields. encoding/json sets a pointer to nil for null, so on its own
+// {"title": null} would look the same as leaving title out.
+func (r *UpdateURLRequest) UnmarshalJSON(data []byte) error {
+	type plain UpdateURLRequest
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	isNull := func(key string) bool {
+		raw, ok := fields[key]
+		return ok && strings.TrimSpace(string(raw)) == "null"
+	}
+
+	if isNull("title") {
+		r.Title = new(*string)
+	}
+	if isNull("description") {
+		r.Description = new(*string)
+	}
+	if isNull("image_url") {
+		r.ImageURL = new(*string)
+	}
+	if isNull("expires_at") {
+		r.ExpiresAt = new(*time.Time)
+	}
+	if isNull("activate_at") {
+		r.ActivateAt = new(*time.Time)
+	}
+	if isNull("password") {
+		r.Password = new(*string)
+	}
+	return nil
+}
+
+// URLStatus filters listings by expiration state
+type URLStatus string
+
+const (
+	StatusAll     URLStatus = "all"
+	StatusActive  URLStatus = "active"
+	StatusExpired URLStatus = "expired"
+)
+
+// ParseURLStatus validates a status filter, treating an empty value as StatusAll
+func ParseURLStatus(s string) (URLStatus, error) {
+	switch status := URLStatus(s); status {
+	case "":
+		return StatusAll, nil
+	case StatusAll, StatusActive, StatusExpired:
+		return status, nil
+	default:
+		return "", fmt.Errorf("invalid status %q: must be active, expired, or all", s)
+	}
 }
 
 // ListURLsResponse represents the response for listing URLs with pagination
@@ -45,4 +239,249 @@ type ListURLsResponse struct {
 	Total int   `json:"total" example:"100" description:"Total number of URLs"`
 	Page  int   `json:"page" example:"1" description:"Current page number"`
 	Limit int   `json:"limit" example:"10" description:"Number of items per page"`
-} 
\ No newline at end of file
+
+	TotalPages int  `json:"total_pages" example:"10" description:"Number of pages at the current limit"`
+	HasNext    bool `json:"has_next" example:"true" description:"Whether a later page exists"`
+	HasPrev    bool `json:"has_prev" example:"false" description:"Whether an earlier page exists"`
+
+	NextCursor string `json:"next_cursor,omitempty" example:"MjAyNC0wMS0wMVQxMjowMDowMFp8NTUwZTg0MDAtZTI5Yi00MWQ0LWE3MTYtNDQ2NjU1NDQwMDAw" description:"Cursor for the next page in cursor mode; absent on the last page"`
+}
+
+// ClickInterval is the bucket width of a click time series
+type ClickInterval string
+
+const (
+	IntervalHour ClickInterval = "hour"
+	IntervalDay  ClickInterval = "day"
+)
+
+// ParseClickInterval validates a time series interval, treating an empty value as IntervalDay
+func ParseClickInterval(s string) (ClickInterval, error) {
+	switch interval := ClickInterval(s); interval {
+	case "":
+		return IntervalDay, nil
+	case IntervalHour, IntervalDay:
+		return interval, nil
+	default:
+		return "", fmt.Errorf("invalid interval %q: must be hour or day", s)
+	}
+}
+
+// Duration returns the width of one bucket
+func (i ClickInterval) Duration() time.Duration {
+	if i == IntervalHour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Truncate returns the start of the UTC bucket containing t
+func (i ClickInterval) Truncate(t time.Time) time.Time {
+	return t.UTC().Truncate(i.Duration())
+}
+
+// ClickBucket is the number of clicks in one interval of a time series
+type ClickBucket struct {
+	Start  time.Time `json:"start" example:"2024-01-01T00:00:00Z" description:"Start of the bucket (UTC)"`
+	Clicks int       `json:"clicks" example:"42" description:"Clicks within the bucket"`
+}
+
+// ClickTimeSeries is a URL's click counts bucketed over a time range. Every
+// bucket in the range is present, including those without clicks.
+type ClickTimeSeries struct {
+	URLID    uuid.UUID     `json:"url_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Interval ClickInterval `json:"interval" example:"day" description:"Bucket width, hour or day"`
+	From     time.Time     `json:"from" example:"2024-01-01T00:00:00Z" description:"Start of the first bucket"`
+	To       time.Time     `json:"to" example:"2024-01-31T00:00:00Z" description:"End of the range (exclusive)"`
+	Total    int           `json:"total" example:"420" description:"Clicks across all buckets"`
+	Buckets  []ClickBucket `json:"buckets"`
+}
+
+// Click is one visit of a URL that reached its destination
+type Click struct {
+	URLID     uuid.UUID
+	At        time.Time
+	Referrer  string
+	UserAgent string
+}
+
+// ClickCount is how many clicks share one referrer or user agent
+type ClickCount struct {
+	Value  string `json:"value" example:"news.example.com"`
+	Clicks int    `json:"clicks" example:"42"`
+}
+
+// ClickStats summarizes a URL's clicks
+type ClickStats struct {
+	URLID         uuid.UUID    `json:"url_id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Total         int          `json:"total" example:"1520" description:"Clicks ever recorded"`
+	Last24h       int          `json:"last_24h" example:"35" description:"Clicks in the last 24 hours"`
+	Last7d        int          `json:"last_7d" example:"240" description:"Clicks in the last 7 days"`
+	Last30d       int          `json:"last_30d" example:"910" description:"Clicks in the last 30 days"`
+	TopReferrers  []ClickCount `json:"top_referrers" description:"Most common referrer hosts, most clicks first"`
+	TopUserAgents []ClickCount `json:"top_user_agents" description:"Most common User-Agent headers, most clicks first"`
+}
+
+// BrokenURL is a URL whose destination failed its last health check
+type BrokenURL struct {
+	ID          uuid.UUID `json:"id" example:"550e8400-e29b-41d4-a716-446655440000"`
+	ShortPath   string    `json:"short_path" example:"abc123"`
+	Destination string    `json:"destination" example:"https://example.com/gone"`
+	Status      int       `json:"status" example:"404" description:"HTTP status of the last check, 0 if the destination could not be reached"`
+	CheckedAt   time.Time `json:"checked_at" example:"2024-01-01T12:00:00Z"`
+	Clicks      int       `json:"clicks" example:"1520" description:"Recorded clicks on the short link"`
+}
+
+// ShortPathCapacity describes how much of the generated short path space is in use
+type ShortPathCapacity struct {
+	Length               int     `json:"length" example:"6" description:"Length of generated short paths"`
+	AlphabetSize         int     `json:"alphabet_size" example:"62" description:"Number of characters generated paths draw from"`
+	KeySpace             float64 `json:"key_space" example:"56800235584" description:"Number of distinct paths at the configured length"`
+	URLCount             int     `json:"url_count" example:"150000" description:"Current number of stored URLs"`
+	CollisionProbability float64 `json:"collision_probability" example:"0.0000026" description:"Chance a newly generated path is already taken"`
+	RecommendedLength    int     `json:"recommended_length" example:"6" description:"Shortest length keeping the collision probability under the target"`
+}
+
+// Cursor marks the last URL of a keyset-paginated page
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// Encode returns the opaque string form of the cursor
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &Cursor{CreatedAt: t, ID: parsedID}, nil
+}
+
+// StringList is a list of strings stored as a JSON array in a text column
+type StringList []string
+
+// Value implements driver.Valuer, storing empty lists as NULL
+func (l StringList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+}
+
+// StringMap is a string-to-string map stored as a JSON object
+type StringMap map[string]string
+
+// Value implements driver.Valuer, storing empty maps as NULL
+func (m StringMap) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(map[string]string(m))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (m *StringMap) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*map[string]string)(m))
+	case string:
+		return json.Unmarshal([]byte(v), (*map[string]string)(m))
+	default:
+		return fmt.Errorf("cannot scan %T into StringMap", src)
+	}
+}
+
+// Device classes a redirect rule can match
+const (
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+)
+
+// RedirectRule sends visitors matching every condition it sets to
+// Destination. A rule must set at least one condition.
+type RedirectRule struct {
+	Device      string   `json:"device,omitempty" example:"ios" description:"Visitor device: mobile, desktop, ios or android"`
+	UserAgent   string   `json:"user_agent,omitempty" example:"Instagram" description:"Case-insensitive substring of the User-Agent header"`
+	Countries   []string `json:"countries,omitempty" example:"PT" description:"ISO 3166-1 alpha-2 countries of the visitor, from the country header"`
+	Languages   []string `json:"languages,omitempty" example:"en" description:"Visitor's preferred language from Accept-Language; en matches en-US too"`
+	Destination string   `json:"destination" example:"https://apps.apple.com/app/id123" description:"Where matching visitors are sent (required)"`
+}
+
+// RedirectRules is a list of redirect rules stored as JSON
+type RedirectRules []RedirectRule
+
+// Value implements driver.Valuer, storing empty lists as NULL
+func (r RedirectRules) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]RedirectRule(r))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (r *RedirectRules) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]RedirectRule)(r))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]RedirectRule)(r))
+	default:
+		return fmt.Errorf("cannot scan %T into RedirectRules", src)
+	}
+}
@@ -3,83 +3,279 @@ package database
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"math"
 	"math/big"
+	neturl "net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const (
+	// charset and minLength are the default alphabet and base length of generated short paths
 	charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	minLength = 6
+
+	// capacityCollisionTarget is the per-generation collision probability above
+	// which a longer path length is recommended
+	capacityCollisionTarget = 0.0001
+
+	// maxGenerateAttempts bounds how often CreateURL generates a new short path
+	// after the previous one turned out to be taken
+	maxGenerateAttempts = 10
 )
 
+// usesRemaining matches URLs whose max_uses limit, if any, is not yet reached
+const usesRemaining = `(max_uses IS NULL OR use_count < max_uses)`
+
+// urlColumns lists the columns selected for a URL, in the order scanURL expects
+const urlColumns = `id, short_path, destination, title, description, image_url, expires_at, activate_at, created_at, updated_at,
+		instant_referrers, blocked_countries, allowed_countries, owner, campaign, max_uses, use_count, reserved_until,
+		last_check_status, last_checked_at, seq, show_qr, internal_note, rules,
+		forward_query, static_params, password_hash, tags, hide_social_tags`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanURL scans a row selected with urlColumns, followed by any extra
+// columns into extra
+func scanURL(row rowScanner, extra ...interface{}) (*URL, error) {
+	var url URL
+	dest := []interface{}{
+		&url.ID,
+		&url.ShortPath,
+		&url.Destination,
+		&url.Title,
+		&url.Description,
+		&url.ImageURL,
+		&url.ExpiresAt,
+		&url.ActivateAt,
+		&url.CreatedAt,
+		&url.UpdatedAt,
+		&url.InstantReferrers,
+		&url.BlockedCountries,
+		&url.AllowedCountries,
+		&url.Owner,
+		&url.Campaign,
+		&url.MaxUses,
+		&url.UseCount,
+		&url.ReservedUntil,
+		&url.LastCheckStatus,
+		&url.LastCheckedAt,
+		&url.Seq,
+		&url.ShowQR,
+		&url.InternalNote,
+		&url.Rules,
+		&url.ForwardQuery,
+		&url.StaticParams,
+		&url.PasswordHash,
+		&url.Tags,
+		&url.HideSocialTags,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &url, nil
+}
+
+// CreateURL stores a new URL. A generated short path is not checked before
+// use: the insert itself claims it, and when another URL already holds it a
+// new path is generated and the insert retried, so concurrent creates cannot
+// race between a check and the insert.
 func (db *DB) CreateURL(ctx context.Context, req CreateURLRequest) (*URL, error) {
-	shortPath := req.ShortPath
-	if shortPath == nil || *shortPath == "" {
-		generatedPath, err := db.generateUniqueShortPath(ctx)
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	passwordHash, err := hashURLPassword(req.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if req.ShortPath != nil && *req.ShortPath != "" {
+		if err := db.releaseLapsedHold(ctx, *req.ShortPath); err != nil {
+			return nil, err
+		}
+		return db.insertURL(ctx, req, *req.ShortPath, nil, passwordHash)
+	}
+
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		shortPath, seq, err := db.generateShortPath(ctx, attempt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate short path: %w", err)
 		}
-		shortPath = &generatedPath
+		if db.reservedPaths[strings.ToLower(shortPath)] {
+			continue
+		}
+
+		url, err := db.insertURL(ctx, req, shortPath, seq, passwordHash)
+		if !IsUniqueViolation(err) || IsDestinationTaken(err) {
+			return url, err
+		}
+	}
+
+	return nil, fmt.Errorf("failed to generate unique short path after %d attempts", maxGenerateAttempts)
+}
+
+// generateShortPath returns a candidate short path for the given attempt,
+// with the sequence number it encodes under the sequential strategies
+func (db *DB) generateShortPath(ctx context.Context, attempt int) (string, *int64, error) {
+	return db.shortPathGenerator().Generate(ctx, db.nextSeq, attempt)
+}
+
+// shortPathGenerator returns the configured generator, random by default
+func (db *DB) shortPathGenerator() ShortPathGenerator {
+	if db.pathGenerator != nil {
+		return db.pathGenerator
 	}
+	return randomGenerator{length: db.pathLength(), alphabet: db.pathAlphabet()}
+}
 
+// insertURL inserts req under shortPath. The unique violation of a taken path
+// is returned wrapped, for CreateURL and callers to detect.
+func (db *DB) insertURL(ctx context.Context, req CreateURLRequest, shortPath string, seq *int64, passwordHash *string) (*URL, error) {
 	// Generate UUID in Go
 	id := uuid.New()
 
 	query := `
-		INSERT INTO urls (id, short_path, destination, title, description, image_url, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, short_path, destination, title, description, image_url, expires_at, created_at, updated_at
+		INSERT INTO urls (id, short_path, destination, title, description, image_url, expires_at, destination_hash, instant_referrers,
+			blocked_countries, allowed_countries, owner, campaign, max_uses, activate_at, seq, show_qr, internal_note, rules,
+			forward_query, static_params, password_hash, tags, hide_social_tags)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
+		RETURNING ` + urlColumns + `
 	`
 
-	var url URL
-	err := db.QueryRowContext(ctx, query,
+	args := []interface{}{
 		id.String(),
-		*shortPath,
+		shortPath,
 		req.Destination,
 		req.Title,
 		req.Description,
 		req.ImageURL,
 		req.ExpiresAt,
-	).Scan(
-		&url.ID,
-		&url.ShortPath,
-		&url.Destination,
-		&url.Title,
-		&url.Description,
-		&url.ImageURL,
-		&url.ExpiresAt,
-		&url.CreatedAt,
-		&url.UpdatedAt,
-	)
+		destinationHash(req.Destination),
+		StringList(req.InstantReferrers),
+		StringList(req.BlockedCountries),
+		StringList(req.AllowedCountries),
+		req.Owner,
+		req.Campaign,
+		maxUses(req.MaxUses),
+		req.ActivateAt,
+		seq,
+		req.ShowQR,
+		req.InternalNote,
+		RedirectRules(req.Rules),
+		req.ForwardQuery,
+		StringMap(req.StaticParams),
+		passwordHash,
+		StringList(req.Tags),
+		req.HideSocialTags,
+	}
+
+	url, err := scanURL(db.QueryRowContext(ctx, query, args...))
+	if IsDestinationTaken(err) {
+		// An expired or used-up link may hold the destination; it gives way
+		if retired, rerr := db.retireDeadHolders(ctx, req.Destination, ownerKey(req.Owner), uuid.Nil); rerr != nil {
+			return nil, rerr
+		} else if retired {
+			url, err = scanURL(db.QueryRowContext(ctx, query, args...))
+		}
+	}
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create URL: %w", err)
 	}
 
-	return &url, nil
+	return url, nil
+}
+
+// retireDeadHolders soft-deletes the expired and used-up links of owner that
+// hold destination under UNIQUE_DESTINATIONS, other than except, so that the
+// destination lookup, which skips them, and the unique index agree on whether
+// the destination is free. It reports whether any link was retired.
+func (db *DB) retireDeadHolders(ctx context.Context, destination, owner string, except uuid.UUID) (bool, error) {
+	query := `
+		UPDATE urls SET deleted_at = $1
+		WHERE destination_hash = $2 AND COALESCE(owner, '') = $3 AND id <> $4 AND deleted_at IS NULL
+			AND ((expires_at IS NOT NULL AND expires_at <= $1) OR NOT ` + usesRemaining + `)
+	`
+	result, err := db.ExecContext(ctx, query, time.Now(), destinationHash(destination), owner, except)
+	if err != nil {
+		return false, fmt.Errorf("failed to retire links holding the destination: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// retireDeadHoldersFor retires the dead holders of the destination link id
+// has once req is applied, for updates and restores that hit the index
+func (db *DB) retireDeadHoldersFor(ctx context.Context, id uuid.UUID, req UpdateURLRequest) (bool, error) {
+	var destination, owner string
+	err := db.QueryRowContext(ctx, `SELECT destination, COALESCE(owner, '') FROM urls WHERE id = $1`, id).Scan(&destination, &owner)
+	if err != nil {
+		return false, fmt.Errorf("failed to get URL: %w", err)
+	}
+	if req.Destination != nil {
+		destination = *req.Destination
+	}
+	if req.Owner != nil {
+		owner = *req.Owner
+	}
+	return db.retireDeadHolders(ctx, destination, owner, id)
+}
+
+// ReserveShortPath holds a short path without a destination until the given
+// time. The hold is activated by setting a destination with UpdateURL; once it
+// lapses the path no longer resolves and can be claimed again.
+func (db *DB) ReserveShortPath(ctx context.Context, shortPath string, until time.Time) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := db.releaseLapsedHold(ctx, shortPath); err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO urls (id, short_path, destination, reserved_until)
+		VALUES ($1, $2, '', $3)
+		RETURNING ` + urlColumns
+
+	url, err := scanURL(db.QueryRowContext(ctx, query, uuid.New().String(), shortPath, until))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve short path: %w", err)
+	}
+
+	return url, nil
+}
+
+// releaseLapsedHold deletes a lapsed reservation of the short path, if any, so
+// the path can be claimed again
+func (db *DB) releaseLapsedHold(ctx context.Context, shortPath string) error {
+	query := `DELETE FROM urls WHERE short_path = $1 AND reserved_until IS NOT NULL AND reserved_until <= $2`
+	if _, err := db.ExecContext(ctx, query, shortPath, time.Now()); err != nil {
+		return fmt.Errorf("failed to release lapsed reservation: %w", err)
+	}
+	return nil
 }
 
 func (db *DB) GetURLByID(ctx context.Context, id uuid.UUID) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT id, short_path, destination, title, description, image_url, expires_at, created_at, updated_at
-		FROM urls WHERE id = $1
+		SELECT ` + urlColumns + `
+		FROM urls WHERE id = $1 AND deleted_at IS NULL
 	`
 
-	var url URL
-	err := db.QueryRowContext(ctx, query, id).Scan(
-		&url.ID,
-		&url.ShortPath,
-		&url.Destination,
-		&url.Title,
-		&url.Description,
-		&url.ImageURL,
-		&url.ExpiresAt,
-		&url.CreatedAt,
-		&url.UpdatedAt,
-	)
+	url, err := scanURL(db.reader().QueryRowContext(ctx, query, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -88,27 +284,20 @@ func (db *DB) GetURLByID(ctx context.Context, id uuid.UUID) (*URL, error) {
 		return nil, fmt.Errorf("failed to get URL: %w", err)
 	}
 
-	return &url, nil
+	return url, nil
 }
 
 func (db *DB) GetURLByShortPath(ctx context.Context, shortPath string) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT id, short_path, destination, title, description, image_url, expires_at, created_at, updated_at
-		FROM urls WHERE short_path = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		SELECT ` + urlColumns + `
+		FROM urls WHERE short_path = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) AND ` + usesRemaining + `
+			AND (activate_at IS NULL OR activate_at <= NOW()) AND (reserved_until IS NULL OR reserved_until > NOW())
 	`
 
-	var url URL
-	err := db.QueryRowContext(ctx, query, shortPath).Scan(
-		&url.ID,
-		&url.ShortPath,
-		&url.Destination,
-		&url.Title,
-		&url.Description,
-		&url.ImageURL,
-		&url.ExpiresAt,
-		&url.CreatedAt,
-		&url.UpdatedAt,
-	)
+	url, err := scanURL(db.reader().QueryRowContext(ctx, query, shortPath))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -117,29 +306,215 @@ func (db *DB) GetURLByShortPath(ctx context.Context, shortPath string) (*URL, er
 		return nil, fmt.Errorf("failed to get URL by short path: %w", err)
 	}
 
-	return &url, nil
+	return url, nil
 }
 
-func (db *DB) ListURLs(ctx context.Context, page, limit int) (*ListURLsResponse, error) {
-	offset := (page - 1) * limit
+// GetExpiredURLByShortPath returns the URL for a short path only if it has
+// expired or used up its max_uses, so callers can tell an expired link from one
+// that never existed
+func (db *DB) GetExpiredURLByShortPath(ctx context.Context, shortPath string) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls WHERE short_path = $1 AND deleted_at IS NULL
+			AND ((expires_at IS NOT NULL AND expires_at <= $2) OR NOT ` + usesRemaining + `)
+	`
+
+	url, err := scanURL(db.reader().QueryRowContext(ctx, query, shortPath, time.Now()))
 
-	// Get total count
-	var total int
-	countQuery := `SELECT COUNT(*) FROM urls`
-	err := db.QueryRowContext(ctx, countQuery).Scan(&total)
 	if err != nil {
-		return nil, fmt.Errorf("failed to count URLs: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get expired URL by short path: %w", err)
 	}
 
-	// Get URLs
+	return url, nil
+}
+
+// GetURLsByIDs returns the live URLs with the given IDs with a single query,
+// keyed by ID. IDs without a URL are absent from the map.
+func (db *DB) GetURLsByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT id, short_path, destination, title, description, image_url, expires_at, created_at, updated_at
-		FROM urls
-		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2
+		SELECT ` + urlColumns + `
+		FROM urls WHERE id = ANY($1) AND deleted_at IS NULL
 	`
 
-	rows, err := db.QueryContext(ctx, query, limit, offset)
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id.String()
+	}
+	return db.urlsByID(ctx, query, pq.Array(keys))
+}
+
+// urlsByID runs a lookup query and indexes the results by ID
+func (db *DB) urlsByID(ctx context.Context, query string, args ...interface{}) (map[uuid.UUID]*URL, error) {
+	urls, err := db.queryURLs(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[uuid.UUID]*URL, len(urls))
+	for i := range urls {
+		result[urls[i].ID] = &urls[i]
+	}
+	return result, nil
+}
+
+// GetURLsByShortPaths returns the active URLs for the given short paths with a
+// single query, keyed by short path; missing and expired paths are absent
+func (db *DB) GetURLsByShortPaths(ctx context.Context, shortPaths []string) (map[string]*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls WHERE short_path = ANY($1) AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) AND ` + usesRemaining + `
+			AND (activate_at IS NULL OR activate_at <= NOW()) AND reserved_until IS NULL
+	`
+
+	return db.urlsByShortPath(ctx, query, pq.Array(shortPaths))
+}
+
+// urlsByShortPath runs a lookup query and indexes the results by short path
+func (db *DB) urlsByShortPath(ctx context.Context, query string, args ...interface{}) (map[string]*URL, error) {
+	urls, err := db.queryURLs(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*URL, len(urls))
+	for i := range urls {
+		result[urls[i].ShortPath] = &urls[i]
+	}
+	return result, nil
+}
+
+// GetURLByDestination returns the live URL of the same owner pointing at the
+// same normalized destination, if any. Expired and used-up links are skipped.
+// It reads the primary, as a lagging replica would miss a link just created.
+func (db *DB) GetURLByDestination(ctx context.Context, destination string, owner *string) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls WHERE destination_hash = $1 AND COALESCE(owner, '') = $2 AND deleted_at IS NULL
+			AND (expires_at IS NULL OR expires_at > $3) AND ` + usesRemaining + `
+		ORDER BY created_at ASC
+		LIMIT 1
+	`
+
+	url, err := scanURL(db.QueryRowContext(ctx, query, destinationHash(destination), ownerKey(owner), time.Now()))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get URL by destination: %w", err)
+	}
+
+	return url, nil
+}
+
+func (db *DB) ListURLs(ctx context.Context, page, limit int, status URLStatus) (*ListURLsResponse, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	return db.listURLs(ctx, "", "", status, "", page, limit)
+}
+
+// SearchURLs returns URLs whose title, description or destination contain the
+// query (case-insensitive), paginated like ListURLs. An empty query lists all
+// URLs; a non-empty tag keeps only URLs carrying it.
+func (db *DB) SearchURLs(ctx context.Context, query string, page, limit int, status URLStatus, tag string) (*ListURLsResponse, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	return db.listURLs(ctx, "ILIKE", query, status, tag, page, limit)
+}
+
+// ListURLsAfter returns up to limit URLs created before the cursor, newest first,
+// optionally filtered by a search query and tag. Unlike offset pagination it
+// stays fast and stable at any depth; a nil cursor starts from the newest URL.
+func (db *DB) ListURLsAfter(ctx context.Context, query string, after *Cursor, limit int, status URLStatus, tag string) (*ListURLsResponse, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	var createdAt interface{}
+	if after != nil {
+		createdAt = after.CreatedAt
+	}
+	return db.listURLsAfter(ctx, "ILIKE", query, status, tag, after, createdAt, limit)
+}
+
+// urlFilter holds the WHERE conditions and arguments shared by a listing's
+// count and page queries
+type urlFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+// newURLFilter builds the expiration status filter and, when query is set, a
+// search using the given pattern-matching operator (Postgres needs ILIKE while
+// SQLite's LIKE is already case-insensitive). A tag keeps URLs whose tags list
+// holds it. Soft-deleted URLs are always excluded.
+func newURLFilter(likeOp, query string, status URLStatus, tag string) *urlFilter {
+	f := &urlFilter{conditions: []string{"deleted_at IS NULL"}}
+
+	if query != "" {
+		f.args = append(f.args, "%"+escapeLike(query)+"%")
+		f.conditions = append(f.conditions, fmt.Sprintf(
+			`(title %[1]s $%[2]d ESCAPE '\' OR description %[1]s $%[2]d ESCAPE '\' OR destination %[1]s $%[2]d ESCAPE '\')`,
+			likeOp, len(f.args),
+		))
+	}
+
+	// Tags are stored as a JSON array of plain words, so the quoted tag
+	// matches exactly one element
+	if tag != "" {
+		f.args = append(f.args, `%"`+escapeLike(tag)+`"%`)
+		f.conditions = append(f.conditions, fmt.Sprintf(`tags LIKE $%d ESCAPE '\'`, len(f.args)))
+	}
+
+	switch status {
+	case StatusActive:
+		f.args = append(f.args, time.Now())
+		f.conditions = append(f.conditions, fmt.Sprintf("(expires_at IS NULL OR expires_at > $%[1]d) AND (activate_at IS NULL OR activate_at <= $%[1]d) AND %s",
+			len(f.args), usesRemaining))
+	case StatusExpired:
+		f.args = append(f.args, time.Now())
+		f.conditions = append(f.conditions, fmt.Sprintf("((expires_at IS NOT NULL AND expires_at <= $%d) OR NOT %s)", len(f.args), usesRemaining))
+	}
+
+	return f
+}
+
+func (f *urlFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// countURLs returns the number of URLs matching the filter
+func (db *DB) countURLs(ctx context.Context, f *urlFilter) (int, error) {
+	var total int
+	err := db.reader().QueryRowContext(ctx, `SELECT COUNT(*) FROM urls `+f.where(), f.args...).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count URLs: %w", err)
+	}
+	return total, nil
+}
+
+// queryURLs runs a listing query and scans every row
+func (db *DB) queryURLs(ctx context.Context, query string, args ...interface{}) ([]URL, error) {
+	rows, err := db.reader().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list URLs: %w", err)
 	}
@@ -147,33 +522,189 @@ func (db *DB) ListURLs(ctx context.Context, page, limit int) (*ListURLsResponse,
 
 	var urls []URL
 	for rows.Next() {
-		var url URL
-		err := rows.Scan(
-			&url.ID,
-			&url.ShortPath,
-			&url.Destination,
-			&url.Title,
-			&url.Description,
-			&url.ImageURL,
-			&url.ExpiresAt,
-			&url.CreatedAt,
-			&url.UpdatedAt,
-		)
+		url, err := scanURL(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan URL: %w", err)
 		}
-		urls = append(urls, url)
+		urls = append(urls, *url)
+	}
+
+	return urls, nil
+}
+
+// StreamAllURLs calls fn for every URL that is not soft-deleted, oldest
+// first, with the number of clicks recorded for it. Rows are read one at a
+// time so exports of any size run in constant memory. It stops at the first
+// error from fn and returns it. Exports take as long as the table is large, so
+// DB_QUERY_TIMEOUT does not apply.
+func (db *DB) StreamAllURLs(ctx context.Context, fn func(url URL, clicks int) error) error {
+	query := `
+		SELECT ` + urlColumns + `, (SELECT COUNT(*) FROM clicks WHERE clicks.url_id = urls.id)
+		FROM urls WHERE deleted_at IS NULL ORDER BY created_at, id
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to stream URLs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clicks int
+		url, err := scanURL(rows, &clicks)
+		if err != nil {
+			return fmt.Errorf("failed to scan URL: %w", err)
+		}
+		if err := fn(*url, clicks); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to stream URLs: %w", err)
+	}
+	return nil
+}
+
+// listURLs runs an offset-paginated listing
+func (db *DB) listURLs(ctx context.Context, likeOp, query string, status URLStatus, tag string, page, limit int) (*ListURLsResponse, error) {
+	offset := (page - 1) * limit
+	f := newURLFilter(likeOp, query, status, tag)
+
+	total, err := db.countURLs(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	selectQuery := fmt.Sprintf(`
+		SELECT `+urlColumns+`
+		FROM urls %s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, f.where(), len(f.args)+1, len(f.args)+2)
+
+	urls, err := db.queryURLs(ctx, selectQuery, append(f.args, limit, offset)...)
+	if err != nil {
+		return nil, err
 	}
 
+	totalPages := pageCount(total, limit)
 	return &ListURLsResponse{
-		URLs:  urls,
-		Total: total,
-		Page:  page,
-		Limit: limit,
+		URLs:       urls,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+		HasNext:    page < totalPages,
+		HasPrev:    page > 1,
 	}, nil
 }
 
+// pageCount returns the number of pages needed for total items at limit per page
+func pageCount(total, limit int) int {
+	if limit < 1 {
+		return 0
+	}
+	return (total + limit - 1) / limit
+}
+
+// listURLsAfter runs a keyset-paginated listing ordered by (created_at, id).
+// createdAt is the cursor's timestamp in the form the driver compares against
+// the stored column.
+func (db *DB) listURLsAfter(ctx context.Context, likeOp, query string, status URLStatus, tag string, after *Cursor, createdAt interface{}, limit int) (*ListURLsResponse, error) {
+	f := newURLFilter(likeOp, query, status, tag)
+
+	total, err := db.countURLs(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	if after != nil {
+		f.args = append(f.args, createdAt, after.ID.String())
+		f.conditions = append(f.conditions, fmt.Sprintf(
+			"(created_at < $%[1]d OR (created_at = $%[1]d AND id < $%[2]d))",
+			len(f.args)-1, len(f.args),
+		))
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	selectQuery := fmt.Sprintf(`
+		SELECT `+urlColumns+`
+		FROM urls %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d
+	`, f.where(), len(f.args)+1)
+
+	urls, err := db.queryURLs(ctx, selectQuery, append(f.args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListURLsResponse{
+		Total:      total,
+		Limit:      limit,
+		TotalPages: pageCount(total, limit),
+		HasPrev:    after != nil,
+	}
+	if len(urls) > limit {
+		response.HasNext = true
+		urls = urls[:limit]
+		last := urls[len(urls)-1]
+		response.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+	response.URLs = urls
+
+	return response, nil
+}
+
+// normalizeDestination canonicalizes a destination so trivially different
+// spellings of the same URL (host case, default port, trailing slash, fragment)
+// compare equal
+func normalizeDestination(destination string) string {
+	u, err := neturl.Parse(strings.TrimSpace(destination))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(destination)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+// ownerKey returns the owner as compared by UNIQUE_DESTINATIONS, where no
+// owner and an empty one are the same
+func ownerKey(owner *string) string {
+	if owner == nil {
+		return ""
+	}
+	return *owner
+}
+
+// destinationHash returns the hex SHA-256 of the normalized destination
+func destinationHash(destination string) string {
+	sum := sha256.Sum256([]byte(normalizeDestination(destination)))
+	return hex.EncodeToString(sum[:])
+}
+
+// escapeLike escapes LIKE wildcards so the query matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// UpdateURL applies the fields set in req, returning ErrURLNotFound when no
+// live URL has the ID
 func (db *DB) UpdateURL(ctx context.Context, id uuid.UUID, req UpdateURLRequest) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	// Build dynamic query
 	query := `UPDATE urls SET updated_at = NOW()`
 	args := []interface{}{}
@@ -188,7 +719,13 @@ func (db *DB) UpdateURL(ctx context.Context, id uuid.UUID, req UpdateURLRequest)
 		argCount++
 		query += fmt.Sprintf(", destination = $%d", argCount)
 		args = append(args, *req.Destination)
+		argCount++
+		query += fmt.Sprintf(", destination_hash = $%d", argCount)
+		args = append(args, destinationHash(*req.Destination))
+		// Setting a destination activates a reserved short path
+		query += ", reserved_until = NULL"
 	}
+	// A nil *string in the metadata fields is written as NULL
 	if req.Title != nil {
 		argCount++
 		query += fmt.Sprintf(", title = $%d", argCount)
@@ -215,41 +752,157 @@ func (db *DB) UpdateURL(ctx context.Context, id uuid.UUID, req UpdateURLRequest)
 			args = append(args, **req.ExpiresAt)
 		}
 	}
+	if req.ActivateAt != nil {
+		if *req.ActivateAt == nil {
+			query += ", activate_at = NULL"
+		} else {
+			argCount++
+			query += fmt.Sprintf(", activate_at = $%d", argCount)
+			args = append(args, **req.ActivateAt)
+		}
+	}
+	if req.InstantReferrers != nil {
+		argCount++
+		query += fmt.Sprintf(", instant_referrers = $%d", argCount)
+		args = append(args, StringList(*req.InstantReferrers))
+	}
+	if req.BlockedCountries != nil {
+		argCount++
+		query += fmt.Sprintf(", blocked_countries = $%d", argCount)
+		args = append(args, StringList(*req.BlockedCountries))
+	}
+	if req.AllowedCountries != nil {
+		argCount++
+		query += fmt.Sprintf(", allowed_countries = $%d", argCount)
+		args = append(args, StringList(*req.AllowedCountries))
+	}
+	if req.Rules != nil {
+		argCount++
+		query += fmt.Sprintf(", rules = $%d", argCount)
+		args = append(args, RedirectRules(*req.Rules))
+	}
+	if req.ForwardQuery != nil {
+		argCount++
+		query += fmt.Sprintf(", forward_query = $%d", argCount)
+		args = append(args, *req.ForwardQuery)
+	}
+	if req.StaticParams != nil {
+		argCount++
+		query += fmt.Sprintf(", static_params = $%d", argCount)
+		args = append(args, StringMap(*req.StaticParams))
+	}
+	if req.Password != nil {
+		passwordHash, err := hashURLPassword(*req.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		argCount++
+		query += fmt.Sprintf(", password_hash = $%d", argCount)
+		args = append(args, passwordHash)
+	}
+	if req.Owner != nil {
+		argCount++
+		query += fmt.Sprintf(", owner = $%d", argCount)
+		args = append(args, *req.Owner)
+	}
+	if req.Campaign != nil {
+		argCount++
+		query += fmt.Sprintf(", campaign = $%d", argCount)
+		args = append(args, *req.Campaign)
+	}
+	if req.Tags != nil {
+		argCount++
+		query += fmt.Sprintf(", tags = $%d", argCount)
+		args = append(args, StringList(*req.Tags))
+	}
+	if req.ShowQR != nil {
+		argCount++
+		query += fmt.Sprintf(", show_qr = $%d", argCount)
+		args = append(args, *req.ShowQR)
+	}
+	if req.HideSocialTags != nil {
+		argCount++
+		query += fmt.Sprintf(", hide_social_tags = $%d", argCount)
+		args = append(args, *req.HideSocialTags)
+	}
+	if req.InternalNote != nil {
+		argCount++
+		query += fmt.Sprintf(", internal_note = $%d", argCount)
+		args = append(args, *req.InternalNote)
+	}
 
 	argCount++
-	query += fmt.Sprintf(" WHERE id = $%d", argCount)
+	query += fmt.Sprintf(" WHERE id = $%d AND deleted_at IS NULL AND (reserved_until IS NULL OR reserved_until > NOW())", argCount)
 	args = append(args, id)
 
-	query += ` RETURNING id, short_path, destination, title, description, image_url, expires_at, created_at, updated_at`
+	query += ` RETURNING ` + urlColumns
 
-	var url URL
-	err := db.QueryRowContext(ctx, query, args...).Scan(
-		&url.ID,
-		&url.ShortPath,
-		&url.Destination,
-		&url.Title,
-		&url.Description,
-		&url.ImageURL,
-		&url.ExpiresAt,
-		&url.CreatedAt,
-		&url.UpdatedAt,
-	)
+	url, err := scanURL(db.QueryRowContext(ctx, query, args...))
+	if IsDestinationTaken(err) {
+		if retired, rerr := db.retireDeadHoldersFor(ctx, id, req); rerr != nil {
+			return nil, rerr
+		} else if retired {
+			url, err = scanURL(db.QueryRowContext(ctx, query, args...))
+		}
+	}
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, nil
+			return nil, ErrURLNotFound
 		}
 		return nil, fmt.Errorf("failed to update URL: %w", err)
 	}
 
-	return &url, nil
+	return url, nil
+}
+
+// GetDeletedURLByShortPath returns the URL for a short path only if it was
+// soft-deleted after deletedAfter, so recently removed links can be explained
+func (db *DB) GetDeletedURLByShortPath(ctx context.Context, shortPath string, deletedAfter time.Time) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls WHERE short_path = $1 AND deleted_at IS NOT NULL AND deleted_at > $2
+	`
+
+	url, err := scanURL(db.reader().QueryRowContext(ctx, query, shortPath, deletedAfter))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get deleted URL by short path: %w", err)
+	}
+	return url, nil
 }
 
+// DeleteURL soft-deletes a URL by setting deleted_at. The row keeps its short
+// path, so the path cannot be reassigned and the URL can be restored.
 func (db *DB) DeleteURL(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE urls SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL`
+	return db.execByID(ctx, "delete", query, time.Now(), id)
+}
+
+// HardDeleteURL permanently removes a URL, whether or not it was soft-deleted
+func (db *DB) HardDeleteURL(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	query := `DELETE FROM urls WHERE id = $1`
-	result, err := db.ExecContext(ctx, query, id)
+	return db.execByID(ctx, "delete", query, id)
+}
+
+// execByID runs a statement targeting a single URL, returning ErrURLNotFound
+// when no row was affected
+func (db *DB) execByID(ctx context.Context, action, query string, args ...interface{}) error {
+	result, err := db.ExecContext(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to delete URL: %w", err)
+		return fmt.Errorf("failed to %s URL: %w", action, err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -258,47 +911,485 @@ func (db *DB) DeleteURL(ctx context.Context, id uuid.UUID) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("URL not found")
+		return ErrURLNotFound
+	}
+
+	return nil
+}
+
+// ConsumeURLUse atomically counts one use of a URL with a max_uses limit and
+// returns the updated URL, or nil when the limit was already reached. The
+// conditional increment keeps concurrent redirects from overshooting the limit.
+func (db *DB) ConsumeURLUse(ctx context.Context, id uuid.UUID) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE urls SET use_count = use_count + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ` + usesRemaining + `
+		RETURNING ` + urlColumns
+
+	url, err := scanURL(db.QueryRowContext(ctx, query, id))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to count URL use: %w", err)
 	}
 
+	return url, nil
+}
+
+// RecordClick stores one visit of a URL for click analytics
+func (db *DB) RecordClick(ctx context.Context, click Click) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `INSERT INTO clicks (url_id, created_at, referrer, user_agent) VALUES ($1, $2, $3, $4)`,
+		click.URLID, click.At.UTC(), nullString(referrerHost(click.Referrer)), nullString(truncate(click.UserAgent, maxUserAgentLength)))
+	if err != nil {
+		return fmt.Errorf("failed to record click: %w", err)
+	}
 	return nil
 }
 
-func (db *DB) generateUniqueShortPath(ctx context.Context) (string, error) {
-	maxAttempts := 10
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		length := minLength + attempt // Increase length on each attempt
-		shortPath := generateRandomString(length)
-		
-		// Check if it exists
-		exists, err := db.shortPathExists(ctx, shortPath)
+// ClickTimeSeries counts a URL's clicks in [from, to) per interval. from is
+// rounded down to the start of its bucket.
+func (db *DB) ClickTimeSeries(ctx context.Context, urlID uuid.UUID, from, to time.Time, interval ClickInterval) (*ClickTimeSeries, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT date_trunc($1, created_at AT TIME ZONE 'UTC') AS bucket, COUNT(*)
+		FROM clicks WHERE url_id = $2 AND created_at >= $3 AND created_at < $4
+		GROUP BY bucket ORDER BY bucket
+	`
+	from = interval.Truncate(from)
+	return db.clickTimeSeries(ctx, query, urlID, from, to.UTC(), interval, string(interval))
+}
+
+// clickTimeSeries runs a bucketed click count query taking the bucket
+// expression argument first, then url_id, from and to, and fills the buckets
+// without clicks with zero
+func (db *DB) clickTimeSeries(ctx context.Context, query string, urlID uuid.UUID, from, to time.Time, interval ClickInterval, bucketArg interface{}) (*ClickTimeSeries, error) {
+	rows, err := db.reader().QueryContext(ctx, query, bucketArg, urlID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clicks: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[time.Time]int)
+	for rows.Next() {
+		var bucket interface{}
+		var count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan clicks: %w", err)
+		}
+		start, err := parseBucket(bucket)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		
-		if !exists {
-			return shortPath, nil
+		counts[start] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query clicks: %w", err)
+	}
+
+	series := &ClickTimeSeries{URLID: urlID, Interval: interval, From: from, To: to, Buckets: []ClickBucket{}}
+	for start := from; start.Before(to); start = start.Add(interval.Duration()) {
+		series.Buckets = append(series.Buckets, ClickBucket{Start: start, Clicks: counts[start]})
+		series.Total += counts[start]
+	}
+	return series, nil
+}
+
+// parseBucket reads a bucket start returned as a timestamp (Postgres) or as
+// text (SQLite)
+func parseBucket(v interface{}) (time.Time, error) {
+	switch b := v.(type) {
+	case time.Time:
+		return time.Date(b.Year(), b.Month(), b.Day(), b.Hour(), 0, 0, 0, time.UTC), nil
+	case []byte:
+		return parseBucket(string(b))
+	case string:
+		t, err := time.Parse("2006-01-02 15:04:05", b)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse click bucket %q: %w", b, err)
 		}
+		return t, nil
+	default:
+		return time.Time{}, fmt.Errorf("cannot scan %T into click bucket", v)
 	}
-	
-	return "", fmt.Errorf("failed to generate unique short path after %d attempts", maxAttempts)
 }
 
-func (db *DB) shortPathExists(ctx context.Context, shortPath string) (bool, error) {
-	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM urls WHERE short_path = $1)`
-	err := db.QueryRowContext(ctx, query, shortPath).Scan(&exists)
-	return exists, err
+// maxUserAgentLength caps the stored User-Agent; longer ones are truncated
+const maxUserAgentLength = 512
+
+// topClickValues is how many referrers and user agents click stats list
+const topClickValues = 10
+
+// referrerHost reduces a Referer header to its lowercased host. Full referrer
+// URLs can carry private paths and tokens, and hosts aggregate better.
+func referrerHost(referrer string) string {
+	u, err := neturl.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
 }
 
-func generateRandomString(length int) string {
-	result := make([]byte, length)
-	charsetLength := big.NewInt(int64(len(charset)))
+// nullString stores an empty string as NULL
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// ClickStats summarizes a URL's clicks: totals over the standard windows
+// ending at now, and its most common referrers and user agents
+func (db *DB) ClickStats(ctx context.Context, urlID uuid.UUID, now time.Time) (*ClickStats, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	now = now.UTC()
+	stats := &ClickStats{URLID: urlID}
+
+	// Placeholders are numbered in the order they appear, which SQLite relies on
+	query := `
+		SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN created_at >= $1 THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN created_at >= $2 THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN created_at >= $3 THEN 1 ELSE 0 END), 0)
+		FROM clicks WHERE url_id = $4
+	`
+	err := db.reader().QueryRowContext(ctx, query, now.Add(-24*time.Hour), now.Add(-7*24*time.Hour), now.Add(-30*24*time.Hour), urlID).
+		Scan(&stats.Total, &stats.Last24h, &stats.Last7d, &stats.Last30d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count clicks: %w", err)
+	}
+
+	if stats.TopReferrers, err = db.topClickValues(ctx, "referrer", urlID); err != nil {
+		return nil, err
+	}
+	if stats.TopUserAgents, err = db.topClickValues(ctx, "user_agent", urlID); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// topClickValues returns the most frequent non-empty values of a clicks
+// column for a URL. column must be a trusted identifier.
+func (db *DB) topClickValues(ctx context.Context, column string, urlID uuid.UUID) ([]ClickCount, error) {
+	query := `
+		SELECT ` + column + `, COUNT(*) AS clicks
+		FROM clicks WHERE url_id = $1 AND ` + column + ` IS NOT NULL
+		GROUP BY ` + column + `
+		ORDER BY clicks DESC, ` + column + `
+		LIMIT $2
+	`
+	rows, err := db.reader().QueryContext(ctx, query, urlID, topClickValues)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top %s: %w", column, err)
+	}
+	defer rows.Close()
+
+	counts := []ClickCount{}
+	for rows.Next() {
+		var count ClickCount
+		if err := rows.Scan(&count.Value, &count.Clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan top %s: %w", column, err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query top %s: %w", column, err)
+	}
+	return counts, nil
+}
+
+// URLsDueForCheck returns up to limit live URLs whose destination was never
+// checked or last checked before checkedBefore, least recently checked first
+func (db *DB) URLsDueForCheck(ctx context.Context, checkedBefore time.Time, limit int) ([]URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls WHERE deleted_at IS NULL AND reserved_until IS NULL AND (expires_at IS NULL OR expires_at > $1)
+			AND (last_checked_at IS NULL OR last_checked_at < $2)
+		ORDER BY last_checked_at NULLS FIRST, id
+		LIMIT $3
+	`
+	return db.queryURLs(ctx, query, time.Now(), checkedBefore, limit)
+}
+
+// RecordDestinationCheck stores the outcome of a destination health check;
+// status is 0 when the destination could not be reached
+func (db *DB) RecordDestinationCheck(ctx context.Context, id uuid.UUID, status int, at time.Time) error {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `UPDATE urls SET last_check_status = $1, last_checked_at = $2 WHERE id = $3`, status, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to record destination check: %w", err)
+	}
+	return nil
+}
+
+// ListBrokenURLs returns up to limit live URLs whose last destination check
+// failed (unreachable or a 4xx/5xx status), most clicked first so high-traffic
+// breakage surfaces at the top
+func (db *DB) ListBrokenURLs(ctx context.Context, limit int) ([]BrokenURL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT u.id, u.short_path, u.destination, u.last_check_status, u.last_checked_at, COUNT(c.id) AS clicks
+		FROM urls u LEFT JOIN clicks c ON c.url_id = u.id
+		WHERE u.deleted_at IS NULL AND u.last_checked_at IS NOT NULL
+			AND (u.last_check_status = 0 OR u.last_check_status >= 400)
+		GROUP BY u.id, u.short_path, u.destination, u.last_check_status, u.last_checked_at
+		ORDER BY clicks DESC, u.short_path
+		LIMIT $1
+	`
+
+	rows, err := db.reader().QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list broken URLs: %w", err)
+	}
+	defer rows.Close()
+
+	broken := []BrokenURL{}
+	for rows.Next() {
+		var b BrokenURL
+		if err := rows.Scan(&b.ID, &b.ShortPath, &b.Destination, &b.Status, &b.CheckedAt, &b.Clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan broken URL: %w", err)
+		}
+		b.CheckedAt = b.CheckedAt.UTC()
+		broken = append(broken, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list broken URLs: %w", err)
+	}
+
+	return broken, nil
+}
+
+// maxUses stores a zero or negative limit as NULL, meaning unlimited
+func maxUses(limit *int) *int {
+	if limit == nil || *limit <= 0 {
+		return nil
+	}
+	return limit
+}
+
+// RestoreURL clears deleted_at on a soft-deleted URL and returns it, or nil if
+// no soft-deleted URL has the ID
+func (db *DB) RestoreURL(ctx context.Context, id uuid.UUID) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE urls SET deleted_at = NULL, updated_at = $1
+		WHERE id = $2 AND deleted_at IS NOT NULL
+		RETURNING ` + urlColumns
+
+	url, err := scanURL(db.QueryRowContext(ctx, query, time.Now(), id))
+	if IsDestinationTaken(err) {
+		if retired, rerr := db.retireDeadHoldersFor(ctx, id, UpdateURLRequest{}); rerr != nil {
+			return nil, rerr
+		} else if retired {
+			url, err = scanURL(db.QueryRowContext(ctx, query, time.Now(), id))
+		}
+	}
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to restore URL: %w", err)
+	}
+
+	return url, nil
+}
+
+// DeleteExpiredURLs purges expired URLs and lapsed reservations in batches of
+// at most batchSize rows. Each batch runs as its own statement so large cleanups
+// never hold one long-running lock on the table. DB_QUERY_TIMEOUT bounds each
+// batch rather than the purge, which takes as long as there are rows to delete.
+// It waits pause between batches and returns the total number of rows deleted.
+func (db *DB) DeleteExpiredURLs(ctx context.Context, batchSize int, pause time.Duration) (int64, error) {
+	if batchSize < 1 {
+		return 0, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+
+	query := `
+		DELETE FROM urls WHERE id IN (
+			SELECT id FROM urls
+			WHERE (expires_at IS NOT NULL AND expires_at <= $1) OR (reserved_until IS NOT NULL AND reserved_until <= $1)
+			LIMIT $2
+		)
+	`
+
+	var total int64
+	for {
+		batchCtx, cancel := db.withQueryTimeout(ctx)
+		result, err := db.ExecContext(batchCtx, query, time.Now(), batchSize)
+		cancel()
+		if err != nil {
+			return total, fmt.Errorf("failed to delete expired URLs: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		total += rowsAffected
+
+		if rowsAffected < int64(batchSize) {
+			return total, nil
+		}
+
+		// Pause between batches so other queries get a turn at the table
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return total, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// pathLength returns the configured base length of generated short paths
+func (db *DB) pathLength() int {
+	if db.shortPathLength > 0 {
+		return db.shortPathLength
+	}
+	return minLength
+}
+
+// pathAlphabet returns the configured alphabet of generated short paths
+func (db *DB) pathAlphabet() string {
+	if db.shortPathAlphabet != "" {
+		return db.shortPathAlphabet
+	}
+	return charset
+}
+
+// validateShortPathAlphabet rejects generation settings that cannot produce
+// distinct paths; zero values mean the defaults
+func validateShortPathAlphabet(length int, alphabet string) error {
+	if length < 0 {
+		return fmt.Errorf("short path length must be positive")
+	}
+	if alphabet == "" {
+		return nil
+	}
+
+	seen := make(map[rune]bool)
+	for _, char := range alphabet {
+		if seen[char] {
+			return fmt.Errorf("short path alphabet contains duplicate character %q", char)
+		}
+		seen[char] = true
+	}
+	if len(seen) < 2 {
+		return fmt.Errorf("short path alphabet must contain at least 2 characters")
+	}
+	if withoutSeparators(alphabet) == "" {
+		return fmt.Errorf("short path alphabet must contain a character other than '-', '_' and '.'")
+	}
+	return nil
+}
+
+// nextSeq allocates the next short path sequence number. The allocating row
+// is removed right away; the serial counter alone keeps numbers unique.
+func (db *DB) nextSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	if err := db.QueryRowContext(ctx, `INSERT INTO short_path_seq DEFAULT VALUES RETURNING id`).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to allocate sequence number: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM short_path_seq WHERE id = $1`, seq); err != nil {
+		return 0, fmt.Errorf("failed to allocate sequence number: %w", err)
+	}
+	return seq, nil
+}
+
+func generateRandomString(length int, alphabet string) string {
+	chars := []rune(alphabet)
+	result := make([]rune, length)
+	charsetLength := big.NewInt(int64(len(chars)))
 	
 	for i := range result {
 		randomIndex, _ := rand.Int(rand.Reader, charsetLength)
-		result[i] = charset[randomIndex.Int64()]
+		result[i] = chars[randomIndex.Int64()]
 	}
 	
 	return string(result)
-} 
\ No newline at end of file
+}
+
+// CountURLs returns the total number of stored URLs
+func (db *DB) CountURLs(ctx context.Context) (int, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	var count int
+	if err := db.reader().QueryRowContext(ctx, `SELECT COUNT(*) FROM urls`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count URLs: %w", err)
+	}
+	return count, nil
+}
+
+// ShortPathCapacity estimates how full the configured generated path space is
+func (db *DB) ShortPathCapacity(ctx context.Context) (*ShortPathCapacity, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	count, err := db.CountURLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	length, alphabet := db.pathLength(), db.pathAlphabet()
+	if generator, ok := db.shortPathGenerator().(randomGenerator); ok {
+		length, alphabet = generator.length, generator.alphabet
+	}
+
+	capacity := EstimateShortPathCapacity(count, length, len([]rune(alphabet)))
+	return &capacity, nil
+}
+
+// EstimateShortPathCapacity reports the key space for a path length and
+// alphabet size and the chance that a new random path collides with one of
+// count existing URLs
+func EstimateShortPathCapacity(count, length, alphabetSize int) ShortPathCapacity {
+	keySpace := math.Pow(float64(alphabetSize), float64(length))
+
+	recommended := length
+	for float64(count)/math.Pow(float64(alphabetSize), float64(recommended)) > capacityCollisionTarget {
+		recommended++
+	}
+
+	return ShortPathCapacity{
+		Length:               length,
+		AlphabetSize:         alphabetSize,
+		KeySpace:             keySpace,
+		URLCount:             count,
+		CollisionProbability: math.Min(float64(count)/keySpace, 1),
+		RecommendedLength:    recommended,
+	}
+}
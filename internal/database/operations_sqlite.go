@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -11,23 +13,17 @@ import (
 // SQLite-compatible operations for testing
 
 func (db *DB) GetURLByShortPathSQLite(ctx context.Context, shortPath string) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT id, short_path, destination, title, description, image_url, expires_at, created_at, updated_at
-		FROM urls WHERE short_path = ? AND (expires_at IS NULL OR expires_at > datetime('now'))
+		SELECT ` + urlColumns + `
+		FROM urls WHERE short_path = ? AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > datetime('now')) AND ` + usesRemaining + `
+			AND (activate_at IS NULL OR activate_at <= ?) AND (reserved_until IS NULL OR reserved_until > ?)
 	`
 
-	var url URL
-	err := db.QueryRowContext(ctx, query, shortPath).Scan(
-		&url.ID,
-		&url.ShortPath,
-		&url.Destination,
-		&url.Title,
-		&url.Description,
-		&url.ImageURL,
-		&url.ExpiresAt,
-		&url.CreatedAt,
-		&url.UpdatedAt,
-	)
+	now := time.Now()
+	url, err := scanURL(db.reader().QueryRowContext(ctx, query, shortPath, now, now))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -36,10 +32,97 @@ func (db *DB) GetURLByShortPathSQLite(ctx context.Context, shortPath string) (*U
 		return nil, fmt.Errorf("failed to get URL by short path: %w", err)
 	}
 
-	return &url, nil
+	return url, nil
+}
+
+func (db *DB) GetURLsByShortPathsSQLite(ctx context.Context, shortPaths []string) (map[string]*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if len(shortPaths) == 0 {
+		return map[string]*URL{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(shortPaths)), ", ")
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls WHERE short_path IN (` + placeholders + `) AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > ?) AND ` + usesRemaining + `
+			AND (activate_at IS NULL OR activate_at <= ?) AND reserved_until IS NULL
+	`
+
+	args := make([]interface{}, 0, len(shortPaths)+1)
+	for _, shortPath := range shortPaths {
+		args = append(args, shortPath)
+	}
+	now := time.Now()
+	args = append(args, now, now)
+
+	return db.urlsByShortPath(ctx, query, args...)
+}
+
+func (db *DB) GetURLsByIDsSQLite(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	if len(ids) == 0 {
+		return map[uuid.UUID]*URL{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	query := `
+		SELECT ` + urlColumns + `
+		FROM urls WHERE id IN (` + placeholders + `) AND deleted_at IS NULL
+	`
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	return db.urlsByID(ctx, query, args...)
+}
+
+func (db *DB) SearchURLsSQLite(ctx context.Context, query string, page, limit int, status URLStatus, tag string) (*ListURLsResponse, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	return db.listURLs(ctx, "LIKE", query, status, tag, page, limit)
+}
+
+// ListURLsAfterSQLite compares the cursor against SQLite's CURRENT_TIMESTAMP text format
+func (db *DB) ListURLsAfterSQLite(ctx context.Context, query string, after *Cursor, limit int, status URLStatus, tag string) (*ListURLsResponse, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	var createdAt interface{}
+	if after != nil {
+		createdAt = after.CreatedAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	return db.listURLsAfter(ctx, "LIKE", query, status, tag, after, createdAt, limit)
+}
+
+// ClickTimeSeriesSQLite buckets clicks with strftime instead of date_trunc
+func (db *DB) ClickTimeSeriesSQLite(ctx context.Context, urlID uuid.UUID, from, to time.Time, interval ClickInterval) (*ClickTimeSeries, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT strftime(?, created_at) AS bucket, COUNT(*)
+		FROM clicks WHERE url_id = ? AND created_at >= ? AND created_at < ?
+		GROUP BY bucket ORDER BY bucket
+	`
+	format := "%Y-%m-%d 00:00:00"
+	if interval == IntervalHour {
+		format = "%Y-%m-%d %H:00:00"
+	}
+	from = interval.Truncate(from)
+	return db.clickTimeSeries(ctx, query, urlID, from, to.UTC(), interval, format)
 }
 
 func (db *DB) UpdateURLSQLite(ctx context.Context, id uuid.UUID, req UpdateURLRequest) (*URL, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
 	// Build dynamic query for SQLite
 	query := `UPDATE urls SET updated_at = datetime('now')`
 	args := []interface{}{}
@@ -54,7 +137,12 @@ func (db *DB) UpdateURLSQLite(ctx context.Context, id uuid.UUID, req UpdateURLRe
 		query += fmt.Sprintf(", destination = ?")
 		args = append(args, *req.Destination)
 		argCount++
+		query += ", destination_hash = ?"
+		args = append(args, destinationHash(*req.Destination))
+		argCount++
+		query += ", reserved_until = NULL"
 	}
+	// A nil *string in the metadata fields is written as NULL
 	if req.Title != nil {
 		query += fmt.Sprintf(", title = ?")
 		args = append(args, *req.Title)
@@ -81,16 +169,108 @@ func (db *DB) UpdateURLSQLite(ctx context.Context, id uuid.UUID, req UpdateURLRe
 			argCount++
 		}
 	}
+	if req.ActivateAt != nil {
+		if *req.ActivateAt == nil {
+			query += ", activate_at = NULL"
+		} else {
+			query += ", activate_at = ?"
+			args = append(args, **req.ActivateAt)
+			argCount++
+		}
+	}
+	if req.InstantReferrers != nil {
+		query += ", instant_referrers = ?"
+		args = append(args, StringList(*req.InstantReferrers))
+		argCount++
+	}
+	if req.BlockedCountries != nil {
+		query += ", blocked_countries = ?"
+		args = append(args, StringList(*req.BlockedCountries))
+		argCount++
+	}
+	if req.AllowedCountries != nil {
+		query += ", allowed_countries = ?"
+		args = append(args, StringList(*req.AllowedCountries))
+		argCount++
+	}
+	if req.Rules != nil {
+		query += ", rules = ?"
+		args = append(args, RedirectRules(*req.Rules))
+		argCount++
+	}
+	if req.ForwardQuery != nil {
+		query += ", forward_query = ?"
+		args = append(args, *req.ForwardQuery)
+		argCount++
+	}
+	if req.StaticParams != nil {
+		query += ", static_params = ?"
+		args = append(args, StringMap(*req.StaticParams))
+		argCount++
+	}
+	if req.Password != nil {
+		passwordHash, err := hashURLPassword(*req.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		query += ", password_hash = ?"
+		args = append(args, passwordHash)
+		argCount++
+	}
+	if req.Owner != nil {
+		query += ", owner = ?"
+		args = append(args, *req.Owner)
+		argCount++
+	}
+	if req.Campaign != nil {
+		query += ", campaign = ?"
+		args = append(args, *req.Campaign)
+		argCount++
+	}
+	if req.Tags != nil {
+		query += ", tags = ?"
+		args = append(args, StringList(*req.Tags))
+		argCount++
+	}
+	if req.ShowQR != nil {
+		query += ", show_qr = ?"
+		args = append(args, *req.ShowQR)
+		argCount++
+	}
+	if req.HideSocialTags != nil {
+		query += ", hide_social_tags = ?"
+		args = append(args, *req.HideSocialTags)
+		argCount++
+	}
+	if req.InternalNote != nil {
+		query += ", internal_note = ?"
+		args = append(args, *req.InternalNote)
+		argCount++
+	}
 
-	query += fmt.Sprintf(" WHERE id = ?")
-	args = append(args, id)
+	query += fmt.Sprintf(" WHERE id = ? AND deleted_at IS NULL AND (reserved_until IS NULL OR reserved_until > ?)")
+	args = append(args, id, time.Now())
 
 	// Execute update
-	_, err := db.ExecContext(ctx, query, args...)
+	result, err := db.ExecContext(ctx, query, args...)
+	if IsDestinationTaken(err) {
+		if retired, rerr := db.retireDeadHoldersFor(ctx, id, req); rerr != nil {
+			return nil, rerr
+		} else if retired {
+			result, err = db.ExecContext(ctx, query, args...)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to update URL: %w", err)
 	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return nil, ErrURLNotFound
+	}
 
 	// Get updated URL
-	return db.GetURLByID(ctx, id)
+	url, err := db.GetURLByID(ctx, id)
+	if err == nil && url == nil {
+		return nil, ErrURLNotFound
+	}
+	return url, err
 }
\ No newline at end of file
@@ -2,10 +2,20 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"url_shortener/internal/config"
+	"url_shortener/internal/sqids"
+
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -143,6 +153,225 @@ func TestGetURLByShortPath(t *testing.T) {
 		require.NoError(t, err)
 		assert.Nil(t, url)
 	})
+
+	t.Run("GetExpiredURLByShortPath", func(t *testing.T) {
+		url, err := db.GetExpiredURLByShortPath(ctx, "expired-url")
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, "https://expired.com", url.Destination)
+
+		// Active and missing paths are not reported as expired
+		url, err = db.GetExpiredURLByShortPath(ctx, "active-url")
+		require.NoError(t, err)
+		assert.Nil(t, url)
+
+		url, err = db.GetExpiredURLByShortPath(ctx, "non-existent")
+		require.NoError(t, err)
+		assert.Nil(t, url)
+	})
+}
+
+func TestGetURLsByShortPaths(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	known := "known"
+	expired := "expired"
+	pastTime := time.Now().Add(-1 * time.Hour)
+	_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &known, Destination: "https://known.com"})
+	require.NoError(t, err)
+	_, err = db.CreateURL(ctx, CreateURLRequest{ShortPath: &expired, Destination: "https://expired.com", ExpiresAt: &pastTime})
+	require.NoError(t, err)
+
+	urls, err := db.GetURLsByShortPathsSQLite(ctx, []string{"known", "unknown", "expired"})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "https://known.com", urls["known"].Destination)
+	assert.Nil(t, urls["unknown"])
+	assert.Nil(t, urls["expired"])
+
+	urls, err = db.GetURLsByShortPathsSQLite(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func TestGetURLsByIDs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	live, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://live.com"})
+	require.NoError(t, err)
+	pastTime := time.Now().Add(-time.Hour)
+	expired, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://expired.com", ExpiresAt: &pastTime})
+	require.NoError(t, err)
+	deleted, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://deleted.com"})
+	require.NoError(t, err)
+	require.NoError(t, db.DeleteURL(ctx, deleted.ID))
+
+	urls, err := db.GetURLsByIDsSQLite(ctx, []uuid.UUID{live.ID, expired.ID, deleted.ID, uuid.New()})
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, "https://live.com", urls[live.ID].Destination)
+	// Like GetURLByID, expired links are still returned to their owners
+	assert.Equal(t, "https://expired.com", urls[expired.ID].Destination)
+	assert.Nil(t, urls[deleted.ID])
+
+	urls, err = db.GetURLsByIDsSQLite(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func TestGetURLByDestination(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://Example.com:443/docs/#intro"})
+	require.NoError(t, err)
+
+	t.Run("MatchesNormalizedDestination", func(t *testing.T) {
+		url, err := db.GetURLByDestination(ctx, "https://example.com/docs", nil)
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, created.ID, url.ID)
+	})
+
+	t.Run("DifferentDestination", func(t *testing.T) {
+		url, err := db.GetURLByDestination(ctx, "https://example.com/other", nil)
+		require.NoError(t, err)
+		assert.Nil(t, url)
+	})
+
+	t.Run("FollowsDestinationUpdates", func(t *testing.T) {
+		_, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{Destination: stringPtr("https://example.com/moved")})
+		require.NoError(t, err)
+
+		url, err := db.GetURLByDestination(ctx, "https://example.com/moved/", nil)
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, created.ID, url.ID)
+
+		url, err = db.GetURLByDestination(ctx, "https://example.com/docs", nil)
+		require.NoError(t, err)
+		assert.Nil(t, url)
+	})
+}
+
+func TestUniqueDestinations(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Each connection to :memory: is a separate database, so share one
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	marketing := "marketing"
+
+	// Links created before the hash existed, and a reservation
+	_, err := db.Exec(`INSERT INTO urls (id, short_path, destination) VALUES ($1, 'old', 'https://example.com/legacy/')`, uuid.New().String())
+	require.NoError(t, err)
+	_, err = db.ReserveShortPath(ctx, "held", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = db.ReserveShortPath(ctx, "held-too", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	hashed, err := backfillDestinationHashes(ctx, db.DB)
+	require.NoError(t, err)
+	assert.Equal(t, 1, hashed)
+	require.NoError(t, indexUniqueDestinations(db.DB, true))
+
+	t.Run("BackfillsExistingLinks", func(t *testing.T) {
+		url, err := db.GetURLByDestination(ctx, "https://example.com/legacy", nil)
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, "old", url.ShortPath)
+	})
+
+	t.Run("RejectsDuplicateOfSameOwner", func(t *testing.T) {
+		_, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/legacy"})
+		require.Error(t, err)
+		assert.True(t, IsDestinationTaken(err))
+
+		// Another owner may link the same destination
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/legacy", Owner: &marketing})
+		require.NoError(t, err)
+
+		found, err := db.GetURLByDestination(ctx, "https://example.com/legacy", &marketing)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, url.ID, found.ID)
+	})
+
+	t.Run("ShortPathConflictIsNotDestination", func(t *testing.T) {
+		path := "old"
+		_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com/elsewhere"})
+		require.Error(t, err)
+		assert.True(t, IsUniqueViolation(err))
+		assert.False(t, IsDestinationTaken(err))
+	})
+
+	t.Run("UpdateIntoDuplicate", func(t *testing.T) {
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/first"})
+		require.NoError(t, err)
+
+		_, err = db.UpdateURLSQLite(ctx, url.ID, UpdateURLRequest{Destination: stringPtr("https://example.com/legacy")})
+		require.Error(t, err)
+		assert.True(t, IsDestinationTaken(err))
+	})
+
+	t.Run("SkipsUsedUpLinks", func(t *testing.T) {
+		maxUses := 1
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/once", MaxUses: &maxUses})
+		require.NoError(t, err)
+		_, err = db.ConsumeURLUse(ctx, url.ID)
+		require.NoError(t, err)
+
+		found, err := db.GetURLByDestination(ctx, "https://example.com/once", nil)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+
+		// A new link takes the destination over and the used-up one is retired
+		again, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/once"})
+		require.NoError(t, err)
+		assert.NotEqual(t, url.ID, again.ID)
+
+		retired, err := db.GetURLByID(ctx, url.ID)
+		require.NoError(t, err)
+		assert.Nil(t, retired)
+	})
+
+	t.Run("ExpiredLinkGivesWay", func(t *testing.T) {
+		expired := time.Now().Add(-time.Hour)
+		old, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/gone", ExpiresAt: &expired})
+		require.NoError(t, err)
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/other"})
+		require.NoError(t, err)
+
+		_, err = db.UpdateURLSQLite(ctx, url.ID, UpdateURLRequest{Destination: stringPtr("https://example.com/gone")})
+		require.NoError(t, err)
+
+		retired, err := db.GetURLByID(ctx, old.ID)
+		require.NoError(t, err)
+		assert.Nil(t, retired)
+
+		// Restoring the expired link conflicts with its live successor
+		_, err = db.RestoreURL(ctx, old.ID)
+		assert.True(t, IsDestinationTaken(err))
+	})
+
+	t.Run("DisablingDropsIndex", func(t *testing.T) {
+		require.NoError(t, indexUniqueDestinations(db.DB, false))
+		_, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/legacy"})
+		assert.NoError(t, err)
+
+		// Duplicates now keep it from being enabled again
+		assert.Error(t, indexUniqueDestinations(db.DB, true))
+	})
 }
 
 func TestListURLs(t *testing.T) {
@@ -161,155 +390,1787 @@ func TestListURLs(t *testing.T) {
 	}
 
 	t.Run("ListFirstPage", func(t *testing.T) {
-		response, err := db.ListURLs(ctx, 1, 3)
+		response, err := db.ListURLs(ctx, 1, 3, StatusAll)
 		require.NoError(t, err)
 		assert.Equal(t, 5, response.Total)
 		assert.Equal(t, 1, response.Page)
 		assert.Equal(t, 3, response.Limit)
 		assert.Len(t, response.URLs, 3)
+		assert.Equal(t, 2, response.TotalPages)
+		assert.True(t, response.HasNext)
+		assert.False(t, response.HasPrev)
 	})
 
 	t.Run("ListSecondPage", func(t *testing.T) {
-		response, err := db.ListURLs(ctx, 2, 3)
+		response, err := db.ListURLs(ctx, 2, 3, StatusAll)
 		require.NoError(t, err)
 		assert.Equal(t, 5, response.Total)
 		assert.Equal(t, 2, response.Page)
 		assert.Equal(t, 3, response.Limit)
 		assert.Len(t, response.URLs, 2)
+		assert.Equal(t, 2, response.TotalPages)
+		assert.False(t, response.HasNext, "last page has no next")
+		assert.True(t, response.HasPrev)
+	})
+
+	t.Run("ExactMultiple", func(t *testing.T) {
+		response, err := db.ListURLs(ctx, 1, 5, StatusAll)
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.TotalPages)
+		assert.False(t, response.HasNext)
+		assert.False(t, response.HasPrev)
+	})
+
+	t.Run("NoResults", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "no-such-url", 1, 3, StatusAll, "")
+		require.NoError(t, err)
+		assert.Equal(t, 0, response.Total)
+		assert.Equal(t, 0, response.TotalPages)
+		assert.False(t, response.HasNext)
+		assert.False(t, response.HasPrev)
 	})
 }
 
-func TestUpdateURL(t *testing.T) {
+func TestPageCount(t *testing.T) {
+	assert.Equal(t, 0, pageCount(0, 10))
+	assert.Equal(t, 1, pageCount(1, 10))
+	assert.Equal(t, 1, pageCount(10, 10))
+	assert.Equal(t, 2, pageCount(11, 10))
+	assert.Equal(t, 0, pageCount(5, 0))
+}
+
+func TestListURLsByStatus(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
 	ctx := context.Background()
 
-	// Create a test URL first
-	req := CreateURLRequest{
-		Destination: "https://original.com",
-		Title:       stringPtr("Original Title"),
+	pastTime := time.Now().Add(-1 * time.Hour)
+	futureTime := time.Now().Add(24 * time.Hour)
+	for i := 0; i < 2; i++ {
+		_, err := db.CreateURL(ctx, CreateURLRequest{
+			Destination: "https://expired.com",
+			Title:       stringPtr("Carnival schedule"),
+			ExpiresAt:   &pastTime,
+		})
+		require.NoError(t, err)
 	}
-	createdURL, err := db.CreateURL(ctx, req)
+	_, err := db.CreateURL(ctx, CreateURLRequest{
+		Destination: "https://active.com",
+		Title:       stringPtr("Carnival parade"),
+		ExpiresAt:   &futureTime,
+	})
+	require.NoError(t, err)
+	_, err = db.CreateURL(ctx, CreateURLRequest{
+		Destination: "https://permanent.com",
+	})
 	require.NoError(t, err)
 
-	t.Run("UpdateDestination", func(t *testing.T) {
-		updateReq := UpdateURLRequest{
-			Destination: stringPtr("https://updated.com"),
-		}
-
-		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, updateReq)
+	t.Run("Active", func(t *testing.T) {
+		response, err := db.ListURLs(ctx, 1, 10, StatusActive)
 		require.NoError(t, err)
-		assert.Equal(t, "https://updated.com", updatedURL.Destination)
-		assert.Equal(t, *createdURL.Title, *updatedURL.Title) // Should remain unchanged
+		assert.Equal(t, 2, response.Total)
+		assert.Len(t, response.URLs, 2)
+		for _, u := range response.URLs {
+			assert.NotEqual(t, "https://expired.com", u.Destination)
+		}
 	})
 
-	t.Run("UpdateTitle", func(t *testing.T) {
-		updateReq := UpdateURLRequest{
-			Title: stringPtr("Updated Title"),
+	t.Run("Expired", func(t *testing.T) {
+		response, err := db.ListURLs(ctx, 1, 10, StatusExpired)
+		require.NoError(t, err)
+		assert.Equal(t, 2, response.Total)
+		for _, u := range response.URLs {
+			assert.Equal(t, "https://expired.com", u.Destination)
 		}
+	})
 
-		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, updateReq)
+	t.Run("All", func(t *testing.T) {
+		response, err := db.ListURLs(ctx, 1, 10, StatusAll)
 		require.NoError(t, err)
-		assert.Equal(t, "Updated Title", *updatedURL.Title)
+		assert.Equal(t, 4, response.Total)
 	})
 
-	t.Run("ClearExpiration", func(t *testing.T) {
-		// First set an expiration
-		futureTime := time.Now().Add(24 * time.Hour)
-		futureTimePtr := &futureTime
-		updateReq := UpdateURLRequest{
-			ExpiresAt: &futureTimePtr,
-		}
-		_, err := db.UpdateURLSQLite(ctx, createdURL.ID, updateReq)
+	t.Run("TotalReflectsFilterAcrossPages", func(t *testing.T) {
+		response, err := db.ListURLs(ctx, 1, 1, StatusExpired)
 		require.NoError(t, err)
+		assert.Equal(t, 2, response.Total)
+		assert.Len(t, response.URLs, 1)
+	})
 
-		// Now clear it
-		var nilTime *time.Time
-		clearReq := UpdateURLRequest{
-			ExpiresAt: &nilTime,
-		}
-		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, clearReq)
+	t.Run("CombinedWithSearch", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "carnival", 1, 10, StatusActive, "")
 		require.NoError(t, err)
-		assert.Nil(t, updatedURL.ExpiresAt)
+		assert.Equal(t, 1, response.Total)
+		require.Len(t, response.URLs, 1)
+		assert.Equal(t, "https://active.com", response.URLs[0].Destination)
 	})
+}
 
-	t.Run("UpdateNonExistentURL", func(t *testing.T) {
-		randomID := uuid.New()
-		updateReq := UpdateURLRequest{
-			Destination: stringPtr("https://nonexistent.com"),
-		}
+func TestParseURLStatus(t *testing.T) {
+	status, err := ParseURLStatus("")
+	require.NoError(t, err)
+	assert.Equal(t, StatusAll, status)
 
-		updatedURL, err := db.UpdateURLSQLite(ctx, randomID, updateReq)
-		require.NoError(t, err)
-		assert.Nil(t, updatedURL)
-	})
+	status, err = ParseURLStatus("expired")
+	require.NoError(t, err)
+	assert.Equal(t, StatusExpired, status)
+
+	_, err = ParseURLStatus("stale")
+	assert.Error(t, err)
 }
 
-func TestDeleteURL(t *testing.T) {
+func TestListURLsAfter(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
 	ctx := context.Background()
 
-	t.Run("DeleteExistingURL", func(t *testing.T) {
-		req := CreateURLRequest{
-			Destination: "https://todelete.com",
+	for i := 0; i < 5; i++ {
+		_, err := db.CreateURL(ctx, CreateURLRequest{
+			Destination: "https://example.com/" + string(rune('a'+i)),
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("WalksEveryRowOnce", func(t *testing.T) {
+		seen := make(map[uuid.UUID]bool)
+		var after *Cursor
+		pages := 0
+		for {
+			response, err := db.ListURLsAfterSQLite(ctx, "", after, 2, StatusAll, "")
+			require.NoError(t, err)
+			assert.Equal(t, 5, response.Total)
+			pages++
+
+			for _, u := range response.URLs {
+				assert.False(t, seen[u.ID], "URL returned twice")
+				seen[u.ID] = true
+			}
+
+			if response.NextCursor == "" {
+				break
+			}
+			after, err = DecodeCursor(response.NextCursor)
+			require.NoError(t, err)
 		}
-		createdURL, err := db.CreateURL(ctx, req)
+
+		assert.Len(t, seen, 5)
+		assert.Equal(t, 3, pages)
+	})
+
+	t.Run("StableWhenRowsAreInserted", func(t *testing.T) {
+		first, err := db.ListURLsAfterSQLite(ctx, "", nil, 2, StatusAll, "")
 		require.NoError(t, err)
+		require.NotEmpty(t, first.NextCursor)
+		assert.True(t, first.HasNext)
+		assert.False(t, first.HasPrev)
 
-		err = db.DeleteURL(ctx, createdURL.ID)
+		created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/new"})
+		require.NoError(t, err)
+		// SQLite timestamps have second precision; make the new row strictly newer
+		_, err = db.ExecContext(ctx, `UPDATE urls SET created_at = datetime('now', '+1 minute') WHERE id = ?`, created.ID)
 		require.NoError(t, err)
 
-		// Verify it's deleted
-		url, err := db.GetURLByID(ctx, createdURL.ID)
+		after, err := DecodeCursor(first.NextCursor)
+		require.NoError(t, err)
+		second, err := db.ListURLsAfterSQLite(ctx, "", after, 10, StatusAll, "")
 		require.NoError(t, err)
-		assert.Nil(t, url)
-	})
 
-	t.Run("DeleteNonExistentURL", func(t *testing.T) {
-		randomID := uuid.New()
-		err := db.DeleteURL(ctx, randomID)
-		require.Error(t, err)
-		assert.Contains(t, err.Error(), "URL not found")
+		for _, u := range second.URLs {
+			assert.NotEqual(t, "https://example.com/new", u.Destination)
+			for _, prev := range first.URLs {
+				assert.NotEqual(t, prev.ID, u.ID)
+			}
+		}
+		assert.Empty(t, second.NextCursor)
+		assert.False(t, second.HasNext)
+		assert.True(t, second.HasPrev)
 	})
 }
 
-func TestGenerateUniqueShortPath(t *testing.T) {
+func TestCursorEncoding(t *testing.T) {
+	cursor := Cursor{CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 123456000, time.UTC), ID: uuid.New()}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	require.NoError(t, err)
+	assert.True(t, cursor.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, cursor.ID, decoded.ID)
+
+	_, err = DecodeCursor("not-a-cursor")
+	assert.Error(t, err)
+}
+
+func TestSearchURLs(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
 
 	ctx := context.Background()
 
-	t.Run("GenerateUniqueShortPath", func(t *testing.T) {
-		path1, err := db.generateUniqueShortPath(ctx)
+	fixtures := []CreateURLRequest{
+		{Destination: "https://example.com/a", Title: stringPtr("Carnival Schedule")},
+		{Destination: "https://example.com/b", Description: stringPtr("Everything about the CARNIVAL parade")},
+		{Destination: "https://carnival.rio/tickets"},
+		{Destination: "https://example.com/c", Title: stringPtr("Unrelated"), Description: stringPtr("Nothing to see")},
+		{Destination: "https://example.com/100%-off"},
+	}
+	for _, req := range fixtures {
+		_, err := db.CreateURL(ctx, req)
 		require.NoError(t, err)
-		assert.GreaterOrEqual(t, len(path1), minLength)
+	}
 
-		path2, err := db.generateUniqueShortPath(ctx)
+	t.Run("MatchesTitle", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "schedule", 1, 10, StatusAll, "")
 		require.NoError(t, err)
-		assert.NotEqual(t, path1, path2)
+		assert.Equal(t, 1, response.Total)
+		require.Len(t, response.URLs, 1)
+		assert.Equal(t, "Carnival Schedule", *response.URLs[0].Title)
+	})
+
+	t.Run("MatchesDescription", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "parade", 1, 10, StatusAll, "")
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.Total)
+		require.Len(t, response.URLs, 1)
+		assert.Equal(t, "https://example.com/b", response.URLs[0].Destination)
+	})
+
+	t.Run("MatchesDestination", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "carnival.rio", 1, 10, StatusAll, "")
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.Total)
+		require.Len(t, response.URLs, 1)
+		assert.Equal(t, "https://carnival.rio/tickets", response.URLs[0].Destination)
+	})
+
+	t.Run("CaseInsensitiveAcrossFields", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "CarNiVaL", 1, 2, StatusAll, "")
+		require.NoError(t, err)
+		assert.Equal(t, 3, response.Total)
+		assert.Len(t, response.URLs, 2)
+	})
+
+	t.Run("WildcardsMatchLiterally", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "100%", 1, 10, StatusAll, "")
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.Total)
+
+		response, err = db.SearchURLsSQLite(ctx, "_", 1, 10, StatusAll, "")
+		require.NoError(t, err)
+		assert.Equal(t, 0, response.Total)
+	})
+
+	t.Run("EmptyQueryListsAll", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "", 1, 10, StatusAll, "")
+		require.NoError(t, err)
+		assert.Equal(t, len(fixtures), response.Total)
 	})
 }
 
-func TestGenerateRandomString(t *testing.T) {
-	t.Run("GenerateRandomString", func(t *testing.T) {
-		str1 := generateRandomString(8)
-		str2 := generateRandomString(8)
-		
-		assert.Len(t, str1, 8)
-		assert.Len(t, str2, 8)
-		assert.NotEqual(t, str1, str2)
-		
-		// Check that it only contains valid characters
-		for _, char := range str1 {
-			assert.Contains(t, charset, string(char))
+func TestFilterByTag(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	carnaval, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/a", Title: stringPtr("Parade"), Tags: []string{"carnaval", "events"}})
+	require.NoError(t, err)
+	assert.Equal(t, StringList{"carnaval", "events"}, carnaval.Tags)
+
+	_, err = db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/b", Tags: []string{"car", "health_dept"}})
+	require.NoError(t, err)
+	_, err = db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/c"})
+	require.NoError(t, err)
+
+	destinations := func(response *ListURLsResponse) []string {
+		var found []string
+		for _, url := range response.URLs {
+			found = append(found, url.Destination)
 		}
+		return found
+	}
+
+	t.Run("MatchesWholeTag", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "", 1, 10, StatusAll, "car")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://example.com/b"}, destinations(response))
 	})
-}
+
+	t.Run("UnderscoreIsLiteral", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "", 1, 10, StatusAll, "health-dept")
+		require.NoError(t, err)
+		assert.Equal(t, 0, response.Total)
+	})
+
+	t.Run("CombinesWithSearch", func(t *testing.T) {
+		response, err := db.SearchURLsSQLite(ctx, "parade", 1, 10, StatusAll, "events")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://example.com/a"}, destinations(response))
+
+		response, err = db.SearchURLsSQLite(ctx, "parade", 1, 10, StatusAll, "car")
+		require.NoError(t, err)
+		assert.Equal(t, 0, response.Total)
+	})
+
+	t.Run("CursorPagination", func(t *testing.T) {
+		response, err := db.ListURLsAfterSQLite(ctx, "", nil, 10, StatusAll, "events")
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.Total)
+		assert.Equal(t, []string{"https://example.com/a"}, destinations(response))
+	})
+
+	t.Run("UpdateReplacesTags", func(t *testing.T) {
+		tags := []string{"archive"}
+		updated, err := db.UpdateURLSQLite(ctx, carnaval.ID, UpdateURLRequest{Tags: &tags})
+		require.NoError(t, err)
+		assert.Equal(t, StringList{"archive"}, updated.Tags)
+
+		response, err := db.SearchURLsSQLite(ctx, "", 1, 10, StatusAll, "carnaval")
+		require.NoError(t, err)
+		assert.Equal(t, 0, response.Total)
+
+		cleared := []string{}
+		updated, err = db.UpdateURLSQLite(ctx, carnaval.ID, UpdateURLRequest{Tags: &cleared})
+		require.NoError(t, err)
+		assert.Empty(t, updated.Tags)
+	})
+}
+
+func TestURLJSONTimestamps(t *testing.T) {
+	timestamps := func(t *testing.T, url *URL) map[string]string {
+		body, err := json.Marshal(url)
+		require.NoError(t, err)
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &fields))
+
+		found := make(map[string]string)
+		for _, key := range []string{"created_at", "updated_at", "expires_at", "activate_at"} {
+			if value, ok := fields[key].(string); ok {
+				found[key] = value
+			}
+		}
+		return found
+	}
+
+	t.Run("ConvertsOffsetsToUTC", func(t *testing.T) {
+		rio := time.FixedZone("BRT", -3*60*60)
+		at := time.Date(2024, 1, 1, 9, 0, 0, 0, rio)
+		url := &URL{CreatedAt: at, UpdatedAt: at, ExpiresAt: &at, ActivateAt: &at}
+
+		for key, value := range timestamps(t, url) {
+			assert.Equal(t, "2024-01-01T12:00:00Z", value, key)
+		}
+		// The URL itself keeps its zone
+		assert.Equal(t, rio, url.ExpiresAt.Location())
+	})
+
+	t.Run("SameFormatAfterCreateAndUpdate", func(t *testing.T) {
+		db := setupTestDB(t)
+		defer db.Close()
+		ctx := context.Background()
+
+		expires := time.Now().Add(time.Hour).In(time.FixedZone("BRT", -3*60*60))
+		created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com", ExpiresAt: &expires})
+		require.NoError(t, err)
+		updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{Destination: stringPtr("https://example.com/new")})
+		require.NoError(t, err)
+
+		for _, url := range []*URL{created, updated} {
+			found := timestamps(t, url)
+			assert.Len(t, found, 3)
+			for key, value := range found {
+				assert.True(t, strings.HasSuffix(value, "Z"), "%s = %s", key, value)
+				_, err := time.Parse(time.RFC3339, value)
+				assert.NoError(t, err, key)
+			}
+		}
+	})
+}
+
+func TestUpdateURL(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	// Create a test URL first
+	req := CreateURLRequest{
+		Destination: "https://original.com",
+		Title:       stringPtr("Original Title"),
+	}
+	createdURL, err := db.CreateURL(ctx, req)
+	require.NoError(t, err)
+
+	t.Run("UpdateDestination", func(t *testing.T) {
+		updateReq := UpdateURLRequest{
+			Destination: stringPtr("https://updated.com"),
+		}
+
+		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, updateReq)
+		require.NoError(t, err)
+		assert.Equal(t, "https://updated.com", updatedURL.Destination)
+		assert.Equal(t, *createdURL.Title, *updatedURL.Title) // Should remain unchanged
+	})
+
+	t.Run("UpdateTitle", func(t *testing.T) {
+		title := stringPtr("Updated Title")
+		updateReq := UpdateURLRequest{
+			Title: &title,
+		}
+
+		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, updateReq)
+		require.NoError(t, err)
+		assert.Equal(t, "Updated Title", *updatedURL.Title)
+	})
+
+	t.Run("ClearExpiration", func(t *testing.T) {
+		// First set an expiration
+		futureTime := time.Now().Add(24 * time.Hour)
+		futureTimePtr := &futureTime
+		updateReq := UpdateURLRequest{
+			ExpiresAt: &futureTimePtr,
+		}
+		_, err := db.UpdateURLSQLite(ctx, createdURL.ID, updateReq)
+		require.NoError(t, err)
+
+		// Now clear it
+		var nilTime *time.Time
+		clearReq := UpdateURLRequest{
+			ExpiresAt: &nilTime,
+		}
+		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, clearReq)
+		require.NoError(t, err)
+		assert.Nil(t, updatedURL.ExpiresAt)
+	})
+
+	t.Run("ClearMetadata", func(t *testing.T) {
+		description := stringPtr("Some description")
+		imageURL := stringPtr("https://original.com/image.jpg")
+		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, UpdateURLRequest{Description: &description, ImageURL: &imageURL})
+		require.NoError(t, err)
+		require.NotNil(t, updatedURL.Title)
+		require.NotNil(t, updatedURL.Description)
+		require.NotNil(t, updatedURL.ImageURL)
+
+		var null *string
+		for name, clearReq := range map[string]UpdateURLRequest{
+			"title":       {Title: &null},
+			"description": {Description: &null},
+			"image_url":   {ImageURL: &null},
+		} {
+			_, err := db.UpdateURLSQLite(ctx, createdURL.ID, clearReq)
+			require.NoError(t, err, name)
+		}
+
+		updatedURL, err = db.GetURLByID(ctx, createdURL.ID)
+		require.NoError(t, err)
+		assert.Nil(t, updatedURL.Title)
+		assert.Nil(t, updatedURL.Description)
+		assert.Nil(t, updatedURL.ImageURL)
+		assert.Equal(t, "https://updated.com", updatedURL.Destination)
+	})
+
+	t.Run("SetAndClearInstantReferrers", func(t *testing.T) {
+		referrers := []string{"app.example.org", "intranet.example.org"}
+		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, UpdateURLRequest{InstantReferrers: &referrers})
+		require.NoError(t, err)
+		assert.Equal(t, StringList(referrers), updatedURL.InstantReferrers)
+
+		empty := []string{}
+		updatedURL, err = db.UpdateURLSQLite(ctx, createdURL.ID, UpdateURLRequest{InstantReferrers: &empty})
+		require.NoError(t, err)
+		assert.Nil(t, updatedURL.InstantReferrers)
+	})
+
+	t.Run("SetCountryLists", func(t *testing.T) {
+		blocked := []string{"US"}
+		allowed := []string{"BR", "PT"}
+		updatedURL, err := db.UpdateURLSQLite(ctx, createdURL.ID, UpdateURLRequest{
+			BlockedCountries: &blocked,
+			AllowedCountries: &allowed,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, StringList(blocked), updatedURL.BlockedCountries)
+		assert.Equal(t, StringList(allowed), updatedURL.AllowedCountries)
+
+		fetched, err := db.GetURLByID(ctx, createdURL.ID)
+		require.NoError(t, err)
+		assert.Equal(t, StringList(allowed), fetched.AllowedCountries)
+	})
+
+	t.Run("ShortPathConflict", func(t *testing.T) {
+		taken := "taken-path"
+		_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &taken, Destination: "https://other.com"})
+		require.NoError(t, err)
+
+		_, err = db.UpdateURLSQLite(ctx, createdURL.ID, UpdateURLRequest{ShortPath: &taken})
+		assert.True(t, IsUniqueViolation(err))
+	})
+
+	t.Run("UpdateNonExistentURL", func(t *testing.T) {
+		randomID := uuid.New()
+		updateReq := UpdateURLRequest{
+			Destination: stringPtr("https://nonexistent.com"),
+		}
+
+		updatedURL, err := db.UpdateURLSQLite(ctx, randomID, updateReq)
+		assert.ErrorIs(t, err, ErrURLNotFound)
+		assert.Nil(t, updatedURL)
+	})
+}
+
+func TestStreamAllURLs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	var created []*URL
+	for i := 0; i < 3; i++ {
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: fmt.Sprintf("https://example.com/%d", i)})
+		require.NoError(t, err)
+		created = append(created, url)
+	}
+	require.NoError(t, db.DeleteURL(ctx, created[1].ID))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, db.RecordClick(ctx, Click{URLID: created[2].ID}))
+	}
+
+	streamed := map[string]int{}
+	err := db.StreamAllURLs(ctx, func(url URL, clicks int) error {
+		streamed[url.Destination] = clicks
+		return nil
+	})
+	require.NoError(t, err)
+	// Deleted URLs are left out
+	assert.Equal(t, map[string]int{"https://example.com/0": 0, "https://example.com/2": 2}, streamed)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = db.StreamAllURLs(ctx, func(URL, int) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDeleteURL(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("DeleteExistingURL", func(t *testing.T) {
+		req := CreateURLRequest{
+			Destination: "https://todelete.com",
+		}
+		createdURL, err := db.CreateURL(ctx, req)
+		require.NoError(t, err)
+
+		err = db.DeleteURL(ctx, createdURL.ID)
+		require.NoError(t, err)
+
+		// Verify it's deleted
+		url, err := db.GetURLByID(ctx, createdURL.ID)
+		require.NoError(t, err)
+		assert.Nil(t, url)
+	})
+
+	t.Run("DeleteNonExistentURL", func(t *testing.T) {
+		randomID := uuid.New()
+		err := db.DeleteURL(ctx, randomID)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrURLNotFound)
+	})
+
+	t.Run("SoftDeleteHidesURLAndKeepsShortPath", func(t *testing.T) {
+		customPath := "soft-deleted"
+		createdURL, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &customPath, Destination: "https://soft.com"})
+		require.NoError(t, err)
+
+		require.NoError(t, db.DeleteURL(ctx, createdURL.ID))
+
+		url, err := db.GetURLByShortPathSQLite(ctx, customPath)
+		require.NoError(t, err)
+		assert.Nil(t, url)
+
+		found, err := db.GetURLsByShortPathsSQLite(ctx, []string{customPath})
+		require.NoError(t, err)
+		assert.Empty(t, found)
+
+		list, err := db.ListURLs(ctx, 1, 100, StatusAll)
+		require.NoError(t, err)
+		for _, u := range list.URLs {
+			assert.NotEqual(t, createdURL.ID, u.ID)
+		}
+
+		// The path stays taken so it cannot be reassigned
+		_, err = db.CreateURL(ctx, CreateURLRequest{ShortPath: &customPath, Destination: "https://other.com"})
+		assert.True(t, IsUniqueViolation(err))
+
+		// Deleting twice reports not found
+		assert.ErrorIs(t, db.DeleteURL(ctx, createdURL.ID), ErrURLNotFound)
+	})
+
+	t.Run("RestoreURL", func(t *testing.T) {
+		createdURL, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://restore.com"})
+		require.NoError(t, err)
+		require.NoError(t, db.DeleteURL(ctx, createdURL.ID))
+
+		restored, err := db.RestoreURL(ctx, createdURL.ID)
+		require.NoError(t, err)
+		require.NotNil(t, restored)
+		assert.Equal(t, createdURL.ShortPath, restored.ShortPath)
+
+		url, err := db.GetURLByID(ctx, createdURL.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, url)
+
+		// Only soft-deleted URLs can be restored
+		restored, err = db.RestoreURL(ctx, createdURL.ID)
+		require.NoError(t, err)
+		assert.Nil(t, restored)
+	})
+
+	t.Run("HardDeleteRemovesSoftDeletedURL", func(t *testing.T) {
+		createdURL, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://hard.com"})
+		require.NoError(t, err)
+		require.NoError(t, db.DeleteURL(ctx, createdURL.ID))
+
+		require.NoError(t, db.HardDeleteURL(ctx, createdURL.ID))
+
+		restored, err := db.RestoreURL(ctx, createdURL.ID)
+		require.NoError(t, err)
+		assert.Nil(t, restored)
+		assert.ErrorIs(t, db.HardDeleteURL(ctx, createdURL.ID), ErrURLNotFound)
+	})
+}
+
+func TestReserveShortPath(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("HoldThenActivate", func(t *testing.T) {
+		held, err := db.ReserveShortPath(ctx, "summer-sale", time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.NotNil(t, held.ReservedUntil)
+		assert.Empty(t, held.Destination)
+
+		// The hold resolves (to show a coming-soon page) but is not bulk-resolvable
+		url, err := db.GetURLByShortPathSQLite(ctx, "summer-sale")
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.NotNil(t, url.ReservedUntil)
+
+		found, err := db.GetURLsByShortPathsSQLite(ctx, []string{"summer-sale"})
+		require.NoError(t, err)
+		assert.Empty(t, found)
+
+		// The path cannot be claimed while held
+		customPath := "summer-sale"
+		_, err = db.CreateURL(ctx, CreateURLRequest{ShortPath: &customPath, Destination: "https://other.com"})
+		assert.True(t, IsUniqueViolation(err))
+
+		destination := "https://example.com/summer"
+		activated, err := db.UpdateURLSQLite(ctx, held.ID, UpdateURLRequest{Destination: &destination})
+		require.NoError(t, err)
+		require.NotNil(t, activated)
+		assert.Equal(t, destination, activated.Destination)
+		assert.Nil(t, activated.ReservedUntil)
+	})
+
+	t.Run("LapsedHoldIsReleased", func(t *testing.T) {
+		held, err := db.ReserveShortPath(ctx, "lapsed", time.Now().Add(-time.Minute))
+		require.NoError(t, err)
+
+		url, err := db.GetURLByShortPathSQLite(ctx, "lapsed")
+		require.NoError(t, err)
+		assert.Nil(t, url)
+
+		// A lapsed hold can no longer be activated
+		destination := "https://example.com/late"
+		url, err = db.UpdateURLSQLite(ctx, held.ID, UpdateURLRequest{Destination: &destination})
+		assert.ErrorIs(t, err, ErrURLNotFound)
+		assert.Nil(t, url)
+
+		// And the path can be claimed again
+		customPath := "lapsed"
+		created, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &customPath, Destination: "https://example.com/new"})
+		require.NoError(t, err)
+		assert.NotEqual(t, held.ID, created.ID)
+	})
+}
+
+func TestScheduledActivation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	customPath := "launch"
+	activateAt := time.Now().Add(time.Hour)
+	expiresAt := time.Now().Add(48 * time.Hour)
+	created, err := db.CreateURL(ctx, CreateURLRequest{
+		ShortPath:   &customPath,
+		Destination: "https://example.com/launch",
+		ActivateAt:  &activateAt,
+		ExpiresAt:   &expiresAt,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, created.ActivateAt)
+	assert.WithinDuration(t, activateAt, *created.ActivateAt, time.Second)
+
+	url, err := db.GetURLByShortPathSQLite(ctx, customPath)
+	require.NoError(t, err)
+	assert.Nil(t, url, "pending links must not resolve")
+
+	found, err := db.GetURLsByShortPathsSQLite(ctx, []string{customPath})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	active, err := db.SearchURLsSQLite(ctx, "", 1, 10, StatusActive, "")
+	require.NoError(t, err)
+	assert.Equal(t, 0, active.Total)
+
+	// Activating now makes it resolve within its expiry window
+	var now *time.Time
+	_, err = db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{ActivateAt: &now})
+	require.NoError(t, err)
+
+	url, err = db.GetURLByShortPathSQLite(ctx, customPath)
+	require.NoError(t, err)
+	require.NotNil(t, url)
+	assert.Nil(t, url.ActivateAt)
+}
+
+func TestClickTimeSeries(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/clicks"})
+	require.NoError(t, err)
+	other, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/other"})
+	require.NoError(t, err)
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	clicks := []time.Time{
+		day.Add(1 * time.Hour),
+		day.Add(1*time.Hour + 30*time.Minute),
+		day.Add(23 * time.Hour),
+		day.Add(24*time.Hour + 5*time.Minute),
+		day.Add(72 * time.Hour), // outside the queried range
+	}
+	for _, at := range clicks {
+		require.NoError(t, db.RecordClick(ctx, Click{URLID: created.ID, At: at}))
+	}
+	require.NoError(t, db.RecordClick(ctx, Click{URLID: other.ID, At: day.Add(time.Hour)}))
+
+	t.Run("Daily", func(t *testing.T) {
+		series, err := db.ClickTimeSeriesSQLite(ctx, created.ID, day.Add(6*time.Hour), day.Add(72*time.Hour), IntervalDay)
+		require.NoError(t, err)
+
+		assert.Equal(t, day, series.From, "from should round down to the bucket start")
+		require.Len(t, series.Buckets, 3)
+		assert.Equal(t, ClickBucket{Start: day, Clicks: 3}, series.Buckets[0])
+		assert.Equal(t, ClickBucket{Start: day.Add(24 * time.Hour), Clicks: 1}, series.Buckets[1])
+		assert.Equal(t, ClickBucket{Start: day.Add(48 * time.Hour), Clicks: 0}, series.Buckets[2])
+		assert.Equal(t, 4, series.Total)
+	})
+
+	t.Run("Hourly", func(t *testing.T) {
+		series, err := db.ClickTimeSeriesSQLite(ctx, created.ID, day, day.Add(3*time.Hour), IntervalHour)
+		require.NoError(t, err)
+
+		require.Len(t, series.Buckets, 3)
+		assert.Equal(t, 0, series.Buckets[0].Clicks)
+		assert.Equal(t, 2, series.Buckets[1].Clicks)
+		assert.Equal(t, 0, series.Buckets[2].Clicks)
+		assert.Equal(t, 2, series.Total)
+	})
+
+	t.Run("NoClicks", func(t *testing.T) {
+		series, err := db.ClickTimeSeriesSQLite(ctx, uuid.New(), day, day.Add(48*time.Hour), IntervalDay)
+		require.NoError(t, err)
+
+		require.Len(t, series.Buckets, 2)
+		assert.Equal(t, 0, series.Total)
+	})
+}
+
+func TestParseClickInterval(t *testing.T) {
+	interval, err := ParseClickInterval("")
+	require.NoError(t, err)
+	assert.Equal(t, IntervalDay, interval)
+
+	interval, err = ParseClickInterval("hour")
+	require.NoError(t, err)
+	assert.Equal(t, IntervalHour, interval)
+
+	_, err = ParseClickInterval("week")
+	assert.Error(t, err)
+}
+
+func TestListBrokenURLs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	create := func(path string) *URL {
+		url, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com/" + path})
+		require.NoError(t, err)
+		return url
+	}
+	healthy := create("healthy")
+	missing := create("missing")
+	down := create("down")
+	unchecked := create("unchecked")
+
+	now := time.Now()
+	require.NoError(t, db.RecordDestinationCheck(ctx, healthy.ID, 200, now))
+	require.NoError(t, db.RecordDestinationCheck(ctx, missing.ID, 404, now))
+	require.NoError(t, db.RecordDestinationCheck(ctx, down.ID, 0, now))
+
+	// Clicks decide the order: the unreachable link is the busiest
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.RecordClick(ctx, Click{URLID: down.ID, At: now}))
+	}
+	require.NoError(t, db.RecordClick(ctx, Click{URLID: missing.ID, At: now}))
+	require.NoError(t, db.RecordClick(ctx, Click{URLID: healthy.ID, At: now}))
+
+	broken, err := db.ListBrokenURLs(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, broken, 2)
+
+	assert.Equal(t, "down", broken[0].ShortPath)
+	assert.Equal(t, 0, broken[0].Status)
+	assert.Equal(t, 3, broken[0].Clicks)
+	assert.Equal(t, "missing", broken[1].ShortPath)
+	assert.Equal(t, 404, broken[1].Status)
+	assert.Equal(t, 1, broken[1].Clicks)
+
+	t.Run("DueForCheck", func(t *testing.T) {
+		due, err := db.URLsDueForCheck(ctx, now.Add(-time.Minute), 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		assert.Equal(t, unchecked.ID, due[0].ID)
+
+		due, err = db.URLsDueForCheck(ctx, now.Add(time.Minute), 10)
+		require.NoError(t, err)
+		assert.Len(t, due, 4)
+		assert.Equal(t, unchecked.ID, due[0].ID, "never-checked URLs come first")
+	})
+}
+
+func TestGetDeletedURLByShortPath(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	path := "removed"
+	created, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com"})
+	require.NoError(t, err)
+
+	// Live links are not tombstones
+	url, err := db.GetDeletedURLByShortPath(ctx, path, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, url)
+
+	require.NoError(t, db.DeleteURL(ctx, created.ID))
+
+	url, err = db.GetDeletedURLByShortPath(ctx, path, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, url)
+	assert.Equal(t, created.ID, url.ID)
+
+	// Deleted before the grace window
+	url, err = db.GetDeletedURLByShortPath(ctx, path, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Nil(t, url)
+}
+
+func TestShowQR(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/event", ShowQR: true})
+	require.NoError(t, err)
+	assert.True(t, created.ShowQR)
+
+	plain, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+	require.NoError(t, err)
+	assert.False(t, plain.ShowQR)
+
+	off := false
+	updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{ShowQR: &off})
+	require.NoError(t, err)
+	assert.False(t, updated.ShowQR)
+}
+
+func TestHideSocialTags(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/private", HideSocialTags: true})
+	require.NoError(t, err)
+	assert.True(t, created.HideSocialTags)
+
+	plain, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+	require.NoError(t, err)
+	assert.False(t, plain.HideSocialTags)
+
+	off := false
+	updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{HideSocialTags: &off})
+	require.NoError(t, err)
+	assert.False(t, updated.HideSocialTags)
+}
+
+func TestInternalNote(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	note := "requested by ticket #123"
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com", InternalNote: &note})
+	require.NoError(t, err)
+	require.NotNil(t, created.InternalNote)
+	assert.Equal(t, note, *created.InternalNote)
+
+	fetched, err := db.GetURLByShortPathSQLite(ctx, created.ShortPath)
+	require.NoError(t, err)
+	require.NotNil(t, fetched.InternalNote)
+	assert.Equal(t, note, *fetched.InternalNote)
+
+	changed := "moved to ticket #456"
+	updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{InternalNote: &changed})
+	require.NoError(t, err)
+	require.NotNil(t, updated.InternalNote)
+	assert.Equal(t, changed, *updated.InternalNote)
+}
+
+func TestRedirectRules(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	rules := []RedirectRule{
+		{Device: DeviceIOS, Destination: "https://apps.apple.com/app/id123"},
+		{Countries: []string{"PT"}, Languages: []string{"pt"}, Destination: "https://example.com/pt"},
+	}
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com", Rules: rules})
+	require.NoError(t, err)
+	assert.Equal(t, RedirectRules(rules), created.Rules)
+
+	fetched, err := db.GetURLByShortPathSQLite(ctx, created.ShortPath)
+	require.NoError(t, err)
+	assert.Equal(t, RedirectRules(rules), fetched.Rules)
+
+	t.Run("Clear", func(t *testing.T) {
+		updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{Rules: &[]RedirectRule{}})
+		require.NoError(t, err)
+		assert.Empty(t, updated.Rules)
+	})
+
+	t.Run("NoRules", func(t *testing.T) {
+		plain, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/plain"})
+		require.NoError(t, err)
+		assert.Nil(t, plain.Rules)
+	})
+}
+
+func TestQueryParams(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	params := map[string]string{"utm_source": "poster", "utm_medium": "qr"}
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com", ForwardQuery: true, StaticParams: params})
+	require.NoError(t, err)
+	assert.True(t, created.ForwardQuery)
+	assert.Equal(t, StringMap(params), created.StaticParams)
+
+	forward := false
+	updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{ForwardQuery: &forward, StaticParams: &map[string]string{}})
+	require.NoError(t, err)
+	assert.False(t, updated.ForwardQuery)
+	assert.Nil(t, updated.StaticParams)
+}
+
+func TestURLPassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	password := "open sesame"
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com", Password: &password})
+	require.NoError(t, err)
+	require.NotNil(t, created.PasswordHash)
+	assert.NotEqual(t, password, *created.PasswordHash)
+	assert.True(t, VerifyURLPassword(created, password))
+	assert.False(t, VerifyURLPassword(created, "guess"))
+
+	t.Run("Change", func(t *testing.T) {
+		changed := "new secret"
+		newPassword := &changed
+		updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{Password: &newPassword})
+		require.NoError(t, err)
+		assert.True(t, VerifyURLPassword(updated, changed))
+		assert.False(t, VerifyURLPassword(updated, password))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		var none *string
+		updated, err := db.UpdateURLSQLite(ctx, created.ID, UpdateURLRequest{Password: &none})
+		require.NoError(t, err)
+		assert.Nil(t, updated.PasswordHash)
+		assert.False(t, VerifyURLPassword(updated, password))
+	})
+
+	t.Run("Unprotected", func(t *testing.T) {
+		plain, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/plain"})
+		require.NoError(t, err)
+		assert.Nil(t, plain.PasswordHash)
+		assert.False(t, VerifyURLPassword(plain, ""))
+	})
+}
+
+func TestClickStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+	require.NoError(t, err)
+	other, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/other"})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	for _, click := range []Click{
+		{At: now.Add(-time.Hour), Referrer: "https://News.example.com/story?token=abc", UserAgent: "Firefox"},
+		{At: now.Add(-2 * time.Hour), Referrer: "https://news.example.com/other", UserAgent: "Firefox"},
+		{At: now.Add(-3 * 24 * time.Hour), Referrer: "https://social.example.org/", UserAgent: "Safari"},
+		{At: now.Add(-20 * 24 * time.Hour), UserAgent: strings.Repeat("x", 600)},
+		{At: now.Add(-90 * 24 * time.Hour)},
+	} {
+		click.URLID = created.ID
+		require.NoError(t, db.RecordClick(ctx, click))
+	}
+	require.NoError(t, db.RecordClick(ctx, Click{URLID: other.ID, At: now, Referrer: "https://elsewhere.example.com/"}))
+
+	stats, err := db.ClickStats(ctx, created.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, stats.URLID)
+	assert.Equal(t, 5, stats.Total)
+	assert.Equal(t, 2, stats.Last24h)
+	assert.Equal(t, 3, stats.Last7d)
+	assert.Equal(t, 4, stats.Last30d)
+	assert.Equal(t, []ClickCount{{Value: "news.example.com", Clicks: 2}, {Value: "social.example.org", Clicks: 1}}, stats.TopReferrers)
+	assert.Equal(t, []ClickCount{
+		{Value: "Firefox", Clicks: 2},
+		{Value: "Safari", Clicks: 1},
+		{Value: strings.Repeat("x", maxUserAgentLength), Clicks: 1},
+	}, stats.TopUserAgents)
+
+	t.Run("NoClicks", func(t *testing.T) {
+		stats, err := db.ClickStats(ctx, uuid.New(), now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, stats.Total)
+		assert.Empty(t, stats.TopReferrers)
+		assert.NotNil(t, stats.TopReferrers)
+	})
+}
+
+func TestConsumeURLUse(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	limit := 2
+	customPath := "twice"
+	created, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &customPath, Destination: "https://example.com/file", MaxUses: &limit})
+	require.NoError(t, err)
+	require.NotNil(t, created.MaxUses)
+	assert.Equal(t, 0, created.UseCount)
+
+	for i := 1; i <= limit; i++ {
+		url, err := db.ConsumeURLUse(ctx, created.ID)
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, i, url.UseCount)
+	}
+
+	// The limit is never overshot
+	url, err := db.ConsumeURLUse(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Nil(t, url)
+
+	// Exhausted links read as expired
+	url, err = db.GetURLByShortPathSQLite(ctx, customPath)
+	require.NoError(t, err)
+	assert.Nil(t, url)
+
+	url, err = db.GetExpiredURLByShortPath(ctx, customPath)
+	require.NoError(t, err)
+	require.NotNil(t, url)
+	assert.Equal(t, limit, url.UseCount)
+
+	t.Run("ZeroMeansUnlimited", func(t *testing.T) {
+		zero := 0
+		created, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com/unlimited", MaxUses: &zero})
+		require.NoError(t, err)
+		assert.Nil(t, created.MaxUses)
+	})
+}
+
+func TestDeleteExpiredURLs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("PurgesAcrossMultipleBatches", func(t *testing.T) {
+		pastTime := time.Now().Add(-1 * time.Hour)
+		for i := 0; i < 7; i++ {
+			_, err := db.CreateURL(ctx, CreateURLRequest{
+				Destination: "https://expired.com",
+				ExpiresAt:   &pastTime,
+			})
+			require.NoError(t, err)
+		}
+
+		futureTime := time.Now().Add(24 * time.Hour)
+		active, err := db.CreateURL(ctx, CreateURLRequest{
+			Destination: "https://active.com",
+			ExpiresAt:   &futureTime,
+		})
+		require.NoError(t, err)
+
+		permanent, err := db.CreateURL(ctx, CreateURLRequest{
+			Destination: "https://permanent.com",
+		})
+		require.NoError(t, err)
+
+		// Batch size smaller than the expired set forces several iterations
+		deleted, err := db.DeleteExpiredURLs(ctx, 3, 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), deleted)
+
+		response, err := db.ListURLs(ctx, 1, 100, StatusAll)
+		require.NoError(t, err)
+		assert.Equal(t, 2, response.Total)
+
+		url, err := db.GetURLByID(ctx, active.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, url)
+
+		url, err = db.GetURLByID(ctx, permanent.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, url)
+	})
+
+	t.Run("NothingToPurge", func(t *testing.T) {
+		deleted, err := db.DeleteExpiredURLs(ctx, 3, 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), deleted)
+	})
+
+	t.Run("PausesBetweenBatches", func(t *testing.T) {
+		pastTime := time.Now().Add(-1 * time.Hour)
+		for i := 0; i < 5; i++ {
+			_, err := db.CreateURL(ctx, CreateURLRequest{
+				Destination: "https://paused.com",
+				ExpiresAt:   &pastTime,
+			})
+			require.NoError(t, err)
+		}
+
+		// The pauses add up past the query timeout, which only bounds each batch
+		db.queryTimeout = 50 * time.Millisecond
+		defer func() { db.queryTimeout = 0 }()
+
+		start := time.Now()
+		deleted, err := db.DeleteExpiredURLs(ctx, 1, 20*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), deleted)
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("StopsWhenCancelledDuringPause", func(t *testing.T) {
+		pastTime := time.Now().Add(-1 * time.Hour)
+		for i := 0; i < 3; i++ {
+			_, err := db.CreateURL(ctx, CreateURLRequest{
+				Destination: "https://cancelled.com",
+				ExpiresAt:   &pastTime,
+			})
+			require.NoError(t, err)
+		}
+
+		cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		deleted, err := db.DeleteExpiredURLs(cancelCtx, 1, time.Hour)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, int64(1), deleted)
+	})
+
+	t.Run("InvalidBatchSize", func(t *testing.T) {
+		_, err := db.DeleteExpiredURLs(ctx, 0, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestReadReplicaRouting(t *testing.T) {
+	primary, err := InitSQLiteDB()
+	require.NoError(t, err)
+	replica, err := InitSQLiteDB()
+	require.NoError(t, err)
+
+	db := NewWithReplica(primary.DB, replica.DB)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	// Seed a row that only exists on the replica
+	replicaPath := "replica-only"
+	replicaURL, err := replica.CreateURL(ctx, CreateURLRequest{
+		ShortPath:   &replicaPath,
+		Destination: "https://replica.com",
+	})
+	require.NoError(t, err)
+
+	t.Run("WritesGoToPrimary", func(t *testing.T) {
+		primaryPath := "primary-only"
+		created, err := db.CreateURL(ctx, CreateURLRequest{
+			ShortPath:   &primaryPath,
+			Destination: "https://primary.com",
+		})
+		require.NoError(t, err)
+
+		url, err := primary.GetURLByID(ctx, created.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, url)
+
+		url, err = replica.GetURLByID(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Nil(t, url)
+	})
+
+	t.Run("GetURLByIDReadsReplica", func(t *testing.T) {
+		url, err := db.GetURLByID(ctx, replicaURL.ID)
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, "https://replica.com", url.Destination)
+	})
+
+	t.Run("GetURLByShortPathReadsReplica", func(t *testing.T) {
+		url, err := db.GetURLByShortPathSQLite(ctx, replicaPath)
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, replicaURL.ID, url.ID)
+	})
+
+	t.Run("ListURLsReadsReplica", func(t *testing.T) {
+		response, err := db.ListURLs(ctx, 1, 10, StatusAll)
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.Total)
+		require.Len(t, response.URLs, 1)
+		assert.Equal(t, replicaURL.ID, response.URLs[0].ID)
+	})
+
+	t.Run("FallsBackToPrimaryWithoutReplica", func(t *testing.T) {
+		primaryOnly := NewWithReplica(primary.DB, nil)
+		response, err := primaryOnly.ListURLs(ctx, 1, 10, StatusAll)
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.Total)
+		assert.Equal(t, "https://primary.com", response.URLs[0].Destination)
+	})
+}
+
+func TestGenerateShortPath(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	t.Run("GenerateShortPath", func(t *testing.T) {
+		path1, _, err := db.generateShortPath(ctx, 0)
+		require.NoError(t, err)
+		assert.Len(t, path1, minLength)
+
+		path2, _, err := db.generateShortPath(ctx, 0)
+		require.NoError(t, err)
+		assert.NotEqual(t, path1, path2)
+	})
+
+	t.Run("GrowsWithAttempts", func(t *testing.T) {
+		path, seq, err := db.generateShortPath(ctx, 3)
+		require.NoError(t, err)
+		assert.Len(t, path, minLength+3)
+		assert.Nil(t, seq)
+	})
+}
+
+func TestConcurrentCreateURL(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Each connection to :memory: is a separate database, so share one
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+
+	// A two-character alphabet at length 1 makes parallel creates collide
+	db.shortPathLength = 1
+	db.shortPathAlphabet = "xy"
+
+	const creates = 50
+	paths := make(chan string, creates)
+	errs := make(chan error, creates)
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+			if err != nil {
+				errs <- err
+				return
+			}
+			paths <- url.ShortPath
+		}()
+	}
+	wg.Wait()
+	close(paths)
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("create failed: %v", err)
+	}
+	seen := make(map[string]bool)
+	for path := range paths {
+		assert.False(t, seen[path], "duplicate short path %s", path)
+		seen[path] = true
+	}
+	assert.Len(t, seen, creates)
+}
+
+func TestGenerateRandomString(t *testing.T) {
+	t.Run("GenerateRandomString", func(t *testing.T) {
+		str1 := generateRandomString(8, charset)
+		str2 := generateRandomString(8, charset)
+		
+		assert.Len(t, str1, 8)
+		assert.Len(t, str2, 8)
+		assert.NotEqual(t, str1, str2)
+		
+		// Check that it only contains valid characters
+		for _, char := range str1 {
+			assert.Contains(t, charset, string(char))
+		}
+	})
+}
+
+func TestConfiguredShortPathGeneration(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	// Unambiguous alphabet without 0/O/1/l
+	alphabet := "abcdefghjkmnpqrstuvwxyz23456789"
+	db.shortPathLength = 4
+	db.shortPathAlphabet = alphabet
+
+	for i := 0; i < 20; i++ {
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, len(url.ShortPath), 4)
+		for _, char := range url.ShortPath {
+			assert.Contains(t, alphabet, string(char))
+		}
+	}
+
+	t.Run("RetriesGrowFromBaseLength", func(t *testing.T) {
+		// A two-character alphabet at length 1 has only two paths; take both
+		db.shortPathLength = 1
+		db.shortPathAlphabet = "xy"
+		for _, path := range []string{"x", "y"} {
+			path := path
+			_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com"})
+			require.NoError(t, err)
+		}
+
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		assert.Len(t, url.ShortPath, 2)
+	})
+
+	t.Run("Capacity", func(t *testing.T) {
+		db.shortPathLength = 4
+		db.shortPathAlphabet = alphabet
+
+		capacity, err := db.ShortPathCapacity(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 4, capacity.Length)
+		assert.Equal(t, len(alphabet), capacity.AlphabetSize)
+	})
+}
+
+func TestSqidsShortPathGeneration(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	cfg := &config.Config{ShortPathStrategy: "sqids", ShortPathLength: 5, ShortPathSalt: "prefeitura"}
+	generator, err := newShortPathGenerator(cfg)
+	require.NoError(t, err)
+	db.pathGenerator = generator
+
+	encoder, err := sqids.New(charset, cfg.ShortPathSalt, cfg.ShortPathLength)
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	var last int64
+	for i := 0; i < 50; i++ {
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		require.NotNil(t, url.Seq)
+		assert.Greater(t, *url.Seq, last)
+		last = *url.Seq
+
+		assert.GreaterOrEqual(t, len(url.ShortPath), 5)
+		assert.False(t, seen[url.ShortPath], "duplicate short path %s", url.ShortPath)
+		seen[url.ShortPath] = true
+
+		// The path decodes back to the row's sequence number
+		decoded, err := encoder.Decode(url.ShortPath)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(*url.Seq), decoded)
+	}
+
+	t.Run("SkipsTakenPaths", func(t *testing.T) {
+		taken := encoder.Encode(uint64(last + 1))
+		_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &taken, Destination: "https://example.com"})
+		require.NoError(t, err)
+
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, last+2, *url.Seq)
+		assert.NotEqual(t, taken, url.ShortPath)
+	})
+
+	t.Run("CustomPathsHaveNoSeq", func(t *testing.T) {
+		path := "custom-sqids"
+		url, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com"})
+		require.NoError(t, err)
+		assert.Nil(t, url.Seq)
+	})
+
+	t.Run("UnknownStrategy", func(t *testing.T) {
+		_, err := newShortPathGenerator(&config.Config{ShortPathStrategy: "uuid"})
+		assert.Error(t, err)
+	})
+}
+
+func TestSequentialShortPathGeneration(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	generator, err := newShortPathGenerator(&config.Config{ShortPathStrategy: "sequential"})
+	require.NoError(t, err)
+	db.pathGenerator = generator
+
+	var last int64
+	for i := 0; i < 70; i++ {
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		require.NotNil(t, url.Seq)
+		assert.Greater(t, *url.Seq, last)
+		last = *url.Seq
+
+		// The path is the sequence number in base62
+		assert.Equal(t, encodeBase(uint64(*url.Seq), charset), url.ShortPath)
+	}
+	assert.Len(t, encodeBase(uint64(last), charset), 2)
+
+	t.Run("SkipsTakenPaths", func(t *testing.T) {
+		taken := encodeBase(uint64(last+1), charset)
+		_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &taken, Destination: "https://example.com"})
+		require.NoError(t, err)
+
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, last+2, *url.Seq)
+		last = *url.Seq
+	})
+
+	t.Run("SkipsReservedPaths", func(t *testing.T) {
+		reserved := encodeBase(uint64(last+1), charset)
+		db.reservedPaths = reservedPathSet(&config.Config{ReservedPaths: []string{strings.ToUpper(reserved)}, APIPrefix: "/api"})
+		assert.True(t, db.reservedPaths["api"])
+
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, last+2, *url.Seq)
+		assert.NotEqual(t, reserved, url.ShortPath)
+	})
+}
+
+func TestNanoidShortPathGeneration(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	generator, err := newShortPathGenerator(&config.Config{ShortPathStrategy: "nanoid", ShortPathLength: 10})
+	require.NoError(t, err)
+	db.pathGenerator = generator
+
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		url, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+		assert.Nil(t, url.Seq)
+		assert.Len(t, url.ShortPath, 10)
+		for _, char := range url.ShortPath {
+			assert.Contains(t, nanoidAlphabet, string(char))
+		}
+		assert.False(t, seen[url.ShortPath], "duplicate short path %s", url.ShortPath)
+		seen[url.ShortPath] = true
+	}
+
+	t.Run("GrowsWithAttempts", func(t *testing.T) {
+		path, _, err := db.generateShortPath(ctx, 2)
+		require.NoError(t, err)
+		assert.Len(t, path, 12)
+	})
+
+	t.Run("NoSeparatorsAtEnds", func(t *testing.T) {
+		generator, err := newShortPathGenerator(&config.Config{ShortPathStrategy: "nanoid", ShortPathLength: 3})
+		require.NoError(t, err)
+
+		inner := false
+		for i := 0; i < 1000; i++ {
+			path, _, err := generator.Generate(ctx, nil, 0)
+			require.NoError(t, err)
+			require.Len(t, path, 3)
+			assert.NotContains(t, pathSeparators, path[:1], path)
+			assert.NotContains(t, pathSeparators, path[2:], path)
+			inner = inner || strings.ContainsAny(path[1:2], pathSeparators)
+		}
+		assert.True(t, inner, "separators should still appear inside paths")
+	})
+
+	t.Run("CapacityUsesNanoidAlphabet", func(t *testing.T) {
+		capacity, err := db.ShortPathCapacity(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 10, capacity.Length)
+		assert.Equal(t, len(nanoidAlphabet), capacity.AlphabetSize)
+	})
+}
+
+func TestEncodeBase(t *testing.T) {
+	assert.Equal(t, "a", encodeBase(0, charset))
+	assert.Equal(t, "b", encodeBase(1, charset))
+	assert.Equal(t, "9", encodeBase(61, charset))
+	assert.Equal(t, "ba", encodeBase(62, charset))
+	assert.Equal(t, "101", encodeBase(5, "01"))
+}
+
+func TestValidateShortPathAlphabet(t *testing.T) {
+	assert.NoError(t, validateShortPathAlphabet(0, ""))
+	assert.NoError(t, validateShortPathAlphabet(4, "abc"))
+	assert.Error(t, validateShortPathAlphabet(4, "a"))
+	assert.Error(t, validateShortPathAlphabet(4, "abca"))
+	assert.Error(t, validateShortPathAlphabet(-1, ""))
+	assert.Error(t, validateShortPathAlphabet(4, "-_."))
+}
 
 // Helper function to create string pointers
 func stringPtr(s string) *string {
 	return &s
-}
\ No newline at end of file
+}
+
+func TestEstimateShortPathCapacity(t *testing.T) {
+	t.Run("KnownLengthAndAlphabet", func(t *testing.T) {
+		capacity := EstimateShortPathCapacity(0, 6, 62)
+		assert.Equal(t, 6, capacity.Length)
+		assert.Equal(t, 62, capacity.AlphabetSize)
+		assert.Equal(t, float64(56800235584), capacity.KeySpace)
+		assert.Equal(t, float64(0), capacity.CollisionProbability)
+		assert.Equal(t, 6, capacity.RecommendedLength)
+	})
+
+	t.Run("GrowsRecommendedLength", func(t *testing.T) {
+		// 62^6 / 10 rows is a 10% chance per generation
+		capacity := EstimateShortPathCapacity(5680023558, 6, 62)
+		assert.InDelta(t, 0.1, capacity.CollisionProbability, 1e-9)
+		// 62^8 is the first key space putting the ratio under 0.01%
+		assert.Equal(t, 8, capacity.RecommendedLength)
+	})
+}
+
+func TestCountURLs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := db.CreateURL(ctx, CreateURLRequest{Destination: "https://example.com"})
+		require.NoError(t, err)
+	}
+
+	count, err := db.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestPrepareSchemaWithoutAutoMigrate(t *testing.T) {
+	schemaObjects := func(t *testing.T, db *sql.DB) int {
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&n))
+		return n
+	}
+
+	t.Run("ExistingTable", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", ":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+
+		_, err = db.Exec(`CREATE TABLE urls (id TEXT PRIMARY KEY)`)
+		require.NoError(t, err)
+		before := schemaObjects(t, db)
+
+		require.NoError(t, prepareSchema(db, false))
+
+		// No DDL ran: the Postgres migrations would fail on SQLite or add indexes
+		assert.Equal(t, before, schemaObjects(t, db))
+	})
+
+	t.Run("MissingTable", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", ":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+
+		err = prepareSchema(db, false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "urls table not found")
+		assert.Equal(t, 0, schemaObjects(t, db))
+	})
+}
+
+func TestFoldShortPaths(t *testing.T) {
+	ctx := context.Background()
+	create := func(t *testing.T, db *DB, path string) {
+		_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com/" + path})
+		require.NoError(t, err)
+	}
+
+	t.Run("LowercasesExistingPaths", func(t *testing.T) {
+		db := setupTestDB(t)
+		defer db.Close()
+		create(t, db, "Promo")
+		create(t, db, "other")
+
+		require.NoError(t, foldShortPaths(db.DB))
+
+		url, err := db.GetURLByShortPathSQLite(ctx, "promo")
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, "https://example.com/Promo", url.Destination)
+
+		// The index rejects a path differing only in case
+		mixed := "OTHER"
+		_, err = db.CreateURL(ctx, CreateURLRequest{ShortPath: &mixed, Destination: "https://example.com/x"})
+		assert.True(t, IsUniqueViolation(err))
+	})
+
+	t.Run("RefusesCaseCollisions", func(t *testing.T) {
+		db := setupTestDB(t)
+		defer db.Close()
+		create(t, db, "Foo")
+		create(t, db, "foo")
+
+		err := foldShortPaths(db.DB)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "differ only in case")
+
+		url, err := db.GetURLByShortPathSQLite(ctx, "Foo")
+		require.NoError(t, err)
+		assert.NotNil(t, url)
+	})
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	path := "taken"
+	_, err := db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com"})
+	require.NoError(t, err)
+
+	_, err = db.CreateURL(ctx, CreateURLRequest{ShortPath: &path, Destination: "https://example.com/other"})
+	require.Error(t, err)
+	assert.True(t, IsUniqueViolation(err))
+
+	assert.True(t, IsUniqueViolation(fmt.Errorf("wrapped: %w", &pq.Error{Code: "23505"})))
+	assert.False(t, IsUniqueViolation(&pq.Error{Code: "23503"}))
+	assert.False(t, IsUniqueViolation(errors.New("duplicate key value violates unique constraint")))
+	assert.False(t, IsUniqueViolation(nil))
+}
+
+func TestConfigurePool(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	configurePool(db, &config.Config{DBMaxOpenConns: 25, DBMaxIdleConns: 5, DBConnMaxLifetime: 30 * time.Minute})
+
+	assert.Equal(t, 25, db.Stats().MaxOpenConnections)
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.queryTimeout = time.Nanosecond
+	time.Sleep(time.Millisecond)
+
+	_, err := db.GetURLByID(context.Background(), uuid.New())
+	require.Error(t, err)
+	assert.True(t, IsTimeout(err))
+
+	db.queryTimeout = 0
+	_, err = db.GetURLByID(context.Background(), uuid.New())
+	assert.NoError(t, err)
+}
+
+func TestIsTimeout(t *testing.T) {
+	assert.True(t, IsTimeout(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	assert.True(t, IsTimeout(&pq.Error{Code: "57014"}))
+	assert.False(t, IsTimeout(&pq.Error{Code: "23505"}))
+	assert.False(t, IsTimeout(context.Canceled))
+	assert.False(t, IsTimeout(nil))
+}
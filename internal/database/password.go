@@ -0,0 +1,28 @@
+package database
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashURLPassword returns the bcrypt hash stored for a link password, or nil
+// when the link has none
+func hashURLPassword(password *string) (*string, error) {
+	if password == nil {
+		return nil, nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	hashed := string(hash)
+	return &hashed, nil
+}
+
+// VerifyURLPassword reports whether password unlocks the URL. URLs without a
+// password never verify.
+func VerifyURLPassword(url *URL, password string) bool {
+	if url == nil || url.PasswordHash == nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(*url.PasswordHash), []byte(password)) == nil
+}
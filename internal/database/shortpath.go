@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"url_shortener/internal/config"
+	"url_shortener/internal/sqids"
+)
+
+// nanoidAlphabet is the URL-safe alphabet of nanoid paths
+const nanoidAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// pathSeparators may appear inside a short path but not at either end
+const pathSeparators = "-_."
+
+// Sequence allocates unique, increasing numbers for sequential strategies
+type Sequence func(ctx context.Context) (int64, error)
+
+// ShortPathGenerator produces the short paths of links created without one.
+// CreateURL calls Generate again with the next attempt when a path turns out
+// to be taken.
+type ShortPathGenerator interface {
+	// Generate returns a candidate path, with the sequence number it encodes
+	// when the strategy is sequential
+	Generate(ctx context.Context, next Sequence, attempt int) (string, *int64, error)
+}
+
+// randomGenerator draws paths from an alphabet. Paths grow one character per
+// attempt, so a crowded length is soon left behind. Separators are kept off
+// the first and last characters, where custom paths may not have them either.
+type randomGenerator struct {
+	length   int
+	alphabet string
+}
+
+func (g randomGenerator) Generate(ctx context.Context, next Sequence, attempt int) (string, *int64, error) {
+	path := []rune(generateRandomString(g.length+attempt, g.alphabet))
+	if edges := withoutSeparators(g.alphabet); edges != "" {
+		path[0] = []rune(generateRandomString(1, edges))[0]
+		path[len(path)-1] = []rune(generateRandomString(1, edges))[0]
+	}
+	return string(path), nil, nil
+}
+
+// withoutSeparators returns alphabet with its separator characters removed
+func withoutSeparators(alphabet string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(pathSeparators, r) {
+			return -1
+		}
+		return r
+	}, alphabet)
+}
+
+// sequenceGenerator encodes the next number of the sequence, so generated
+// paths never collide with each other. A retry only skips a number whose path
+// was already taken by a custom path.
+type sequenceGenerator struct {
+	encode func(n uint64) string
+}
+
+func (g sequenceGenerator) Generate(ctx context.Context, next Sequence, attempt int) (string, *int64, error) {
+	seq, err := next(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return g.encode(uint64(seq)), &seq, nil
+}
+
+// newShortPathGenerator returns the generator for the configured short path
+// strategy
+func newShortPathGenerator(cfg *config.Config) (ShortPathGenerator, error) {
+	length := cfg.ShortPathLength
+	if length <= 0 {
+		length = minLength
+	}
+	alphabet := cfg.ShortPathAlphabet
+	if alphabet == "" {
+		alphabet = charset
+	}
+
+	switch cfg.ShortPathStrategy {
+	case "", "random":
+		return randomGenerator{length: length, alphabet: alphabet}, nil
+	case "nanoid":
+		return randomGenerator{length: length, alphabet: nanoidAlphabet}, nil
+	case "sequential":
+		return sequenceGenerator{encode: func(n uint64) string { return encodeBase(n, alphabet) }}, nil
+	case "sqids":
+		encoder, err := sqids.New(alphabet, cfg.ShortPathSalt, length)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sqids short path settings: %w", err)
+		}
+		return sequenceGenerator{encode: encoder.Encode}, nil
+	default:
+		return nil, fmt.Errorf("unknown short path strategy %q (must be random, nanoid, sequential or sqids)", cfg.ShortPathStrategy)
+	}
+}
+
+// reservedPathSet returns the lowercased reserved paths, including the first
+// segments of the API and health routes
+func reservedPathSet(cfg *config.Config) map[string]bool {
+	set := make(map[string]bool)
+	for _, route := range []string{cfg.APIPrefix, cfg.HealthPath} {
+		root, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+		if root != "" {
+			set[strings.ToLower(root)] = true
+		}
+	}
+	for _, path := range cfg.ReservedPaths {
+		set[strings.ToLower(path)] = true
+	}
+	return set
+}
+
+// encodeBase writes n in the positional base of alphabet, e.g. base62 with
+// the default alphabet
+func encodeBase(n uint64, alphabet string) string {
+	chars := []rune(alphabet)
+	base := uint64(len(chars))
+
+	var result []rune
+	for {
+		result = append([]rune{chars[n%base]}, result...)
+		n /= base
+		if n == 0 {
+			return string(result)
+		}
+	}
+}
@@ -22,7 +22,7 @@ func InitSQLiteDB() (*DB, error) {
 		return nil, fmt.Errorf("failed to create SQLite tables: %w", err)
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
 }
 
 // createSQLiteTables creates tables with SQLite-compatible syntax
@@ -37,12 +37,50 @@ func createSQLiteTables(db *sql.DB) error {
 		image_url TEXT,
 		expires_at DATETIME,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		destination_hash TEXT,
+		instant_referrers TEXT,
+		blocked_countries TEXT,
+		allowed_countries TEXT,
+		deleted_at DATETIME,
+		owner TEXT,
+		campaign TEXT,
+		max_uses INTEGER,
+		use_count INTEGER NOT NULL DEFAULT 0,
+		activate_at DATETIME,
+		reserved_until DATETIME,
+		last_check_status INTEGER,
+		last_checked_at DATETIME,
+		seq INTEGER UNIQUE,
+		show_qr BOOLEAN NOT NULL DEFAULT FALSE,
+		internal_note TEXT,
+		rules TEXT,
+		forward_query BOOLEAN NOT NULL DEFAULT FALSE,
+		static_params TEXT,
+		password_hash TEXT,
+		tags TEXT,
+		hide_social_tags BOOLEAN NOT NULL DEFAULT FALSE
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_urls_short_path ON urls(short_path);
 	CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls(expires_at);
 	CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls(created_at);
+	CREATE INDEX IF NOT EXISTS idx_urls_destination_hash ON urls(destination_hash);
+	CREATE INDEX IF NOT EXISTS idx_urls_last_checked_at ON urls(last_checked_at);
+
+	CREATE TABLE IF NOT EXISTS clicks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url_id TEXT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		referrer TEXT,
+		user_agent TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_clicks_url_id_created_at ON clicks(url_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS short_path_seq (
+		id INTEGER PRIMARY KEY AUTOINCREMENT
+	);
 	`
 
 	_, err := db.Exec(query)
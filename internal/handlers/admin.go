@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/database"
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RequireAdmin guards operator endpoints with the ADMIN_TOKEN bearer token.
+// When no token is configured the admin API is disabled entirely.
+func (h *Handler) RequireAdmin(c *gin.Context) {
+	if h.config.AdminToken == "" {
+		abortError(c, http.StatusForbidden, apierror.CodeAdminDisabled, "admin API is disabled")
+		return
+	}
+
+	if !h.isAdmin(c) {
+		abortError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid or missing admin token")
+		return
+	}
+
+	c.Next()
+}
+
+// isAdmin reports whether the request carries the configured admin token
+func (h *Handler) isAdmin(c *gin.Context) bool {
+	if h.config.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) == 1
+}
+
+// visibleURL returns url as the requester may see it: internal notes are
+// only shown to admins, and password hashes to no one. The URL is copied
+// rather than modified, since it may be shared with the cache.
+func (h *Handler) visibleURL(c *gin.Context, url *database.URL) *database.URL {
+	if url == nil || url.PasswordHash == nil && (url.InternalNote == nil || h.isAdmin(c)) {
+		return url
+	}
+	if !h.isAdmin(c) {
+		return publicURL(url)
+	}
+	visible := *url
+	visible.PasswordHash = nil
+	return &visible
+}
+
+// publicURL returns a copy of url without its internal note or password hash
+func publicURL(url *database.URL) *database.URL {
+	public := *url
+	public.InternalNote = nil
+	public.PasswordHash = nil
+	return &public
+}
+
+// ReloadTemplates re-parses the HTML templates from their configured paths
+// @Summary Reload HTML templates
+// @Description Re-parses the page templates from their configured paths, falling back to the built-in pages, and swaps them in atomically. On a parse error the running templates are kept and the error is returned.
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} apierror.Response
+// @Failure 403 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /admin/templates/reload [post]
+func (h *Handler) ReloadTemplates(c *gin.Context) {
+	_, span := telemetry.StartSpan(c.Request.Context(), "reload_templates")
+	defer endSpan(c, span)
+
+	pages, err := parseTemplates(h.config)
+	if err != nil {
+		span.RecordError(err)
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "Failed to parse templates: "+err.Error())
+		return
+	}
+
+	h.templates.Store(pages)
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded"})
+}
+
+// FlushCache empties the URL cache
+// @Summary Flush the URL cache
+// @Description Removes every cached URL and not-found marker from Redis, e.g. after the database was changed out of band. Keys are scanned and removed in batches so Redis is not blocked; QR codes and rate limit counters are kept. In-process L1 caches of other replicas expire on their own.
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} apierror.Response
+// @Failure 403 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /admin/cache/flush [post]
+func (h *Handler) FlushCache(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "flush_cache")
+	defer endSpan(c, span)
+
+	removed, err := h.cache.FlushURLs(ctx)
+	if err != nil {
+		span.RecordError(err)
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to flush cache")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "flushed", "removed": removed})
+}
+
+// InvalidateCachedURL drops one short path from the cache
+// @Summary Invalidate a cached URL
+// @Description Removes the cached entries of a short path: the URL by path and by ID, and any not-found marker. The next request reads the database again.
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param shortPath path string true "Short path"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} apierror.Response
+// @Failure 403 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /admin/cache/{shortPath} [delete]
+func (h *Handler) InvalidateCachedURL(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "invalidate_cached_url")
+	defer endSpan(c, span)
+
+	shortPath := normalizeShortPath(h.config, c.Param("shortPath"))
+	span.SetAttributes(attribute.String("url.short_path", shortPath))
+
+	// The ID entry can only be found through the URL, cached or stored
+	url, err := h.cache.GetURL(ctx, shortPath)
+	if err != nil {
+		span.RecordError(err)
+	}
+	if url == nil {
+		if url, err = h.db.GetURLByShortPath(ctx, shortPath); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	errs := []error{h.cache.DeleteURL(ctx, shortPath), h.cache.DeleteNotFound(ctx, shortPath)}
+	if url != nil {
+		errs = append(errs, h.cache.DeleteURLByID(ctx, url.ID.String()))
+	}
+	if err := errors.Join(errs...); err != nil {
+		span.RecordError(err)
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to invalidate cache")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "invalidated", "short_path": shortPath})
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"url_shortener/internal/config"
+	"url_shortener/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadTemplates(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	dir := t.TempDir()
+	writeTemplate := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+	handler.config.RedirectTemplatePath = writeTemplate("redirect.html", `v1`)
+	handler.config.ExpiredTemplatePath = writeTemplate("expired.html", `expired`)
+	handler.config.ComingSoonTemplatePath = writeTemplate("coming_soon.html", `soon`)
+	handler.config.DeletedTemplatePath = writeTemplate("deleted.html", `removed`)
+	handler.config.PasswordTemplatePath = writeTemplate("password.html", `password`)
+	handler.config.AdminToken = "secret"
+
+	pages, err := parseTemplates(handler.config)
+	require.NoError(t, err)
+	handler.templates.Store(pages)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/admin/templates/reload", handler.RequireAdmin, handler.ReloadTemplates)
+
+	reload := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/admin/templates/reload", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	renderRedirect := func() string {
+		var buf bytes.Buffer
+		require.NoError(t, handler.pages().redirect.Execute(&buf, nil))
+		return buf.String()
+	}
+
+	t.Run("PicksUpChanges", func(t *testing.T) {
+		writeTemplate("redirect.html", `v2`)
+
+		w := reload("secret")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"reloaded"}`, w.Body.String())
+		assert.Equal(t, "v2", renderRedirect())
+	})
+
+	t.Run("ParseErrorKeepsRunningTemplates", func(t *testing.T) {
+		writeTemplate("redirect.html", `{{ .Broken `)
+
+		w := reload("secret")
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "redirect.html")
+		assert.Equal(t, "v2", renderRedirect())
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		w := reload("")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("WrongToken", func(t *testing.T) {
+		w := reload("wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("DisabledWithoutToken", func(t *testing.T) {
+		handler.config.AdminToken = ""
+		defer func() { handler.config.AdminToken = "secret" }()
+
+		w := reload("secret")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestTemplateSources(t *testing.T) {
+	t.Run("EmbeddedByDefault", func(t *testing.T) {
+		// The tests run from the package directory, where none of the
+		// internal/templates paths exist
+		pages, err := parseTemplates(&config.Config{})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, pages.redirect.Execute(&buf, map[string]any{"Destination": "https://example.com"}))
+		assert.Contains(t, buf.String(), "https://example.com")
+		for _, page := range []*template.Template{pages.expired, pages.comingSoon, pages.deleted, pages.password} {
+			assert.NotNil(t, page)
+		}
+	})
+
+	t.Run("DevModeReparsesEachRequest", func(t *testing.T) {
+		handler, _, _ := setupTestHandler()
+		path := filepath.Join(t.TempDir(), "redirect.html")
+		require.NoError(t, os.WriteFile(path, []byte(`v1`), 0o644))
+		handler.config.RedirectTemplatePath = path
+		handler.config.TemplateDev = true
+
+		render := func() string {
+			var buf bytes.Buffer
+			require.NoError(t, handler.pages().redirect.Execute(&buf, nil))
+			return buf.String()
+		}
+		assert.Equal(t, "v1", render())
+
+		require.NoError(t, os.WriteFile(path, []byte(`v2`), 0o644))
+		assert.Equal(t, "v2", render())
+
+		// A broken edit falls back to the stored templates
+		handler.templates.Store(&pageTemplates{redirect: template.Must(template.New("").Parse(`stored`))})
+		require.NoError(t, os.WriteFile(path, []byte(`{{ .Broken `), 0o644))
+		assert.Equal(t, "stored", render())
+	})
+}
+
+func TestCacheAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func() (*gin.Engine, *MockDatabase, *MockCache) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.AdminToken = "secret"
+		router := gin.New()
+		admin := router.Group("/admin", handler.RequireAdmin)
+		admin.POST("/cache/flush", handler.FlushCache)
+		admin.DELETE("/cache/:shortPath", handler.InvalidateCachedURL)
+		return router, mockDB, mockCache
+	}
+	send := func(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Flush", func(t *testing.T) {
+		router, _, mockCache := newRouter()
+		mockCache.On("FlushURLs", mock.Anything).Return(int64(42), nil).Once()
+
+		w := send(router, "POST", "/admin/cache/flush", "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"flushed","removed":42}`, w.Body.String())
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("FlushError", func(t *testing.T) {
+		router, _, mockCache := newRouter()
+		mockCache.On("FlushURLs", mock.Anything).Return(int64(0), errors.New("connection refused"))
+
+		w := send(router, "POST", "/admin/cache/flush", "secret")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("InvalidateCachedURL", func(t *testing.T) {
+		router, mockDB, mockCache := newRouter()
+		url := &database.URL{ID: uuid.New(), ShortPath: "promo", Destination: "https://example.com"}
+		mockCache.On("GetURL", mock.Anything, "promo").Return(url, nil)
+		mockCache.On("DeleteURL", mock.Anything, "promo").Return(nil).Once()
+		mockCache.On("DeleteNotFound", mock.Anything, "promo").Return(nil).Once()
+		mockCache.On("DeleteURLByID", mock.Anything, url.ID.String()).Return(nil).Once()
+
+		w := send(router, "DELETE", "/admin/cache/promo", "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"invalidated","short_path":"promo"}`, w.Body.String())
+		mockCache.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "GetURLByShortPath", mock.Anything, mock.Anything)
+	})
+
+	t.Run("InvalidateFindsIDInDatabase", func(t *testing.T) {
+		router, mockDB, mockCache := newRouter()
+		url := &database.URL{ID: uuid.New(), ShortPath: "promo", Destination: "https://example.com"}
+		mockCache.On("GetURL", mock.Anything, "promo").Return(nil, nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, "promo").Return(url, nil)
+		mockCache.On("DeleteURL", mock.Anything, "promo").Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, "promo").Return(nil)
+		mockCache.On("DeleteURLByID", mock.Anything, url.ID.String()).Return(nil).Once()
+
+		w := send(router, "DELETE", "/admin/cache/promo", "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("RequiresToken", func(t *testing.T) {
+		router, _, mockCache := newRouter()
+
+		assert.Equal(t, http.StatusUnauthorized, send(router, "POST", "/admin/cache/flush", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, send(router, "DELETE", "/admin/cache/promo", "wrong").Code)
+		mockCache.AssertNotCalled(t, "FlushURLs", mock.Anything)
+	})
+}
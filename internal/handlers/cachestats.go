@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"url_shortener/internal/database"
+	"url_shortener/internal/l1cache"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Cache levels a URL read can be served from
+const (
+	cacheLevelL1    = "l1"
+	cacheLevelRedis = "redis"
+	cacheLevelMiss  = "miss"
+)
+
+// cacheCounters counts the URL cache reads of one lookup site
+type cacheCounters struct {
+	l1Hits    atomic.Int64
+	redisHits atomic.Int64
+	misses    atomic.Int64
+}
+
+// cacheStats counts cache reads since the process started. They are
+// package-level so every handler in the process reports into the same totals.
+var cacheStats struct {
+	redirect cacheCounters
+	getURL   cacheCounters
+}
+
+// readCache runs a cache read, counting it and recording on the span whether
+// it hit and at which level
+func readCache(ctx context.Context, counters *cacheCounters, read func(context.Context) (*database.URL, error)) (*database.URL, error) {
+	readCtx, fromL1 := l1cache.WithHitReport(ctx)
+	url, err := read(readCtx)
+
+	level := cacheLevelMiss
+	switch {
+	case url == nil:
+		counters.misses.Add(1)
+	case *fromL1:
+		level = cacheLevelL1
+		counters.l1Hits.Add(1)
+	default:
+		level = cacheLevelRedis
+		counters.redisHits.Add(1)
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if err != nil {
+		span.RecordError(err)
+	}
+	attrs := []attribute.KeyValue{attribute.Bool("cache.hit", url != nil), attribute.String("cache.level", level)}
+	span.SetAttributes(attrs...)
+	span.AddEvent("cache_lookup", trace.WithAttributes(attrs...))
+	return url, err
+}
+
+// CacheSiteStats are the cache read counts of one lookup site
+type CacheSiteStats struct {
+	L1Hits    int64   `json:"l1_hits" example:"9000"`
+	RedisHits int64   `json:"redis_hits" example:"800"`
+	Misses    int64   `json:"misses" example:"200"`
+	HitRatio  float64 `json:"hit_ratio" example:"0.98"`
+}
+
+// CacheStatsResponse reports cache effectiveness since the process started
+type CacheStatsResponse struct {
+	Redirect CacheSiteStats `json:"redirect"`
+	GetURL   CacheSiteStats `json:"get_url"`
+}
+
+func (s *cacheCounters) stats() CacheSiteStats {
+	stats := CacheSiteStats{L1Hits: s.l1Hits.Load(), RedisHits: s.redisHits.Load(), Misses: s.misses.Load()}
+	if total := stats.L1Hits + stats.RedisHits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.L1Hits+stats.RedisHits) / float64(total)
+	}
+	return stats
+}
+
+// CacheStats reports the URL cache hit counts of this process
+// @Summary Cache statistics
+// @Description URL cache reads by redirects and GET /urls/{id} since this process started, split into in-process (L1) hits, Redis hits and misses. Counts are per replica.
+// @Tags debug
+// @Produce json
+// @Success 200 {object} CacheStatsResponse
+// @Router /debug/cache-stats [get]
+func (h *Handler) CacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, CacheStatsResponse{
+		Redirect: cacheStats.redirect.stats(),
+		GetURL:   cacheStats.getURL.stats(),
+	})
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/clientip"
+	"url_shortener/internal/database"
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxClickBuckets caps the number of buckets one time series request may span
+const maxClickBuckets = 1000
+
+// Background click writing: clickWriters goroutines drain a queue of up to
+// clickQueueSize clicks, and clicks arriving while it is full are dropped
+const (
+	clickWriters   = 2
+	clickQueueSize = 10000
+)
+
+// errClickDropped is recorded on the redirect span when the click queue is full
+var errClickDropped = errors.New("click queue full, click dropped")
+
+// startClickWriter moves click recording off the redirect path
+func (h *Handler) startClickWriter() {
+	h.clickQueue = make(chan database.Click, clickQueueSize)
+	for i := 0; i < clickWriters; i++ {
+		go func() {
+			for click := range h.clickQueue {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := h.db.RecordClick(ctx, click); err != nil {
+					log.Printf("Error recording click for %s: %v", click.URLID, err)
+				}
+				cancel()
+			}
+		}()
+	}
+}
+
+// recordClick queues a click for the background writer, or writes it inline
+// when the writer is not running
+func (h *Handler) recordClick(ctx context.Context, click database.Click) {
+	span := trace.SpanFromContext(ctx)
+	if h.clickQueue == nil {
+		if err := h.db.RecordClick(ctx, click); err != nil {
+			span.RecordError(err)
+		}
+		return
+	}
+
+	select {
+	case h.clickQueue <- click:
+	default:
+		span.RecordError(errClickDropped)
+	}
+}
+
+// ClickStats handles the analytics summary of a URL
+// @Summary Click statistics
+// @Description Total clicks of a URL, clicks over the last 24 hours, 7 days and 30 days, and its 10 most common referrer hosts and user agents. Clicks are written in the background, so the most recent visits may take a moment to appear.
+// @Tags urls
+// @Produce json
+// @Param id path string true "URL ID" format(uuid)
+// @Success 200 {object} database.ClickStats
+// @Failure 400 {object} apierror.Response
+// @Failure 404 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /urls/{id}/stats [get]
+func (h *Handler) ClickStats(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "click_stats")
+	defer endSpan(c, span)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidURLID, "invalid URL ID")
+		return
+	}
+	span.SetAttributes(attribute.String("url.id", id.String()))
+
+	url, err := h.db.GetURLByID(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		respondDBError(c, err, "failed to get URL")
+		return
+	}
+	if url == nil {
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+		return
+	}
+
+	stats, err := h.db.ClickStats(ctx, id, time.Now())
+	if err != nil {
+		span.RecordError(err)
+		respondDBError(c, err, "failed to get clicks")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// ClickTimeSeries handles bucketed click counts for a URL
+// @Summary Clicks time series
+// @Description Click counts of a URL bucketed per hour or day over [from, to). Buckets without clicks are included with a zero count. Defaults to the last 30 days per day, or the last 24 hours per hour.
+// @Tags urls
+// @Produce json
+// @Param id path string true "URL ID" format(uuid)
+// @Param from query string false "Start of the range (RFC 3339), rounded down to the bucket start"
+// @Param to query string false "End of the range (RFC 3339, exclusive; default: now)"
+// @Param interval query string false "Bucket width: hour or day (default: day)"
+// @Success 200 {object} database.ClickTimeSeries
+// @Failure 400 {object} apierror.Response
+// @Failure 404 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /urls/{id}/clicks/timeseries [get]
+func (h *Handler) ClickTimeSeries(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "click_timeseries")
+	defer endSpan(c, span)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidURLID, "invalid URL ID")
+		return
+	}
+	span.SetAttributes(attribute.String("url.id", id.String()))
+
+	interval, err := database.ParseClickInterval(c.Query("interval"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	to := time.Now()
+	if s := c.Query("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "to must be an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	from := to.Add(-30 * 24 * time.Hour)
+	if interval == database.IntervalHour {
+		from = to.Add(-24 * time.Hour)
+	}
+	if s := c.Query("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "from must be an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	if !from.Before(to) {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "from must be before to")
+		return
+	}
+	step := interval.Duration()
+	if buckets := (to.Sub(interval.Truncate(from)) + step - 1) / step; buckets > maxClickBuckets {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("range spans more than %d %s buckets", maxClickBuckets, interval))
+		return
+	}
+
+	url, err := h.db.GetURLByID(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		respondDBError(c, err, "failed to get URL")
+		return
+	}
+	if url == nil {
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+		return
+	}
+
+	series, err := h.db.ClickTimeSeries(ctx, id, from, to, interval)
+	if err != nil {
+		span.RecordError(err)
+		respondDBError(c, err, "failed to get clicks")
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+// ClickEvent is posted to CLICK_WEBHOOK_URL for every visit that reaches the destination
+type ClickEvent struct {
+	ShortPath   string    `json:"short_path" example:"abc123"`
+	Destination string    `json:"destination" example:"https://example.com"`
+	Timestamp   time.Time `json:"timestamp" example:"2024-01-01T12:00:00Z"`
+	UserAgent   string    `json:"user_agent" example:"Mozilla/5.0"`
+	IP          string    `json:"ip" example:"203.0.113.7"`
+	Referrer    string    `json:"referrer" example:"https://news.example.com/"`
+}
+
+// notifyClick queues a click event for the webhook, if one is configured.
+// Delivery happens in the background and a full queue drops the event.
+func (h *Handler) notifyClick(ctx context.Context, c *gin.Context, url *database.URL, destination string) {
+	if h.clickWebhook == nil {
+		return
+	}
+
+	sent := h.clickWebhook.Send(ClickEvent{
+		ShortPath:   url.ShortPath,
+		Destination: destination,
+		Timestamp:   time.Now().UTC(),
+		UserAgent:   c.Request.UserAgent(),
+		IP:          clientip.IP(c),
+		Referrer:    c.Request.Referer(),
+	})
+	if !sent {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("webhook.dropped", true))
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deprecation describes a superseded endpoint or mode that still works but is
+// announced to clients through response headers (RFC 8594)
+type deprecation struct {
+	// sunset is when the endpoint may stop working; zero omits the Sunset header
+	sunset time.Time
+
+	// successor is the replacement clients should move to, linked with
+	// rel="successor-version" when set
+	successor string
+
+	// message is sent as a Warning header
+	message string
+}
+
+// apply sets the deprecation headers on the response
+func (d deprecation) apply(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	if !d.sunset.IsZero() {
+		c.Header("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.successor != "" {
+		c.Header("Link", "<"+d.successor+">; rel=\"successor-version\"")
+	}
+	if d.message != "" {
+		c.Header("Warning", "299 - "+strconv.Quote(d.message))
+	}
+}
+
+// offsetPaginationDeprecation returns the notice for page-based listing, or
+// false when offset pagination is not flagged as deprecated
+func (h *Handler) offsetPaginationDeprecation() (deprecation, bool) {
+	if !h.config.DeprecateOffsetPagination {
+		return deprecation{}, false
+	}
+	return deprecation{
+		sunset:    h.config.OffsetPaginationSunset,
+		successor: h.config.APIPrefix + "/urls?cursor=",
+		message:   "offset pagination is deprecated, use cursor pagination instead",
+	}, true
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/database"
+	"url_shortener/internal/linkcheck"
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errHTTPSRequired rejects destinations under REQUIRE_HTTPS_DESTINATION
+var errHTTPSRequired = errors.New("destination must use https")
+
+// errSelfDestination rejects destinations on the shortener's own host
+var errSelfDestination = errors.New("destination must not point at this shortener")
+
+// destinationClient probes destinations before upgrading them to https. Like
+// metadataClient it only connects to public addresses.
+var destinationClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         publicDialContext(5 * time.Second),
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     30 * time.Second,
+	},
+	// The probe only needs the https origin to answer, not to follow it anywhere
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// enforceHTTPS applies REQUIRE_HTTPS_DESTINATION and returns the destination
+// to store. https destinations pass unchanged; http ones are upgraded when
+// UPGRADE_HTTP_DESTINATION is set and the https version is reachable. Anything
+// else is rejected.
+func (h *Handler) enforceHTTPS(ctx context.Context, destination string) (string, error) {
+	if !h.config.RequireHTTPSDestination {
+		return destination, nil
+	}
+
+	u, err := neturl.Parse(destination)
+	if err != nil || u.Host == "" {
+		return "", errHTTPSRequired
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return destination, nil
+	case "http":
+		if !h.config.UpgradeHTTPDestination {
+			return "", errHTTPSRequired
+		}
+		u.Scheme = "https"
+		if !reachable(ctx, u.String()) {
+			return "", errors.New("destination must use https and its https version is not reachable")
+		}
+		return u.String(), nil
+	default:
+		return "", errHTTPSRequired
+	}
+}
+
+// reachable reports whether url answers without a server error
+func reachable(ctx context.Context, url string) bool {
+	status := linkcheck.Status(ctx, destinationClient, url)
+	return status != 0 && status < http.StatusInternalServerError
+}
+
+// checkURLLength rejects a destination or image URL longer than
+// MAX_DESTINATION_LEN
+func (h *Handler) checkURLLength(field, value string) error {
+	if limit := h.config.MaxDestinationLen; limit > 0 && len(value) > limit {
+		return fmt.Errorf("%s must be at most %d bytes", field, limit)
+	}
+	return nil
+}
+
+// checkSelfDestination rejects destinations on the PUBLIC_BASE_URL host unless
+// ALLOW_SELF_DESTINATION is set, since they can lead back to short links
+func (h *Handler) checkSelfDestination(destination string) error {
+	if h.config.AllowSelfDestination || h.config.PublicBaseURL == "" {
+		return nil
+	}
+	if hostOf(destination) == hostOf(h.config.PublicBaseURL) {
+		return errSelfDestination
+	}
+	return nil
+}
+
+// loopsBack reports whether redirecting to destination would come back to
+// the shortener, judged by the request host as well as PUBLIC_BASE_URL so
+// links stored before either was configured are caught too. With
+// ALLOW_SELF_DESTINATION only a destination naming the link itself counts.
+func (h *Handler) loopsBack(c *gin.Context, url *database.URL, destination string) bool {
+	u, err := neturl.Parse(destination)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	host := comparableHost(u.Host)
+	if host != comparableHost(c.Request.Host) && host != hostOf(h.config.PublicBaseURL) {
+		return false
+	}
+	if !h.config.AllowSelfDestination {
+		return true
+	}
+	return normalizeShortPath(h.config, strings.Trim(u.Path, "/")) == url.ShortPath
+}
+
+// hostOf returns the comparable host of rawURL, or "" when it has none
+func hostOf(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return comparableHost(u.Host)
+}
+
+// comparableHost lowercases host and drops a default port
+func comparableHost(host string) string {
+	host = strings.ToLower(host)
+	for _, port := range []string{":80", ":443"} {
+		host = strings.TrimSuffix(host, port)
+	}
+	return host
+}
+
+// maxStaticParams caps the static query parameters on one URL
+const maxStaticParams = 20
+
+// validateStaticParams checks the static query parameters of a URL
+func validateStaticParams(params map[string]string) error {
+	if len(params) > maxStaticParams {
+		return fmt.Errorf("at most %d static_params are allowed", maxStaticParams)
+	}
+	for name := range params {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("static_params names must not be empty")
+		}
+	}
+	return nil
+}
+
+// withQuery adds the URL's static params and, when it forwards its query, the
+// visitor's query parameters to destination. Forwarded parameters replace
+// same-named ones already in the destination, and static params replace both,
+// so a link's fixed attribution cannot be overridden from the short link.
+func withQuery(destination string, url *database.URL, visitorQuery neturl.Values) string {
+	forward := url.ForwardQuery && len(visitorQuery) > 0
+	if !forward && len(url.StaticParams) == 0 {
+		return destination
+	}
+
+	u, err := neturl.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	query := u.Query()
+	if forward {
+		for name, values := range visitorQuery {
+			query[name] = values
+		}
+	}
+	for name, value := range url.StaticParams {
+		query.Set(name, value)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// BrokenURLs lists links whose destination failed its last health check
+// @Summary Broken links
+// @Description Links whose destination was unreachable or answered 4xx/5xx at the last health check, most clicked first. format=text returns a plain-text report with one "short_path status clicks destination" line per link, for cron emails.
+// @Tags urls
+// @Produce json,plain
+// @Param format query string false "Response format: json or text (default: json)"
+// @Param limit query int false "Maximum links returned (default: 100, max: 1000)"
+// @Success 200 {array} database.BrokenURL
+// @Failure 400 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /urls/broken [get]
+func (h *Handler) BrokenURLs(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "broken_urls")
+	defer endSpan(c, span)
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "text" {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "format must be json or text")
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	broken, err := h.db.ListBrokenURLs(ctx, limit)
+	if err != nil {
+		span.RecordError(err)
+		respondDBError(c, err, "failed to list broken URLs")
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, broken)
+		return
+	}
+
+	var report strings.Builder
+	for _, b := range broken {
+		status := strconv.Itoa(b.Status)
+		if b.Status == 0 {
+			status = "unreachable"
+		}
+		fmt.Fprintf(&report, "%s\t%s\t%d clicks\t%s\n", b.ShortPath, status, b.Clicks, b.Destination)
+	}
+	c.String(http.StatusOK, report.String())
+}
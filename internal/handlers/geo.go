@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"url_shortener/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// visitorCountry returns the visitor's ISO 3166-1 alpha-2 country from the
+// configured header, or "" when it is not configured or not a real country
+func (h *Handler) visitorCountry(c *gin.Context) string {
+	if h.config.CountryHeader == "" {
+		return ""
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(c.GetHeader(h.config.CountryHeader)))
+	// Proxies use XX for unknown and T1 for Tor
+	if !isCountryCode(country) || country == "XX" || country == "T1" {
+		return ""
+	}
+	return country
+}
+
+// isGeoBlocked reports whether the URL is unavailable in the visitor's country.
+// A URL with allowed_countries is blocked for unknown countries, while
+// blocked_countries alone never blocks an unknown country.
+func (h *Handler) isGeoBlocked(c *gin.Context, url *database.URL) bool {
+	if len(url.BlockedCountries) == 0 && len(url.AllowedCountries) == 0 {
+		return false
+	}
+
+	country := h.visitorCountry(c)
+	if len(url.AllowedCountries) > 0 && (country == "" || !containsFold(url.AllowedCountries, country)) {
+		return true
+	}
+	return country != "" && containsFold(url.BlockedCountries, country)
+}
+
+// validateCountryCodes checks that every entry is an ISO 3166-1 alpha-2 code
+func validateCountryCodes(field string, codes []string) error {
+	for _, code := range codes {
+		if !isCountryCode(strings.ToUpper(code)) {
+			return fmt.Errorf("invalid %s entry %q: must be a two-letter country code", field, code)
+		}
+	}
+	return nil
+}
+
+func isCountryCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,28 +2,58 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	neturl "net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/clientip"
 	"url_shortener/internal/config"
 	"url_shortener/internal/database"
+	"url_shortener/internal/singleflight"
 	"url_shortener/internal/telemetry"
+	"url_shortener/internal/templates"
+	"url_shortener/internal/webhook"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Database interface for dependency injection
 type Database interface {
 	CreateURL(ctx context.Context, req database.CreateURLRequest) (*database.URL, error)
+	ReserveShortPath(ctx context.Context, shortPath string, until time.Time) (*database.URL, error)
 	GetURLByID(ctx context.Context, id uuid.UUID) (*database.URL, error)
 	GetURLByShortPath(ctx context.Context, shortPath string) (*database.URL, error)
-	ListURLs(ctx context.Context, page, limit int) (*database.ListURLsResponse, error)
+	GetExpiredURLByShortPath(ctx context.Context, shortPath string) (*database.URL, error)
+	GetDeletedURLByShortPath(ctx context.Context, shortPath string, deletedAfter time.Time) (*database.URL, error)
+	GetURLByDestination(ctx context.Context, destination string, owner *string) (*database.URL, error)
+	GetURLsByShortPaths(ctx context.Context, shortPaths []string) (map[string]*database.URL, error)
+	GetURLsByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*database.URL, error)
+	ListURLs(ctx context.Context, page, limit int, status database.URLStatus) (*database.ListURLsResponse, error)
+	SearchURLs(ctx context.Context, query string, page, limit int, status database.URLStatus, tag string) (*database.ListURLsResponse, error)
+	ShortPathCapacity(ctx context.Context) (*database.ShortPathCapacity, error)
+	ListURLsAfter(ctx context.Context, query string, after *database.Cursor, limit int, status database.URLStatus, tag string) (*database.ListURLsResponse, error)
 	UpdateURL(ctx context.Context, id uuid.UUID, req database.UpdateURLRequest) (*database.URL, error)
+	StreamAllURLs(ctx context.Context, fn func(url database.URL, clicks int) error) error
 	DeleteURL(ctx context.Context, id uuid.UUID) error
+	HardDeleteURL(ctx context.Context, id uuid.UUID) error
+	RestoreURL(ctx context.Context, id uuid.UUID) (*database.URL, error)
+	ConsumeURLUse(ctx context.Context, id uuid.UUID) (*database.URL, error)
+	RecordClick(ctx context.Context, click database.Click) error
+	ClickStats(ctx context.Context, urlID uuid.UUID, now time.Time) (*database.ClickStats, error)
+	ClickTimeSeries(ctx context.Context, urlID uuid.UUID, from, to time.Time, interval database.ClickInterval) (*database.ClickTimeSeries, error)
+	ListBrokenURLs(ctx context.Context, limit int) ([]database.BrokenURL, error)
 	PingContext(ctx context.Context) error
 }
 
@@ -32,9 +62,16 @@ type Cache interface {
 	GetURL(ctx context.Context, shortPath string) (*database.URL, error)
 	SetURL(ctx context.Context, shortPath string, url *database.URL) error
 	DeleteURL(ctx context.Context, shortPath string) error
+	SetExpiredURL(ctx context.Context, shortPath string, url *database.URL) error
 	GetURLByID(ctx context.Context, id string) (*database.URL, error)
 	SetURLByID(ctx context.Context, id string, url *database.URL) error
 	DeleteURLByID(ctx context.Context, id string) error
+	IsNotFound(ctx context.Context, shortPath string) (bool, error)
+	SetNotFound(ctx context.Context, shortPath string) error
+	DeleteNotFound(ctx context.Context, shortPath string) error
+	FlushURLs(ctx context.Context) (int64, error)
+	GetQRCode(ctx context.Context, key string) ([]byte, error)
+	SetQRCode(ctx context.Context, key string, data []byte) error
 	Ping(ctx context.Context) error
 }
 
@@ -42,109 +79,426 @@ type Handler struct {
 	db     Database
 	cache  Cache
 	config *config.Config
-	tmpl   *template.Template
+
+	// templates holds the parsed HTML pages; it is replaced as a whole when
+	// the templates are reloaded
+	templates atomic.Pointer[pageTemplates]
+
+	// lookups shares database reads among concurrent cache misses for the same URL
+	lookups singleflight.Group[*database.URL]
+
+	// cookieKey signs the cookies that unlock password-protected links
+	cookieKey []byte
+
+	// clickWebhook is notified of every redirect; nil when CLICK_WEBHOOK_URL is unset
+	clickWebhook ClickNotifier
+
+	// clickQueue feeds the background click writer; nil writes clicks inline
+	clickQueue chan database.Click
+}
+
+// ClickNotifier delivers click events without blocking, reporting false when
+// an event was dropped
+type ClickNotifier interface {
+	Send(event any) bool
+}
+
+// pageTemplates are the HTML pages rendered by the handlers. The expired and
+// coming-soon pages are optional; when nil a JSON error is returned instead.
+type pageTemplates struct {
+	redirect   *template.Template
+	expired    *template.Template
+	comingSoon *template.Template
+	deleted    *template.Template
+	password   *template.Template
+}
+
+// parseTemplates parses every page template from its configured path, or the
+// built-in page when no path is configured
+func parseTemplates(cfg *config.Config) (*pageTemplates, error) {
+	redirect, err := parseTemplate(cfg.RedirectTemplatePath, "redirect.html")
+	if err != nil {
+		return nil, err
+	}
+	expired, err := parseTemplate(cfg.ExpiredTemplatePath, "expired.html")
+	if err != nil {
+		return nil, err
+	}
+	comingSoon, err := parseTemplate(cfg.ComingSoonTemplatePath, "coming_soon.html")
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := parseTemplate(cfg.DeletedTemplatePath, "deleted.html")
+	if err != nil {
+		return nil, err
+	}
+	password, err := parseTemplate(cfg.PasswordTemplatePath, "password.html")
+	if err != nil {
+		return nil, err
+	}
+	return &pageTemplates{redirect: redirect, expired: expired, comingSoon: comingSoon, deleted: deleted, password: password}, nil
+}
+
+// parseTemplate parses the template file at path, or the embedded page name
+// when path is empty
+func parseTemplate(path, name string) (*template.Template, error) {
+	if path == "" {
+		return template.ParseFS(templates.FS, name)
+	}
+	return template.ParseFiles(path)
 }
 
 func New(db Database, cache Cache, cfg *config.Config) *Handler {
-	// Parse HTML template
-	tmpl := template.Must(template.ParseFiles("internal/templates/redirect.html"))
+	// Parse HTML templates
+	pages, err := parseTemplates(cfg)
+	if err != nil {
+		panic(err)
+	}
 
-	return &Handler{
-		db:     db,
-		cache:  cache,
-		config: cfg,
-		tmpl:   tmpl,
+	h := &Handler{
+		db:        db,
+		cache:     cache,
+		config:    cfg,
+		cookieKey: passwordCookieKey(cfg),
+	}
+	if cfg.ClickTracking {
+		h.startClickWriter()
+	}
+	if cfg.ClickWebhookURL != "" {
+		h.clickWebhook = webhook.New(cfg.ClickWebhookURL, cfg.ClickWebhookSecret, cfg.ClickWebhookWorkers, cfg.ClickWebhookQueueSize)
 	}
+	h.templates.Store(pages)
+	return h
 }
 
 // NewWithTemplate creates a handler with optional template (for testing)
 func NewWithTemplate(db Database, cache Cache, cfg *config.Config, tmpl *template.Template) *Handler {
-	return &Handler{
-		db:     db,
-		cache:  cache,
-		config: cfg,
-		tmpl:   tmpl,
+	h := &Handler{
+		db:        db,
+		cache:     cache,
+		config:    cfg,
+		cookieKey: passwordCookieKey(cfg),
 	}
+	h.templates.Store(&pageTemplates{redirect: tmpl})
+	return h
+}
+
+// pages returns the current page templates. With TEMPLATE_DEV they are
+// re-parsed on every call, so edits show up on the next request; a template
+// that fails to parse is logged and the last good ones are used.
+func (h *Handler) pages() *pageTemplates {
+	if h.config.TemplateDev {
+		pages, err := parseTemplates(h.config)
+		if err == nil {
+			return pages
+		}
+		log.Printf("Error parsing templates: %v", err)
+	}
+	if pages := h.templates.Load(); pages != nil {
+		return pages
+	}
+	return &pageTemplates{}
+}
+
+// ShortPathCapacity reports how full the generated short path space is
+// @Summary Short path capacity
+// @Description Key space for the generated path length and alphabet, current URL count, the chance a new path collides, and the recommended length
+// @Tags debug
+// @Produce json
+// @Success 200 {object} database.ShortPathCapacity
+// @Failure 500 {object} apierror.Response
+// @Router /debug/shortpath-capacity [get]
+func (h *Handler) ShortPathCapacity(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "shortpath_capacity")
+	defer endSpan(c, span)
+
+	capacity, err := h.db.ShortPathCapacity(ctx)
+	if err != nil {
+		span.RecordError(err)
+		respondDBError(c, err, "failed to count URLs")
+		return
+	}
+
+	c.JSON(http.StatusOK, capacity)
+}
+
+// Dependency states in the health check. A dependency is degraded when its
+// ping succeeds but takes longer than HEALTH_LATENCY_THRESHOLD.
+const (
+	dependencyUp       = "up"
+	dependencyDegraded = "degraded"
+	dependencyDown     = "down"
+)
+
+// DependencyHealth is the result of pinging one dependency
+type DependencyHealth struct {
+	Status    string  `json:"status" example:"up"`
+	LatencyMS float64 `json:"latency_ms" example:"1.25"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthResponse is the health check body. Status is healthy, degraded or
+// unhealthy, following the worst dependency.
+type HealthResponse struct {
+	Status   string           `json:"status" example:"healthy"`
+	Database DependencyHealth `json:"database"`
+	Redis    DependencyHealth `json:"redis"`
 }
 
 // HealthCheck handles the health check endpoint
 // @Summary Health check
-// @Description Check the health status of the service
+// @Description Check the health status of the service. Reports each dependency's ping latency; a slow dependency is degraded, a failing one down.
 // @Tags health
 // @Accept json
 // @Produce json
-// @Success 200 {object} map[string]string
-// @Failure 503 {object} map[string]string
+// @Success 200 {object} HealthResponse "healthy, or degraded when a dependency is slow or Redis is down but not required"
+// @Failure 503 {object} HealthResponse
 // @Router /health [get]
 func (h *Handler) HealthCheck(c *gin.Context) {
 	ctx, span := telemetry.StartSpan(c.Request.Context(), "health_check")
-	defer span.End()
+	defer endSpan(c, span)
 
 	// Add timeout to context for health checks
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// Check database connection
-	if err := h.db.PingContext(ctx); err != nil {
-		span.RecordError(err)
-		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "error": "database connection failed"})
-		return
+	response := HealthResponse{
+		Status:   "healthy",
+		Database: h.checkDependency(ctx, "database", h.db.PingContext),
+		Redis:    h.checkDependency(ctx, "redis", h.cache.Ping),
 	}
 
-	// Check Redis connection
-	if err := h.cache.Ping(ctx); err != nil {
-		span.RecordError(err)
-		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "error": "redis connection failed"})
-		return
+	// The service can run DB-only unless Redis is required
+	status := http.StatusOK
+	switch {
+	case response.Database.Status == dependencyDown,
+		response.Redis.Status == dependencyDown && h.config.RedisRequired:
+		response.Status = "unhealthy"
+		status = http.StatusServiceUnavailable
+	case response.Database.Status != dependencyUp, response.Redis.Status != dependencyUp:
+		response.Status = "degraded"
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	c.JSON(status, response)
+}
+
+// checkDependency pings one dependency and times it
+func (h *Handler) checkDependency(ctx context.Context, name string, ping func(context.Context) error) DependencyHealth {
+	start := time.Now()
+	err := ping(ctx)
+	elapsed := time.Since(start)
+
+	health := DependencyHealth{
+		Status:    dependencyUp,
+		LatencyMS: float64(elapsed.Microseconds()) / 1000,
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Float64("health."+name+".latency_ms", health.LatencyMS))
+
+	switch {
+	case err != nil:
+		trace.SpanFromContext(ctx).RecordError(err)
+		health.Status = dependencyDown
+		health.Error = name + " connection failed"
+	case h.config.HealthLatencyThreshold > 0 && elapsed > h.config.HealthLatencyThreshold:
+		health.Status = dependencyDegraded
+	}
+	return health
 }
 
 // CreateURL handles URL creation
 // @Summary Create a new short URL
-// @Description Create a new short URL with optional custom path and metadata
+// @Description Create a new short URL with optional custom path and metadata. When unique destinations are enforced, an existing link to the same destination is returned instead.
 // @Tags urls
 // @Accept json
 // @Produce json
-// @Param url body database.CreateURLRequest true "URL creation request"
-// @Success 201 {object} database.URL
-// @Failure 400 {object} map[string]string
-// @Failure 409 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Param url body CreateURLWithQRRequest true "URL creation request"
+// @Param with_qr query bool false "Also return a QR code of the short URL as base64 in qr_code, like include_qr"
+// @Param auto_metadata query bool false "Fill unset title, description and image_url from the destination's Open Graph tags, like auto_metadata in the body"
+// @Success 200 {object} URLWithQR "Existing link to the same destination (unique destinations mode)"
+// @Success 201 {object} URLWithQR "The URL, with qr_code when requested"
+// @Header 201 {string} Location "API path of the created URL"
+// @Header 201 {string} Link "The short URL, as rel=shortlink"
+// @Failure 400 {object} apierror.Response
+// @Failure 409 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
 // @Router /urls [post]
 func (h *Handler) CreateURL(c *gin.Context) {
 	ctx, span := telemetry.StartSpan(c.Request.Context(), "create_url")
-	defer span.End()
+	defer endSpan(c, span)
+
+	var req CreateURLWithQRRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+	if autoMetadata, _ := strconv.ParseBool(c.Query("auto_metadata")); autoMetadata {
+		req.AutoMetadata = true
+	}
+
+	url, status, createErr := h.createURL(ctx, req.CreateURLRequest)
+	if createErr != nil {
+		apierror.Write(c, status, *createErr)
+		return
+	}
+
+	span.SetAttributes(urlAttributes(url)...)
+
+	// A 200 means an existing link was returned, so nothing was created
+	if status == http.StatusCreated {
+		c.Header("Location", h.config.APIPrefix+"/urls/"+url.ID.String())
+		c.Header("Link", "<"+h.shortURL(c, url.ShortPath)+">; rel=\"shortlink\"")
+	}
 
-	var req database.CreateURLRequest
+	withQR, _ := strconv.ParseBool(c.Query("with_qr"))
+	if !withQR && !req.IncludeQR {
+		c.JSON(status, h.visibleURL(c, url))
+		return
+	}
+	c.JSON(status, h.urlWithQR(ctx, c, url, req.QROptions))
+}
+
+// ReserveURL handles holding a short path before its destination is known
+// @Summary Reserve a short path
+// @Description Hold a short path without a destination. Until a destination is set with PUT /urls/{id} the path shows a coming-soon page; unfilled holds lapse after the reservation TTL.
+// @Tags urls
+// @Accept json
+// @Produce json
+// @Param reservation body database.ReserveURLRequest true "Short path to reserve"
+// @Success 201 {object} database.URL
+// @Failure 400 {object} apierror.Response
+// @Failure 409 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /urls/reserve [post]
+func (h *Handler) ReserveURL(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "reserve_url")
+	defer endSpan(c, span)
+
+	var req database.ReserveURLRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 		return
 	}
 
+	req.ShortPath = normalizeShortPath(h.config, req.ShortPath)
+	if err := ValidateShortPath(h.config, req.ShortPath); err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidShortPath, err.Error())
+		return
+	}
+
+	url, err := h.db.ReserveShortPath(ctx, req.ShortPath, time.Now().Add(h.config.ReservationTTL))
+	if err != nil {
+		span.RecordError(err)
+		if database.IsUniqueViolation(err) {
+			respondError(c, http.StatusConflict, apierror.CodeShortPathTaken, "short path already exists")
+			return
+		}
+		respondDBError(c, err, "failed to reserve short path")
+		return
+	}
+
+	span.SetAttributes(urlAttributes(url)...)
+
+	// The path may have been looked up before it was reserved
+	if err := h.cache.DeleteNotFound(ctx, url.ShortPath); err != nil {
+		span.RecordError(err)
+	}
+
+	c.JSON(http.StatusCreated, url)
+}
+
+// createURL validates and stores a new short URL and warms the cache. It returns
+// the status to respond with and, when creation failed, the error.
+func (h *Handler) createURL(ctx context.Context, req database.CreateURLRequest) (*database.URL, int, *apierror.Error) {
+	span := trace.SpanFromContext(ctx)
+
 	// Validate short path if provided
+	if req.ShortPath != nil {
+		shortPath := normalizeShortPath(h.config, *req.ShortPath)
+		req.ShortPath = &shortPath
+	}
 	if req.ShortPath != nil && *req.ShortPath != "" {
-		if !isValidShortPath(*req.ShortPath) {
-			if isReservedPath(*req.ShortPath) {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "short path is reserved and cannot be used"})
-			} else {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid short path format"})
-			}
-			return
+		if err := ValidateShortPath(h.config, *req.ShortPath); err != nil {
+			return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidShortPath, Message: err.Error()}
+		}
+	}
+	if err := validateCountryCodes("blocked_countries", req.BlockedCountries); err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+	}
+	if err := validateCountryCodes("allowed_countries", req.AllowedCountries); err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+	}
+	req.Tags = tags
+	if err := h.validateExpiresAt(req.ExpiresAt); err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+	}
+	if req.ActivateAt != nil && req.ExpiresAt != nil && !req.ActivateAt.Before(*req.ExpiresAt) {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: "activate_at must be before expires_at"}
+	}
+	if req.MaxUses != nil && *req.MaxUses < 0 {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: "max_uses must not be negative"}
+	}
+	if err := h.checkURLLength("destination", req.Destination); err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidDestination, Message: err.Error()}
+	}
+	if req.ImageURL != nil {
+		if err := h.checkURLLength("image_url", *req.ImageURL); err != nil {
+			return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
 		}
 	}
 
+	destination, err := h.enforceHTTPS(ctx, req.Destination)
+	if err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidDestination, Message: err.Error()}
+	}
+	if err := h.checkSelfDestination(destination); err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidDestination, Message: err.Error()}
+	}
+	req.Destination = destination
+	if err := h.prepareRules(ctx, req.Rules); err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+	}
+	if err := validateStaticParams(req.StaticParams); err != nil {
+		return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+	}
+	if req.Password != nil {
+		if err := validatePassword(*req.Password); err != nil {
+			return nil, http.StatusBadRequest, &apierror.Error{Code: apierror.CodeInvalidRequest, Message: err.Error()}
+		}
+	}
+
+	// At most one short link per destination and owner when configured
+	if h.config.UniqueDestinations {
+		if existing, status, apiErr := h.existingDestination(ctx, req); existing != nil || apiErr != nil {
+			return existing, status, apiErr
+		}
+	}
+
+	if req.AutoMetadata {
+		h.fillMetadata(ctx, &req)
+	}
+
 	url, err := h.db.CreateURL(ctx, req)
 	if err != nil {
 		span.RecordError(err)
-		if strings.Contains(err.Error(), "unique constraint") {
-			c.JSON(http.StatusConflict, gin.H{"error": "short path already exists"})
-			return
+		if database.IsDestinationTaken(err) {
+			// Another create got there first. Dead holders were already
+			// retired, so the winner is missing only if it lapsed meanwhile.
+			if existing, status, apiErr := h.existingDestination(ctx, req); existing != nil || apiErr != nil {
+				return existing, status, apiErr
+			}
+			return nil, http.StatusConflict, &apierror.Error{Code: apierror.CodeDestinationTaken, Message: "another short link to this destination was created concurrently; retry the request"}
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create URL"})
-		return
+		if database.IsUniqueViolation(err) {
+			return nil, http.StatusConflict, &apierror.Error{Code: apierror.CodeShortPathTaken, Message: "short path already exists"}
+		}
+		status, apiErr := dbError(err, "failed to create URL")
+		return nil, status, &apiErr
 	}
 
 	// Cache the new URL
@@ -155,8 +509,76 @@ func (h *Handler) CreateURL(c *gin.Context) {
 	if err := h.cache.SetURLByID(ctx, url.ID.String(), url); err != nil {
 		span.RecordError(err)
 	}
+	// The path may have been looked up before it existed
+	if err := h.cache.DeleteNotFound(ctx, url.ShortPath); err != nil {
+		span.RecordError(err)
+	}
 
-	c.JSON(http.StatusCreated, url)
+	return url, http.StatusCreated, nil
+}
+
+// existingDestination returns the live link of the same owner to req's
+// destination under UNIQUE_DESTINATIONS, or a conflict when req asks for a
+// different custom path. It returns neither when the destination is free.
+func (h *Handler) existingDestination(ctx context.Context, req database.CreateURLRequest) (*database.URL, int, *apierror.Error) {
+	existing, err := h.db.GetURLByDestination(ctx, req.Destination, req.Owner)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		status, apiErr := dbError(err, "failed to create URL")
+		return nil, status, &apiErr
+	}
+	if existing == nil {
+		return nil, 0, nil
+	}
+	if req.ShortPath != nil && *req.ShortPath != "" && *req.ShortPath != existing.ShortPath {
+		return nil, http.StatusConflict, &apierror.Error{
+			Code:    apierror.CodeDestinationTaken,
+			Message: "a short link to this destination already exists",
+			Details: map[string]string{"short_path": existing.ShortPath},
+		}
+	}
+	return existing, http.StatusOK, nil
+}
+
+// shortURL returns the public URL of a short path, using PUBLIC_BASE_URL when
+// set and otherwise the scheme and host the request arrived on. The scheme
+// comes from X-Forwarded-Proto only behind a trusted proxy.
+func (h *Handler) shortURL(c *gin.Context, shortPath string) string {
+	if base := strings.TrimRight(h.config.PublicBaseURL, "/"); base != "" {
+		return base + "/" + shortPath
+	}
+
+	return clientip.Scheme(c, h.config.TrustedProxies) + "://" + c.Request.Host + "/" + shortPath
+}
+
+// lookupURLByShortPath reads a URL from the database. Concurrent misses for
+// the same path share one query, so a hot link dropping out of the cache does
+// not send a burst of identical queries to the database.
+func (h *Handler) lookupURLByShortPath(ctx context.Context, shortPath string) (*database.URL, error) {
+	return h.sharedLookup(ctx, "path:"+shortPath, func(ctx context.Context) (*database.URL, error) {
+		return h.db.GetURLByShortPath(ctx, shortPath)
+	})
+}
+
+// lookupURLByID is lookupURLByShortPath for lookups by ID
+func (h *Handler) lookupURLByID(ctx context.Context, id uuid.UUID) (*database.URL, error) {
+	return h.sharedLookup(ctx, "id:"+id.String(), func(ctx context.Context) (*database.URL, error) {
+		return h.db.GetURLByID(ctx, id)
+	})
+}
+
+// sharedLookup runs lookup once for all concurrent callers with the same key.
+// The query is detached from the first caller's cancellation, since the others
+// depend on it, and each caller gets its own copy of the URL.
+func (h *Handler) sharedLookup(ctx context.Context, key string, lookup func(context.Context) (*database.URL, error)) (*database.URL, error) {
+	url, err, shared := h.lookups.Do(key, func() (*database.URL, error) {
+		return lookup(context.WithoutCancel(ctx))
+	})
+	if err != nil || url == nil || !shared {
+		return url, err
+	}
+	copied := *url
+	return &copied, nil
 }
 
 // GetURL handles getting a URL by ID
@@ -167,38 +589,38 @@ func (h *Handler) CreateURL(c *gin.Context) {
 // @Produce json
 // @Param id path string true "URL ID" format(uuid)
 // @Success 200 {object} database.URL
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} apierror.Response
+// @Failure 404 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
 // @Router /urls/{id} [get]
 func (h *Handler) GetURL(c *gin.Context) {
 	ctx, span := telemetry.StartSpan(c.Request.Context(), "get_url")
-	defer span.End()
+	defer endSpan(c, span)
 
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid URL ID"})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidURLID, "invalid URL ID")
 		return
 	}
+	span.SetAttributes(attribute.String("url.id", id.String()))
 
 	// Try cache first
-	url, err := h.cache.GetURLByID(ctx, id.String())
-	if err != nil {
-		span.RecordError(err)
-	}
+	url, _ := readCache(ctx, &cacheStats.getURL, func(ctx context.Context) (*database.URL, error) {
+		return h.cache.GetURLByID(ctx, id.String())
+	})
 
 	if url == nil {
 		// Cache miss, get from database
-		url, err = h.db.GetURLByID(ctx, id)
+		url, err = h.lookupURLByID(ctx, id)
 		if err != nil {
 			span.RecordError(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get URL"})
+			respondDBError(c, err, "failed to get URL")
 			return
 		}
 
 		if url == nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+			respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
 			return
 		}
 
@@ -210,24 +632,30 @@ func (h *Handler) GetURL(c *gin.Context) {
 			span.RecordError(err)
 		}
 	}
+	span.SetAttributes(attribute.String("url.short_path", url.ShortPath))
 
-	c.JSON(http.StatusOK, url)
+	c.JSON(http.StatusOK, h.visibleURL(c, url))
 }
 
 // ListURLs handles listing URLs with pagination
 // @Summary List URLs
-// @Description Retrieve a paginated list of short URLs
+// @Description Retrieve a paginated list of short URLs, optionally filtered by a search term, tag and expiration status
 // @Tags urls
 // @Accept json
 // @Produce json
+// @Param q query string false "Case-insensitive search over title, description and destination"
+// @Param status query string false "Expiration status filter: active, expired, or all" default(all)
+// @Param tag query string false "Only URLs carrying this tag"
+// @Param cursor query string false "Cursor from a previous next_cursor; an empty value starts cursor mode at the newest URL and page is ignored"
 // @Param page query int false "Page number" default(1) minimum(1)
 // @Param limit query int false "Number of items per page" default(10) minimum(1) maximum(100)
 // @Success 200 {object} database.ListURLsResponse
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
 // @Router /urls [get]
 func (h *Handler) ListURLs(c *gin.Context) {
 	ctx, span := telemetry.StartSpan(c.Request.Context(), "list_urls")
-	defer span.End()
+	defer endSpan(c, span)
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
@@ -239,80 +667,271 @@ func (h *Handler) ListURLs(c *gin.Context) {
 		limit = 10
 	}
 
-	result, err := h.db.ListURLs(ctx, page, limit)
+	status, err := database.ParseURLStatus(c.Query("status"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	var tag string
+	if tag = strings.ToLower(strings.TrimSpace(c.Query("tag"))); tag != "" {
+		if err := validateTag(tag); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	var result *database.ListURLsResponse
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		// Keyset mode: preferred for deep pagination
+		var after *database.Cursor
+		if cursor != "" {
+			after, err = database.DecodeCursor(cursor)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+				return
+			}
+		}
+		result, err = h.db.ListURLsAfter(ctx, c.Query("q"), after, limit, status, tag)
+	} else {
+		if notice, ok := h.offsetPaginationDeprecation(); ok {
+			notice.apply(c)
+		}
+		if q := c.Query("q"); q != "" || tag != "" {
+			result, err = h.db.SearchURLs(ctx, q, page, limit, status, tag)
+		} else {
+			result, err = h.db.ListURLs(ctx, page, limit, status)
+		}
+	}
 	if err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list URLs"})
+		respondDBError(c, err, "failed to list URLs")
 		return
 	}
 
+	for i := range result.URLs {
+		result.URLs[i] = *h.visibleURL(c, &result.URLs[i])
+	}
 	c.JSON(http.StatusOK, result)
 }
 
-// UpdateURL handles URL updates
-// @Summary Update URL
-// @Description Update an existing short URL
+// maxResolveBatchSize caps the short paths accepted by ResolveBatch
+const maxResolveBatchSize = 100
+
+// ResolveBatchRequest is the request body for resolving several short paths at once
+type ResolveBatchRequest struct {
+	ShortPaths []string `json:"short_paths" binding:"required" example:"abc123,promo" description:"Short paths to resolve (max 100)"`
+}
+
+// ResolveBatchResponse maps each requested short path to its URL, or null when
+// missing, expired or password-protected
+type ResolveBatchResponse struct {
+	URLs map[string]*database.URL `json:"urls"`
+}
+
+// ResolveBatch handles resolving several short paths in one call
+// @Summary Resolve short paths in bulk
+// @Description Resolve up to 100 short paths, reading the cache first and fetching all misses with a single query. Missing, expired or password-protected paths map to null.
 // @Tags urls
 // @Accept json
 // @Produce json
-// @Param id path string true "URL ID" format(uuid)
-// @Param url body database.UpdateURLRequest true "URL update request"
-// @Success 200 {object} database.URL
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
-// @Router /urls/{id} [put]
-func (h *Handler) UpdateURL(c *gin.Context) {
-	ctx, span := telemetry.StartSpan(c.Request.Context(), "update_url")
-	defer span.End()
-
-	idStr := c.Param("id")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid URL ID"})
-		return
-	}
-
-	var req database.UpdateURLRequest
+// @Param request body ResolveBatchRequest true "Short paths to resolve"
+// @Success 200 {object} ResolveBatchResponse
+// @Failure 400 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /urls/resolve-batch [post]
+func (h *Handler) ResolveBatch(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "resolve_batch")
+	defer endSpan(c, span)
+
+	var req ResolveBatchRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+	if len(req.ShortPaths) > maxResolveBatchSize {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("at most %d short paths can be resolved at once", maxResolveBatchSize))
 		return
 	}
 
-	// Validate short path if provided
-	if req.ShortPath != nil && *req.ShortPath != "" {
-		if !isValidShortPath(*req.ShortPath) {
-			if isReservedPath(*req.ShortPath) {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "short path is reserved and cannot be used"})
-			} else {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid short path format"})
+	now := time.Now()
+	resolved := make(map[string]*database.URL, len(req.ShortPaths))
+	var misses []string
+
+	for _, shortPath := range req.ShortPaths {
+		shortPath = normalizeShortPath(h.config, shortPath)
+		if _, seen := resolved[shortPath]; seen {
+			continue
+		}
+		resolved[shortPath] = nil
+
+		url, err := h.cache.GetURL(ctx, shortPath)
+		if err != nil {
+			span.RecordError(err)
+		}
+		if url != nil {
+			if (url.ExpiresAt == nil || url.ExpiresAt.After(now)) && isActive(url, now) && url.ReservedUntil == nil && url.PasswordHash == nil {
+				resolved[shortPath] = publicURL(url)
 			}
+			continue
+		}
+
+		notFound, err := h.cache.IsNotFound(ctx, shortPath)
+		if err != nil {
+			span.RecordError(err)
+		}
+		if !notFound {
+			misses = append(misses, shortPath)
+		}
+	}
+
+	if len(misses) > 0 {
+		found, err := h.db.GetURLsByShortPaths(ctx, misses)
+		if err != nil {
+			span.RecordError(err)
+			respondDBError(c, err, "failed to resolve URLs")
 			return
 		}
+
+		for _, shortPath := range misses {
+			url := found[shortPath]
+			if url == nil {
+				if err := h.cache.SetNotFound(ctx, shortPath); err != nil {
+					span.RecordError(err)
+				}
+				continue
+			}
+			// Protected destinations are only revealed after the password
+			if url.PasswordHash == nil {
+				resolved[shortPath] = publicURL(url)
+			}
+			if err := h.cache.SetURL(ctx, shortPath, url); err != nil {
+				span.RecordError(err)
+			}
+		}
 	}
 
-	url, err := h.db.UpdateURL(ctx, id, req)
-	if err != nil {
+	c.JSON(http.StatusOK, ResolveBatchResponse{URLs: resolved})
+}
+
+// maxBatchGetSize caps the IDs accepted by BatchGetURLs
+const maxBatchGetSize = 100
+
+// BatchGetRequest is the request body for fetching several URLs by ID at once
+type BatchGetRequest struct {
+	IDs []string `json:"ids" binding:"required" example:"550e8400-e29b-41d4-a716-446655440000" description:"URL IDs to fetch (max 100)"`
+}
+
+// BatchGetResponse lists the URLs found, in request order, and the IDs without one
+type BatchGetResponse struct {
+	URLs    []*database.URL `json:"urls"`
+	Missing []string        `json:"missing"`
+}
+
+// BatchGetURLs handles fetching several URLs by ID in one call
+// @Summary Get URLs in bulk
+// @Description Fetch up to 100 URLs by ID, reading the cache first and fetching all misses with a single query. IDs without a URL, including deleted ones, are listed in missing.
+// @Tags urls
+// @Accept json
+// @Produce json
+// @Param request body BatchGetRequest true "URL IDs to fetch"
+// @Success 200 {object} BatchGetResponse
+// @Failure 400 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /urls/batch-get [post]
+func (h *Handler) BatchGetURLs(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "batch_get_urls")
+	defer endSpan(c, span)
+
+	var req BatchGetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update URL"})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 		return
 	}
-
-	if url == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+	if len(req.IDs) > maxBatchGetSize {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("at most %d URLs can be fetched at once", maxBatchGetSize))
 		return
 	}
 
-	// Update cache
-	if err := h.cache.SetURLByID(ctx, id.String(), url); err != nil {
-		span.RecordError(err)
+	ids := make([]uuid.UUID, 0, len(req.IDs))
+	found := make(map[uuid.UUID]*database.URL, len(req.IDs))
+	var misses []uuid.UUID
+
+	for _, idStr := range req.IDs {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidURLID, "invalid URL ID: "+idStr)
+			return
+		}
+		if _, seen := found[id]; seen {
+			continue
+		}
+		ids = append(ids, id)
+		found[id] = nil
+
+		url, err := h.cache.GetURLByID(ctx, id.String())
+		if err != nil {
+			span.RecordError(err)
+		}
+		if url != nil {
+			found[id] = url
+			continue
+		}
+		misses = append(misses, id)
 	}
-	if err := h.cache.SetURL(ctx, url.ShortPath, url); err != nil {
-		span.RecordError(err)
+
+	if len(misses) > 0 {
+		fetched, err := h.db.GetURLsByIDs(ctx, misses)
+		if err != nil {
+			span.RecordError(err)
+			respondDBError(c, err, "failed to get URLs")
+			return
+		}
+
+		for _, id := range misses {
+			url := fetched[id]
+			if url == nil {
+				continue
+			}
+			found[id] = url
+			if err := h.cache.SetURLByID(ctx, id.String(), url); err != nil {
+				span.RecordError(err)
+			}
+			if err := h.cache.SetURL(ctx, url.ShortPath, url); err != nil {
+				span.RecordError(err)
+			}
+		}
 	}
 
-	c.JSON(http.StatusOK, url)
+	response := BatchGetResponse{URLs: []*database.URL{}, Missing: []string{}}
+	for _, id := range ids {
+		if url := found[id]; url != nil {
+			response.URLs = append(response.URLs, h.visibleURL(c, url))
+		} else {
+			response.Missing = append(response.Missing, id.String())
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateURL handles URL updates
+// @Summary Update URL
+// @Description Update an existing short URL
+// @Tags urls
+// @Accept json
+// @Produce json
+// @Param id path string true "URL ID" format(uuid)
+// @Param url body database.UpdateURLRequest true "URL update request"
+// @Success 200 {object} database.URL
+// @Failure 400 {object} apierror.Response
+// @Failure 404 {object} apierror.Response
+// @Failure 409 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
+// @Router /urls/{id} [put]
+func (h *Handler) UpdateURL(c *gin.Context) {
+	h.updateURL(c, "update_url")
 }
 
 // PatchURL handles partial URL updates
@@ -324,55 +943,143 @@ func (h *Handler) UpdateURL(c *gin.Context) {
 // @Param id path string true "URL ID" format(uuid)
 // @Param url body database.UpdateURLRequest true "URL update request"
 // @Success 200 {object} database.URL
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} apierror.Response
+// @Failure 404 {object} apierror.Response
+// @Failure 409 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
 // @Router /urls/{id} [patch]
 func (h *Handler) PatchURL(c *gin.Context) {
-	ctx, span := telemetry.StartSpan(c.Request.Context(), "patch_url")
-	defer span.End()
+	h.updateURL(c, "patch_url")
+}
+
+// updateURL applies an update request to the URL in the path, for PUT and
+// PATCH alike
+func (h *Handler) updateURL(c *gin.Context, spanName string) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), spanName)
+	defer endSpan(c, span)
 
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid URL ID"})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidURLID, "invalid URL ID")
 		return
 	}
+	span.SetAttributes(attribute.String("url.id", id.String()))
 
 	var req database.UpdateURLRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 		return
 	}
 
 	// Validate short path if provided
+	if req.ShortPath != nil {
+		shortPath := normalizeShortPath(h.config, *req.ShortPath)
+		req.ShortPath = &shortPath
+	}
 	if req.ShortPath != nil && *req.ShortPath != "" {
-		if !isValidShortPath(*req.ShortPath) {
-			if isReservedPath(*req.ShortPath) {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "short path is reserved and cannot be used"})
-			} else {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid short path format"})
-			}
+		if err := ValidateShortPath(h.config, *req.ShortPath); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidShortPath, err.Error())
+			return
+		}
+	}
+	if req.Destination != nil && *req.Destination == "" {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidDestination, "destination must not be empty")
+		return
+	}
+	if req.Destination != nil {
+		if err := h.checkURLLength("destination", *req.Destination); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidDestination, err.Error())
+			return
+		}
+		destination, err := h.enforceHTTPS(ctx, *req.Destination)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidDestination, err.Error())
+			return
+		}
+		if err := h.checkSelfDestination(destination); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidDestination, err.Error())
+			return
+		}
+		req.Destination = &destination
+	}
+	if req.ImageURL != nil && *req.ImageURL != nil {
+		if err := h.checkURLLength("image_url", **req.ImageURL); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if err := validateCountryUpdate(req); err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+			return
+		}
+		req.Tags = &tags
+	}
+	if req.Rules != nil {
+		if err := h.prepareRules(ctx, *req.Rules); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if req.StaticParams != nil {
+		if err := validateStaticParams(*req.StaticParams); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if req.Password != nil && *req.Password != nil {
+		if err := validatePassword(**req.Password); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if req.ExpiresAt != nil {
+		if err := h.validateExpiresAt(*req.ExpiresAt); err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 			return
 		}
 	}
 
-	url, err := h.db.UpdateURL(ctx, id, req)
-	if err != nil {
-		span.RecordError(err)
-		if strings.Contains(err.Error(), "not found") {
-			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+	// The stored link supplies the short path to evict after a rename and
+	// the times the request leaves unchanged
+	var previous *database.URL
+	if req.ShortPath != nil || req.ActivateAt != nil || req.ExpiresAt != nil {
+		if previous, err = h.db.GetURLByID(ctx, id); err != nil {
+			span.RecordError(err)
+			respondDBError(c, err, "failed to get URL")
 			return
 		}
-		if strings.Contains(err.Error(), "unique constraint") {
-			c.JSON(http.StatusConflict, gin.H{"error": "short path already exists"})
+	}
+	if previous != nil {
+		activateAt, expiresAt := previous.ActivateAt, previous.ExpiresAt
+		if req.ActivateAt != nil {
+			activateAt = *req.ActivateAt
+		}
+		if req.ExpiresAt != nil {
+			expiresAt = *req.ExpiresAt
+		}
+		if activateAt != nil && expiresAt != nil && !activateAt.Before(*expiresAt) {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "activate_at must be before expires_at")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update URL"})
+	}
+
+	url, err := h.db.UpdateURL(ctx, id, req)
+	if err != nil {
+		span.RecordError(err)
+		respondUpdateError(c, err)
 		return
 	}
 
+	span.SetAttributes(attribute.String("url.short_path", url.ShortPath))
+
 	// Update cache
 	if err := h.cache.SetURLByID(ctx, id.String(), url); err != nil {
 		span.RecordError(err)
@@ -380,49 +1087,92 @@ func (h *Handler) PatchURL(c *gin.Context) {
 	if err := h.cache.SetURL(ctx, url.ShortPath, url); err != nil {
 		span.RecordError(err)
 	}
+	if req.ShortPath != nil {
+		if err := h.cache.DeleteNotFound(ctx, url.ShortPath); err != nil {
+			span.RecordError(err)
+		}
+	}
+	if previous != nil && previous.ShortPath != url.ShortPath {
+		if err := h.cache.DeleteURL(ctx, previous.ShortPath); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	c.JSON(http.StatusOK, h.visibleURL(c, url))
+}
 
-	c.JSON(http.StatusOK, url)
+// respondUpdateError maps a failed UpdateURL to its response, so PUT and
+// PATCH agree on 404 for unknown IDs and 409 for taken short paths
+func respondUpdateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, database.ErrURLNotFound):
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+	case database.IsDestinationTaken(err):
+		respondError(c, http.StatusConflict, apierror.CodeDestinationTaken, "a short link to this destination already exists")
+	case database.IsUniqueViolation(err):
+		respondError(c, http.StatusConflict, apierror.CodeShortPathTaken, "short path already exists")
+	default:
+		respondDBError(c, err, "failed to update URL")
+	}
 }
 
 // DeleteURL handles URL deletion
 // @Summary Delete URL
-// @Description Delete a short URL by its ID
+// @Description Soft-delete a short URL by its ID so it can be restored later; with hard=true the URL is removed permanently, including one that was already soft-deleted
 // @Tags urls
 // @Accept json
 // @Produce json
 // @Param id path string true "URL ID" format(uuid)
+// @Param hard query bool false "Permanently remove the URL instead of soft-deleting it"
 // @Success 204 "URL deleted successfully"
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} apierror.Response
+// @Failure 404 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
 // @Router /urls/{id} [delete]
 func (h *Handler) DeleteURL(c *gin.Context) {
 	ctx, span := telemetry.StartSpan(c.Request.Context(), "delete_url")
-	defer span.End()
+	defer endSpan(c, span)
 
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid URL ID"})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidURLID, "invalid URL ID")
 		return
 	}
+	span.SetAttributes(attribute.String("url.id", id.String()))
+	hard, _ := strconv.ParseBool(c.Query("hard"))
 
 	// Get URL first to know the short path for cache invalidation
 	url, err := h.db.GetURLByID(ctx, id)
 	if err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get URL"})
+		respondDBError(c, err, "failed to get URL")
 		return
 	}
 
-	if url == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+	// A soft-deleted URL is invisible to lookups but can still be hard-deleted
+	if url == nil && !hard {
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
 		return
 	}
 
-	if err := h.db.DeleteURL(ctx, id); err != nil {
+	if url != nil {
+		span.SetAttributes(attribute.String("url.short_path", url.ShortPath))
+	}
+	span.SetAttributes(attribute.Bool("delete.hard", hard))
+
+	if hard {
+		err = h.db.HardDeleteURL(ctx, id)
+	} else {
+		err = h.db.DeleteURL(ctx, id)
+	}
+	if err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete URL"})
+		if errors.Is(err, database.ErrURLNotFound) {
+			respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+			return
+		}
+		respondDBError(c, err, "failed to delete URL")
 		return
 	}
 
@@ -430,51 +1180,164 @@ func (h *Handler) DeleteURL(c *gin.Context) {
 	if err := h.cache.DeleteURLByID(ctx, id.String()); err != nil {
 		span.RecordError(err)
 	}
-	if err := h.cache.DeleteURL(ctx, url.ShortPath); err != nil {
-		span.RecordError(err)
+	if url != nil {
+		if err := h.cache.DeleteURL(ctx, url.ShortPath); err != nil {
+			span.RecordError(err)
+		}
 	}
 
 	c.Status(http.StatusNoContent)
 }
 
+// RestoreURL handles restoring a soft-deleted URL
+// @Summary Restore URL
+// @Description Restore a soft-deleted short URL by its ID
+// @Tags urls
+// @Accept json
+// @Produce json
+// @Param id path string true "URL ID" format(uuid)
+// @Success 200 {object} database.URL
+// @Failure 400 {object} apierror.Response
+// @Failure 404 {object} apierror.Response
+// @Failure 409 {object} apierror.Response "Another link to the destination exists (UNIQUE_DESTINATIONS)"
+// @Failure 500 {object} apierror.Response
+// @Router /urls/{id}/restore [post]
+func (h *Handler) RestoreURL(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "restore_url")
+	defer endSpan(c, span)
+
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidURLID, "invalid URL ID")
+		return
+	}
+	span.SetAttributes(attribute.String("url.id", id.String()))
+
+	url, err := h.db.RestoreURL(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+		if database.IsDestinationTaken(err) {
+			respondError(c, http.StatusConflict, apierror.CodeDestinationTaken, "another short link to this destination was created since the deletion")
+			return
+		}
+		respondDBError(c, err, "failed to restore URL")
+		return
+	}
+
+	if url == nil {
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "deleted URL not found")
+		return
+	}
+
+	span.SetAttributes(attribute.String("url.short_path", url.ShortPath))
+
+	// Warm the cache and drop any not-found marker left while it was deleted
+	if err := h.cache.SetURLByID(ctx, id.String(), url); err != nil {
+		span.RecordError(err)
+	}
+	if err := h.cache.SetURL(ctx, url.ShortPath, url); err != nil {
+		span.RecordError(err)
+	}
+	if err := h.cache.DeleteNotFound(ctx, url.ShortPath); err != nil {
+		span.RecordError(err)
+	}
+
+	c.JSON(http.StatusOK, h.visibleURL(c, url))
+}
+
+// RedirectManifest is the JSON representation of a redirect, served to clients
+// that request application/json on the short path (e.g. single-page apps)
+type RedirectManifest struct {
+	Destination string     `json:"destination" example:"https://example.com"`
+	ShortPath   string     `json:"short_path" example:"abc123"`
+	ExpiresAt   *time.Time `json:"expires_at" example:"2024-12-31T23:59:59Z"`
+}
+
 // Redirect handles the short URL redirect
 // @Summary Redirect to destination URL
-// @Description Redirect to the destination URL with metadata HTML page
+// @Description Redirect to the destination URL with metadata HTML page, or return a JSON manifest when the client accepts application/json. The first of the URL's rules matching the visitor's device, user agent, country or language overrides the destination.
 // @Tags redirect
 // @Accept html
-// @Produce html
+// @Produce html,json
 // @Param shortPath path string true "Short path"
 // @Success 200 {string} string "HTML page with redirect"
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Success 200 {object} RedirectManifest "Redirect manifest (Accept: application/json)"
+// @Success 302 "Instant redirect for referrers in instant_referrers"
+// @Failure 404 {object} apierror.Response
+// @Failure 410 {string} string "Expired link page, or a JSON error for Accept: application/json"
+// @Failure 401 {string} string "Password form for protected links, or a JSON error for Accept: application/json"
+// @Failure 451 {object} apierror.Response "Blocked in the visitor's country"
+// @Failure 500 {object} apierror.Response
+// @Failure 508 {object} apierror.Response "The destination leads back to the shortener"
 // @Router /{shortPath} [get]
+// @Router /{shortPath} [head]
 func (h *Handler) Redirect(c *gin.Context) {
 	ctx, span := telemetry.StartSpan(c.Request.Context(), "redirect")
-	defer span.End()
+	defer endSpan(c, span)
 
-	shortPath := c.Param("shortPath")
+	shortPath := normalizeShortPath(h.config, c.Param("shortPath"))
 	if shortPath == "" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
 		return
 	}
+	span.SetAttributes(attribute.String("url.short_path", shortPath))
 
 	// Try cache first
-	url, err := h.cache.GetURL(ctx, shortPath)
-	if err != nil {
-		span.RecordError(err)
-	}
+	url, _ := readCache(ctx, &cacheStats.redirect, func(ctx context.Context) (*database.URL, error) {
+		return h.cache.GetURL(ctx, shortPath)
+	})
 
 	if url == nil {
+		// Skip the database for paths recently confirmed missing
+		notFound, err := h.cache.IsNotFound(ctx, shortPath)
+		if err != nil {
+			span.RecordError(err)
+		}
+		if notFound {
+			respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+			return
+		}
+
 		// Cache miss, get from database
-		url, err = h.db.GetURLByShortPath(ctx, shortPath)
+		url, err = h.lookupURLByShortPath(ctx, shortPath)
 		if err != nil {
 			span.RecordError(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get URL"})
+			respondDBError(c, err, "failed to get URL")
 			return
 		}
 
 		if url == nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found or expired"})
+			// Expired links get an explanation rather than a bare 404. The
+			// link is cached briefly, so the next visits render it from there.
+			expired, err := h.db.GetExpiredURLByShortPath(ctx, shortPath)
+			if err != nil {
+				span.RecordError(err)
+			}
+			if expired != nil {
+				if err := h.cache.SetExpiredURL(ctx, shortPath, expired); err != nil {
+					span.RecordError(err)
+				}
+				h.renderExpired(ctx, c, expired)
+				return
+			}
+
+			// Recently deleted links get a tombstone page during the grace period
+			if h.config.DeletedTombstoneGrace > 0 {
+				deleted, err := h.db.GetDeletedURLByShortPath(ctx, shortPath, time.Now().Add(-h.config.DeletedTombstoneGrace))
+				if err != nil {
+					span.RecordError(err)
+				}
+				if deleted != nil {
+					h.renderStatusPage(ctx, c, h.pages().deleted, http.StatusGone, apierror.CodeURLRemoved, "URL was removed", deleted)
+					return
+				}
+			}
+
+			if err := h.cache.SetNotFound(ctx, shortPath); err != nil {
+				span.RecordError(err)
+			}
+			respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
 			return
 		}
 
@@ -484,9 +1347,110 @@ func (h *Handler) Redirect(c *gin.Context) {
 		}
 	}
 
+	span.SetAttributes(attribute.String("url.id", url.ID.String()))
+
+	// Reserved paths show a coming-soon page until a destination is set
+	if url.ReservedUntil != nil {
+		if url.ReservedUntil.After(time.Now()) {
+			h.renderStatusPage(ctx, c, h.pages().comingSoon, http.StatusNotFound, apierror.CodeURLNotActive, "URL is not available yet", url)
+		} else {
+			respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+		}
+		return
+	}
+
+	// Scheduled links stay hidden until their activation moment
+	if !isActive(url, time.Now()) {
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+		return
+	}
+
+	// Tag the trace and baggage so redirect traffic can be correlated with campaigns
+	attrs := redirectAttributes(url)
+	span.SetAttributes(attrs...)
+	ctx = telemetry.WithBaggage(ctx, attrs...)
+
 	// Check if URL is expired
 	if url.ExpiresAt != nil && url.ExpiresAt.Before(time.Now()) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "URL has expired"})
+		h.renderExpired(ctx, c, url)
+		return
+	}
+
+	// Compliance restrictions apply before any form of redirect
+	if h.isGeoBlocked(c, url) {
+		respondError(c, http.StatusUnavailableForLegalReasons, apierror.CodeRegionBlocked, "this link is not available in your region")
+		return
+	}
+
+	// Protected links ask for their password before any form of redirect
+	if url.PasswordHash != nil && !h.hasLinkAccess(c, url) {
+		h.renderPasswordPage(ctx, c, url, false)
+		return
+	}
+
+	// HEAD requests only check that the link resolves: they answer with the
+	// same status and headers as a visit, but are not counted as one
+	visit := c.Request.Method != http.MethodHead
+
+	// Links with a use limit count this visit and expire once it is reached
+	if visit && url.MaxUses != nil && *url.MaxUses > 0 {
+		consumed, err := h.db.ConsumeURLUse(ctx, url.ID)
+		if err != nil {
+			span.RecordError(err)
+			respondDBError(c, err, "failed to get URL")
+			return
+		}
+
+		if consumed == nil || (consumed.MaxUses != nil && consumed.UseCount >= *consumed.MaxUses) {
+			if err := h.cache.DeleteURL(ctx, url.ShortPath); err != nil {
+				span.RecordError(err)
+			}
+			if err := h.cache.DeleteURLByID(ctx, url.ID.String()); err != nil {
+				span.RecordError(err)
+			}
+		}
+		if consumed == nil {
+			h.renderExpired(ctx, c, url)
+			return
+		}
+		url = consumed
+	}
+
+	span.SetAttributes(attribute.Bool("url.expired", false))
+
+	// Rules pick a destination for this visitor; without any, it is the URL's own
+	destination := withQuery(h.ruleDestination(c, url), url, c.Request.URL.Query())
+	if h.loopsBack(c, url, destination) {
+		respondError(c, http.StatusLoopDetected, apierror.CodeRedirectLoop, "this link redirects back to the shortener")
+		return
+	}
+	if visit {
+		h.notifyClick(ctx, c, url, destination)
+	}
+
+	// Every visit that reaches the destination counts as a click
+	if visit && h.config.ClickTracking {
+		h.recordClick(ctx, database.Click{
+			URLID:     url.ID,
+			At:        time.Now(),
+			Referrer:  c.Request.Referer(),
+			UserAgent: c.Request.UserAgent(),
+		})
+	}
+
+	// Clients that handle routing themselves get the destination as JSON
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, RedirectManifest{
+			Destination: destination,
+			ShortPath:   url.ShortPath,
+			ExpiresAt:   database.InUTC(url.ExpiresAt),
+		})
+		return
+	}
+
+	// Trusted referrers skip the preview page and redirect instantly
+	if h.isInstantReferrer(c.Request.Referer(), url) {
+		c.Redirect(http.StatusFound, destination)
 		return
 	}
 
@@ -494,108 +1458,194 @@ func (h *Handler) Redirect(c *gin.Context) {
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	
 	templateData := gin.H{
-		"Title":        url.Title,
-		"Description":  url.Description,
-		"ImageURL":     url.ImageURL,
-		"Destination":  url.Destination,
+		"Title":         url.Title,
+		"Description":   url.Description,
+		"ImageURL":      url.ImageURL,
+		"Destination":   destination,
 		"TwitterDomain": h.config.TwitterDomain,
+		"TwitterCard":   h.twitterCard(url),
+		"SocialTags":    !url.HideSocialTags,
+	}
+	if h.config.RedirectPageQR && url.ShowQR {
+		templateData["QRCode"] = h.redirectPageQR(ctx, destination)
 	}
 
-	if err := h.tmpl.Execute(c.Writer, templateData); err != nil {
+	if err := h.pages().redirect.Execute(c.Writer, templateData); err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render template"})
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to render template")
 		return
 	}
 }
 
-// Helper function to validate short path format
-func isValidShortPath(shortPath string) bool {
-	if len(shortPath) < 1 || len(shortPath) > 255 {
-		return false
+// twitterCard returns the twitter:card type of url's redirect page, picking a
+// large image card under TWITTER_CARD=auto only when there is an image to show
+func (h *Handler) twitterCard(url *database.URL) string {
+	if h.config.TwitterCard != "" && h.config.TwitterCard != "auto" {
+		return h.config.TwitterCard
 	}
-	
-	// Only allow alphanumeric characters and hyphens
-	for _, char := range shortPath {
-		if !((char >= 'a' && char <= 'z') || 
-			 (char >= 'A' && char <= 'Z') || 
-			 (char >= '0' && char <= '9') || 
-			 char == '-') {
-			return false
+	if url.ImageURL != nil && *url.ImageURL != "" {
+		return "summary_large_image"
+	}
+	return "summary"
+}
+
+// redirectPageQR returns a PNG data URL of a QR code for destination, or an
+// empty URL when it cannot be generated so the page renders without it
+func (h *Handler) redirectPageQR(ctx context.Context, destination string) template.URL {
+	opts := h.qrDefaults()
+	opts.Data = destination
+
+	imgData, err := h.generateQRCode(ctx, opts)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		return ""
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(imgData))
+}
+
+// endSpan records the response status on a handler span and ends it
+func endSpan(c *gin.Context, span trace.Span) {
+	span.SetAttributes(attribute.Int("http.response.status_code", c.Writer.Status()))
+	span.End()
+}
+
+// respondError writes the shared error body with a stable code
+func respondError(c *gin.Context, status int, code, message string) {
+	apierror.Write(c, status, apierror.Error{Code: code, Message: message})
+}
+
+// respondDBError responds to a failed database call, telling clients to
+// retry when the query timed out rather than reporting an internal error
+func respondDBError(c *gin.Context, err error, message string) {
+	status, apiErr := dbError(err, message)
+	if status == http.StatusServiceUnavailable {
+		c.Header("Retry-After", "1")
+	}
+	apierror.Write(c, status, apiErr)
+}
+
+// dbError maps a failed database call to its status and body
+func dbError(err error, message string) (int, apierror.Error) {
+	if database.IsTimeout(err) {
+		return http.StatusServiceUnavailable, apierror.Error{Code: apierror.CodeDatabaseTimeout, Message: "database timed out, retry later"}
+	}
+	return http.StatusInternalServerError, apierror.Error{Code: apierror.CodeInternal, Message: message}
+}
+
+// abortError is respondError for middleware, stopping the handler chain
+func abortError(c *gin.Context, status int, code, message string) {
+	apierror.Abort(c, status, code, message)
+}
+
+// urlAttributes identifies a URL on a span
+func urlAttributes(url *database.URL) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("url.id", url.ID.String()),
+		attribute.String("url.short_path", url.ShortPath),
+	}
+}
+
+// isActive reports whether a URL's activate_at, if any, has been reached
+func isActive(url *database.URL, now time.Time) bool {
+	return url.ActivateAt == nil || !url.ActivateAt.After(now)
+}
+
+// redirectAttributes returns the low-cardinality business attributes of a
+// redirect. The destination is reduced to its host so raw URLs never end up in
+// telemetry.
+func redirectAttributes(url *database.URL) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("url.short_path", url.ShortPath)}
+	if url.Owner != nil && *url.Owner != "" {
+		attrs = append(attrs, attribute.String("url.owner", *url.Owner))
+	}
+	if url.Campaign != nil && *url.Campaign != "" {
+		attrs = append(attrs, attribute.String("url.campaign", *url.Campaign))
+	}
+	if dest, err := neturl.Parse(url.Destination); err == nil && dest.Hostname() != "" {
+		attrs = append(attrs, attribute.String("url.destination_host", strings.ToLower(dest.Hostname())))
+	}
+	return attrs
+}
+
+// renderExpired responds 410 Gone for an expired URL with the expired page, or
+// with JSON for clients that accept it
+func (h *Handler) renderExpired(ctx context.Context, c *gin.Context, url *database.URL) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("url.expired", true))
+	h.renderStatusPage(ctx, c, h.pages().expired, http.StatusGone, apierror.CodeURLExpired, "URL has expired", url)
+}
+
+// renderStatusPage responds with status and an HTML page describing why the URL
+// does not redirect, or with a JSON error when there is no template or the
+// client accepts JSON
+func (h *Handler) renderStatusPage(ctx context.Context, c *gin.Context, tmpl *template.Template, status int, code, message string, url *database.URL) {
+	if tmpl == nil || c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		respondError(c, status, code, message)
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+
+	templateData := gin.H{
+		"Title":       url.Title,
+		"Description": url.Description,
+		"ExpiresAt":   url.ExpiresAt,
+	}
+
+	if err := tmpl.Execute(c.Writer, templateData); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
+
+// validateExpiresAt rejects an expiration that is not in the future, which
+// would create a link that is born expired, unless ALLOW_PAST_EXPIRY is set
+func (h *Handler) validateExpiresAt(expiresAt *time.Time) error {
+	if expiresAt == nil || h.config.AllowPastExpiry {
+		return nil
+	}
+	if !expiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+// validateCountryUpdate checks the country lists of an update request
+func validateCountryUpdate(req database.UpdateURLRequest) error {
+	if req.BlockedCountries != nil {
+		if err := validateCountryCodes("blocked_countries", *req.BlockedCountries); err != nil {
+			return err
 		}
 	}
-	
-	// Check if the path is reserved
-	if isReservedPath(shortPath) {
+	if req.AllowedCountries != nil {
+		if err := validateCountryCodes("allowed_countries", *req.AllowedCountries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isInstantReferrer reports whether the referer's host (or a parent domain of it)
+// is listed in the URL's or the global instant_referrers
+func (h *Handler) isInstantReferrer(referer string, url *database.URL) bool {
+	if referer == "" {
 		return false
 	}
-	
-	return true
-}
-
-// Helper function to check if a path is reserved for API endpoints
-func isReservedPath(shortPath string) bool {
-	reservedPaths := []string{
-		// API endpoints
-		"api",
-		"health",
-		"urls",
-		
-		// Swagger documentation
-		"swagger",
-		"docs",
-		"doc",
-		"api-docs",
-		"openapi",
-		
-		// Common web paths that might conflict
-		"admin",
-		"login",
-		"logout",
-		"register",
-		"signup",
-		"signin",
-		"dashboard",
-		"profile",
-		"settings",
-		"help",
-		"support",
-		"contact",
-		"about",
-		"privacy",
-		"terms",
-		"faq",
-		
-		// HTTP methods (in case someone tries to be clever)
-		"get",
-		"post",
-		"put",
-		"patch",
-		"delete",
-		"head",
-		"options",
-		
-		// Common file extensions
-		"css",
-		"js",
-		"png",
-		"jpg",
-		"jpeg",
-		"gif",
-		"svg",
-		"ico",
-		"pdf",
-		"txt",
-		"xml",
-		"json",
+
+	ref, err := neturl.Parse(referer)
+	if err != nil || ref.Hostname() == "" {
+		return false
 	}
-	
-	// Case-insensitive check
-	lowerPath := strings.ToLower(shortPath)
-	for _, reserved := range reservedPaths {
-		if lowerPath == reserved {
-			return true
+	host := strings.ToLower(ref.Hostname())
+
+	for _, list := range [][]string{url.InstantReferrers, h.config.InstantReferrers} {
+		for _, allowed := range list {
+			allowed = strings.ToLower(strings.TrimSpace(allowed))
+			if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+				return true
+			}
 		}
 	}
-	
+
 	return false
-} 
\ No newline at end of file
+}
+ 
\ No newline at end of file
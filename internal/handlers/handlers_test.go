@@ -3,20 +3,43 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"url_shortener/docs"
+	"url_shortener/internal/apierror"
 	"url_shortener/internal/config"
 	"url_shortener/internal/database"
+	"url_shortener/internal/l1cache"
+	"url_shortener/internal/qrcode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Mock implementations
@@ -32,6 +55,14 @@ func (m *MockDatabase) CreateURL(ctx context.Context, req database.CreateURLRequ
 	return args.Get(0).(*database.URL), args.Error(1)
 }
 
+func (m *MockDatabase) ReserveShortPath(ctx context.Context, shortPath string, until time.Time) (*database.URL, error) {
+	args := m.Called(ctx, shortPath, until)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.URL), args.Error(1)
+}
+
 func (m *MockDatabase) GetURLByID(ctx context.Context, id uuid.UUID) (*database.URL, error) {
 	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
@@ -48,8 +79,64 @@ func (m *MockDatabase) GetURLByShortPath(ctx context.Context, shortPath string)
 	return args.Get(0).(*database.URL), args.Error(1)
 }
 
-func (m *MockDatabase) ListURLs(ctx context.Context, page, limit int) (*database.ListURLsResponse, error) {
-	args := m.Called(ctx, page, limit)
+func (m *MockDatabase) GetExpiredURLByShortPath(ctx context.Context, shortPath string) (*database.URL, error) {
+	args := m.Called(ctx, shortPath)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.URL), args.Error(1)
+}
+
+func (m *MockDatabase) GetURLByDestination(ctx context.Context, destination string, owner *string) (*database.URL, error) {
+	args := m.Called(ctx, destination, owner)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.URL), args.Error(1)
+}
+
+func (m *MockDatabase) ListURLs(ctx context.Context, page, limit int, status database.URLStatus) (*database.ListURLsResponse, error) {
+	args := m.Called(ctx, page, limit, status)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.ListURLsResponse), args.Error(1)
+}
+
+func (m *MockDatabase) GetURLsByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*database.URL, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[uuid.UUID]*database.URL), args.Error(1)
+}
+
+func (m *MockDatabase) GetURLsByShortPaths(ctx context.Context, shortPaths []string) (map[string]*database.URL, error) {
+	args := m.Called(ctx, shortPaths)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]*database.URL), args.Error(1)
+}
+
+func (m *MockDatabase) ShortPathCapacity(ctx context.Context) (*database.ShortPathCapacity, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.ShortPathCapacity), args.Error(1)
+}
+
+func (m *MockDatabase) ListURLsAfter(ctx context.Context, query string, after *database.Cursor, limit int, status database.URLStatus, tag string) (*database.ListURLsResponse, error) {
+	args := m.Called(ctx, query, after, limit, status, tag)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.ListURLsResponse), args.Error(1)
+}
+
+func (m *MockDatabase) SearchURLs(ctx context.Context, query string, page, limit int, status database.URLStatus, tag string) (*database.ListURLsResponse, error) {
+	args := m.Called(ctx, query, page, limit, status, tag)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -64,11 +151,80 @@ func (m *MockDatabase) UpdateURL(ctx context.Context, id uuid.UUID, req database
 	return args.Get(0).(*database.URL), args.Error(1)
 }
 
+func (m *MockDatabase) StreamAllURLs(ctx context.Context, fn func(url database.URL, clicks int) error) error {
+	args := m.Called(ctx)
+	clicks := args.Get(1).([]int)
+	for i, url := range args.Get(0).([]database.URL) {
+		if err := fn(url, clicks[i]); err != nil {
+			return err
+		}
+	}
+	return args.Error(2)
+}
+
 func (m *MockDatabase) DeleteURL(ctx context.Context, id uuid.UUID) error {
 	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
+func (m *MockDatabase) HardDeleteURL(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) RestoreURL(ctx context.Context, id uuid.UUID) (*database.URL, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.URL), args.Error(1)
+}
+
+func (m *MockDatabase) ConsumeURLUse(ctx context.Context, id uuid.UUID) (*database.URL, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.URL), args.Error(1)
+}
+
+func (m *MockDatabase) RecordClick(ctx context.Context, click database.Click) error {
+	args := m.Called(ctx, click)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ClickStats(ctx context.Context, urlID uuid.UUID, now time.Time) (*database.ClickStats, error) {
+	args := m.Called(ctx, urlID, now)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.ClickStats), args.Error(1)
+}
+
+func (m *MockDatabase) ClickTimeSeries(ctx context.Context, urlID uuid.UUID, from, to time.Time, interval database.ClickInterval) (*database.ClickTimeSeries, error) {
+	args := m.Called(ctx, urlID, from, to, interval)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.ClickTimeSeries), args.Error(1)
+}
+
+func (m *MockDatabase) GetDeletedURLByShortPath(ctx context.Context, shortPath string, deletedAfter time.Time) (*database.URL, error) {
+	args := m.Called(ctx, shortPath, deletedAfter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.URL), args.Error(1)
+}
+
+func (m *MockDatabase) ListBrokenURLs(ctx context.Context, limit int) ([]database.BrokenURL, error) {
+	args := m.Called(ctx, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]database.BrokenURL), args.Error(1)
+}
+
 func (m *MockDatabase) PingContext(ctx context.Context) error {
 	args := m.Called(ctx)
 	return args.Error(0)
@@ -96,6 +252,11 @@ func (m *MockCache) DeleteURL(ctx context.Context, shortPath string) error {
 	return args.Error(0)
 }
 
+func (m *MockCache) SetExpiredURL(ctx context.Context, shortPath string, url *database.URL) error {
+	args := m.Called(ctx, shortPath, url)
+	return args.Error(0)
+}
+
 func (m *MockCache) GetURLByID(ctx context.Context, id string) (*database.URL, error) {
 	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
@@ -114,6 +275,39 @@ func (m *MockCache) DeleteURLByID(ctx context.Context, id string) error {
 	return args.Error(0)
 }
 
+func (m *MockCache) IsNotFound(ctx context.Context, shortPath string) (bool, error) {
+	args := m.Called(ctx, shortPath)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockCache) SetNotFound(ctx context.Context, shortPath string) error {
+	args := m.Called(ctx, shortPath)
+	return args.Error(0)
+}
+
+func (m *MockCache) DeleteNotFound(ctx context.Context, shortPath string) error {
+	args := m.Called(ctx, shortPath)
+	return args.Error(0)
+}
+
+func (m *MockCache) FlushURLs(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockCache) GetQRCode(ctx context.Context, key string) ([]byte, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockCache) SetQRCode(ctx context.Context, key string, data []byte) error {
+	args := m.Called(ctx, key, data)
+	return args.Error(0)
+}
+
 func (m *MockCache) Ping(ctx context.Context) error {
 	args := m.Called(ctx)
 	return args.Error(0)
@@ -123,7 +317,12 @@ func setupTestHandler() (*Handler, *MockDatabase, *MockCache) {
 	mockDB := new(MockDatabase)
 	mockCache := new(MockCache)
 	cfg := &config.Config{
-		TwitterDomain: "test.com",
+		TwitterDomain:      "test.com",
+		ShortPathMinLength: 1,
+		ShortPathMaxLength: 255,
+		ReservedPaths:      config.DefaultReservedPaths,
+
+		QRDefaultIncludeLogo: true,
 	}
 
 	// Create handler without template (skip file parsing for tests)
@@ -131,7 +330,6 @@ func setupTestHandler() (*Handler, *MockDatabase, *MockCache) {
 		db:     mockDB,
 		cache:  mockCache,
 		config: cfg,
-		tmpl:   nil, // Skip template for unit tests
 	}
 
 	return handler, mockDB, mockCache
@@ -154,10 +352,13 @@ func TestHealthCheck(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, w.Code)
 
-		var response map[string]string
+		var response HealthResponse
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 		require.NoError(t, err)
-		assert.Equal(t, "healthy", response["status"])
+		assert.Equal(t, "healthy", response.Status)
+		assert.Equal(t, "up", response.Database.Status)
+		assert.Equal(t, "up", response.Redis.Status)
+		assert.Contains(t, w.Body.String(), `"latency_ms"`)
 
 		mockDB.AssertExpectations(t)
 		mockCache.AssertExpectations(t)
@@ -176,7 +377,8 @@ func TestHealthCheck(t *testing.T) {
 		router.GET("/health", handler.HealthCheck)
 
 		mockDB.On("PingContext", mock.Anything).Return(assert.AnError)
-		// Cache won't be called since DB fails first
+		// Redis is still reported so monitoring sees every dependency
+		mockCache.On("Ping", mock.Anything).Return(nil)
 
 		req, _ := http.NewRequest("GET", "/health", nil)
 		w := httptest.NewRecorder()
@@ -184,7 +386,93 @@ func TestHealthCheck(t *testing.T) {
 
 		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 
+		var response HealthResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "unhealthy", response.Status)
+		assert.Equal(t, "down", response.Database.Status)
+		assert.Equal(t, "database connection failed", response.Database.Error)
+		assert.Equal(t, "up", response.Redis.Status)
+
 		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("DegradedRedis", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		mockCache := new(MockCache)
+		handler := &Handler{
+			db:     mockDB,
+			cache:  mockCache,
+			config: &config.Config{},
+		}
+		router := gin.New()
+		router.GET("/health", handler.HealthCheck)
+
+		mockDB.On("PingContext", mock.Anything).Return(nil)
+		mockCache.On("Ping", mock.Anything).Return(assert.AnError)
+
+		req, _ := http.NewRequest("GET", "/health", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response HealthResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, "degraded", response.Status)
+		assert.Equal(t, "down", response.Redis.Status)
+	})
+
+	t.Run("DegradedLatency", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		mockCache := new(MockCache)
+		handler := &Handler{
+			db:     mockDB,
+			cache:  mockCache,
+			config: &config.Config{HealthLatencyThreshold: 5 * time.Millisecond},
+		}
+		router := gin.New()
+		router.GET("/health", handler.HealthCheck)
+
+		mockDB.On("PingContext", mock.Anything).Run(func(mock.Arguments) {
+			time.Sleep(20 * time.Millisecond)
+		}).Return(nil)
+		mockCache.On("Ping", mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest("GET", "/health", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response HealthResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "degraded", response.Status)
+		assert.Equal(t, "degraded", response.Database.Status)
+		assert.GreaterOrEqual(t, response.Database.LatencyMS, 20.0)
+		assert.Equal(t, "up", response.Redis.Status)
+	})
+
+	t.Run("UnhealthyRequiredRedis", func(t *testing.T) {
+		mockDB := new(MockDatabase)
+		mockCache := new(MockCache)
+		handler := &Handler{
+			db:     mockDB,
+			cache:  mockCache,
+			config: &config.Config{RedisRequired: true},
+		}
+		router := gin.New()
+		router.GET("/health", handler.HealthCheck)
+
+		mockDB.On("PingContext", mock.Anything).Return(nil)
+		mockCache.On("Ping", mock.Anything).Return(assert.AnError)
+
+		req, _ := http.NewRequest("GET", "/health", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 	})
 }
 
@@ -215,6 +503,7 @@ func TestCreateURL(t *testing.T) {
 
 		mockCache.On("SetURL", mock.Anything, "abc123", expectedURL).Return(nil)
 		mockCache.On("SetURLByID", mock.Anything, testID.String(), expectedURL).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, "abc123").Return(nil)
 
 		requestBody := database.CreateURLRequest{
 			Destination: "https://example.com",
@@ -224,10 +513,13 @@ func TestCreateURL(t *testing.T) {
 
 		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
+		req.Host = "sho.rt"
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "/urls/"+testID.String(), w.Header().Get("Location"))
+		assert.Equal(t, `<http://sho.rt/abc123>; rel="shortlink"`, w.Header().Get("Link"))
 
 		var response database.URL
 		err := json.Unmarshal(w.Body.Bytes(), &response)
@@ -264,172 +556,3614 @@ func TestCreateURL(t *testing.T) {
 	})
 }
 
-func TestGetURL(t *testing.T) {
-	handler, mockDB, mockCache := setupTestHandler()
-
+func TestCreateURLExpiresAtValidation(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	router := gin.New()
-	router.GET("/urls/:id", handler.GetURL)
-
-	t.Run("GetURLSuccess", func(t *testing.T) {
-		testID := uuid.New()
-		expectedURL := &database.URL{
-			ID:          testID,
-			ShortPath:   "abc123",
-			Destination: "https://example.com",
-		}
 
-		mockCache.On("GetURLByID", mock.Anything, testID.String()).Return(nil, assert.AnError) // Cache miss
-		mockDB.On("GetURLByID", mock.Anything, testID).Return(expectedURL, nil)
-		mockCache.On("SetURLByID", mock.Anything, testID.String(), expectedURL).Return(nil)
-		mockCache.On("SetURL", mock.Anything, "abc123", expectedURL).Return(nil)
+	post := func(handler *Handler, expiresAt time.Time) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
 
-		req, _ := http.NewRequest("GET", "/urls/"+testID.String(), nil)
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{Destination: "https://example.com", ExpiresAt: &expiresAt})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
+		return w
+	}
 
-		assert.Equal(t, http.StatusOK, w.Code)
+	expectCreate := func(mockDB *MockDatabase, mockCache *MockCache) {
+		created := &database.URL{ID: uuid.New(), ShortPath: "abc123", Destination: "https://example.com"}
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+	}
 
-		var response database.URL
-		err := json.Unmarshal(w.Body.Bytes(), &response)
-		require.NoError(t, err)
-		assert.Equal(t, expectedURL.ID, response.ID)
+	t.Run("PastRejected", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
 
-		mockDB.AssertExpectations(t)
-		mockCache.AssertExpectations(t)
+		w := post(handler, time.Now().Add(-time.Hour))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "expires_at must be in the future")
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
 	})
 
-	t.Run("GetURLNotFound", func(t *testing.T) {
-		testID := uuid.New()
+	t.Run("NowRejected", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
 
-		mockCache.On("GetURLByID", mock.Anything, testID.String()).Return(nil, assert.AnError) // Cache miss
-		mockDB.On("GetURLByID", mock.Anything, testID).Return(nil, nil) // Not found
+		w := post(handler, time.Now())
 
-		req, _ := http.NewRequest("GET", "/urls/"+testID.String(), nil)
-		w := httptest.NewRecorder()
-		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
 
-		assert.Equal(t, http.StatusNotFound, w.Code)
+	t.Run("FutureAccepted", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		expectCreate(mockDB, mockCache)
 
-		mockDB.AssertExpectations(t)
-		mockCache.AssertExpectations(t)
+		w := post(handler, time.Now().Add(time.Hour))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
 	})
 
-	t.Run("GetURLInvalidID", func(t *testing.T) {
-		req, _ := http.NewRequest("GET", "/urls/invalid-uuid", nil)
+	t.Run("PastAllowedByConfig", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.AllowPastExpiry = true
+		expectCreate(mockDB, mockCache)
+
+		w := post(handler, time.Now().Add(-time.Hour))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("PastRejectedOnPatch", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.PATCH("/urls/:id", handler.PatchURL)
+
+		body := fmt.Sprintf(`{"expires_at":%q}`, time.Now().Add(-time.Hour).Format(time.RFC3339))
+		req, _ := http.NewRequest("PATCH", "/urls/"+uuid.New().String(), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "UpdateURL", mock.Anything, mock.Anything, mock.Anything)
 	})
 }
 
-func TestListURLs(t *testing.T) {
-	handler, mockDB, _ := setupTestHandler()
-
+func TestPatchURLClearsFields(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	router := gin.New()
-	router.GET("/urls", handler.ListURLs)
 
-	t.Run("ListURLsSuccess", func(t *testing.T) {
-		expectedResponse := &database.ListURLsResponse{
-			URLs: []database.URL{
-				{ID: uuid.New(), ShortPath: "abc123", Destination: "https://example.com"},
-				{ID: uuid.New(), ShortPath: "def456", Destination: "https://test.com"},
-			},
-			Total: 2,
-			Page:  1,
-			Limit: 10,
-		}
+	patch := func(body string) database.UpdateURLRequest {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.PATCH("/urls/:id", handler.PatchURL)
 
-		mockDB.On("ListURLs", mock.Anything, 1, 10).Return(expectedResponse, nil)
+		var received database.UpdateURLRequest
+		url := &database.URL{ID: uuid.New(), ShortPath: "abc123", Destination: "https://example.com"}
+		mockDB.On("GetURLByID", mock.Anything, url.ID).Return(url, nil).Maybe()
+		mockDB.On("UpdateURL", mock.Anything, url.ID, mock.Anything).
+			Run(func(args mock.Arguments) { received = args.Get(2).(database.UpdateURLRequest) }).
+			Return(url, nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
-		req, _ := http.NewRequest("GET", "/urls", nil)
+		req, _ := http.NewRequest("PATCH", "/urls/"+url.ID.String(), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return received
+	}
 
-		assert.Equal(t, http.StatusOK, w.Code)
+	t.Run("NullClears", func(t *testing.T) {
+		req := patch(`{"title":null,"description":null,"image_url":null,"expires_at":null}`)
 
-		var response database.ListURLsResponse
-		err := json.Unmarshal(w.Body.Bytes(), &response)
-		require.NoError(t, err)
-		assert.Equal(t, expectedResponse.Total, response.Total)
-		assert.Len(t, response.URLs, 2)
+		for name, field := range map[string]**string{"title": req.Title, "description": req.Description, "image_url": req.ImageURL} {
+			require.NotNil(t, field, name)
+			assert.Nil(t, *field, name)
+		}
+		require.NotNil(t, req.ExpiresAt)
+		assert.Nil(t, *req.ExpiresAt)
+	})
+
+	t.Run("OmittedKeeps", func(t *testing.T) {
+		req := patch(`{"destination":"https://example.com/new"}`)
+
+		assert.Nil(t, req.Title)
+		assert.Nil(t, req.Description)
+		assert.Nil(t, req.ImageURL)
+		assert.Nil(t, req.ExpiresAt)
+	})
+
+	t.Run("ValueSets", func(t *testing.T) {
+		req := patch(`{"title":"New title"}`)
+
+		require.NotNil(t, req.Title)
+		require.NotNil(t, *req.Title)
+		assert.Equal(t, "New title", **req.Title)
+	})
+}
+
+func TestUpdateURLErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	send := func(method string, err error) *httptest.ResponseRecorder {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.PUT("/urls/:id", handler.UpdateURL)
+		router.PATCH("/urls/:id", handler.PatchURL)
+
+		id := uuid.New()
+		mockDB.On("GetURLByID", mock.Anything, id).Return(&database.URL{ID: id, ShortPath: "old"}, nil)
+		mockDB.On("UpdateURL", mock.Anything, id, mock.Anything).Return(nil, err)
+
+		req, _ := http.NewRequest(method, "/urls/"+id.String(), bytes.NewBufferString(`{"short_path":"taken"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, method := range []string{"PUT", "PATCH"} {
+		t.Run(method, func(t *testing.T) {
+			assert.Equal(t, http.StatusNotFound, send(method, database.ErrURLNotFound).Code)
+			assert.Equal(t, http.StatusConflict, send(method, fmt.Errorf("failed to update URL: %w", &pq.Error{Code: "23505"})).Code)
+			assert.Equal(t, http.StatusInternalServerError, send(method, errors.New("connection reset")).Code)
+		})
+	}
+}
+
+func TestUpdateURLShortPathEvictsOldPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, method := range []string{"PUT", "PATCH"} {
+		t.Run(method, func(t *testing.T) {
+			handler, mockDB, mockCache := setupTestHandler()
+			router := gin.New()
+			router.PUT("/urls/:id", handler.UpdateURL)
+			router.PATCH("/urls/:id", handler.PatchURL)
+
+			id := uuid.New()
+			renamed := &database.URL{ID: id, ShortPath: "new-path", Destination: "https://example.com"}
+			mockDB.On("GetURLByID", mock.Anything, id).Return(&database.URL{ID: id, ShortPath: "old-path", Destination: "https://example.com"}, nil)
+			mockDB.On("UpdateURL", mock.Anything, id, mock.Anything).Return(renamed, nil)
+			mockCache.On("SetURLByID", mock.Anything, id.String(), renamed).Return(nil)
+			mockCache.On("SetURL", mock.Anything, "new-path", renamed).Return(nil)
+			mockCache.On("DeleteNotFound", mock.Anything, "new-path").Return(nil)
+			mockCache.On("DeleteURL", mock.Anything, "old-path").Return(nil)
+
+			req, _ := http.NewRequest(method, "/urls/"+id.String(), bytes.NewBufferString(`{"short_path":"new-path"}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockCache.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRequireHTTPSDestination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(upgrade bool) (*gin.Engine, *MockDatabase, *MockCache) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.RequireHTTPSDestination = true
+		handler.config.UpgradeHTTPDestination = upgrade
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
+		router.PATCH("/urls/:id", handler.PatchURL)
+		return router, mockDB, mockCache
+	}
+	post := func(router *gin.Engine, destination string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{Destination: destination})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	expectCreate := func(mockDB *MockDatabase, mockCache *MockCache, destination string) {
+		created := &database.URL{ID: uuid.New(), ShortPath: "abc123", Destination: destination}
+		mockDB.On("CreateURL", mock.Anything, mock.MatchedBy(func(req database.CreateURLRequest) bool {
+			return req.Destination == destination
+		})).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+	}
+
+	t.Run("HTTPRejectedOnCreate", func(t *testing.T) {
+		router, mockDB, _ := newRouter(false)
+
+		w := post(router, "http://example.com")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "destination must use https")
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("HTTPSAccepted", func(t *testing.T) {
+		router, mockDB, mockCache := newRouter(false)
+		expectCreate(mockDB, mockCache, "https://example.com")
+
+		w := post(router, "https://example.com")
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("HTTPRejectedOnPatch", func(t *testing.T) {
+		router, mockDB, _ := newRouter(false)
+
+		req, _ := http.NewRequest("PATCH", "/urls/"+uuid.New().String(), bytes.NewBufferString(`{"destination":"http://example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "UpdateURL", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("UpgradedWhenReachable", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		original := destinationClient
+		destinationClient = server.Client()
+		defer func() { destinationClient = original }()
+
+		host := strings.TrimPrefix(server.URL, "https://")
+		router, mockDB, mockCache := newRouter(true)
+		expectCreate(mockDB, mockCache, "https://"+host+"/page")
+
+		w := post(router, "http://"+host+"/page")
 
+		assert.Equal(t, http.StatusCreated, w.Code)
 		mockDB.AssertExpectations(t)
 	})
 
-	t.Run("ListURLsWithPagination", func(t *testing.T) {
-		expectedResponse := &database.ListURLsResponse{
-			URLs:  []database.URL{},
-			Total: 25,
-			Page:  2,
-			Limit: 5,
+	t.Run("UpgradeProbeSkipsInternalHosts", func(t *testing.T) {
+		// The https origin answers, but on loopback, which the probe may not reach
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		// Trust the test certificate but keep the guarded dialer
+		client := server.Client()
+		client.Transport.(*http.Transport).DialContext = publicDialContext(time.Second)
+		original := destinationClient
+		destinationClient = client
+		defer func() { destinationClient = original }()
+
+		host := strings.TrimPrefix(server.URL, "https://")
+		router, mockDB, _ := newRouter(true)
+
+		w := post(router, "http://"+host+"/page")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("RejectedWhenUpgradeUnreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		host := strings.TrimPrefix(server.URL, "http://")
+		server.Close()
+
+		router, mockDB, _ := newRouter(true)
+
+		w := post(router, "http://"+host+"/page")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+}
+
+func TestMaxDestinationLen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler, mockDB, _ := setupTestHandler()
+	handler.config.MaxDestinationLen = 40
+	router := gin.New()
+	router.POST("/urls", handler.CreateURL)
+	router.PATCH("/urls/:id", handler.PatchURL)
+
+	long := "https://example.com/" + strings.Repeat("a", 21)
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/urls", `{"destination":"`+long+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "destination must be at most 40 bytes")
+
+	w = send("POST", "/urls", `{"destination":"https://example.com","image_url":"`+long+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "image_url must be at most 40 bytes")
+
+	w = send("PATCH", "/urls/"+uuid.New().String(), `{"destination":"`+long+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("PATCH", "/urls/"+uuid.New().String(), `{"image_url":"`+long+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	mockDB.AssertNotCalled(t, "UpdateURL", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSelfDestination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newHandler := func(allow bool) (*Handler, *MockDatabase, *MockCache) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.PublicBaseURL = "https://sho.rt"
+		handler.config.AllowSelfDestination = allow
+		return handler, mockDB, mockCache
+	}
+
+	t.Run("RejectsOwnHostOnCreate", func(t *testing.T) {
+		handler, mockDB, _ := newHandler(false)
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
+
+		for _, body := range []string{
+			`{"destination":"https://SHO.rt:443/other"}`,
+			`{"destination":"https://example.com","rules":[{"device":"ios","destination":"https://sho.rt/app"}]}`,
+		} {
+			req, _ := http.NewRequest("POST", "/urls", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+			assert.Contains(t, w.Body.String(), "must not point at this shortener")
 		}
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
 
-		mockDB.On("ListURLs", mock.Anything, 2, 5).Return(expectedResponse, nil)
+	t.Run("RejectsOwnHostOnUpdate", func(t *testing.T) {
+		handler, mockDB, _ := newHandler(false)
+		router := gin.New()
+		router.PATCH("/urls/:id", handler.PatchURL)
 
-		req, _ := http.NewRequest("GET", "/urls?page=2&limit=5", nil)
+		req, _ := http.NewRequest("PATCH", "/urls/"+uuid.New().String(), bytes.NewBufferString(`{"destination":"https://sho.rt/other"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "UpdateURL", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	redirect := func(handler *Handler, mockCache *MockCache, destination string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		mockCache.On("GetURL", mock.Anything, "loop").Return(&database.URL{ID: uuid.New(), ShortPath: "loop", Destination: destination}, nil)
+
+		req, _ := http.NewRequest("GET", "/loop", nil)
+		req.Host = "localhost:8080"
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("RedirectRefusesOwnHost", func(t *testing.T) {
+		for _, destination := range []string{"https://sho.rt/other", "http://localhost:8080/other"} {
+			handler, _, mockCache := newHandler(false)
+			w := redirect(handler, mockCache, destination)
+			assert.Equal(t, http.StatusLoopDetected, w.Code, destination)
+			assert.Contains(t, w.Body.String(), apierror.CodeRedirectLoop)
+		}
+
+		handler, _, mockCache := newHandler(false)
+		assert.Equal(t, http.StatusOK, redirect(handler, mockCache, "http://localhost:3000/app").Code)
+	})
+
+	t.Run("AllowedOwnHostStillRefusesItself", func(t *testing.T) {
+		handler, _, mockCache := newHandler(true)
+		assert.Equal(t, http.StatusOK, redirect(handler, mockCache, "https://sho.rt/other").Code)
+
+		handler, _, mockCache = newHandler(true)
+		assert.Equal(t, http.StatusLoopDetected, redirect(handler, mockCache, "https://sho.rt/loop").Code)
+	})
+}
+
+func TestCreateURLUniqueDestinations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	existing := &database.URL{
+		ID:          uuid.New(),
+		ShortPath:   "exists",
+		Destination: "https://example.com/page",
+	}
+
+	newRouter := func() (*gin.Engine, *MockDatabase, *MockCache) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.UniqueDestinations = true
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
+		return router, mockDB, mockCache
+	}
+
+	t.Run("ReturnsExistingLink", func(t *testing.T) {
+		router, mockDB, _ := newRouter()
+		mockDB.On("GetURLByDestination", mock.Anything, "https://EXAMPLE.com/page/", (*string)(nil)).Return(existing, nil)
+
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{Destination: "https://EXAMPLE.com/page/"})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
 
-		var response database.ListURLsResponse
+		var response database.URL
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 		require.NoError(t, err)
-		assert.Equal(t, 2, response.Page)
-		assert.Equal(t, 5, response.Limit)
+		assert.Equal(t, existing.ID, response.ID)
+
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("RejectsDifferentCustomPath", func(t *testing.T) {
+		router, mockDB, _ := newRouter()
+		mockDB.On("GetURLByDestination", mock.Anything, "https://example.com/page", (*string)(nil)).Return(existing, nil)
+
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{
+			ShortPath:   stringPtr("another"),
+			Destination: "https://example.com/page",
+		})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var body apierror.Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, apierror.CodeDestinationTaken, body.Error.Code)
+		assert.Equal(t, existing.ShortPath, body.Error.Details["short_path"])
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("CreatesWhenDestinationIsNew", func(t *testing.T) {
+		router, mockDB, mockCache := newRouter()
+		created := &database.URL{ID: uuid.New(), ShortPath: "fresh", Destination: "https://example.com/new"}
+
+		mockDB.On("GetURLByDestination", mock.Anything, "https://example.com/new", (*string)(nil)).Return(nil, nil)
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, "fresh", created).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, created.ID.String(), created).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, "fresh").Return(nil)
+
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{Destination: "https://example.com/new"})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	destinationTaken := &pq.Error{Code: "23505", Constraint: "idx_urls_unique_destination"}
+
+	t.Run("ScopedByOwner", func(t *testing.T) {
+		router, mockDB, mockCache := newRouter()
+		owner := "marketing"
+		created := &database.URL{ID: uuid.New(), ShortPath: "team", Destination: "https://example.com/page"}
+
+		mockDB.On("GetURLByDestination", mock.Anything, "https://example.com/page", &owner).Return(nil, nil)
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{Destination: "https://example.com/page", Owner: &owner})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("LostRaceReturnsWinner", func(t *testing.T) {
+		router, mockDB, _ := newRouter()
+		// The check misses the link another request is creating
+		mockDB.On("GetURLByDestination", mock.Anything, "https://example.com/page", (*string)(nil)).Return(nil, nil).Once()
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(nil, destinationTaken)
+		mockDB.On("GetURLByDestination", mock.Anything, "https://example.com/page", (*string)(nil)).Return(existing, nil).Once()
+
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{Destination: "https://example.com/page"})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
 
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), existing.ID.String())
 		mockDB.AssertExpectations(t)
 	})
+
+	t.Run("LapsedWinnerConflicts", func(t *testing.T) {
+		router, mockDB, _ := newRouter()
+		// The concurrent create that won is no longer live by the recheck
+		mockDB.On("GetURLByDestination", mock.Anything, "https://example.com/page", (*string)(nil)).Return(nil, nil)
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(nil, destinationTaken)
+
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{Destination: "https://example.com/page"})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), apierror.CodeDestinationTaken)
+	})
+
+	t.Run("UpdateIntoDuplicateConflicts", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		handler.config.UniqueDestinations = true
+		router := gin.New()
+		router.PATCH("/urls/:id", handler.PatchURL)
+
+		id := uuid.New()
+		mockDB.On("UpdateURL", mock.Anything, id, mock.Anything).Return(nil, destinationTaken)
+
+		req, _ := http.NewRequest("PATCH", "/urls/"+id.String(), bytes.NewBufferString(`{"destination":"https://example.com/page"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), apierror.CodeDestinationTaken)
+	})
 }
 
-func TestDeleteURL(t *testing.T) {
+func TestGetURL(t *testing.T) {
 	handler, mockDB, mockCache := setupTestHandler()
 
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	router.DELETE("/urls/:id", handler.DeleteURL)
+	router.GET("/urls/:id", handler.GetURL)
 
-	t.Run("DeleteURLSuccess", func(t *testing.T) {
+	t.Run("GetURLSuccess", func(t *testing.T) {
 		testID := uuid.New()
-		testURL := &database.URL{
-			ID:        testID,
-			ShortPath: "abc123",
+		expectedURL := &database.URL{
+			ID:          testID,
+			ShortPath:   "abc123",
+			Destination: "https://example.com",
 		}
 
-		mockDB.On("GetURLByID", mock.Anything, testID).Return(testURL, nil)
-		mockDB.On("DeleteURL", mock.Anything, testID).Return(nil)
-		mockCache.On("DeleteURL", mock.Anything, "abc123").Return(nil)
-		mockCache.On("DeleteURLByID", mock.Anything, testID.String()).Return(nil)
+		mockCache.On("GetURLByID", mock.Anything, testID.String()).Return(nil, assert.AnError) // Cache miss
+		mockDB.On("GetURLByID", mock.Anything, testID).Return(expectedURL, nil)
+		mockCache.On("SetURLByID", mock.Anything, testID.String(), expectedURL).Return(nil)
+		mockCache.On("SetURL", mock.Anything, "abc123", expectedURL).Return(nil)
 
-		req, _ := http.NewRequest("DELETE", "/urls/"+testID.String(), nil)
+		req, _ := http.NewRequest("GET", "/urls/"+testID.String(), nil)
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
 
-		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response database.URL
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, expectedURL.ID, response.ID)
 
 		mockDB.AssertExpectations(t)
 		mockCache.AssertExpectations(t)
 	})
 
-	t.Run("DeleteURLNotFound", func(t *testing.T) {
+	t.Run("GetURLNotFound", func(t *testing.T) {
 		testID := uuid.New()
 
-		mockDB.On("GetURLByID", mock.Anything, testID).Return(nil, nil)
+		mockCache.On("GetURLByID", mock.Anything, testID.String()).Return(nil, assert.AnError) // Cache miss
+		mockDB.On("GetURLByID", mock.Anything, testID).Return(nil, nil) // Not found
 
-		req, _ := http.NewRequest("DELETE", "/urls/"+testID.String(), nil)
+		req, _ := http.NewRequest("GET", "/urls/"+testID.String(), nil)
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusNotFound, w.Code)
 
 		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
 	})
-}
 
-// Helper function to create string pointers
-func stringPtr(s string) *string {
-	return &s
-}
\ No newline at end of file
+	t.Run("GetURLInvalidID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls/invalid-uuid", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestListURLs(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/urls", handler.ListURLs)
+
+	t.Run("ListURLsSuccess", func(t *testing.T) {
+		expectedResponse := &database.ListURLsResponse{
+			URLs: []database.URL{
+				{ID: uuid.New(), ShortPath: "abc123", Destination: "https://example.com"},
+				{ID: uuid.New(), ShortPath: "def456", Destination: "https://test.com"},
+			},
+			Total: 2,
+			Page:  1,
+			Limit: 10,
+		}
+
+		mockDB.On("ListURLs", mock.Anything, 1, 10, database.StatusAll).Return(expectedResponse, nil)
+
+		req, _ := http.NewRequest("GET", "/urls", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response database.ListURLsResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, expectedResponse.Total, response.Total)
+		assert.Len(t, response.URLs, 2)
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListURLsWithPagination", func(t *testing.T) {
+		expectedResponse := &database.ListURLsResponse{
+			URLs:  []database.URL{},
+			Total: 25,
+			Page:  2,
+			Limit: 5,
+		}
+
+		mockDB.On("ListURLs", mock.Anything, 2, 5, database.StatusAll).Return(expectedResponse, nil)
+
+		req, _ := http.NewRequest("GET", "/urls?page=2&limit=5", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response database.ListURLsResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, 2, response.Page)
+		assert.Equal(t, 5, response.Limit)
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListURLsWithSearch", func(t *testing.T) {
+		expectedResponse := &database.ListURLsResponse{
+			URLs: []database.URL{
+				{ID: uuid.New(), ShortPath: "promo", Destination: "https://example.com/promo"},
+			},
+			Total: 1,
+			Page:  1,
+			Limit: 10,
+		}
+
+		mockDB.On("SearchURLs", mock.Anything, "promo", 1, 10, database.StatusAll, "").Return(expectedResponse, nil)
+
+		req, _ := http.NewRequest("GET", "/urls?q=promo", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response database.ListURLsResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, 1, response.Total)
+		assert.Len(t, response.URLs, 1)
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListURLsWithTag", func(t *testing.T) {
+		expectedResponse := &database.ListURLsResponse{URLs: []database.URL{}, Page: 1, Limit: 10}
+		mockDB.On("SearchURLs", mock.Anything, "", 1, 10, database.StatusAll, "carnaval").Return(expectedResponse, nil)
+
+		req, _ := http.NewRequest("GET", "/urls?tag=Carnaval", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListURLsWithInvalidTag", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls?tag=not%20a%20tag", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("ListURLsWithStatus", func(t *testing.T) {
+		expectedResponse := &database.ListURLsResponse{
+			URLs:  []database.URL{},
+			Total: 3,
+			Page:  1,
+			Limit: 10,
+		}
+
+		mockDB.On("ListURLs", mock.Anything, 1, 10, database.StatusExpired).Return(expectedResponse, nil)
+
+		req, _ := http.NewRequest("GET", "/urls?status=expired", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response database.ListURLsResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, 3, response.Total)
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListURLsWithCursor", func(t *testing.T) {
+		after := database.Cursor{CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), ID: uuid.New()}
+		expectedResponse := &database.ListURLsResponse{
+			URLs:       []database.URL{{ID: uuid.New(), ShortPath: "older", Destination: "https://example.com"}},
+			Total:      30,
+			Limit:      1,
+			NextCursor: "next",
+		}
+
+		mockDB.On("ListURLsAfter", mock.Anything, "", &after, 1, database.StatusAll, "").Return(expectedResponse, nil)
+
+		req, _ := http.NewRequest("GET", "/urls?limit=1&cursor="+after.Encode(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response database.ListURLsResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, "next", response.NextCursor)
+		assert.Len(t, response.URLs, 1)
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListURLsFirstCursorPage", func(t *testing.T) {
+		expectedResponse := &database.ListURLsResponse{URLs: []database.URL{}, Limit: 10}
+
+		mockDB.On("ListURLsAfter", mock.Anything, "promo", (*database.Cursor)(nil), 10, database.StatusAll, "").Return(expectedResponse, nil)
+
+		req, _ := http.NewRequest("GET", "/urls?cursor=&q=promo", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ListURLsInvalidCursor", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls?cursor=not-a-cursor", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid cursor")
+	})
+
+	t.Run("ListURLsInvalidStatus", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls?status=stale", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid status")
+	})
+}
+
+func TestListURLsDeprecation(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+	handler.config.APIPrefix = "/api"
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/urls", handler.ListURLs)
+
+	mockDB.On("ListURLs", mock.Anything, 1, 10, database.StatusAll).Return(&database.ListURLsResponse{URLs: []database.URL{}}, nil)
+	mockDB.On("ListURLsAfter", mock.Anything, "", (*database.Cursor)(nil), 10, database.StatusAll, "").Return(&database.ListURLsResponse{URLs: []database.URL{}}, nil)
+
+	t.Run("NotFlagged", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+	})
+
+	handler.config.DeprecateOffsetPagination = true
+	handler.config.OffsetPaginationSunset = time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+
+	t.Run("OffsetPagination", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls?page=2", nil)
+		mockDB.On("ListURLs", mock.Anything, 2, 10, database.StatusAll).Return(&database.ListURLsResponse{URLs: []database.URL{}}, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Deprecation"))
+		assert.Equal(t, "Mon, 30 Jun 2025 00:00:00 GMT", w.Header().Get("Sunset"))
+		assert.Equal(t, `</api/urls?cursor=>; rel="successor-version"`, w.Header().Get("Link"))
+		assert.Contains(t, w.Header().Get("Warning"), "299 - ")
+	})
+
+	t.Run("CursorPagination", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls?cursor=", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+		assert.Empty(t, w.Header().Get("Sunset"))
+	})
+}
+
+func TestDeleteURL(t *testing.T) {
+	handler, mockDB, mockCache := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.DELETE("/urls/:id", handler.DeleteURL)
+
+	t.Run("DeleteURLSuccess", func(t *testing.T) {
+		testID := uuid.New()
+		testURL := &database.URL{
+			ID:        testID,
+			ShortPath: "abc123",
+		}
+
+		mockDB.On("GetURLByID", mock.Anything, testID).Return(testURL, nil)
+		mockDB.On("DeleteURL", mock.Anything, testID).Return(nil)
+		mockCache.On("DeleteURL", mock.Anything, "abc123").Return(nil)
+		mockCache.On("DeleteURLByID", mock.Anything, testID.String()).Return(nil)
+
+		req, _ := http.NewRequest("DELETE", "/urls/"+testID.String(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("DeleteURLNotFound", func(t *testing.T) {
+		testID := uuid.New()
+
+		mockDB.On("GetURLByID", mock.Anything, testID).Return(nil, nil)
+
+		req, _ := http.NewRequest("DELETE", "/urls/"+testID.String(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("HardDeleteSoftDeletedURL", func(t *testing.T) {
+		testID := uuid.New()
+
+		mockDB.On("GetURLByID", mock.Anything, testID).Return(nil, nil)
+		mockDB.On("HardDeleteURL", mock.Anything, testID).Return(nil)
+		mockCache.On("DeleteURLByID", mock.Anything, testID.String()).Return(nil)
+
+		req, _ := http.NewRequest("DELETE", "/urls/"+testID.String()+"?hard=true", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		mockDB.AssertNotCalled(t, "DeleteURL", mock.Anything, testID)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("HardDeleteMissingURL", func(t *testing.T) {
+		testID := uuid.New()
+
+		mockDB.On("GetURLByID", mock.Anything, testID).Return(nil, nil)
+		mockDB.On("HardDeleteURL", mock.Anything, testID).Return(database.ErrURLNotFound)
+
+		req, _ := http.NewRequest("DELETE", "/urls/"+testID.String()+"?hard=true", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestDatabaseTimeout(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.DELETE("/urls/:id", handler.DeleteURL)
+
+	testID := uuid.New()
+	mockDB.On("GetURLByID", mock.Anything, testID).Return(nil, fmt.Errorf("get url: %w", context.DeadlineExceeded))
+
+	req, _ := http.NewRequest("DELETE", "/urls/"+testID.String(), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	var resp apierror.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, apierror.CodeDatabaseTimeout, resp.Error.Code)
+}
+
+func TestRestoreURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("RestoreSoftDeletedURL", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/:id/restore", handler.RestoreURL)
+
+		testID := uuid.New()
+		testURL := &database.URL{ID: testID, ShortPath: "abc123", Destination: "https://example.com"}
+
+		mockDB.On("RestoreURL", mock.Anything, testID).Return(testURL, nil)
+		mockCache.On("SetURLByID", mock.Anything, testID.String(), testURL).Return(nil)
+		mockCache.On("SetURL", mock.Anything, "abc123", testURL).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, "abc123").Return(nil)
+
+		req, _ := http.NewRequest("POST", "/urls/"+testID.String()+"/restore", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("RestoreNotDeleted", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/:id/restore", handler.RestoreURL)
+
+		testID := uuid.New()
+		mockDB.On("RestoreURL", mock.Anything, testID).Return(nil, nil)
+
+		req, _ := http.NewRequest("POST", "/urls/"+testID.String()+"/restore", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestRedirectDeletedTombstone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	setup := func() (*Handler, *MockDatabase, *gin.Engine) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.DeletedTombstoneGrace = 24 * time.Hour
+		handler.templates.Store(&pageTemplates{deleted: template.Must(template.New("deleted").Parse(`removed: {{ .Title }}`))})
+
+		mockCache.On("GetURL", mock.Anything, mock.Anything).Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, mock.Anything).Return(false, nil)
+		mockCache.On("SetNotFound", mock.Anything, mock.Anything).Return(nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, mock.Anything).Return(nil, nil)
+		mockDB.On("GetExpiredURLByShortPath", mock.Anything, mock.Anything).Return(nil, nil)
+
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		return handler, mockDB, router
+	}
+
+	withinGrace := mock.MatchedBy(func(after time.Time) bool {
+		return time.Since(after) > 23*time.Hour && time.Since(after) <= 24*time.Hour+time.Minute
+	})
+
+	t.Run("JustDeletedShowsTombstone", func(t *testing.T) {
+		_, mockDB, router := setup()
+		title := "Campanha"
+		deleted := &database.URL{ID: uuid.New(), ShortPath: "gone", Destination: "https://example.com", Title: &title}
+		mockDB.On("GetDeletedURLByShortPath", mock.Anything, "gone", withinGrace).Return(deleted, nil)
+
+		req, _ := http.NewRequest("GET", "/gone", nil)
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.Equal(t, "removed: Campanha", w.Body.String())
+	})
+
+	t.Run("OldDeletionIsNotFound", func(t *testing.T) {
+		_, mockDB, router := setup()
+		mockDB.On("GetDeletedURLByShortPath", mock.Anything, "old", withinGrace).Return(nil, nil)
+
+		req, _ := http.NewRequest("GET", "/old", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("DisabledByDefault", func(t *testing.T) {
+		handler, mockDB, router := setup()
+		handler.config.DeletedTombstoneGrace = 0
+
+		req, _ := http.NewRequest("GET", "/gone", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		mockDB.AssertNotCalled(t, "GetDeletedURLByShortPath", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestRedirectNegativeCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("MissStoresNotFoundMarker", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		mockCache.On("GetURL", mock.Anything, "missing").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, "missing").Return(false, nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, "missing").Return(nil, nil)
+		mockDB.On("GetExpiredURLByShortPath", mock.Anything, "missing").Return(nil, nil)
+		mockCache.On("SetNotFound", mock.Anything, "missing").Return(nil)
+
+		req, _ := http.NewRequest("GET", "/missing", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":{"code":"url_not_found","message":"URL not found"}}`, w.Body.String())
+
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("NotFoundMarkerSkipsDatabase", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		mockCache.On("GetURL", mock.Anything, "missing").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, "missing").Return(true, nil)
+
+		req, _ := http.NewRequest("GET", "/missing", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		mockDB.AssertNotCalled(t, "GetURLByShortPath", mock.Anything, mock.Anything)
+		mockCache.AssertExpectations(t)
+	})
+}
+
+func TestRedirectExpired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	past := time.Now().Add(-time.Hour)
+	expiredURL := &database.URL{
+		ID:          uuid.New(),
+		ShortPath:   "promo",
+		Destination: "https://example.com/offer",
+		Title:       stringPtr("Summer Offer"),
+		Description: stringPtr("Half price on everything"),
+		ExpiresAt:   &past,
+	}
+
+	setup := func() (*gin.Engine, *MockDatabase, *MockCache) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.templates.Store(&pageTemplates{expired: template.Must(template.New("expired").Parse(`<h1>{{ .Title }}</h1><p>{{ .Description }}</p>`))})
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		mockCache.On("GetURL", mock.Anything, "promo").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, "promo").Return(false, nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, "promo").Return(nil, nil)
+		mockDB.On("GetExpiredURLByShortPath", mock.Anything, "promo").Return(expiredURL, nil)
+		mockCache.On("SetExpiredURL", mock.Anything, "promo", expiredURL).Return(nil)
+		return router, mockDB, mockCache
+	}
+
+	t.Run("RendersExpiredPage", func(t *testing.T) {
+		router, _, mockCache := setup()
+
+		req, _ := http.NewRequest("GET", "/promo", nil)
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), "Summer Offer")
+		assert.Contains(t, w.Body.String(), "Half price on everything")
+		mockCache.AssertNotCalled(t, "SetNotFound", mock.Anything, mock.Anything)
+	})
+
+	t.Run("AcceptJSONReturnsError", func(t *testing.T) {
+		router, _, _ := setup()
+
+		req, _ := http.NewRequest("GET", "/promo", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.JSONEq(t, `{"error":{"code":"url_expired","message":"URL has expired"}}`, w.Body.String())
+	})
+
+	t.Run("CachesExpiredLink", func(t *testing.T) {
+		router, _, mockCache := setup()
+
+		req, _ := http.NewRequest("GET", "/promo", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		mockCache.AssertCalled(t, "SetExpiredURL", mock.Anything, "promo", expiredURL)
+	})
+
+	t.Run("CachedExpiredLinkSkipsDatabase", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		mockCache.On("GetURL", mock.Anything, "promo").Return(expiredURL, nil)
+
+		req, _ := http.NewRequest("GET", "/promo", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		mockDB.AssertNotCalled(t, "GetURLByShortPath", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "GetExpiredURLByShortPath", mock.Anything, mock.Anything)
+	})
+}
+
+func TestReserveURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	post := func(handler *Handler, body string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/urls/reserve", handler.ReserveURL)
+
+		req, _ := http.NewRequest("POST", "/urls/reserve", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("ReservesWithTTL", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.ReservationTTL = 24 * time.Hour
+
+		until := time.Now().Add(24 * time.Hour)
+		held := &database.URL{ID: uuid.New(), ShortPath: "summer-sale", ReservedUntil: &until}
+		mockDB.On("ReserveShortPath", mock.Anything, "summer-sale", mock.MatchedBy(func(t time.Time) bool {
+			return time.Until(t) > 23*time.Hour && time.Until(t) <= 24*time.Hour
+		})).Return(held, nil)
+		mockCache.On("DeleteNotFound", mock.Anything, "summer-sale").Return(nil)
+
+		w := post(handler, `{"short_path":"summer-sale"}`)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response database.URL
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "summer-sale", response.ShortPath)
+		assert.NotNil(t, response.ReservedUntil)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("ReservedPathRejected", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+
+		w := post(handler, `{"short_path":"admin"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "ReserveShortPath", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("TakenPathConflicts", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		mockDB.On("ReserveShortPath", mock.Anything, "taken", mock.Anything).
+			Return(nil, fmt.Errorf("failed to reserve short path: %w", &pq.Error{Code: "23505"}))
+
+		w := post(handler, `{"short_path":"taken"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("RedirectShowsComingSoon", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		handler.templates.Store(&pageTemplates{comingSoon: template.Must(template.New("soon").Parse(`<h1>Coming soon</h1>`))})
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		until := time.Now().Add(time.Hour)
+		held := &database.URL{ID: uuid.New(), ShortPath: "summer-sale", ReservedUntil: &until}
+		mockCache.On("GetURL", mock.Anything, "summer-sale").Return(held, nil)
+
+		req, _ := http.NewRequest("GET", "/summer-sale", nil)
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "Coming soon")
+	})
+}
+
+func TestRedirectScheduledActivation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	get := func(url *database.URL) int {
+		handler, _, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		mockCache.On("GetURL", mock.Anything, url.ShortPath).Return(url, nil)
+
+		req, _ := http.NewRequest("GET", "/"+url.ShortPath, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	t.Run("NotYetActive", func(t *testing.T) {
+		code := get(&database.URL{ID: uuid.New(), ShortPath: "launch", Destination: "https://example.com", ActivateAt: &future})
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("Active", func(t *testing.T) {
+		later := time.Now().Add(2 * time.Hour)
+		code := get(&database.URL{ID: uuid.New(), ShortPath: "launch", Destination: "https://example.com", ActivateAt: &past, ExpiresAt: &later})
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("ActivateAfterExpiryRejected", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
+
+		jsonBody, _ := json.Marshal(database.CreateURLRequest{
+			Destination: "https://example.com",
+			ActivateAt:  &future,
+			ExpiresAt:   &future,
+		})
+		req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("UpdateComparesWithStoredTimes", func(t *testing.T) {
+		later := future.Add(time.Hour)
+		stored := &database.URL{ID: uuid.New(), ShortPath: "launch", Destination: "https://example.com", ActivateAt: &future}
+
+		update := func(method, body string) (int, *MockDatabase) {
+			handler, mockDB, mockCache := setupTestHandler()
+			router := gin.New()
+			router.PUT("/urls/:id", handler.UpdateURL)
+			router.PATCH("/urls/:id", handler.PatchURL)
+			mockDB.On("GetURLByID", mock.Anything, stored.ID).Return(stored, nil)
+			mockDB.On("UpdateURL", mock.Anything, stored.ID, mock.Anything).Return(stored, nil)
+			mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+			req, _ := http.NewRequest(method, "/urls/"+stored.ID.String(), bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code, mockDB
+		}
+		body := func(field string, at time.Time) string {
+			return `{"` + field + `":"` + at.Format(time.RFC3339Nano) + `"}`
+		}
+
+		for _, method := range []string{"PUT", "PATCH"} {
+			// Expiring at or before the stored activation is rejected
+			code, mockDB := update(method, body("expires_at", future))
+			assert.Equal(t, http.StatusBadRequest, code, method)
+			mockDB.AssertNotCalled(t, "UpdateURL", mock.Anything, mock.Anything, mock.Anything)
+
+			code, _ = update(method, body("expires_at", later))
+			assert.Equal(t, http.StatusOK, code, method)
+
+			// Clearing the activation lifts the constraint
+			soon := time.Now().Add(30 * time.Minute)
+			code, _ = update(method, `{"activate_at":null,"expires_at":"`+soon.Format(time.RFC3339Nano)+`"}`)
+			assert.Equal(t, http.StatusOK, code, method)
+		}
+	})
+}
+
+func TestRedirectMaxUses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	intPtr := func(i int) *int { return &i }
+
+	t.Run("CountsUseAndRedirects", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		cachedURL := &database.URL{ID: uuid.New(), ShortPath: "once", Destination: "https://example.com/file", MaxUses: intPtr(3), UseCount: 1}
+		consumed := *cachedURL
+		consumed.UseCount = 2
+
+		mockCache.On("GetURL", mock.Anything, "once").Return(cachedURL, nil)
+		mockDB.On("ConsumeURLUse", mock.Anything, cachedURL.ID).Return(&consumed, nil)
+
+		req, _ := http.NewRequest("GET", "/once", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockCache.AssertNotCalled(t, "DeleteURL", mock.Anything, mock.Anything)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("LastUseInvalidatesCache", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		cachedURL := &database.URL{ID: uuid.New(), ShortPath: "once", Destination: "https://example.com/file", MaxUses: intPtr(1)}
+		consumed := *cachedURL
+		consumed.UseCount = 1
+
+		mockCache.On("GetURL", mock.Anything, "once").Return(cachedURL, nil)
+		mockDB.On("ConsumeURLUse", mock.Anything, cachedURL.ID).Return(&consumed, nil)
+		mockCache.On("DeleteURL", mock.Anything, "once").Return(nil)
+		mockCache.On("DeleteURLByID", mock.Anything, cachedURL.ID.String()).Return(nil)
+
+		req, _ := http.NewRequest("GET", "/once", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("ExhaustedLinkIsExpired", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		cachedURL := &database.URL{ID: uuid.New(), ShortPath: "once", Destination: "https://example.com/file", MaxUses: intPtr(1)}
+
+		mockCache.On("GetURL", mock.Anything, "once").Return(cachedURL, nil)
+		mockDB.On("ConsumeURLUse", mock.Anything, cachedURL.ID).Return(nil, nil)
+		mockCache.On("DeleteURL", mock.Anything, "once").Return(nil)
+		mockCache.On("DeleteURLByID", mock.Anything, cachedURL.ID.String()).Return(nil)
+
+		req, _ := http.NewRequest("GET", "/once", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		mockCache.AssertExpectations(t)
+	})
+}
+
+func TestRedirectTraceAttributes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	handler, _, mockCache := setupTestHandler()
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	cachedURL := &database.URL{
+		ID:          uuid.New(),
+		ShortPath:   "promo",
+		Destination: "https://Shop.Example.com/offer?utm_source=email",
+		Owner:       stringPtr("marketing"),
+		Campaign:    stringPtr("carnaval-2025"),
+	}
+	mockCache.On("GetURL", mock.Anything, "promo").Return(cachedURL, nil)
+
+	req, _ := http.NewRequest("GET", "/promo", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+
+	attrs := make(map[string]string)
+	for _, attr := range spans[0].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, "promo", attrs["url.short_path"])
+	assert.Equal(t, "marketing", attrs["url.owner"])
+	assert.Equal(t, "carnaval-2025", attrs["url.campaign"])
+	assert.Equal(t, "shop.example.com", attrs["url.destination_host"])
+	assert.Equal(t, cachedURL.ID.String(), attrs["url.id"])
+	assert.Equal(t, "true", attrs["cache.hit"])
+	assert.Equal(t, "false", attrs["url.expired"])
+	assert.Equal(t, "200", attrs["http.response.status_code"])
+	for _, value := range attrs {
+		assert.NotContains(t, value, "utm_source", "raw destinations must not be recorded")
+	}
+}
+
+// recordSpans installs a span recorder as the global tracer provider for the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// lastSpanAttributes returns the attributes of the most recently ended span
+func lastSpanAttributes(t *testing.T, recorder *tracetest.SpanRecorder) map[string]string {
+	spans := recorder.Ended()
+	require.NotEmpty(t, spans)
+
+	attrs := make(map[string]string)
+	for _, attr := range spans[len(spans)-1].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	return attrs
+}
+
+func TestHandlerSpanAttributes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("RedirectExpiredFromDatabase", func(t *testing.T) {
+		recorder := recordSpans(t)
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		expiredAt := time.Now().Add(-time.Hour)
+		expired := &database.URL{ID: uuid.New(), ShortPath: "old", Destination: "https://example.com", ExpiresAt: &expiredAt}
+		mockCache.On("GetURL", mock.Anything, "old").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, "old").Return(false, nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, "old").Return(nil, nil)
+		mockDB.On("GetExpiredURLByShortPath", mock.Anything, "old").Return(expired, nil)
+		mockCache.On("SetExpiredURL", mock.Anything, "old", expired).Return(nil)
+
+		req, _ := http.NewRequest("GET", "/old", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		attrs := lastSpanAttributes(t, recorder)
+		assert.Equal(t, "old", attrs["url.short_path"])
+		assert.Equal(t, "false", attrs["cache.hit"])
+		assert.Equal(t, "true", attrs["url.expired"])
+		assert.Equal(t, "410", attrs["http.response.status_code"])
+	})
+
+	t.Run("GetURLCacheMiss", func(t *testing.T) {
+		recorder := recordSpans(t)
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/urls/:id", handler.GetURL)
+
+		url := &database.URL{ID: uuid.New(), ShortPath: "abc123", Destination: "https://example.com"}
+		mockCache.On("GetURLByID", mock.Anything, url.ID.String()).Return(nil, nil)
+		mockDB.On("GetURLByID", mock.Anything, url.ID).Return(url, nil)
+		mockCache.On("SetURLByID", mock.Anything, url.ID.String(), url).Return(nil)
+		mockCache.On("SetURL", mock.Anything, "abc123", url).Return(nil)
+
+		req, _ := http.NewRequest("GET", "/urls/"+url.ID.String(), nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		attrs := lastSpanAttributes(t, recorder)
+		assert.Equal(t, url.ID.String(), attrs["url.id"])
+		assert.Equal(t, "abc123", attrs["url.short_path"])
+		assert.Equal(t, "false", attrs["cache.hit"])
+		assert.Equal(t, "200", attrs["http.response.status_code"])
+	})
+
+	t.Run("GetURLNotFound", func(t *testing.T) {
+		recorder := recordSpans(t)
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/urls/:id", handler.GetURL)
+
+		id := uuid.New()
+		mockCache.On("GetURLByID", mock.Anything, id.String()).Return(nil, nil)
+		mockDB.On("GetURLByID", mock.Anything, id).Return(nil, nil)
+
+		req, _ := http.NewRequest("GET", "/urls/"+id.String(), nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		attrs := lastSpanAttributes(t, recorder)
+		assert.Equal(t, id.String(), attrs["url.id"])
+		assert.Equal(t, "404", attrs["http.response.status_code"])
+	})
+}
+
+func TestRedirectJSONManifest(t *testing.T) {
+	handler, _, mockCache := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	t.Run("AcceptJSONReturnsManifest", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		cachedURL := &database.URL{
+			ID:          uuid.New(),
+			ShortPath:   "spa123",
+			Destination: "https://example.com/app",
+			ExpiresAt:   &expiresAt,
+		}
+
+		mockCache.On("GetURL", mock.Anything, "spa123").Return(cachedURL, nil)
+
+		req, _ := http.NewRequest("GET", "/spa123", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+		var response RedirectManifest
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/app", response.Destination)
+		assert.Equal(t, "spa123", response.ShortPath)
+		require.NotNil(t, response.ExpiresAt)
+		assert.True(t, expiresAt.Equal(*response.ExpiresAt))
+
+		mockCache.AssertExpectations(t)
+	})
+}
+
+func TestRedirectRecordsClick(t *testing.T) {
+	handler, mockDB, mockCache := setupTestHandler()
+	handler.config.ClickTracking = true
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	cachedURL := &database.URL{
+		ID:          uuid.New(),
+		ShortPath:   "clicked",
+		Destination: "https://example.com/app",
+	}
+	mockCache.On("GetURL", mock.Anything, "clicked").Return(cachedURL, nil)
+	mockDB.On("RecordClick", mock.Anything, mock.MatchedBy(func(click database.Click) bool {
+		return click.URLID == cachedURL.ID && click.Referrer == "https://news.example.com/story" &&
+			click.UserAgent == "TestAgent/1.0" && !click.At.IsZero()
+	})).Return(nil)
+
+	req, _ := http.NewRequest("GET", "/clicked", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Referer", "https://news.example.com/story")
+	req.Header.Set("User-Agent", "TestAgent/1.0")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRedirectHEAD(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("ResolvesWithoutCountingVisit", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.ClickTracking = true
+		handler.templates.Store(&pageTemplates{redirect: template.Must(template.New("redirect").Parse(`<a href="{{ .Destination }}">go</a>`))})
+		router := gin.New()
+		router.HEAD("/:shortPath", handler.Redirect)
+
+		maxUses := 5
+		cachedURL := &database.URL{
+			ID:          uuid.New(),
+			ShortPath:   "checked",
+			Destination: "https://example.com/app",
+			MaxUses:     &maxUses,
+		}
+		mockCache.On("GetURL", mock.Anything, "checked").Return(cachedURL, nil)
+
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		resp, err := http.Head(server.URL + "/checked")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+		assert.Empty(t, body)
+		mockDB.AssertNotCalled(t, "ConsumeURLUse", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "RecordClick", mock.Anything, mock.Anything)
+	})
+
+	t.Run("ExpiredLinkIsGone", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.templates.Store(&pageTemplates{expired: template.Must(template.New("expired").Parse(`expired`))})
+		router := gin.New()
+		router.HEAD("/:shortPath", handler.Redirect)
+
+		past := time.Now().Add(-time.Hour)
+		expiredURL := &database.URL{ID: uuid.New(), ShortPath: "promo", Destination: "https://example.com", ExpiresAt: &past}
+		mockCache.On("GetURL", mock.Anything, "promo").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, "promo").Return(false, nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, "promo").Return(nil, nil)
+		mockDB.On("GetExpiredURLByShortPath", mock.Anything, "promo").Return(expiredURL, nil)
+		mockCache.On("SetExpiredURL", mock.Anything, "promo", expiredURL).Return(nil)
+
+		req, _ := http.NewRequest("HEAD", "/promo", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+	})
+
+	t.Run("InstantReferrerSendsLocation", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		router := gin.New()
+		router.HEAD("/:shortPath", handler.Redirect)
+
+		cachedURL := &database.URL{
+			ID:               uuid.New(),
+			ShortPath:        "instant",
+			Destination:      "https://example.com/app",
+			InstantReferrers: []string{"news.example.com"},
+		}
+		mockCache.On("GetURL", mock.Anything, "instant").Return(cachedURL, nil)
+
+		req, _ := http.NewRequest("HEAD", "/instant", nil)
+		req.Header.Set("Referer", "https://news.example.com/story")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/app", w.Header().Get("Location"))
+	})
+}
+
+func TestRedirectRecordsClickInBackground(t *testing.T) {
+	handler, mockDB, mockCache := setupTestHandler()
+	handler.config.ClickTracking = true
+	handler.startClickWriter()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	cachedURL := &database.URL{ID: uuid.New(), ShortPath: "clicked", Destination: "https://example.com/app"}
+	mockCache.On("GetURL", mock.Anything, "clicked").Return(cachedURL, nil)
+
+	// The write blocks until released, so the redirect must not wait for it
+	release := make(chan struct{})
+	recorded := make(chan database.Click, 1)
+	mockDB.On("RecordClick", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		<-release
+		recorded <- args.Get(1).(database.Click)
+	}).Return(nil)
+
+	req, _ := http.NewRequest("GET", "/clicked", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	close(release)
+	select {
+	case click := <-recorded:
+		assert.Equal(t, cachedURL.ID, click.URLID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("click was not recorded")
+	}
+}
+
+// recordingNotifier keeps the click events it is sent
+type recordingNotifier struct {
+	events []any
+}
+
+func (n *recordingNotifier) Send(event any) bool {
+	n.events = append(n.events, event)
+	return true
+}
+
+func TestRedirectNotifiesWebhook(t *testing.T) {
+	handler, _, mockCache := setupTestHandler()
+	notifier := &recordingNotifier{}
+	handler.clickWebhook = notifier
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	cachedURL := &database.URL{
+		ID:           uuid.New(),
+		ShortPath:    "clicked",
+		Destination:  "https://example.com/app",
+		StaticParams: database.StringMap{"utm_source": "webhook"},
+	}
+	mockCache.On("GetURL", mock.Anything, "clicked").Return(cachedURL, nil)
+	mockCache.On("GetURL", mock.Anything, "gone").Return(nil, nil)
+	mockCache.On("IsNotFound", mock.Anything, "gone").Return(true, nil)
+
+	req, _ := http.NewRequest("GET", "/clicked", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "TestAgent/1.0")
+	req.Header.Set("Referer", "https://news.example.com/")
+	req.RemoteAddr = "203.0.113.7:51234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Len(t, notifier.events, 1)
+	event, ok := notifier.events[0].(ClickEvent)
+	require.True(t, ok)
+	assert.Equal(t, "clicked", event.ShortPath)
+	assert.Equal(t, "https://example.com/app?utm_source=webhook", event.Destination)
+	assert.Equal(t, "TestAgent/1.0", event.UserAgent)
+	assert.Equal(t, "203.0.113.7", event.IP)
+	assert.Equal(t, "https://news.example.com/", event.Referrer)
+	assert.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+
+	// Visits that don't resolve send nothing
+	req, _ = http.NewRequest("GET", "/gone", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, notifier.events, 1)
+}
+
+func TestRedirectSharesDatabaseLookups(t *testing.T) {
+	handler, mockDB, mockCache := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	const requests = 5
+	var misses sync.WaitGroup
+	misses.Add(requests)
+	release := make(chan struct{})
+
+	url := &database.URL{ID: uuid.New(), ShortPath: "viral", Destination: "https://example.com/viral"}
+	mockCache.On("GetURL", mock.Anything, "viral").Return(nil, nil)
+	mockCache.On("IsNotFound", mock.Anything, "viral").Run(func(mock.Arguments) { misses.Done() }).Return(false, nil)
+	mockCache.On("SetURL", mock.Anything, "viral", mock.Anything).Return(nil)
+	mockDB.On("GetURLByShortPath", mock.Anything, "viral").Run(func(mock.Arguments) { <-release }).Return(url, nil)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/viral", nil)
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+
+	// Let every request miss the cache and reach the lookup before the query returns
+	misses.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	mockDB.AssertNumberOfCalls(t, "GetURLByShortPath", 1)
+}
+
+func TestRedirectSocialTags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Render the built-in page, which is what the tags live in
+	pages, err := parseTemplates(&config.Config{})
+	require.NoError(t, err)
+
+	title := "Carnaval"
+	image := "https://example.com/banner.png"
+	render := func(card string, url *database.URL) string {
+		handler, _, mockCache := setupTestHandler()
+		handler.config.TwitterCard = card
+		handler.templates.Store(pages)
+
+		url.ID = uuid.New()
+		url.ShortPath = "social"
+		url.Destination = "https://example.com/event"
+		url.Title = &title
+		mockCache.On("GetURL", mock.Anything, "social").Return(url, nil)
+
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		req, _ := http.NewRequest("GET", "/social", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	t.Run("AutoCardFollowsImage", func(t *testing.T) {
+		assert.Contains(t, render("auto", &database.URL{ImageURL: &image}), `<meta name="twitter:card" content="summary_large_image">`)
+		assert.Contains(t, render("auto", &database.URL{}), `<meta name="twitter:card" content="summary">`)
+	})
+
+	t.Run("ConfiguredCard", func(t *testing.T) {
+		assert.Contains(t, render("summary", &database.URL{ImageURL: &image}), `<meta name="twitter:card" content="summary">`)
+	})
+
+	t.Run("HiddenPerURL", func(t *testing.T) {
+		body := render("auto", &database.URL{ImageURL: &image, HideSocialTags: true})
+		assert.NotContains(t, body, "og:")
+		assert.NotContains(t, body, "twitter:")
+		assert.NotContains(t, body, title)
+		// The page still redirects
+		assert.Contains(t, body, `http-equiv="refresh"`)
+	})
+}
+
+func TestRedirectPageQR(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	render := func(enabled, showQR bool) string {
+		handler, _, mockCache := setupTestHandler()
+		handler.config.RedirectPageQR = enabled
+		handler.templates.Store(&pageTemplates{redirect: template.Must(template.New("redirect").Parse(`{{ if .QRCode }}<img src="{{ .QRCode }}">{{ end }}`))})
+
+		url := &database.URL{ID: uuid.New(), ShortPath: "poster", Destination: "https://example.com/event", ShowQR: showQR}
+		mockCache.On("GetURL", mock.Anything, "poster").Return(url, nil)
+
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		req, _ := http.NewRequest("GET", "/poster", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	t.Run("EmbedsDestinationQR", func(t *testing.T) {
+		body := render(true, true)
+
+		const prefix = `<img src="data:image/png;base64,`
+		require.True(t, strings.HasPrefix(body, prefix), body)
+		// html/template entity-escapes '+' in attributes; browsers decode it
+		encoded := html.UnescapeString(strings.TrimSuffix(strings.TrimPrefix(body, prefix), `">`))
+		imgData, err := base64.StdEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+
+		decoded, err := qrcode.Decode(imgData)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/event", decoded)
+	})
+
+	t.Run("OptInPerURL", func(t *testing.T) {
+		assert.Empty(t, render(true, false))
+	})
+
+	t.Run("DisabledByConfig", func(t *testing.T) {
+		assert.Empty(t, render(false, true))
+	})
+}
+
+func TestClickTimeSeries(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/urls/:id/clicks/timeseries", handler.ClickTimeSeries)
+
+	id := uuid.New()
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(48 * time.Hour)
+
+	t.Run("Success", func(t *testing.T) {
+		series := &database.ClickTimeSeries{
+			URLID:    id,
+			Interval: database.IntervalDay,
+			From:     from,
+			To:       to,
+			Total:    3,
+			Buckets: []database.ClickBucket{
+				{Start: from, Clicks: 3},
+				{Start: from.Add(24 * time.Hour), Clicks: 0},
+			},
+		}
+		mockDB.On("GetURLByID", mock.Anything, id).Return(&database.URL{ID: id, ShortPath: "abc"}, nil).Once()
+		mockDB.On("ClickTimeSeries", mock.Anything, id, from, to, database.IntervalDay).Return(series, nil).Once()
+
+		req, _ := http.NewRequest("GET", "/urls/"+id.String()+"/clicks/timeseries?from=2024-03-01T00:00:00Z&to=2024-03-03T00:00:00Z", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response database.ClickTimeSeries
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 3, response.Total)
+		assert.Len(t, response.Buckets, 2)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		mockDB.On("GetURLByID", mock.Anything, mock.Anything).Return(nil, nil).Once()
+
+		req, _ := http.NewRequest("GET", "/urls/"+uuid.New().String()+"/clicks/timeseries", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		cases := map[string]string{
+			"InvalidInterval": "?interval=week",
+			"InvalidFrom":     "?from=yesterday",
+			"FromAfterTo":     "?from=2024-03-03T00:00:00Z&to=2024-03-01T00:00:00Z",
+			"TooManyBuckets":  "?interval=hour&from=2024-01-01T00:00:00Z&to=2024-03-01T00:00:00Z",
+		}
+		for name, query := range cases {
+			t.Run(name, func(t *testing.T) {
+				req, _ := http.NewRequest("GET", "/urls/"+id.String()+"/clicks/timeseries"+query, nil)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+			})
+		}
+	})
+}
+
+func TestRedirectInstantReferrers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tmpl := template.Must(template.New("redirect").Parse(`preview {{ .Destination }}`))
+	cachedURL := &database.URL{
+		ID:               uuid.New(),
+		ShortPath:        "internal",
+		Destination:      "https://example.com/target",
+		InstantReferrers: database.StringList{"intranet.example.org"},
+	}
+
+	newRouter := func(cfg *config.Config) *gin.Engine {
+		mockCache := new(MockCache)
+		mockCache.On("GetURL", mock.Anything, "internal").Return(cachedURL, nil)
+		handler := NewWithTemplate(new(MockDatabase), mockCache, cfg, tmpl)
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		return router
+	}
+
+	t.Run("PerURLMatchRedirectsInstantly", func(t *testing.T) {
+		router := newRouter(&config.Config{})
+
+		req, _ := http.NewRequest("GET", "/internal", nil)
+		req.Header.Set("Referer", "https://portal.intranet.example.org/home")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/target", w.Header().Get("Location"))
+	})
+
+	t.Run("GlobalMatchRedirectsInstantly", func(t *testing.T) {
+		router := newRouter(&config.Config{InstantReferrers: []string{"apps.rio"}})
+
+		req, _ := http.NewRequest("GET", "/internal", nil)
+		req.Header.Set("Referer", "https://apps.rio/page")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("NonMatchingRefererGetsPreview", func(t *testing.T) {
+		router := newRouter(&config.Config{InstantReferrers: []string{"apps.rio"}})
+
+		req, _ := http.NewRequest("GET", "/internal", nil)
+		req.Header.Set("Referer", "https://notintranet.example.org/")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "preview https://example.com/target")
+	})
+
+	t.Run("MissingRefererGetsPreview", func(t *testing.T) {
+		router := newRouter(&config.Config{})
+
+		req, _ := http.NewRequest("GET", "/internal", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "preview")
+	})
+}
+
+// Helper function to create string pointers
+func stringPtr(s string) *string {
+	return &s
+}
+func TestReservedPathAPIPrefix(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+	handler.config.APIPrefix = "/link/api"
+
+	assert.True(t, isReservedPath(handler.config, "link"))
+	assert.True(t, isReservedPath(handler.config, "LINK"))
+	assert.True(t, isReservedPath(handler.config, "swagger"))
+	assert.False(t, isReservedPath(handler.config, "linked"))
+	assert.ErrorIs(t, ValidateShortPath(handler.config, "link"), ErrReservedShortPath)
+}
+
+func TestReservedPathHealthPath(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+	handler.config.ReservedPaths = nil
+	handler.config.HealthPath = "/healthz"
+
+	assert.True(t, isReservedPath(handler.config, "healthz"))
+	assert.False(t, isReservedPath(handler.config, "health"))
+}
+
+func TestReservedPathsFromConfig(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+	handler.config.ReservedPaths = []string{"blog", "Careers"}
+
+	assert.True(t, isReservedPath(handler.config, "blog"))
+	assert.True(t, isReservedPath(handler.config, "careers"))
+	assert.True(t, isReservedPath(handler.config, "BLOG"))
+	assert.False(t, isReservedPath(handler.config, "about"))
+	assert.NoError(t, ValidateShortPath(handler.config, "about"))
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" Carnaval ", "events", "carnaval", "health_dept"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"carnaval", "events", "health_dept"}, tags)
+
+	for _, invalid := range [][]string{{""}, {"two words"}, {"quote\""}, {strings.Repeat("a", maxTagLength+1)}} {
+		_, err := normalizeTags(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	tooMany := make([]string, maxTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag%d", i)
+	}
+	_, err = normalizeTags(tooMany)
+	assert.Error(t, err)
+}
+
+func TestCreateURLTags(t *testing.T) {
+	handler, mockDB, mockCache := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/urls", handler.CreateURL)
+
+	created := &database.URL{ID: uuid.New(), ShortPath: "tagged", Destination: "https://example.com", Tags: database.StringList{"carnaval"}}
+	mockDB.On("CreateURL", mock.Anything, mock.MatchedBy(func(req database.CreateURLRequest) bool {
+		return assert.ObjectsAreEqual([]string{"carnaval"}, req.Tags)
+	})).Return(created, nil)
+	mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/urls", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"destination":"https://example.com","tags":["Carnaval","carnaval"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"tags":["carnaval"]`)
+
+	w = post(`{"destination":"https://example.com","tags":["no spaces"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNumberOfCalls(t, "CreateURL", 1)
+}
+
+func TestCreateURLAutoMetadata(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/event" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<head><meta property="og:title" content="Carnaval">` +
+			`<meta property="og:description" content="Programação"><meta property="og:image" content="/cover.png"></head>`))
+	}))
+	defer page.Close()
+
+	// The test page is on loopback, which destinations may not normally reach.
+	// Pooled connections skip the check, so they are dropped on every change.
+	allowAddrs := func(allowed func(netip.Addr) bool) {
+		destinationAddrAllowed = allowed
+		metadataClient.CloseIdleConnections()
+	}
+	allowAll := func(netip.Addr) bool { return true }
+	allowAddrs(allowAll)
+	defer allowAddrs(publicAddr)
+
+	gin.SetMode(gin.TestMode)
+
+	var stored database.CreateURLRequest
+	setup := func() *gin.Engine {
+		handler, mockDB, mockCache := setupTestHandler()
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			stored = args.Get(1).(database.CreateURLRequest)
+		}).Return(&database.URL{ID: uuid.New(), ShortPath: "event"}, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
+		return router
+	}
+	post := func(router *gin.Engine, path, body string) int {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("FillsUnsetFields", func(t *testing.T) {
+		router := setup()
+		code := post(router, "/urls", `{"destination":"`+page.URL+`/event","title":"Mine","auto_metadata":true}`)
+
+		require.Equal(t, http.StatusCreated, code)
+		assert.Equal(t, "Mine", *stored.Title)
+		assert.Equal(t, "Programação", *stored.Description)
+		assert.Equal(t, page.URL+"/cover.png", *stored.ImageURL)
+	})
+
+	t.Run("QueryParameter", func(t *testing.T) {
+		router := setup()
+		code := post(router, "/urls?auto_metadata=true", `{"destination":"`+page.URL+`/event"}`)
+
+		require.Equal(t, http.StatusCreated, code)
+		require.NotNil(t, stored.Title)
+		assert.Equal(t, "Carnaval", *stored.Title)
+	})
+
+	t.Run("FetchFailureStillCreates", func(t *testing.T) {
+		router := setup()
+		code := post(router, "/urls", `{"destination":"`+page.URL+`/gone","auto_metadata":true}`)
+
+		require.Equal(t, http.StatusCreated, code)
+		assert.Nil(t, stored.Title)
+		assert.Nil(t, stored.ImageURL)
+	})
+
+	t.Run("OffByDefault", func(t *testing.T) {
+		router := setup()
+		code := post(router, "/urls", `{"destination":"`+page.URL+`/event"}`)
+
+		require.Equal(t, http.StatusCreated, code)
+		assert.Nil(t, stored.Title)
+	})
+
+	t.Run("SkipsNonPublicAddresses", func(t *testing.T) {
+		allowAddrs(publicAddr)
+		defer allowAddrs(allowAll)
+
+		router := setup()
+		code := post(router, "/urls", `{"destination":"`+page.URL+`/event","auto_metadata":true}`)
+
+		require.Equal(t, http.StatusCreated, code)
+		assert.Nil(t, stored.Title)
+	})
+
+	t.Run("ChecksRedirectTargets", func(t *testing.T) {
+		// A second loopback address stands in for an internal host
+		listener, err := net.Listen("tcp", "127.0.0.2:0")
+		if err != nil {
+			t.Skipf("cannot listen on 127.0.0.2: %v", err)
+		}
+		internal := httptest.NewUnstartedServer(page.Config.Handler)
+		internal.Listener.Close()
+		internal.Listener = listener
+		internal.Start()
+		defer internal.Close()
+
+		redirect := httptest.NewServer(http.RedirectHandler(internal.URL+"/event", http.StatusFound))
+		defer redirect.Close()
+
+		router := setup()
+		code := post(router, "/urls", `{"destination":"`+redirect.URL+`","auto_metadata":true}`)
+		require.Equal(t, http.StatusCreated, code)
+		require.NotNil(t, stored.Title)
+
+		allowAddrs(func(addr netip.Addr) bool { return addr == netip.MustParseAddr("127.0.0.1") })
+		defer allowAddrs(allowAll)
+
+		router = setup()
+		code = post(router, "/urls", `{"destination":"`+redirect.URL+`","auto_metadata":true}`)
+		require.Equal(t, http.StatusCreated, code)
+		assert.Nil(t, stored.Title)
+	})
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:4700::1111":        true,
+		"127.0.0.1":              false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"100.64.0.1":             false,
+		"0.0.0.0":                false,
+		"255.255.255.255":        false,
+		"224.0.0.1":              false,
+		"::1":                    false,
+		"fe80::1":                false,
+		"fd00:ec2::254":          false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+		"64:ff9b::a9fe:a9fe":     false,
+	} {
+		assert.Equal(t, public, publicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestCaseInsensitivePaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("CreateLowercasesPath", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.CaseInsensitivePaths = true
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
+
+		created := &database.URL{ID: uuid.New(), ShortPath: "summer-promo", Destination: "https://example.com"}
+		mockDB.On("CreateURL", mock.Anything, mock.MatchedBy(func(req database.CreateURLRequest) bool {
+			return *req.ShortPath == "summer-promo"
+		})).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest("POST", "/urls", strings.NewReader(`{"short_path":"Summer-Promo","destination":"https://example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("RedirectLowercasesPath", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		handler.config.CaseInsensitivePaths = true
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		cachedURL := &database.URL{ID: uuid.New(), ShortPath: "promo", Destination: "https://example.com"}
+		mockCache.On("GetURL", mock.Anything, "promo").Return(cachedURL, nil)
+
+		req, _ := http.NewRequest("GET", "/PROMO", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("DisabledKeepsCase", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+
+		mockCache.On("GetURL", mock.Anything, "PROMO").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, "PROMO").Return(true, nil)
+
+		req, _ := http.NewRequest("GET", "/PROMO", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		mockDB.AssertNotCalled(t, "GetURLByShortPath", mock.Anything, mock.Anything)
+	})
+}
+
+func TestValidateShortPath(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+	cfg := handler.config
+
+	valid := []string{"abc123", "team_name_2024", "release.v2", "my-link", "a"}
+	for _, path := range valid {
+		assert.NoError(t, ValidateShortPath(cfg, path), path)
+	}
+
+	invalid := []string{"", "-abc", "abc-", "_abc", "abc_", ".abc", "abc.", "has space", "slash/path", "emoji✓"}
+	for _, path := range invalid {
+		assert.ErrorIs(t, ValidateShortPath(cfg, path), ErrInvalidShortPath, path)
+	}
+
+	assert.ErrorIs(t, ValidateShortPath(cfg, "Admin"), ErrReservedShortPath)
+
+	t.Run("ConfiguredLength", func(t *testing.T) {
+		cfg := *cfg
+		cfg.ShortPathMinLength = 4
+		cfg.ShortPathMaxLength = 8
+
+		assert.ErrorIs(t, ValidateShortPath(&cfg, "abc"), ErrInvalidShortPath)
+		assert.NoError(t, ValidateShortPath(&cfg, "abcd"))
+		assert.NoError(t, ValidateShortPath(&cfg, "abcdefgh"))
+		assert.ErrorIs(t, ValidateShortPath(&cfg, "abcdefghi"), ErrInvalidShortPath)
+	})
+}
+
+func TestShortPathCapacity(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/debug/shortpath-capacity", handler.ShortPathCapacity)
+
+	t.Run("Success", func(t *testing.T) {
+		capacity := database.EstimateShortPathCapacity(568002, 6, 62)
+		mockDB.On("ShortPathCapacity", mock.Anything).Return(&capacity, nil).Once()
+
+		req, _ := http.NewRequest("GET", "/debug/shortpath-capacity", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response database.ShortPathCapacity
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, 568002, response.URLCount)
+		assert.Equal(t, 6, response.Length)
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("DatabaseError", func(t *testing.T) {
+		mockDB.On("ShortPathCapacity", mock.Anything).Return(nil, assert.AnError).Once()
+
+		req, _ := http.NewRequest("GET", "/debug/shortpath-capacity", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestRedirectGeoblocking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tmpl := template.Must(template.New("redirect").Parse(`preview {{ .Destination }}`))
+	cfg := &config.Config{CountryHeader: "CF-IPCountry"}
+
+	newRouter := func(url *database.URL) *gin.Engine {
+		mockCache := new(MockCache)
+		mockCache.On("GetURL", mock.Anything, url.ShortPath).Return(url, nil)
+		handler := NewWithTemplate(new(MockDatabase), mockCache, cfg, tmpl)
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		return router
+	}
+
+	get := func(router *gin.Engine, path, country string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if country != "" {
+			req.Header.Set("CF-IPCountry", country)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	blocked := newRouter(&database.URL{
+		ID:               uuid.New(),
+		ShortPath:        "blocked",
+		Destination:      "https://example.com",
+		BlockedCountries: database.StringList{"US", "ca"},
+	})
+
+	t.Run("BlockedCountry", func(t *testing.T) {
+		w := get(blocked, "/blocked", "US")
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+
+		w = get(blocked, "/blocked", "CA")
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	})
+
+	t.Run("OtherCountryAllowed", func(t *testing.T) {
+		w := get(blocked, "/blocked", "BR")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "preview https://example.com")
+	})
+
+	t.Run("UnknownCountryNotBlocked", func(t *testing.T) {
+		w := get(blocked, "/blocked", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	allowed := newRouter(&database.URL{
+		ID:               uuid.New(),
+		ShortPath:        "brazil",
+		Destination:      "https://example.com",
+		AllowedCountries: database.StringList{"BR"},
+	})
+
+	t.Run("AllowedCountry", func(t *testing.T) {
+		w := get(allowed, "/brazil", "br")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("OutsideAllowList", func(t *testing.T) {
+		w := get(allowed, "/brazil", "PT")
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	})
+
+	t.Run("UnknownCountryOutsideAllowList", func(t *testing.T) {
+		w := get(allowed, "/brazil", "XX")
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	})
+}
+
+func TestCreateURLInvalidCountry(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/urls", handler.CreateURL)
+
+	body := `{"destination":"https://example.com","blocked_countries":["USA"]}`
+	req, _ := http.NewRequest("POST", "/urls", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "blocked_countries")
+	mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+}
+
+func TestRedirectRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tmpl := template.Must(template.New("redirect").Parse(`preview {{ .Destination }}`))
+	url := &database.URL{
+		ID:          uuid.New(),
+		ShortPath:   "campaign",
+		Destination: "https://example.com",
+		Rules: database.RedirectRules{
+			{Device: "ios", Destination: "https://apps.apple.com/app/id123"},
+			{Device: "android", Destination: "https://play.google.com/store/apps/details?id=rio"},
+			{Countries: []string{"PT"}, Destination: "https://example.com/pt"},
+			{Languages: []string{"en"}, Destination: "https://example.com/en"},
+		},
+	}
+
+	mockCache := new(MockCache)
+	mockCache.On("GetURL", mock.Anything, url.ShortPath).Return(url, nil)
+	mockCache.On("GetURL", mock.Anything, "plain").Return(&database.URL{ID: uuid.New(), ShortPath: "plain", Destination: "https://example.com"}, nil)
+	handler := NewWithTemplate(new(MockDatabase), mockCache, &config.Config{CountryHeader: "CF-IPCountry"}, tmpl)
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"IOS", map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"}, "https://apps.apple.com/app/id123"},
+		{"Android", map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile"}, "https://play.google.com/store/apps/details?id=rio"},
+		{"Country", map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)", "CF-IPCountry": "PT"}, "https://example.com/pt"},
+		{"FirstMatchWins", map[string]string{"User-Agent": "Mozilla/5.0 (iPhone)", "CF-IPCountry": "PT"}, "https://apps.apple.com/app/id123"},
+		{"Language", map[string]string{"Accept-Language": "fr;q=0.5, en-US, pt;q=0.8"}, "https://example.com/en"},
+		{"DefaultDestination", map[string]string{"User-Agent": "Mozilla/5.0 (Windows NT 10.0)", "Accept-Language": "pt-BR,pt;q=0.9,en;q=0.8"}, "https://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get("/campaign", tt.headers)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "preview "+tt.want, w.Body.String())
+			assert.Contains(t, w.Header().Get("Vary"), "User-Agent")
+		})
+	}
+
+	t.Run("JSONManifest", func(t *testing.T) {
+		w := get("/campaign", map[string]string{"Accept": "application/json", "User-Agent": "Mozilla/5.0 (iPad)"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"destination":"https://apps.apple.com/app/id123"`)
+	})
+
+	t.Run("NoRules", func(t *testing.T) {
+		w := get("/plain", map[string]string{"User-Agent": "Mozilla/5.0 (iPhone)"})
+		assert.Equal(t, "preview https://example.com", w.Body.String())
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+}
+
+func TestClickStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Success", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.GET("/urls/:id/stats", handler.ClickStats)
+
+		id := uuid.New()
+		stats := &database.ClickStats{
+			URLID:         id,
+			Total:         12,
+			Last24h:       2,
+			Last7d:        5,
+			Last30d:       9,
+			TopReferrers:  []database.ClickCount{{Value: "news.example.com", Clicks: 4}},
+			TopUserAgents: []database.ClickCount{},
+		}
+		mockDB.On("GetURLByID", mock.Anything, id).Return(&database.URL{ID: id}, nil)
+		mockDB.On("ClickStats", mock.Anything, id, mock.AnythingOfType("time.Time")).Return(stats, nil)
+
+		req, _ := http.NewRequest("GET", "/urls/"+id.String()+"/stats", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{
+			"url_id": "`+id.String()+`",
+			"total": 12, "last_24h": 2, "last_7d": 5, "last_30d": 9,
+			"top_referrers": [{"value": "news.example.com", "clicks": 4}],
+			"top_user_agents": []
+		}`, w.Body.String())
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.GET("/urls/:id/stats", handler.ClickStats)
+
+		id := uuid.New()
+		mockDB.On("GetURLByID", mock.Anything, id).Return(nil, nil)
+
+		req, _ := http.NewRequest("GET", "/urls/"+id.String()+"/stats", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		mockDB.AssertNotCalled(t, "ClickStats", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("InvalidID", func(t *testing.T) {
+		handler, _, _ := setupTestHandler()
+		router := gin.New()
+		router.GET("/urls/:id/stats", handler.ClickStats)
+
+		req, _ := http.NewRequest("GET", "/urls/not-a-uuid/stats", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestWithQuery(t *testing.T) {
+	visitor := neturl.Values{"utm_source": {"newsletter"}, "ref": {"a", "b"}}
+
+	tests := []struct {
+		name        string
+		destination string
+		url         database.URL
+		want        string
+	}{
+		{"Unchanged", "https://example.com/page?b=2&a=1", database.URL{}, "https://example.com/page?b=2&a=1"},
+		{"ForwardQuery", "https://example.com/page", database.URL{ForwardQuery: true}, "https://example.com/page?ref=a&ref=b&utm_source=newsletter"},
+		{"ExistingQuery", "https://example.com/page?id=7&utm_source=site#top", database.URL{ForwardQuery: true}, "https://example.com/page?id=7&ref=a&ref=b&utm_source=newsletter#top"},
+		{"StaticParams", "https://example.com/page?id=7", database.URL{StaticParams: database.StringMap{"utm_medium": "qr"}}, "https://example.com/page?id=7&utm_medium=qr"},
+		{"StaticParamsWin", "https://example.com/page", database.URL{ForwardQuery: true, StaticParams: database.StringMap{"utm_source": "poster"}}, "https://example.com/page?ref=a&ref=b&utm_source=poster"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, withQuery(tt.destination, &tt.url, visitor))
+		})
+	}
+
+	t.Run("NoVisitorQuery", func(t *testing.T) {
+		url := database.URL{ForwardQuery: true}
+		assert.Equal(t, "https://example.com/page?b=2&a=1", withQuery("https://example.com/page?b=2&a=1", &url, nil))
+	})
+}
+
+func TestRedirectForwardsQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	url := &database.URL{
+		ID:           uuid.New(),
+		ShortPath:    "promo",
+		Destination:  "https://example.com/landing?lang=pt",
+		ForwardQuery: true,
+		StaticParams: database.StringMap{"utm_medium": "shortlink"},
+	}
+	mockCache := new(MockCache)
+	mockCache.On("GetURL", mock.Anything, url.ShortPath).Return(url, nil)
+	handler := NewWithTemplate(new(MockDatabase), mockCache, &config.Config{}, template.Must(template.New("redirect").Parse(`preview {{ .Destination }}`)))
+	router := gin.New()
+	router.GET("/:shortPath", handler.Redirect)
+
+	req, _ := http.NewRequest("GET", "/promo?utm_source=x", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var manifest RedirectManifest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &manifest))
+	assert.Equal(t, "https://example.com/landing?lang=pt&utm_medium=shortlink&utm_source=x", manifest.Destination)
+}
+
+func TestCreateURLInvalidRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{"MissingDestination", `[{"device":"ios"}]`, "rules[0].destination is required"},
+		{"NoCondition", `[{"destination":"https://example.com/a"}]`, "rules[0] needs a device"},
+		{"InvalidDevice", `[{"device":"ios","destination":"https://example.com/a"},{"device":"watch","destination":"https://example.com/b"}]`, "invalid rules[1].device"},
+		{"InvalidCountry", `[{"countries":["PRT"],"destination":"https://example.com/a"}]`, "rules[0].countries"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockDB, _ := setupTestHandler()
+			router := gin.New()
+			router.POST("/urls", handler.CreateURL)
+
+			body := `{"destination":"https://example.com","rules":` + tt.rules + `}`
+			req, _ := http.NewRequest("POST", "/urls", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.want)
+			mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestCreateURLUniqueViolation(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/urls", handler.CreateURL)
+
+	// Wrapped the way the database layer returns it
+	dupErr := fmt.Errorf("failed to create URL: %w", &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint \"urls_short_path_key\""})
+	mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(nil, dupErr)
+
+	jsonBody, _ := json.Marshal(database.CreateURLRequest{
+		ShortPath:   stringPtr("taken"),
+		Destination: "https://example.com",
+	})
+	req, _ := http.NewRequest("POST", "/urls", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "short path already exists")
+}
+
+func TestCacheStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler, mockDB, mockCache := setupTestHandler()
+	handler.cache = l1cache.NewTiered(mockCache, 10, time.Minute)
+	router := gin.New()
+	router.GET("/urls/:id", handler.GetURL)
+	router.GET("/debug/cache-stats", handler.CacheStats)
+
+	stats := func() CacheStatsResponse {
+		req, _ := http.NewRequest("GET", "/debug/cache-stats", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response CacheStatsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	get := func(id uuid.UUID) {
+		req, _ := http.NewRequest("GET", "/urls/"+id.String(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+	}
+
+	cached := &database.URL{ID: uuid.New(), ShortPath: "cached", Destination: "https://example.com"}
+	missing := uuid.New()
+	mockCache.On("GetURLByID", mock.Anything, cached.ID.String()).Return(cached, nil).Once()
+	mockCache.On("GetURLByID", mock.Anything, missing.String()).Return(nil, nil)
+	mockDB.On("GetURLByID", mock.Anything, missing).Return(nil, nil)
+
+	// Counters are process-wide, so compare against the starting totals
+	before := stats().GetURL
+	get(cached.ID) // Redis hit, copied into L1
+	get(cached.ID) // L1 hit
+	get(missing)
+	after := stats().GetURL
+
+	assert.Equal(t, int64(1), after.L1Hits-before.L1Hits)
+	assert.Equal(t, int64(1), after.RedisHits-before.RedisHits)
+	assert.Equal(t, int64(1), after.Misses-before.Misses)
+	assert.Greater(t, after.HitRatio, 0.0)
+	mockCache.AssertExpectations(t)
+}
+
+func TestResolveBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("MixesCachedKnownUnknownAndExpired", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/resolve-batch", handler.ResolveBatch)
+
+		past := time.Now().Add(-time.Hour)
+		cached := &database.URL{ID: uuid.New(), ShortPath: "cached", Destination: "https://example.com/cached"}
+		expired := &database.URL{ID: uuid.New(), ShortPath: "expired", Destination: "https://example.com/old", ExpiresAt: &past}
+		stored := &database.URL{ID: uuid.New(), ShortPath: "stored", Destination: "https://example.com/stored"}
+
+		mockCache.On("GetURL", mock.Anything, "cached").Return(cached, nil)
+		mockCache.On("GetURL", mock.Anything, "expired").Return(expired, nil)
+		mockCache.On("GetURL", mock.Anything, "stored").Return(nil, nil)
+		mockCache.On("GetURL", mock.Anything, "unknown").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, "stored").Return(false, nil)
+		mockCache.On("IsNotFound", mock.Anything, "unknown").Return(false, nil)
+
+		// Both misses are fetched with one query
+		mockDB.On("GetURLsByShortPaths", mock.Anything, []string{"stored", "unknown"}).
+			Return(map[string]*database.URL{"stored": stored}, nil).Once()
+		mockCache.On("SetURL", mock.Anything, "stored", stored).Return(nil)
+		mockCache.On("SetNotFound", mock.Anything, "unknown").Return(nil)
+
+		body := `{"short_paths":["cached","expired","stored","unknown","cached"]}`
+		req, _ := http.NewRequest("POST", "/urls/resolve-batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			URLs map[string]*database.URL `json:"urls"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.URLs, 4)
+		assert.Equal(t, "https://example.com/cached", response.URLs["cached"].Destination)
+		assert.Equal(t, "https://example.com/stored", response.URLs["stored"].Destination)
+		assert.Nil(t, response.URLs["expired"])
+		assert.Nil(t, response.URLs["unknown"])
+		assert.Contains(t, w.Body.String(), `"unknown":null`)
+
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("HidesPasswordProtected", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/resolve-batch", handler.ResolveBatch)
+
+		hash := "hash"
+		cached := &database.URL{ID: uuid.New(), ShortPath: "cached", Destination: "https://example.com/secret-cached", PasswordHash: &hash}
+		stored := &database.URL{ID: uuid.New(), ShortPath: "stored", Destination: "https://example.com/secret-stored", PasswordHash: &hash}
+		open := &database.URL{ID: uuid.New(), ShortPath: "open", Destination: "https://example.com/open"}
+
+		mockCache.On("GetURL", mock.Anything, "cached").Return(cached, nil)
+		mockCache.On("GetURL", mock.Anything, "stored").Return(nil, nil)
+		mockCache.On("GetURL", mock.Anything, "open").Return(nil, nil)
+		mockCache.On("IsNotFound", mock.Anything, mock.Anything).Return(false, nil)
+		mockDB.On("GetURLsByShortPaths", mock.Anything, []string{"stored", "open"}).
+			Return(map[string]*database.URL{"stored": stored, "open": open}, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+		body := `{"short_paths":["cached","stored","open"]}`
+		req, _ := http.NewRequest("POST", "/urls/resolve-batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "secret")
+		assert.Contains(t, w.Body.String(), `"cached":null`)
+		assert.Contains(t, w.Body.String(), `"stored":null`)
+		assert.Contains(t, w.Body.String(), "https://example.com/open")
+	})
+
+	t.Run("RejectsOversizedBatch", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/resolve-batch", handler.ResolveBatch)
+
+		paths := make([]string, maxResolveBatchSize+1)
+		for i := range paths {
+			paths[i] = fmt.Sprintf("p%d", i)
+		}
+		jsonBody, _ := json.Marshal(ResolveBatchRequest{ShortPaths: paths})
+		req, _ := http.NewRequest("POST", "/urls/resolve-batch", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "GetURLsByShortPaths", mock.Anything, mock.Anything)
+	})
+}
+
+func TestBatchGetURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	post := func(router *gin.Engine, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/urls/batch-get", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("MixesCachedStoredAndMissing", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/batch-get", handler.BatchGetURLs)
+
+		note := "ticket #1"
+		cached := &database.URL{ID: uuid.New(), ShortPath: "cached", Destination: "https://example.com/cached", InternalNote: &note}
+		stored := &database.URL{ID: uuid.New(), ShortPath: "stored", Destination: "https://example.com/stored"}
+		unknown := uuid.New()
+
+		mockCache.On("GetURLByID", mock.Anything, cached.ID.String()).Return(cached, nil)
+		mockCache.On("GetURLByID", mock.Anything, stored.ID.String()).Return(nil, nil)
+		mockCache.On("GetURLByID", mock.Anything, unknown.String()).Return(nil, nil)
+
+		// Both misses are fetched with one query
+		mockDB.On("GetURLsByIDs", mock.Anything, []uuid.UUID{unknown, stored.ID}).
+			Return(map[uuid.UUID]*database.URL{stored.ID: stored}, nil).Once()
+		mockCache.On("SetURLByID", mock.Anything, stored.ID.String(), stored).Return(nil)
+		mockCache.On("SetURL", mock.Anything, "stored", stored).Return(nil)
+
+		body := fmt.Sprintf(`{"ids":[%q,%q,%q,%q]}`, unknown, stored.ID, cached.ID, stored.ID)
+		w := post(router, body)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response BatchGetResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.URLs, 2)
+		assert.Equal(t, stored.ID, response.URLs[0].ID)
+		assert.Equal(t, cached.ID, response.URLs[1].ID)
+		assert.Nil(t, response.URLs[1].InternalNote)
+		assert.Equal(t, []string{unknown.String()}, response.Missing)
+
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("RejectsInvalidID", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/batch-get", handler.BatchGetURLs)
+
+		w := post(router, `{"ids":["not-a-uuid"]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), apierror.CodeInvalidURLID)
+		mockDB.AssertNotCalled(t, "GetURLsByIDs", mock.Anything, mock.Anything)
+	})
+
+	t.Run("RejectsOversizedBatch", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls/batch-get", handler.BatchGetURLs)
+
+		ids := make([]string, maxBatchGetSize+1)
+		for i := range ids {
+			ids[i] = uuid.New().String()
+		}
+		jsonBody, _ := json.Marshal(BatchGetRequest{IDs: ids})
+		w := post(router, string(jsonBody))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "GetURLsByIDs", mock.Anything, mock.Anything)
+	})
+}
+
+func TestPasswordProtection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("open sesame"), bcrypt.MinCost)
+	require.NoError(t, err)
+	passwordHash := string(hash)
+	url := &database.URL{ID: uuid.New(), ShortPath: "secret", Destination: "https://example.com/report.pdf", PasswordHash: &passwordHash}
+
+	handler, _, mockCache := setupTestHandler()
+	handler.config.AdminToken = "admin"
+	handler.templates.Store(&pageTemplates{
+		redirect: template.Must(template.New("redirect").Parse(`preview {{ .Destination }}`)),
+		password: template.Must(template.New("password").Parse(`password{{ if .Failed }} failed{{ end }}`)),
+	})
+	mockCache.On("GetURL", mock.Anything, url.ShortPath).Return(url, nil)
+	mockCache.On("GetURLByID", mock.Anything, url.ID.String()).Return(url, nil)
+
+	router := gin.New()
+	router.GET("/urls/:id", handler.GetURL)
+	router.GET("/:shortPath", handler.Redirect)
+	router.POST("/:shortPath", handler.UnlockURL)
+
+	visit := func(cookie *http.Cookie, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/secret?ref=mail", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	unlock := func(password string) *httptest.ResponseRecorder {
+		form := neturl.Values{"password": {password}}
+		req, _ := http.NewRequest("POST", "/secret?ref=mail", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("AsksForPassword", func(t *testing.T) {
+		w := visit(nil, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "password", w.Body.String())
+
+		w = visit(nil, "application/json")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.JSONEq(t, `{"error":{"code":"password_required","message":"password required"}}`, w.Body.String())
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		w := unlock("guess")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "password failed", w.Body.String())
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("CorrectPasswordUnlocks", func(t *testing.T) {
+		w := unlock("open sesame")
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/secret?ref=mail", w.Header().Get("Location"))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "/secret", cookies[0].Path)
+		assert.True(t, cookies[0].HttpOnly)
+
+		w = visit(cookies[0], "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "preview https://example.com/report.pdf", w.Body.String())
+	})
+
+	t.Run("SecureOnlyOverHTTPS", func(t *testing.T) {
+		secure := func() bool {
+			form := neturl.Values{"password": {"open sesame"}}
+			req, _ := http.NewRequest("POST", "/secret", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.RemoteAddr = "10.1.2.3:4000"
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			cookies := w.Result().Cookies()
+			require.Len(t, cookies, 1)
+			return cookies[0].Secure
+		}
+
+		// Any client can claim https; only a trusted proxy is believed
+		assert.False(t, secure())
+		handler.config.TrustedProxies = []string{"10.0.0.0/8"}
+		defer func() { handler.config.TrustedProxies = nil }()
+		assert.True(t, secure())
+	})
+
+	t.Run("TamperedCookieRejected", func(t *testing.T) {
+		expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		w := visit(&http.Cookie{Name: linkAccessCookie, Value: expires + ".forged"}, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("ExpiredCookieRejected", func(t *testing.T) {
+		expires := time.Now().Add(-time.Minute).Unix()
+		value := strconv.FormatInt(expires, 10) + "." + handler.linkAccessSignature(url, expires)
+		w := visit(&http.Cookie{Name: linkAccessCookie, Value: value}, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("HashNeverReturned", func(t *testing.T) {
+		for _, authorization := range []string{"", "Bearer admin"} {
+			req, _ := http.NewRequest("GET", "/urls/"+url.ID.String(), nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.NotContains(t, w.Body.String(), "password_hash")
+			assert.NotContains(t, w.Body.String(), passwordHash)
+		}
+	})
+}
+
+func TestUnlockURLMixedCase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("open sesame"), bcrypt.MinCost)
+	require.NoError(t, err)
+	passwordHash := string(hash)
+	url := &database.URL{ID: uuid.New(), ShortPath: "promo", Destination: "https://example.com", PasswordHash: &passwordHash}
+
+	handler, _, mockCache := setupTestHandler()
+	handler.config.CaseInsensitivePaths = true
+	mockCache.On("GetURL", mock.Anything, "promo").Return(url, nil)
+
+	router := gin.New()
+	router.POST("/:shortPath", handler.UnlockURL)
+
+	form := neturl.Values{"password": {"open sesame"}}
+	req, _ := http.NewRequest("POST", "/Promo?ref=mail", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// The redirect must land on the path the cookie is scoped to
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/promo?ref=mail", w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "/promo", cookies[0].Path)
+}
+
+func TestUnlockURLUnprotected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler, _, mockCache := setupTestHandler()
+	mockCache.On("GetURL", mock.Anything, "open").Return(&database.URL{ID: uuid.New(), ShortPath: "open", Destination: "https://example.com"}, nil)
+
+	router := gin.New()
+	router.POST("/:shortPath", handler.UnlockURL)
+
+	req, _ := http.NewRequest("POST", "/open", strings.NewReader("password=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUnlockURLExpired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash := "hash"
+	past := time.Now().Add(-time.Hour)
+
+	unlock := func(handler *Handler, shortPath string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/:shortPath", handler.UnlockURL)
+
+		req, _ := http.NewRequest("POST", "/"+shortPath, strings.NewReader("password=x"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("ExpiredReturnsGone", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		mockCache.On("GetURL", mock.Anything, "secret").Return(nil, nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, "secret").Return(nil, nil)
+		mockDB.On("GetExpiredURLByShortPath", mock.Anything, "secret").Return(&database.URL{ID: uuid.New(), ShortPath: "secret", Destination: "https://example.com", PasswordHash: &hash, ExpiresAt: &past}, nil)
+
+		w := unlock(handler, "secret")
+
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.JSONEq(t, `{"error":{"code":"url_expired","message":"URL has expired"}}`, w.Body.String())
+	})
+
+	t.Run("CachedExpiredReturnsGone", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		mockCache.On("GetURL", mock.Anything, "secret").Return(&database.URL{ID: uuid.New(), ShortPath: "secret", Destination: "https://example.com", PasswordHash: &hash, ExpiresAt: &past}, nil)
+
+		w := unlock(handler, "secret")
+
+		assert.Equal(t, http.StatusGone, w.Code)
+	})
+
+	t.Run("MissingReturnsNotFound", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		mockCache.On("GetURL", mock.Anything, "missing").Return(nil, nil)
+		mockDB.On("GetURLByShortPath", mock.Anything, "missing").Return(nil, nil)
+		mockDB.On("GetExpiredURLByShortPath", mock.Anything, "missing").Return(nil, nil)
+
+		w := unlock(handler, "missing")
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":{"code":"url_not_found","message":"URL not found"}}`, w.Body.String())
+	})
+}
+
+func TestInternalNoteVisibility(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	note := "requested by ticket #123"
+	newURL := func() *database.URL {
+		return &database.URL{ID: uuid.New(), ShortPath: "noted", Destination: "https://example.com", InternalNote: &note}
+	}
+
+	getURL := func(authorization string) *httptest.ResponseRecorder {
+		handler, _, mockCache := setupTestHandler()
+		handler.config.AdminToken = "secret"
+		url := newURL()
+		mockCache.On("GetURLByID", mock.Anything, url.ID.String()).Return(url, nil)
+
+		router := gin.New()
+		router.GET("/urls/:id", handler.GetURL)
+		req, _ := http.NewRequest("GET", "/urls/"+url.ID.String(), nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	t.Run("ShownToAdmin", func(t *testing.T) {
+		w := getURL("Bearer secret")
+
+		var response database.URL
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.NotNil(t, response.InternalNote)
+		assert.Equal(t, note, *response.InternalNote)
+	})
+
+	t.Run("HiddenWithoutToken", func(t *testing.T) {
+		assert.NotContains(t, getURL("").Body.String(), "internal_note")
+		assert.NotContains(t, getURL("Bearer wrong").Body.String(), "internal_note")
+	})
+
+	t.Run("ListShownToAdminOnly", func(t *testing.T) {
+		list := func(authorization string) string {
+			handler, mockDB, _ := setupTestHandler()
+			handler.config.AdminToken = "secret"
+			mockDB.On("ListURLs", mock.Anything, 1, 10, database.StatusAll).
+				Return(&database.ListURLsResponse{URLs: []database.URL{*newURL()}, Total: 1, Page: 1, Limit: 10}, nil)
+
+			router := gin.New()
+			router.GET("/urls", handler.ListURLs)
+			req, _ := http.NewRequest("GET", "/urls", nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			return w.Body.String()
+		}
+
+		assert.Contains(t, list("Bearer secret"), note)
+		assert.NotContains(t, list(""), "internal_note")
+	})
+
+	t.Run("OmittedFromResolve", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		handler.config.AdminToken = "secret"
+		url := newURL()
+		mockCache.On("GetURL", mock.Anything, "noted").Return(url, nil)
+
+		router := gin.New()
+		router.POST("/urls/resolve-batch", handler.ResolveBatch)
+		req, _ := http.NewRequest("POST", "/urls/resolve-batch", bytes.NewBufferString(`{"short_paths":["noted"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "https://example.com")
+		assert.NotContains(t, w.Body.String(), "internal_note")
+		// The cached URL is left intact
+		assert.NotNil(t, url.InternalNote)
+	})
+
+	t.Run("OmittedFromInterstitial", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		handler.templates.Store(&pageTemplates{redirect: template.Must(template.New("redirect").Parse(`{{ printf "%+v" . }}`))})
+		mockCache.On("GetURL", mock.Anything, "noted").Return(newURL(), nil)
+
+		router := gin.New()
+		router.GET("/:shortPath", handler.Redirect)
+		req, _ := http.NewRequest("GET", "/noted", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "https://example.com")
+		assert.NotContains(t, w.Body.String(), "ticket")
+	})
+}
+
+// streamRecorder adds the CloseNotify that gin's Context.Stream requires
+type streamRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (streamRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestExportURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	createdAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	note := "ticket #123"
+	urls := []database.URL{
+		{ID: uuid.New(), ShortPath: "first", Destination: "https://example.com/a", Title: stringPtr(`Quoted "title", with comma`), ExpiresAt: &expiresAt, CreatedAt: createdAt, InternalNote: &note},
+		{ID: uuid.New(), ShortPath: "second", Destination: "https://example.com/b", CreatedAt: createdAt},
+	}
+
+	export := func(format string) *httptest.ResponseRecorder {
+		handler, mockDB, _ := setupTestHandler()
+		mockDB.On("StreamAllURLs", mock.Anything).Return(urls, []int{42, 0}, nil)
+
+		router := gin.New()
+		router.GET("/urls/export", handler.ExportURLs)
+		req, _ := http.NewRequest("GET", "/urls/export?format="+format, nil)
+		w := &streamRecorder{httptest.NewRecorder()}
+		router.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		w := export("csv")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		records, err := csv.NewReader(w.Body).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"id", "short_path", "destination", "title", "expires_at", "created_at", "click_count"},
+			{urls[0].ID.String(), "first", "https://example.com/a", `Quoted "title", with comma`, "2030-01-02T03:04:05Z", "2024-05-06T07:08:09Z", "42"},
+			{urls[1].ID.String(), "second", "https://example.com/b", "", "", "2024-05-06T07:08:09Z", "0"},
+		}, records)
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		w := export("json")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		require.Len(t, lines, 2)
+		var first database.URL
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+		assert.Equal(t, "first", first.ShortPath)
+		// Internal notes stay out of exports without the admin token
+		assert.Nil(t, first.InternalNote)
+	})
+
+	t.Run("InvalidFormat", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.GET("/urls/export", handler.ExportURLs)
+		req, _ := http.NewRequest("GET", "/urls/export?format=xml", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "StreamAllURLs", mock.Anything)
+	})
+}
+
+func TestImportURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// expectImport accepts every destination except those on taken paths
+	expectImport := func(mockDB *MockDatabase, mockCache *MockCache) {
+		mockDB.On("CreateURL", mock.Anything, mock.MatchedBy(func(req database.CreateURLRequest) bool {
+			return req.ShortPath != nil && *req.ShortPath == "taken"
+		})).Return(nil, fmt.Errorf("failed to create URL: %w", &pq.Error{Code: "23505"}))
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(&database.URL{ID: uuid.New(), ShortPath: "abc123"}, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+	}
+	send := func(query, contentType string, body io.Reader) (ImportResult, *httptest.ResponseRecorder, *MockDatabase) {
+		handler, mockDB, mockCache := setupTestHandler()
+		expectImport(mockDB, mockCache)
+		router := gin.New()
+		router.POST("/urls/import", handler.ImportURLs)
+
+		req, _ := http.NewRequest("POST", "/urls/import"+query, body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var result ImportResult
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		}
+		return result, w, mockDB
+	}
+
+	csvBody := strings.Join([]string{
+		"id,short_path,destination,title,expires_at",
+		",promo,https://example.com/promo,Promo,",
+		",taken,https://example.com/other,,",
+		",nodest,,,",
+		`,"bad path",https://example.com/bad,,`,
+		",late,https://example.com/late,,yesterday",
+		",,https://example.com/generated,,2099-01-01T00:00:00Z",
+	}, "\n")
+
+	t.Run("CSV", func(t *testing.T) {
+		result, w, mockDB := send("", "text/csv", strings.NewReader(csvBody))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 2, result.Created)
+		assert.Equal(t, 1, result.Skipped)
+		require.Len(t, result.Errors, 3)
+		assert.Equal(t, ImportError{Line: 4, ShortPath: "nodest", Error: "destination is required"}, result.Errors[0])
+		assert.Equal(t, 5, result.Errors[1].Line)
+		assert.Equal(t, "bad path", result.Errors[1].ShortPath)
+		assert.Equal(t, ImportError{Line: 6, ShortPath: "late", Error: "invalid expires_at: must be an RFC 3339 timestamp"}, result.Errors[2])
+
+		mockDB.AssertCalled(t, "CreateURL", mock.Anything, mock.MatchedBy(func(req database.CreateURLRequest) bool {
+			return req.ShortPath == nil && req.Destination == "https://example.com/generated" && req.ExpiresAt != nil
+		}))
+	})
+
+	t.Run("ConflictsAsErrors", func(t *testing.T) {
+		result, w, _ := send("?on_conflict=error", "text/csv", strings.NewReader(csvBody))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 2, result.Created)
+		assert.Equal(t, 0, result.Skipped)
+		require.Len(t, result.Errors, 4)
+		assert.Equal(t, ImportError{Line: 3, ShortPath: "taken", Error: "short path already exists"}, result.Errors[0])
+	})
+
+	t.Run("JSONArray", func(t *testing.T) {
+		body := "[\n" +
+			`  {"short_path": "one", "destination": "https://example.com/1"},` + "\n" +
+			`  {"short_path": "two", "destination": 2},` + "\n" +
+			`  {"short_path": "taken", "destination": "https://example.com/3"}` + "\n" +
+			"]"
+		result, w, _ := send("", "application/json", strings.NewReader(body))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, result.Created)
+		assert.Equal(t, 1, result.Skipped)
+		require.Len(t, result.Errors, 1)
+		assert.Equal(t, ImportError{Line: 3, ShortPath: "two", Error: "invalid destination: expected string"}, result.Errors[0])
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		body := `{"short_path": "one", "destination": "https://example.com/1"}` + "\n" +
+			`{"short_path": "two", "destination": "https://example.com/2", "expires_at": "soon"}` + "\n"
+		result, w, _ := send("?format=json", "application/x-ndjson", strings.NewReader(body))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, result.Created)
+		require.Len(t, result.Errors, 1)
+		assert.Equal(t, 2, result.Errors[0].Line)
+	})
+
+	t.Run("MultipartUpload", func(t *testing.T) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, err := mw.CreateFormFile("file", "links.csv")
+		require.NoError(t, err)
+		_, err = part.Write([]byte("short_path,destination\npromo,https://example.com/promo\n"))
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+
+		result, w, _ := send("", mw.FormDataContentType(), &body)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, result.Created)
+		assert.Empty(t, result.Errors)
+	})
+
+	t.Run("InvalidUploads", func(t *testing.T) {
+		for name, tc := range map[string]struct{ contentType, body string }{
+			"MalformedJSON":     {"application/json", `[{"destination": "https://example.com"`},
+			"CSVWithoutColumn":  {"text/csv", "short_path,url\npromo,https://example.com\n"},
+			"Empty":             {"application/json", "[]"},
+			"UnknownConflictOp": {"application/json", "[]"},
+		} {
+			query := ""
+			if name == "UnknownConflictOp" {
+				query = "?on_conflict=overwrite"
+			}
+			_, w, mockDB := send(query, tc.contentType, strings.NewReader(tc.body))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, name)
+			mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+		}
+	})
+}
+
+func TestBrokenURLs(t *testing.T) {
+	handler, mockDB, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/urls/broken", handler.BrokenURLs)
+
+	broken := []database.BrokenURL{
+		{ID: uuid.New(), ShortPath: "down", Destination: "https://down.example.com", Status: 0, Clicks: 3},
+		{ID: uuid.New(), ShortPath: "missing", Destination: "https://example.com/gone", Status: 404, Clicks: 1},
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		mockDB.On("ListBrokenURLs", mock.Anything, 100).Return(broken, nil).Once()
+
+		req, _ := http.NewRequest("GET", "/urls/broken?format=text", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, "down\tunreachable\t3 clicks\thttps://down.example.com", lines[0])
+		assert.Equal(t, "missing\t404\t1 clicks\thttps://example.com/gone", lines[1])
+		assert.NotContains(t, w.Body.String(), "healthy")
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		mockDB.On("ListBrokenURLs", mock.Anything, 5).Return(broken, nil).Once()
+
+		req, _ := http.NewRequest("GET", "/urls/broken?limit=5", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var response []database.BrokenURL
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Len(t, response, 2)
+	})
+
+	t.Run("InvalidFormat", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/urls/broken?format=csv", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/openapi.json", handler.OpenAPIJSON)
+	router.GET("/openapi.yaml", handler.OpenAPIYAML)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Origin", "https://ci.example.com")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The checked-in docs package has no spec until swag generates it
+	original := docs.SwaggerInfo.SwaggerTemplate
+	defer func() { docs.SwaggerInfo.SwaggerTemplate = original }()
+
+	t.Run("NotGenerated", func(t *testing.T) {
+		docs.SwaggerInfo.SwaggerTemplate = ""
+		assert.Equal(t, http.StatusInternalServerError, get("/openapi.json").Code)
+		assert.Equal(t, http.StatusInternalServerError, get("/openapi.yaml").Code)
+	})
+
+	docs.SwaggerInfo.SwaggerTemplate = `{"swagger":"2.0","info":{"title":"{{.Title}}","version":"{{.Version}}"},"basePath":"{{.BasePath}}"}`
+
+	t.Run("JSON", func(t *testing.T) {
+		w := get("/openapi.json")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.JSONEq(t, `{"swagger":"2.0","info":{"title":"`+docs.SwaggerInfo.Title+`","version":"`+docs.SwaggerInfo.Version+`"},"basePath":"`+docs.SwaggerInfo.BasePath+`"}`, w.Body.String())
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		w := get("/openapi.yaml")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/yaml; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, w.Body.String(), "swagger: \"2.0\"")
+		assert.Contains(t, w.Body.String(), "version: \""+docs.SwaggerInfo.Version+"\"")
+	})
+}
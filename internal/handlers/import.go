@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/database"
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxImportRows caps how many URLs one import may create
+const maxImportRows = 10000
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Created int           `json:"created" example:"98"`
+	Skipped int           `json:"skipped" example:"1"`
+	Errors  []ImportError `json:"errors"`
+}
+
+// ImportError reports a row that could not be imported
+type ImportError struct {
+	Line      int    `json:"line" example:"7"`
+	ShortPath string `json:"short_path,omitempty" example:"promo"`
+	Error     string `json:"error" example:"destination is required"`
+}
+
+// importRow is one parsed row, or the reason it could not be parsed
+type importRow struct {
+	line int
+	req  database.CreateURLRequest
+	err  error
+}
+
+// ImportURLs creates URLs in bulk from a CSV or JSON upload
+// @Summary Import URLs
+// @Description Create many URLs from a CSV file (header row required; columns short_path, destination, title, description, image_url, expires_at, activate_at, owner, campaign, others ignored) or JSON (an array or newline-delimited objects shaped like the create request), sent as the request body or as a multipart "file" field. Each row is validated and created like POST /urls, independently of the others. Rows whose short path already exists are skipped, or reported as errors with on_conflict=error. Row errors carry the line they came from.
+// @Tags urls
+// @Accept text/csv,json,mpfd
+// @Produce json
+// @Param format query string false "Upload format: csv or json (default: from the Content-Type or file name, else json)"
+// @Param on_conflict query string false "What to do with rows whose short path exists: skip or error (default: skip)"
+// @Param file formData file false "CSV or JSON file, for multipart uploads"
+// @Success 200 {object} ImportResult
+// @Failure 400 {object} apierror.Response
+// @Router /urls/import [post]
+func (h *Handler) ImportURLs(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "import_urls")
+	defer endSpan(c, span)
+
+	onConflict := c.DefaultQuery("on_conflict", "skip")
+	if onConflict != "skip" && onConflict != "error" {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "on_conflict must be skip or error")
+		return
+	}
+
+	data, format, err := readImportUpload(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.String("import.format", format))
+
+	var rows []importRow
+	switch format {
+	case "csv":
+		rows, err = parseImportCSV(data)
+	case "json":
+		rows, err = parseImportJSON(data)
+	default:
+		err = errors.New("format must be csv or json")
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "no rows to import")
+		return
+	}
+	if len(rows) > maxImportRows {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("too many rows (max %d)", maxImportRows))
+		return
+	}
+
+	result := ImportResult{Errors: []ImportError{}}
+	for _, row := range rows {
+		var shortPath string
+		if row.req.ShortPath != nil {
+			shortPath = *row.req.ShortPath
+		}
+		fail := func(msg string) {
+			result.Errors = append(result.Errors, ImportError{Line: row.line, ShortPath: shortPath, Error: msg})
+		}
+
+		if row.err != nil {
+			fail(row.err.Error())
+			continue
+		}
+		if strings.TrimSpace(row.req.Destination) == "" {
+			fail("destination is required")
+			continue
+		}
+
+		_, status, createErr := h.createURL(ctx, row.req)
+		switch {
+		case status == http.StatusCreated:
+			result.Created++
+		case (status == http.StatusOK || status == http.StatusConflict) && onConflict == "skip":
+			// The short path, or with unique destinations the destination, is taken
+			result.Skipped++
+		case status == http.StatusOK:
+			fail("a short link to this destination already exists")
+		default:
+			fail(createErr.Message)
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("import.created", result.Created),
+		attribute.Int("import.skipped", result.Skipped),
+		attribute.Int("import.errors", len(result.Errors)),
+	)
+	c.JSON(http.StatusOK, result)
+}
+
+// readImportUpload returns the uploaded data, from the multipart "file" field
+// or the raw body, and its format: the format query parameter if given, else
+// csv for a CSV Content-Type or .csv file name, else json
+func readImportUpload(c *gin.Context) ([]byte, string, error) {
+	format := c.Query("format")
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+
+	var data []byte
+	if mediaType == "multipart/form-data" {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, "", errors.New("multipart upload needs a file field")
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read upload: %w", err)
+		}
+		defer file.Close()
+		if data, err = io.ReadAll(file); err != nil {
+			return nil, "", fmt.Errorf("failed to read upload: %w", err)
+		}
+		if format == "" && strings.EqualFold(path.Ext(header.Filename), ".csv") {
+			format = "csv"
+		}
+		mediaType, _, _ = mime.ParseMediaType(header.Header.Get("Content-Type"))
+	} else {
+		var err error
+		if data, err = io.ReadAll(c.Request.Body); err != nil {
+			return nil, "", fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	if format == "" {
+		format = "json"
+		if mediaType == "text/csv" {
+			format = "csv"
+		}
+	}
+	return data, format, nil
+}
+
+// parseImportCSV reads rows by header name, so exports from this service and
+// other shorteners can be imported as long as they have a destination column
+func parseImportCSV(data []byte) ([]importRow, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["destination"]; !ok {
+		return nil, errors.New("CSV header must include a destination column")
+	}
+
+	var rows []importRow
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{line: parseErr.StartLine, err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		line, _ := r.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		optional := func(name string) *string {
+			if value := field(name); value != "" {
+				return &value
+			}
+			return nil
+		}
+
+		row := importRow{line: line, req: database.CreateURLRequest{
+			ShortPath:   optional("short_path"),
+			Destination: field("destination"),
+			Title:       optional("title"),
+			Description: optional("description"),
+			ImageURL:    optional("image_url"),
+			Owner:       optional("owner"),
+			Campaign:    optional("campaign"),
+		}}
+		for _, t := range []struct {
+			name string
+			dst  **time.Time
+		}{{"expires_at", &row.req.ExpiresAt}, {"activate_at", &row.req.ActivateAt}} {
+			value := field(t.name)
+			if value == "" {
+				continue
+			}
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				row.err = fmt.Errorf("invalid %s: must be an RFC 3339 timestamp", t.name)
+				break
+			}
+			*t.dst = &parsed
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseImportJSON reads a JSON array of create requests or newline-delimited
+// objects, as produced by the export. A value that does not fit the request
+// shape is a row error; malformed JSON fails the whole import.
+func parseImportJSON(data []byte) ([]importRow, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	lineAt := func(offset int64) int {
+		rest := bytes.TrimLeft(data[offset:], " \t\r\n,")
+		start := len(data) - len(rest)
+		return 1 + bytes.Count(data[:start], []byte("\n"))
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	array := len(trimmed) > 0 && trimmed[0] == '['
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	var rows []importRow
+	for dec.More() {
+		line := lineAt(dec.InputOffset())
+		var req database.CreateURLRequest
+		err := dec.Decode(&req)
+		// The decoder reads a whole value before unmarshaling it, so only
+		// syntax errors leave it unable to continue with the next row
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		var timeErr *time.ParseError
+		switch {
+		case errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF):
+			return nil, fmt.Errorf("invalid JSON at line %d: %w", line, err)
+		case errors.As(err, &typeErr) && typeErr.Field == "":
+			err = errors.New("row must be an object")
+		case errors.As(err, &typeErr):
+			err = fmt.Errorf("invalid %s: expected %s", typeErr.Field, typeErr.Type)
+		case errors.As(err, &timeErr):
+			err = errors.New("invalid timestamp: must be RFC 3339")
+		}
+		rows = append(rows, importRow{line: line, req: req, err: err})
+	}
+
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"url_shortener/internal/database"
+	"url_shortener/internal/opengraph"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Limits on fetching a destination for auto_metadata, so a slow or huge page
+// delays link creation only briefly
+const (
+	metadataMaxBytes     = 512 << 10
+	metadataMaxRedirects = 3
+)
+
+// nonPublicPrefixes are the special-purpose ranges that publicAddr rejects
+// besides loopback, private, link-local and multicast addresses
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which may map to private IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+}
+
+// publicAddr reports whether addr is a public unicast address, so that
+// fetching a destination cannot reach the service's own network or a cloud
+// metadata endpoint such as 169.254.169.254
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// destinationAddrAllowed decides which addresses the clients that fetch
+// destinations may connect to
+var destinationAddrAllowed = publicAddr
+
+// publicDialContext returns a dialer for clients that fetch destinations. The
+// address is checked when each connection is dialed, after DNS resolution
+// and for every redirect, so neither a hostname resolving to an internal
+// address nor a redirect to one gets through. Such clients must not use
+// proxies, since the check would then only see the proxy.
+func publicDialContext(timeout time.Duration) func(context.Context, string, string) (net.Conn, error) {
+	return (&net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !destinationAddrAllowed(addrPort.Addr()) {
+				return fmt.Errorf("destination address %s is not public", addrPort.Addr())
+			}
+			return nil
+		},
+	}).DialContext
+}
+
+// metadataClient fetches destinations to read their Open Graph tags
+var metadataClient = &http.Client{
+	Timeout: 3 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         publicDialContext(3 * time.Second),
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: 3 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     30 * time.Second,
+	},
+	CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+		if len(via) > metadataMaxRedirects {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+// fillMetadata sets the title, description and image the request leaves
+// unset from the destination's Open Graph tags. A destination that cannot be
+// fetched or declares nothing leaves them unset; creation goes ahead either way.
+func (h *Handler) fillMetadata(ctx context.Context, req *database.CreateURLRequest) {
+	if req.Title != nil && req.Description != nil && req.ImageURL != nil {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+	meta, err := opengraph.Fetch(ctx, metadataClient, req.Destination, metadataMaxBytes)
+	if err != nil {
+		span.AddEvent("auto_metadata_skipped", trace.WithAttributes(attribute.String("reason", err.Error())))
+		return
+	}
+
+	if req.Title == nil && meta.Title != "" {
+		req.Title = &meta.Title
+	}
+	if req.Description == nil && meta.Description != "" {
+		req.Description = &meta.Description
+	}
+	if req.ImageURL == nil && meta.Image != "" && h.checkURLLength("image_url", meta.Image) == nil {
+		req.ImageURL = &meta.Image
+	}
+	span.AddEvent("auto_metadata_filled")
+}
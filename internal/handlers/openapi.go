@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"url_shortener/docs"
+	"url_shortener/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpec returns the generated spec, the same document the Swagger UI
+// loads, with the base path set at startup
+func openAPISpec() []byte {
+	return []byte(docs.SwaggerInfo.ReadDoc())
+}
+
+// allowAnyOrigin lets browser tools fetch the spec from any origin. The spec
+// is public, so unlike the rest of the API it does not depend on CORS_ORIGINS.
+func allowAnyOrigin(c *gin.Context) {
+	if c.Writer.Header().Get("Access-Control-Allow-Origin") == "" {
+		c.Header("Access-Control-Allow-Origin", "*")
+	}
+}
+
+// OpenAPIJSON serves the OpenAPI spec as JSON
+// @Summary OpenAPI spec (JSON)
+// @Description The generated OpenAPI spec, for client generators. It is the document the Swagger UI shows, at a stable path and readable from any origin.
+// @Tags docs
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} apierror.Response
+// @Router /openapi.json [get]
+func (h *Handler) OpenAPIJSON(c *gin.Context) {
+	allowAnyOrigin(c)
+
+	spec := openAPISpec()
+	if !json.Valid(spec) {
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "OpenAPI spec has not been generated")
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+}
+
+// OpenAPIYAML serves the OpenAPI spec as YAML
+// @Summary OpenAPI spec (YAML)
+// @Description The spec of GET /openapi.json converted to YAML
+// @Tags docs
+// @Produce application/yaml
+// @Success 200 {string} string
+// @Failure 500 {object} apierror.Response
+// @Router /openapi.yaml [get]
+func (h *Handler) OpenAPIYAML(c *gin.Context) {
+	allowAnyOrigin(c)
+
+	// JSON is a subset of YAML, so the spec decodes as YAML directly
+	var spec map[string]any
+	if err := yaml.Unmarshal(openAPISpec(), &spec); err != nil || spec == nil {
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "OpenAPI spec has not been generated")
+		return
+	}
+	out, err := yaml.Marshal(spec)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to encode OpenAPI spec")
+		return
+	}
+	c.Data(http.StatusOK, "application/yaml; charset=utf-8", out)
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/clientip"
+	"url_shortener/internal/config"
+	"url_shortener/internal/database"
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// linkAccessCookie holds the proof that the visitor entered a link's password.
+// It is scoped to the link's path, so each protected link has its own.
+const linkAccessCookie = "link_access"
+
+// maxPasswordBytes is the longest password bcrypt can hash
+const maxPasswordBytes = 72
+
+// validatePassword checks a new link password
+func validatePassword(password string) error {
+	if password == "" {
+		return errors.New("password must not be empty")
+	}
+	if len(password) > maxPasswordBytes {
+		return errors.New("password must be at most 72 bytes")
+	}
+	return nil
+}
+
+// passwordCookieKey returns the configured cookie signing key, or a random one
+// when none is set, which main warns about
+func passwordCookieKey(cfg *config.Config) []byte {
+	if cfg.PasswordCookieSecret != "" {
+		return []byte(cfg.PasswordCookieSecret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// linkAccessSignature signs an unlock of url valid until expires. The password
+// hash is part of the signature, so changing the password revokes earlier unlocks.
+func (h *Handler) linkAccessSignature(url *database.URL, expires int64) string {
+	mac := hmac.New(sha256.New, h.cookieKey)
+	mac.Write([]byte(url.ID.String() + "|" + strconv.FormatInt(expires, 10) + "|" + *url.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hasLinkAccess reports whether the request carries a valid, unexpired unlock
+// cookie for the protected url
+func (h *Handler) hasLinkAccess(c *gin.Context, url *database.URL) bool {
+	value, err := c.Cookie(linkAccessCookie)
+	if err != nil {
+		return false
+	}
+
+	expiresPart, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresPart, 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(h.linkAccessSignature(url, expires)))
+}
+
+// grantLinkAccess sets the cookie unlocking url for PASSWORD_COOKIE_TTL
+func (h *Handler) grantLinkAccess(c *gin.Context, url *database.URL) {
+	ttl := h.config.PasswordCookieTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	expires := time.Now().Add(ttl).Unix()
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     linkAccessCookie,
+		Value:    strconv.FormatInt(expires, 10) + "." + h.linkAccessSignature(url, expires),
+		Path:     "/" + url.ShortPath,
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   clientip.Scheme(c, h.config.TrustedProxies) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// renderPasswordPage responds 401 with the password form, or with a JSON
+// error when there is no template or the client accepts JSON
+func (h *Handler) renderPasswordPage(ctx context.Context, c *gin.Context, url *database.URL, failed bool) {
+	code, message := apierror.CodePasswordRequired, "password required"
+	if failed {
+		code, message = apierror.CodeIncorrectPassword, "incorrect password"
+	}
+
+	tmpl := h.pages().password
+	if tmpl == nil || c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		respondError(c, http.StatusUnauthorized, code, message)
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusUnauthorized)
+
+	templateData := gin.H{
+		"Title":  url.Title,
+		"Failed": failed,
+	}
+	if err := tmpl.Execute(c.Writer, templateData); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
+
+// unlockRequest is the password submitted for a protected link
+type unlockRequest struct {
+	Password string `form:"password" json:"password"`
+}
+
+// UnlockURL checks the password of a protected link
+// @Summary Unlock a password-protected link
+// @Description Checks the password submitted from the password form (or as JSON). On success it sets a short-lived cookie unlocking the link and redirects back to it with 303.
+// @Tags redirect
+// @Accept x-www-form-urlencoded,json
+// @Produce html,json
+// @Param shortPath path string true "Short path"
+// @Param password formData string true "Link password"
+// @Success 303 "Redirect back to the unlocked link"
+// @Failure 401 {string} string "Password form with an error, or a JSON error for Accept: application/json"
+// @Failure 404 {object} apierror.Response
+// @Failure 410 {string} string "Expired link page, or a JSON error for Accept: application/json"
+// @Failure 500 {object} apierror.Response
+// @Router /{shortPath} [post]
+func (h *Handler) UnlockURL(c *gin.Context) {
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "unlock_url")
+	defer endSpan(c, span)
+
+	shortPath := normalizeShortPath(h.config, c.Param("shortPath"))
+	span.SetAttributes(attribute.String("url.short_path", shortPath))
+
+	url, err := h.cache.GetURL(ctx, shortPath)
+	if err != nil {
+		span.RecordError(err)
+	}
+	if url == nil {
+		url, err = h.lookupURLByShortPath(ctx, shortPath)
+		if err != nil {
+			span.RecordError(err)
+			respondDBError(c, err, "failed to get URL")
+			return
+		}
+	}
+	if url == nil {
+		// Expired protected links answer 410, as Redirect does
+		expired, err := h.db.GetExpiredURLByShortPath(ctx, shortPath)
+		if err != nil {
+			span.RecordError(err)
+		}
+		if expired != nil && expired.PasswordHash != nil {
+			h.renderExpired(ctx, c, expired)
+			return
+		}
+	}
+
+	now := time.Now()
+	if url != nil && url.PasswordHash != nil && url.ExpiresAt != nil && url.ExpiresAt.Before(now) {
+		h.renderExpired(ctx, c, url)
+		return
+	}
+	if url == nil || url.PasswordHash == nil || url.ReservedUntil != nil || !isActive(url, now) {
+		respondError(c, http.StatusNotFound, apierror.CodeURLNotFound, "URL not found")
+		return
+	}
+
+	var req unlockRequest
+	if err := c.ShouldBind(&req); err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+	if !database.VerifyURLPassword(url, req.Password) {
+		span.SetAttributes(attribute.Bool("url.unlocked", false))
+		h.renderPasswordPage(ctx, c, url, true)
+		return
+	}
+
+	span.SetAttributes(attribute.Bool("url.unlocked", true))
+	h.grantLinkAccess(c, url)
+	// Back to the link, query string included, now as a GET. The path is the
+	// stored one the cookie is scoped to, as cookie paths are case-sensitive
+	// and the visitor may have typed /Promo for promo.
+	location := "/" + url.ShortPath
+	if c.Request.URL.RawQuery != "" {
+		location += "?" + c.Request.URL.RawQuery
+	}
+	c.Redirect(http.StatusSeeOther, location)
+}
@@ -1,30 +1,107 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"strconv"
 	"strings"
 
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/database"
 	"url_shortener/internal/qrcode"
 	"url_shortener/internal/telemetry"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // QRCodeRequest represents the request body for generating a QR code via POST
 type QRCodeRequest struct {
-	Data                 string  `json:"data" binding:"required" example:"https://example.com" description:"The data to encode in the QR code (required)"`
+	Data                 string  `json:"data" example:"https://example.com" description:"The data to encode in the QR code (required for url and text content, unless shorten=true)"`
+	ContentType          *string `json:"content_type,omitempty" example:"wifi" description:"Payload type: url, text, wifi, vcard, geo (default: url); wifi, vcard and geo build the payload from the matching field"`
+	WiFi                 *qrcode.WiFi  `json:"wifi,omitempty" description:"Network to join, for content_type wifi"`
+	VCard                *qrcode.VCard `json:"vcard,omitempty" description:"Contact to save, for content_type vcard"`
+	Geo                  *qrcode.Geo   `json:"geo,omitempty" description:"Location to open, for content_type geo"`
+	Destination          *string `json:"destination,omitempty" example:"https://example.com/long/path" description:"Destination to shorten when shorten=true; the QR encodes the new short URL"`
+	ShortPath            *string `json:"short_path,omitempty" example:"custom-path" description:"Custom short path for the link created when shorten=true (optional)"`
 	Size                 *int    `json:"size,omitempty" example:"512" description:"Output image size in pixels (default: 256, min: 64, max: 2048)"`
-	ErrorCorrection      *string `json:"error_correction,omitempty" example:"high" description:"Error correction level: low, medium, high, highest (default: high)"`
+	Scale                *int    `json:"scale,omitempty" example:"10" description:"Pixels per module, instead of size, for crisp print output (min: 1, max: 32); the image must still fit 2048 pixels. Ignored when size is set"`
+	ErrorCorrection      *string `json:"error_correction,omitempty" example:"high" description:"Error correction level: low, medium, high, highest (default: highest with a logo, otherwise high); rejected if too low for the logo"`
 	ForegroundColor      *string `json:"foreground_color,omitempty" example:"#000000" description:"QR code foreground color in hex (default: #000000)"`
 	BackgroundColor      *string `json:"background_color,omitempty" example:"#FFFFFF" description:"Background color in hex (default: #FFFFFF)"`
+	EyeColor             *string `json:"eye_color,omitempty" example:"#1E3A8A" description:"Color of the three corner eyes in hex (default: foreground_color)"`
+	EyeBallColor         *string `json:"eye_ball_color,omitempty" example:"#F59E0B" description:"Color of the eye centers in hex (default: eye_color)"`
 	TransparentBackground *bool   `json:"transparent_background,omitempty" example:"false" description:"Make background transparent (default: false)"`
-	IncludeLogo          *bool   `json:"include_logo,omitempty" example:"true" description:"Include logo in center (default: true)"`
+	IncludeLogo          *bool   `json:"include_logo,omitempty" example:"true" description:"Include logo in center (default: QR_DEFAULT_INCLUDE_LOGO, true unless configured)"`
 	LogoColor            *string `json:"logo_color,omitempty" example:"#FF5733" description:"Logo color in hex (optional, uses original if not set)"`
 	LogoShape            *string `json:"logo_shape,omitempty" example:"circle" description:"Logo shape: circle or square (default: circle)"`
+	LogoName             *string `json:"logo_name,omitempty" example:"saude" description:"Name of a registered logo (QR_LOGOS) to use instead of the default"`
 	ModuleShape          *string `json:"module_shape,omitempty" example:"square" description:"QR module shape: square, circle, rounded (default: square)"`
+	ModuleRadius         *float64 `json:"module_radius,omitempty" example:"0.3" description:"Corner radius of rounded modules as a fraction of the module size (default: 0.3, min: 0, max: 0.5)"`
 	BorderWidth          *int    `json:"border_width,omitempty" example:"2" description:"Border width in modules (default: 2, min: 0, max: 10)"`
-	Format               *string `json:"format,omitempty" example:"png" description:"Output format: png or jpeg (default: png)"`
+	Format               *string `json:"format,omitempty" example:"png" description:"Output format: png, jpeg or webp (default: png)"`
+}
+
+// CreateURLWithQRRequest is the body of POST /urls: a CreateURLRequest that
+// can also ask for a QR code of the new short URL
+type CreateURLWithQRRequest struct {
+	database.CreateURLRequest
+	IncludeQR bool           `json:"include_qr,omitempty" example:"true" description:"Return a QR code of the short URL as base64 in qr_code (default: false)"`
+	QROptions *QRCodeRequest `json:"qr_options,omitempty" description:"Styling of the QR code; data, content and shorten fields are ignored since it always encodes the short URL"`
+}
+
+// URLWithQR is a created URL together with the QR code of its short URL
+type URLWithQR struct {
+	*database.URL
+	QRCode  string `json:"qr_code,omitempty" example:"iVBORw0KGgo..." description:"Base64 QR code image of the short URL, in qr_options.format"`
+	QRError string `json:"qr_error,omitempty" example:"invalid foreground color" description:"Why the QR code could not be generated; the URL was created regardless"`
+}
+
+// MarshalJSON adds the QR fields to the URL's own JSON. Without it the
+// promoted URL.MarshalJSON would write the URL alone.
+func (u URLWithQR) MarshalJSON() ([]byte, error) {
+	urlJSON, err := json.Marshal(u.URL)
+	if err != nil {
+		return nil, err
+	}
+	qrJSON, err := json.Marshal(struct {
+		QRCode  string `json:"qr_code,omitempty"`
+		QRError string `json:"qr_error,omitempty"`
+	}{u.QRCode, u.QRError})
+	if err != nil {
+		return nil, err
+	}
+	if len(qrJSON) == len("{}") {
+		return urlJSON, nil
+	}
+	return append(append(urlJSON[:len(urlJSON)-1], ','), qrJSON[1:]...), nil
+}
+
+// urlWithQR generates the QR code of url's short URL for a create request.
+// The URL already exists at this point, so a QR failure is reported
+// alongside it rather than failing the request.
+func (h *Handler) urlWithQR(ctx context.Context, c *gin.Context, url *database.URL, styling *QRCodeRequest) URLWithQR {
+	if styling == nil {
+		styling = &QRCodeRequest{}
+	}
+	opts := buildQROptions(h.qrDefaults(), h.shortURL(c, url.ShortPath), styling)
+
+	result := URLWithQR{URL: h.visibleURL(c, url)}
+	imgData, err := h.generateQRCode(ctx, opts)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		result.QRError = err.Error()
+		return result
+	}
+	result.QRCode = base64.StdEncoding.EncodeToString(imgData)
+	return result
 }
 
 // GenerateQRCodePOST handles POST requests for QR code generation with JSON body
@@ -32,72 +109,119 @@ type QRCodeRequest struct {
 // @Description Generate a QR code with full customization options via JSON body
 // @Tags qrcode
 // @Accept json
-// @Produce image/png,image/jpeg
+// @Produce image/png,image/jpeg,image/webp,multipart/mixed
 // @Param qr body QRCodeRequest true "QR code generation request"
+// @Param include_decoded query bool false "Return a multipart response with the image and its decoded content"
+// @Param shorten query bool false "Create a short link for destination and encode the short URL; the link is returned in the X-Short-URL, X-Short-Path and X-Short-Link-ID headers"
 // @Success 200 {file} binary "QR code image"
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} apierror.Response
+// @Failure 409 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
 // @Router /qr [post]
 func (h *Handler) GenerateQRCodePOST(c *gin.Context) {
-	_, span := telemetry.StartSpan(c.Request.Context(), "generate_qr_post")
-	defer span.End()
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "generate_qr_post")
+	defer endSpan(c, span)
 
 	var req QRCodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	contentType := qrcode.ContentURL
+	if req.ContentType != nil && *req.ContentType != "" {
+		contentType = strings.ToLower(*req.ContentType)
+	}
+
+	// Format wifi, vcard and geo payloads server-side
+	data, err := qrcode.BuildPayload(qrcode.Payload{
+		ContentType: contentType,
+		Data:        req.Data,
+		WiFi:        req.WiFi,
+		VCard:       req.VCard,
+		Geo:         req.Geo,
+	})
+	if err != nil {
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 		return
 	}
 
+	if shorten, _ := strconv.ParseBool(c.Query("shorten")); shorten {
+		if req.Destination == nil || *req.Destination == "" {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "destination is required when shorten=true")
+			return
+		}
+		if contentType != qrcode.ContentURL {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "shorten=true only supports content_type url")
+			return
+		}
+
+		// Shorten first so the QR encodes the stable short URL
+		url, status, createErr := h.createURL(ctx, database.CreateURLRequest{
+			Destination: *req.Destination,
+			ShortPath:   req.ShortPath,
+		})
+		if createErr != nil {
+			apierror.Write(c, status, *createErr)
+			return
+		}
+
+		data = h.shortURL(c, url.ShortPath)
+		c.Header("X-Short-URL", data)
+		c.Header("X-Short-Path", url.ShortPath)
+		c.Header("X-Short-Link-ID", url.ID.String())
+	}
+
 	// Build options from request
-	opts := buildQROptions(req.Data, &req)
+	opts := buildQROptions(h.qrDefaults(), data, &req)
 
 	// Generate QR code
-	imgData, err := qrcode.Generate(opts)
+	imgData, err := h.generateQRCode(ctx, opts)
 	if err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
 		return
 	}
 
-	// Set content type and return image
-	contentType := "image/png"
-	if opts.Format == "jpeg" {
-		contentType = "image/jpeg"
-	}
-
-	c.Data(http.StatusOK, contentType, imgData)
+	writeQRCode(c, opts, imgData)
 }
 
 // GenerateQRCodeGET handles GET requests for QR code generation with query parameters
 // @Summary Generate QR code (GET)
 // @Description Generate a QR code with customization options via query parameters
 // @Tags qrcode
-// @Produce image/png,image/jpeg
+// @Produce image/png,image/jpeg,image/webp,multipart/mixed
 // @Param data query string true "The data to encode in the QR code"
 // @Param size query int false "Output image size in pixels (default: 256, min: 64, max: 2048)"
-// @Param error_correction query string false "Error correction level: low, medium, high, highest (default: high)"
+// @Param scale query int false "Pixels per module, instead of size, for crisp print output (min: 1, max: 32); the image must still fit 2048 pixels. Ignored when size is set"
+// @Param error_correction query string false "Error correction level: low, medium, high, highest (default: highest with a logo, otherwise high); rejected if too low for the logo"
 // @Param foreground_color query string false "QR code foreground color in hex (default: #000000)"
 // @Param background_color query string false "Background color in hex (default: #FFFFFF)"
+// @Param eye_color query string false "Color of the three corner eyes in hex (default: foreground_color)"
+// @Param eye_ball_color query string false "Color of the eye centers in hex (default: eye_color)"
 // @Param transparent_background query bool false "Make background transparent (default: false)"
-// @Param include_logo query bool false "Include logo in center (default: true)"
+// @Param include_logo query bool false "Include logo in center (default: QR_DEFAULT_INCLUDE_LOGO)"
 // @Param logo_color query string false "Logo color in hex (optional)"
 // @Param logo_shape query string false "Logo shape: circle or square (default: circle)"
+// @Param logo_name query string false "Name of a registered logo (QR_LOGOS) to use instead of the default"
 // @Param module_shape query string false "QR module shape: square, circle, rounded (default: square)"
+// @Param module_radius query number false "Corner radius of rounded modules as a fraction of the module size (default: 0.3, min: 0, max: 0.5)"
 // @Param border_width query int false "Border width in modules (default: 2, min: 0, max: 10)"
-// @Param format query string false "Output format: png or jpeg (default: png)"
+// @Param format query string false "Output format: png, jpeg or webp (default: png)"
+// @Param include_decoded query bool false "Return a multipart response with the image and its decoded content"
 // @Success 200 {file} binary "QR code image"
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} apierror.Response
+// @Failure 500 {object} apierror.Response
 // @Router /qr [get]
 func (h *Handler) GenerateQRCodeGET(c *gin.Context) {
-	_, span := telemetry.StartSpan(c.Request.Context(), "generate_qr_get")
-	defer span.End()
+	ctx, span := telemetry.StartSpan(c.Request.Context(), "generate_qr_get")
+	defer endSpan(c, span)
 
 	// Get required data parameter
 	data := c.Query("data")
 	if data == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "data query parameter is required"})
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "data query parameter is required")
 		return
 	}
 
@@ -105,11 +229,22 @@ func (h *Handler) GenerateQRCodeGET(c *gin.Context) {
 	var req QRCodeRequest
 	req.Data = data
 
-	// Parse size
+	// Parse size and scale
 	if sizeStr := c.Query("size"); sizeStr != "" {
-		if size, err := strconv.Atoi(sizeStr); err == nil {
-			req.Size = &size
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("size must be a whole number of pixels, got %q", sizeStr))
+			return
 		}
+		req.Size = &size
+	}
+	if scaleStr := c.Query("scale"); scaleStr != "" {
+		scale, err := strconv.Atoi(scaleStr)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, fmt.Sprintf("scale must be a whole number of pixels per module, got %q", scaleStr))
+			return
+		}
+		req.Scale = &scale
 	}
 
 	// Parse error correction
@@ -127,6 +262,14 @@ func (h *Handler) GenerateQRCodeGET(c *gin.Context) {
 		req.BackgroundColor = &bg
 	}
 
+	// Parse eye colors
+	if ec := c.Query("eye_color"); ec != "" {
+		req.EyeColor = &ec
+	}
+	if ebc := c.Query("eye_ball_color"); ebc != "" {
+		req.EyeBallColor = &ebc
+	}
+
 	// Parse transparent background
 	if tb := c.Query("transparent_background"); tb != "" {
 		if val, err := strconv.ParseBool(tb); err == nil {
@@ -151,11 +294,23 @@ func (h *Handler) GenerateQRCodeGET(c *gin.Context) {
 		req.LogoShape = &ls
 	}
 
+	// Parse logo name
+	if ln := c.Query("logo_name"); ln != "" {
+		req.LogoName = &ln
+	}
+
 	// Parse module shape
 	if ms := c.Query("module_shape"); ms != "" {
 		req.ModuleShape = &ms
 	}
 
+	// Parse module radius
+	if mr := c.Query("module_radius"); mr != "" {
+		if val, err := strconv.ParseFloat(mr, 64); err == nil {
+			req.ModuleRadius = &val
+		}
+	}
+
 	// Parse border width
 	if bw := c.Query("border_width"); bw != "" {
 		if val, err := strconv.Atoi(bw); err == nil {
@@ -169,33 +324,139 @@ func (h *Handler) GenerateQRCodeGET(c *gin.Context) {
 	}
 
 	// Build options from request
-	opts := buildQROptions(data, &req)
+	opts := buildQROptions(h.qrDefaults(), data, &req)
 
 	// Generate QR code
+	imgData, err := h.generateQRCode(ctx, opts)
+	if err != nil {
+		span.RecordError(err)
+		respondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	writeQRCode(c, opts, imgData)
+}
+
+// generateQRCode encodes a QR code, serving identical requests from the cache
+// for QRCacheTTL
+func (h *Handler) generateQRCode(ctx context.Context, opts qrcode.Options) ([]byte, error) {
+	if h.config.QRCacheTTL <= 0 {
+		return qrcode.Generate(opts)
+	}
+
+	span := trace.SpanFromContext(ctx)
+	key := qrcode.CacheKey(opts)
+
+	cached, err := h.cache.GetQRCode(ctx, key)
+	if err != nil {
+		span.RecordError(err)
+	} else if cached != nil {
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		return cached, nil
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+
 	imgData, err := qrcode.Generate(opts)
 	if err != nil {
+		return nil, err
+	}
+
+	if err := h.cache.SetQRCode(ctx, key, imgData); err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+
+	return imgData, nil
+}
+
+// QRDecodedResult is the JSON part of an include_decoded response
+type QRDecodedResult struct {
+	Decoded string `json:"decoded" example:"https://example.com" description:"Text decoded from the generated image"`
+	Matches bool   `json:"matches" example:"true" description:"Whether the decoded text equals the requested data"`
+	Error   string `json:"error,omitempty" description:"Why the image could not be decoded, if it could not"`
+}
+
+// writeQRCode writes the generated image, or a multipart/mixed response with the
+// image and its re-decoded content when include_decoded is set
+func writeQRCode(c *gin.Context, opts qrcode.Options, imgData []byte) {
+	contentType := qrcode.ContentType(opts.Format)
+
+	includeDecoded, _ := strconv.ParseBool(c.Query("include_decoded"))
+	if !includeDecoded {
+		c.Data(http.StatusOK, contentType, imgData)
 		return
 	}
 
-	// Set content type and return image
-	contentType := "image/png"
-	if opts.Format == "jpeg" {
-		contentType = "image/jpeg"
+	// Decode the generated image to prove it is scannable
+	result := QRDecodedResult{}
+	if decoded, err := qrcode.Decode(imgData); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Decoded = decoded
+		result.Matches = decoded == opts.Data
+	}
+
+	body, multipartType, err := buildDecodedMultipart(imgData, contentType, opts.Format, result)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to build multipart response")
+		return
 	}
 
-	c.Data(http.StatusOK, contentType, imgData)
+	c.Data(http.StatusOK, multipartType, body)
 }
 
-// buildQROptions builds QR code options from request parameters with defaults
-func buildQROptions(data string, req *QRCodeRequest) qrcode.Options {
+// buildDecodedMultipart encodes the image and the decode result as multipart/mixed
+func buildDecodedMultipart(imgData []byte, contentType, format string, result QRDecodedResult) ([]byte, string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	imgPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {contentType},
+		"Content-Disposition": {`attachment; filename="qrcode.` + format + `"`},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := imgPart.Write(imgData); err != nil {
+		return nil, "", err
+	}
+
+	jsonPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/json"},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.NewEncoder(jsonPart).Encode(result); err != nil {
+		return nil, "", err
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return body.Bytes(), "multipart/mixed; boundary=" + mw.Boundary(), nil
+}
+
+// qrDefaults returns the QR code options requests start from, with the
+// configured logos and whether a logo is included unless asked otherwise
+func (h *Handler) qrDefaults() qrcode.Options {
 	opts := qrcode.DefaultOptions()
+	opts.IncludeLogo = h.config.QRDefaultIncludeLogo
+	opts.Logos = h.config.QRLogos
+	opts.LogoPath = h.config.LogoPath
+	return opts
+}
+
+// buildQROptions applies request parameters to the default options
+func buildQROptions(opts qrcode.Options, data string, req *QRCodeRequest) qrcode.Options {
 	opts.Data = data
 
-	// Apply custom values if provided
+	// Apply custom values if provided; an explicit size wins over scale
 	if req.Size != nil {
 		opts.Size = *req.Size
+	} else if req.Scale != nil {
+		opts.Size = 0
+		opts.Scale = *req.Scale
 	}
 
 	if req.ErrorCorrection != nil {
@@ -210,6 +471,14 @@ func buildQROptions(data string, req *QRCodeRequest) qrcode.Options {
 		opts.BackgroundColor = *req.BackgroundColor
 	}
 
+	if req.EyeColor != nil {
+		opts.EyeColor = *req.EyeColor
+	}
+
+	if req.EyeBallColor != nil {
+		opts.EyeBallColor = *req.EyeBallColor
+	}
+
 	if req.TransparentBackground != nil {
 		opts.TransparentBackground = *req.TransparentBackground
 	}
@@ -226,10 +495,18 @@ func buildQROptions(data string, req *QRCodeRequest) qrcode.Options {
 		opts.LogoShape = strings.ToLower(*req.LogoShape)
 	}
 
+	if req.LogoName != nil {
+		opts.LogoName = *req.LogoName
+	}
+
 	if req.ModuleShape != nil {
 		opts.ModuleShape = strings.ToLower(*req.ModuleShape)
 	}
 
+	if req.ModuleRadius != nil {
+		opts.ModuleRadius = *req.ModuleRadius
+	}
+
 	if req.BorderWidth != nil {
 		opts.BorderWidth = *req.BorderWidth
 	}
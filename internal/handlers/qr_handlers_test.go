@@ -0,0 +1,870 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"url_shortener/internal/database"
+	"url_shortener/internal/qrcode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateQRCodeIncludeDecoded(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	t.Run("MultipartContainsImageAndDecodedData", func(t *testing.T) {
+		data := "https://example.com/some/path?x=1"
+		query := url.Values{
+			"data":            {data},
+			"include_logo":    {"false"},
+			"include_decoded": {"1"},
+		}
+
+		req, _ := http.NewRequest("GET", "/qr?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/mixed", mediaType)
+
+		mr := multipart.NewReader(w.Body, params["boundary"])
+
+		imgPart, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", imgPart.Header.Get("Content-Type"))
+		imgData, err := io.ReadAll(imgPart)
+		require.NoError(t, err)
+		assert.NotEmpty(t, imgData)
+
+		jsonPart, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", jsonPart.Header.Get("Content-Type"))
+
+		var result QRDecodedResult
+		require.NoError(t, json.NewDecoder(jsonPart).Decode(&result))
+		assert.Equal(t, data, result.Decoded)
+		assert.True(t, result.Matches)
+		assert.Empty(t, result.Error)
+	})
+
+	t.Run("PlainImageByDefault", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=hello&include_logo=false", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	})
+}
+
+func TestGenerateQRCodeShorten(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("EncodesCreatedShortURL", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.POST("/qr", handler.GenerateQRCodePOST)
+
+		created := &database.URL{ID: uuid.New(), ShortPath: "abc123", Destination: "https://example.com/long/path"}
+		mockDB.On("CreateURL", mock.Anything, mock.MatchedBy(func(req database.CreateURLRequest) bool {
+			return req.Destination == "https://example.com/long/path"
+		})).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, "abc123", created).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, created.ID.String(), created).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, "abc123").Return(nil)
+
+		body := `{"destination":"https://example.com/long/path","include_logo":false}`
+		req, _ := http.NewRequest("POST", "http://sho.rt/qr?shorten=true", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		assert.Equal(t, "http://sho.rt/abc123", w.Header().Get("X-Short-URL"))
+		assert.Equal(t, "abc123", w.Header().Get("X-Short-Path"))
+		assert.Equal(t, created.ID.String(), w.Header().Get("X-Short-Link-ID"))
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "http://sho.rt/abc123", decoded)
+
+		mockDB.AssertExpectations(t)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("UsesPublicBaseURL", func(t *testing.T) {
+		handler, mockDB, mockCache := setupTestHandler()
+		handler.config.PublicBaseURL = "https://pref.rio/"
+		router := gin.New()
+		router.POST("/qr", handler.GenerateQRCodePOST)
+
+		created := &database.URL{ID: uuid.New(), ShortPath: "promo", Destination: "https://example.com"}
+		mockDB.On("CreateURL", mock.Anything, mock.Anything).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+
+		body := `{"destination":"https://example.com","short_path":"promo","include_logo":false}`
+		req, _ := http.NewRequest("POST", "/qr?shorten=true", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://pref.rio/promo", w.Header().Get("X-Short-URL"))
+	})
+
+	t.Run("MissingDestination", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.POST("/qr", handler.GenerateQRCodePOST)
+
+		req, _ := http.NewRequest("POST", "/qr?shorten=true", bytes.NewBufferString(`{"data":"hello"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("InvalidShortPath", func(t *testing.T) {
+		handler, mockDB, _ := setupTestHandler()
+		router := gin.New()
+		router.POST("/qr", handler.GenerateQRCodePOST)
+
+		body := `{"destination":"https://example.com","short_path":"admin"}`
+		req, _ := http.NewRequest("POST", "/qr?shorten=true", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "reserved")
+		mockDB.AssertNotCalled(t, "CreateURL", mock.Anything, mock.Anything)
+	})
+}
+
+func TestCreateURLWithQR(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	create := func(target, body string) (*httptest.ResponseRecorder, *MockDatabase) {
+		handler, mockDB, mockCache := setupTestHandler()
+		router := gin.New()
+		router.POST("/urls", handler.CreateURL)
+
+		created := &database.URL{ID: uuid.New(), ShortPath: "abc123", Destination: "https://example.com/long/path"}
+		mockDB.On("CreateURL", mock.Anything, mock.MatchedBy(func(req database.CreateURLRequest) bool {
+			return req.Destination == "https://example.com/long/path"
+		})).Return(created, nil)
+		mockCache.On("SetURL", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("SetURLByID", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockCache.On("DeleteNotFound", mock.Anything, mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest("POST", target, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w, mockDB
+	}
+	decodeQR := func(t *testing.T, w *httptest.ResponseRecorder) (map[string]interface{}, []byte) {
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		encoded, _ := resp["qr_code"].(string)
+		img, err := base64.StdEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+		return resp, img
+	}
+
+	t.Run("WithQRQuery", func(t *testing.T) {
+		w, mockDB := create("http://sho.rt/urls?with_qr=true",
+			`{"destination":"https://example.com/long/path","qr_options":{"include_logo":false}}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		resp, img := decodeQR(t, w)
+		assert.Equal(t, "abc123", resp["short_path"])
+		decoded, err := qrcode.Decode(img)
+		require.NoError(t, err)
+		assert.Equal(t, "http://sho.rt/abc123", decoded)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("IncludeQRBodyWithOptions", func(t *testing.T) {
+		w, _ := create("http://sho.rt/urls",
+			`{"destination":"https://example.com/long/path","include_qr":true,"qr_options":{"include_logo":false,"format":"jpeg","size":300}}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		_, img := decodeQR(t, w)
+		config, format, err := image.DecodeConfig(bytes.NewReader(img))
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, 300, config.Width)
+	})
+
+	t.Run("OffByDefault", func(t *testing.T) {
+		w, _ := create("/urls", `{"destination":"https://example.com/long/path"}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.NotContains(t, w.Body.String(), "qr_code")
+	})
+
+	t.Run("InvalidOptionsStillCreateURL", func(t *testing.T) {
+		w, mockDB := create("/urls?with_qr=true",
+			`{"destination":"https://example.com/long/path","qr_options":{"include_logo":false,"foreground_color":"not-a-color"}}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "abc123", resp["short_path"])
+		assert.NotContains(t, resp, "qr_code")
+		assert.NotEmpty(t, resp["qr_error"])
+		mockDB.AssertExpectations(t)
+	})
+}
+func TestGenerateQRCodeContentTypes(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/qr", handler.GenerateQRCodePOST)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/qr", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "WiFi",
+			body:     `{"content_type":"wifi","wifi":{"ssid":"Eventos;Rio","password":"s3cret"},"include_logo":false}`,
+			expected: `WIFI:T:WPA;S:Eventos\;Rio;P:s3cret;;`,
+		},
+		{
+			name:     "OpenWiFi",
+			body:     `{"content_type":"wifi","wifi":{"ssid":"Praia","hidden":true},"include_logo":false}`,
+			expected: `WIFI:T:nopass;S:Praia;H:true;;`,
+		},
+		{
+			name:     "VCard",
+			body:     `{"content_type":"vcard","vcard":{"first_name":"Maria","last_name":"Silva","organization":"Prefeitura do Rio","email":"maria@rio.rj.gov.br"},"include_logo":false}`,
+			expected: "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Silva;Maria;;;\r\nFN:Maria Silva\r\nORG:Prefeitura do Rio\r\nEMAIL:maria@rio.rj.gov.br\r\nEND:VCARD",
+		},
+		{
+			name:     "Geo",
+			body:     `{"content_type":"geo","geo":{"latitude":-22.9068,"longitude":-43.1729},"include_logo":false}`,
+			expected: "geo:-22.9068,-43.1729",
+		},
+		{
+			name:     "Text",
+			body:     `{"content_type":"text","data":"hello world","include_logo":false}`,
+			expected: "hello world",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(tt.body)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			decoded, err := qrcode.Decode(w.Body.Bytes())
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, decoded)
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{"content_type":"wifi"}`,
+			`{"content_type":"wifi","wifi":{"ssid":"x","encryption":"WPA"}}`,
+			`{"content_type":"vcard","vcard":{"phone":"123"}}`,
+			`{"content_type":"geo","geo":{"latitude":91,"longitude":0}}`,
+			`{"content_type":"sms","data":"x"}`,
+		} {
+			w := post(body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+
+	t.Run("ShortenRequiresURL", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/qr?shorten=true", bytes.NewBufferString(`{"content_type":"geo","geo":{"latitude":0,"longitude":0},"destination":"https://example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestGenerateQRCodeNamedLogo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// A solid red square stands in for a department brand
+	logo := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(logo, logo.Bounds(), &image.Uniform{color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	logoPath := filepath.Join(t.TempDir(), "saude.png")
+	f, err := os.Create(logoPath)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(f, logo))
+	require.NoError(t, f.Close())
+
+	handler, _, _ := setupTestHandler()
+	handler.config.QRLogos = map[string]string{"saude": logoPath}
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	t.Run("UsesRegisteredLogo", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=https://example.com&size=256&logo_name=Saude", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		r, g, b, _ := img.At(128, 128).RGBA()
+		assert.Equal(t, [3]uint32{0xffff, 0, 0}, [3]uint32{r, g, b}, "center should show the named logo")
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", decoded)
+	})
+
+	t.Run("UnknownLogoName", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=hello&logo_name=educacao", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unknown logo_name")
+	})
+}
+
+func TestGenerateQRCodeLogoPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// A solid blue square stands in for the deployment's logo
+	logo := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(logo, logo.Bounds(), &image.Uniform{color.RGBA{B: 255, A: 255}}, image.Point{}, draw.Src)
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	f, err := os.Create(logoPath)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(f, logo))
+	require.NoError(t, f.Close())
+
+	get := func(handler *Handler, query string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/qr", handler.GenerateQRCodeGET)
+		req, _ := http.NewRequest("GET", "/qr?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("UsesConfiguredLogo", func(t *testing.T) {
+		handler, _, _ := setupTestHandler()
+		handler.config.LogoPath = logoPath
+
+		w := get(handler, "data=https://example.com&size=256")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		r, g, b, _ := img.At(128, 128).RGBA()
+		assert.Equal(t, [3]uint32{0, 0, 0xffff}, [3]uint32{r, g, b}, "center should show the configured logo")
+	})
+
+	t.Run("BuiltInLogoByDefault", func(t *testing.T) {
+		// The tests run from the package directory, where internal/assets
+		// does not exist, so this only works with the embedded logo
+		handler, _, _ := setupTestHandler()
+		handler.config.LogoPath = ""
+
+		w := get(handler, "data=https://example.com&size=256&include_logo=true")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		withoutLogo := get(handler, "data=https://example.com&size=256&include_logo=false")
+		require.Equal(t, http.StatusOK, withoutLogo.Code)
+		assert.NotEqual(t, withoutLogo.Body.Bytes(), w.Body.Bytes(), "the built-in logo should be composited")
+	})
+
+	t.Run("DefaultCanBeDisabled", func(t *testing.T) {
+		handler, _, _ := setupTestHandler()
+		handler.config.LogoPath = logoPath
+		handler.config.QRDefaultIncludeLogo = false
+
+		w := get(handler, "data=https://example.com&size=256")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		withoutLogo := get(handler, "data=https://example.com&size=256&include_logo=false")
+		require.Equal(t, http.StatusOK, withoutLogo.Code)
+		assert.Equal(t, withoutLogo.Body.Bytes(), w.Body.Bytes(), "no logo unless the request asks for one")
+
+		withLogo := get(handler, "data=https://example.com&size=256&include_logo=true")
+		require.Equal(t, http.StatusOK, withLogo.Code)
+		img, err := png.Decode(bytes.NewReader(withLogo.Body.Bytes()))
+		require.NoError(t, err)
+		r, g, b, _ := img.At(128, 128).RGBA()
+		assert.Equal(t, [3]uint32{0, 0, 0xffff}, [3]uint32{r, g, b}, "an explicit include_logo still adds the logo")
+	})
+
+	t.Run("MissingLogoDegrades", func(t *testing.T) {
+		handler, _, _ := setupTestHandler()
+		handler.config.LogoPath = filepath.Join(t.TempDir(), "missing.png")
+
+		w := get(handler, "data=https://example.com&size=256&include_logo=true")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		withoutLogo := get(handler, "data=https://example.com&size=256&include_logo=false")
+		require.Equal(t, http.StatusOK, withoutLogo.Code)
+		assert.Equal(t, withoutLogo.Body.Bytes(), w.Body.Bytes(), "a missing logo should yield a plain QR code")
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", decoded)
+	})
+}
+
+func TestGenerateQRCodeFormats(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	t.Run("WebP", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=https://example.com&include_logo=false&format=webp", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+
+		body := w.Body.Bytes()
+		require.Greater(t, len(body), 16)
+		assert.Equal(t, "RIFF", string(body[0:4]))
+		assert.Equal(t, "WEBP", string(body[8:12]))
+
+		decoded, err := qrcode.Decode(body)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", decoded)
+	})
+
+	t.Run("JPEG", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=hello&include_logo=false&format=jpeg", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		assert.Equal(t, []byte{0xff, 0xd8}, w.Body.Bytes()[:2])
+	})
+
+	t.Run("TransparentJPEGRejected", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=hello&include_logo=false&format=jpeg&transparent_background=true", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "transparent_background is not supported with format jpeg")
+	})
+
+	t.Run("UnsupportedFormat", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=hello&include_logo=false&format=gif", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "format must be png, jpeg or webp")
+	})
+}
+
+func TestGenerateQRCodeCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	opts := qrcode.DefaultOptions()
+	opts.Data = "hello"
+	opts.IncludeLogo = false
+
+	t.Run("MissGeneratesAndStores", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		handler.config.QRCacheTTL = time.Hour
+		router := gin.New()
+		router.GET("/qr", handler.GenerateQRCodeGET)
+
+		key := qrcode.CacheKey(opts)
+		mockCache.On("GetQRCode", mock.Anything, key).Return(nil, nil)
+		mockCache.On("SetQRCode", mock.Anything, key, mock.Anything).Return(nil)
+
+		req, _ := http.NewRequest("GET", "/qr?data=hello&include_logo=false", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		mockCache.AssertCalled(t, "SetQRCode", mock.Anything, key, w.Body.Bytes())
+	})
+
+	t.Run("HitSkipsGeneration", func(t *testing.T) {
+		handler, _, mockCache := setupTestHandler()
+		handler.config.QRCacheTTL = time.Hour
+		router := gin.New()
+		router.GET("/qr", handler.GenerateQRCodeGET)
+
+		cached := []byte("cached image")
+		mockCache.On("GetQRCode", mock.Anything, qrcode.CacheKey(opts)).Return(cached, nil)
+
+		req, _ := http.NewRequest("GET", "/qr?data=hello&include_logo=false", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, cached, w.Body.Bytes())
+		mockCache.AssertNotCalled(t, "SetQRCode", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("FormatInKey", func(t *testing.T) {
+		webp := opts
+		webp.Format = "webp"
+
+		assert.NotEqual(t, qrcode.CacheKey(opts), qrcode.CacheKey(webp))
+		assert.True(t, strings.HasPrefix(qrcode.CacheKey(webp), "webp:"))
+	})
+
+	t.Run("NamedLogoResolvedInKey", func(t *testing.T) {
+		a := opts
+		a.LogoName = "saude"
+		a.Logos = map[string]string{"saude": "/assets/a.png"}
+		b := a
+		b.Logos = map[string]string{"saude": "/assets/b.png"}
+
+		assert.NotEqual(t, qrcode.CacheKey(a), qrcode.CacheKey(b))
+	})
+}
+
+func TestGenerateQRCodeModuleShapes(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	generate := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/qr?data=https://example.com&include_logo=false&size=512"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	pixels := func(body []byte) []color.Gray {
+		img, err := png.Decode(bytes.NewReader(body))
+		require.NoError(t, err)
+		b := img.Bounds()
+		out := make([]color.Gray, 0, b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				out = append(out, color.GrayModel.Convert(img.At(x, y)).(color.Gray))
+			}
+		}
+		return out
+	}
+
+	images := map[string][]color.Gray{}
+	for _, query := range []string{"&module_shape=square", "&module_shape=circle", "&module_shape=rounded", "&module_shape=rounded&module_radius=0.2"} {
+		w := generate(query)
+		require.Equal(t, http.StatusOK, w.Code, query)
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err, query)
+		assert.Equal(t, "https://example.com", decoded, query)
+
+		images[query] = pixels(w.Body.Bytes())
+	}
+
+	queries := make([]string, 0, len(images))
+	for query := range images {
+		queries = append(queries, query)
+	}
+	for i := range queries {
+		for j := i + 1; j < len(queries); j++ {
+			assert.NotEqual(t, images[queries[i]], images[queries[j]], "%s and %s should differ", queries[i], queries[j])
+		}
+	}
+
+	t.Run("InvalidShape", func(t *testing.T) {
+		w := generate("&module_shape=hexagon")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("InvalidRadius", func(t *testing.T) {
+		w := generate("&module_shape=rounded&module_radius=0.8")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestGenerateQRCodeScale(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	generate := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/qr?data=https://example.com&include_logo=false"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	width := func(body []byte) int {
+		img, err := png.Decode(bytes.NewReader(body))
+		require.NoError(t, err)
+		return img.Bounds().Dx()
+	}
+
+	t.Run("SizesByModules", func(t *testing.T) {
+		w := generate("&scale=10")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		single := generate("&scale=1")
+		require.Equal(t, http.StatusOK, single.Code)
+		modules := width(single.Body.Bytes())
+		assert.Equal(t, modules*10, width(w.Body.Bytes()))
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", decoded)
+	})
+
+	t.Run("SizeWins", func(t *testing.T) {
+		w := generate("&scale=10&size=300")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 300, width(w.Body.Bytes()))
+	})
+
+	t.Run("RejectsOutOfRangeScale", func(t *testing.T) {
+		w := generate("&scale=40")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "got 40")
+	})
+
+	t.Run("RejectsScaleAboveMaxSize", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?include_logo=false&scale=32&data="+strings.Repeat("a", 500), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "use a smaller scale")
+	})
+
+	t.Run("EchoesInvalidSize", func(t *testing.T) {
+		w := generate("&size=10")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "got 10")
+
+		w = generate("&size=big")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `got \"big\"`)
+	})
+}
+
+func TestGenerateQRCodeBorderWidth(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	generate := func(query string) image.Image {
+		req, _ := http.NewRequest("GET", "/qr?data=https://example.com&include_logo=false&scale=4"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, query)
+
+		img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		return img
+	}
+	dark := func(img image.Image, x, y int) bool {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 128
+	}
+
+	none := generate("&border_width=0")
+	wide := generate("&border_width=10")
+
+	// The finder pattern starts at the very corner without a quiet zone
+	assert.True(t, dark(none, 0, 0))
+	assert.Equal(t, none.Bounds().Dx()+2*10*4, wide.Bounds().Dx())
+	assert.False(t, dark(wide, 39, 39))
+	assert.True(t, dark(wide, 40, 40))
+
+	t.Run("RejectsOutOfRange", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/qr?data=https://example.com&border_width=11", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "got 11")
+	})
+}
+
+func TestGenerateQRCodeEyeColors(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	generate := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/qr?data=https://example.com&include_logo=false&scale=4&border_width=0"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	rgb := func(img image.Image, x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	black := color.RGBA{A: 255}
+
+	t.Run("ColorsEyesAndBalls", func(t *testing.T) {
+		w := generate("&eye_color=%23FF0000&eye_ball_color=%230000FF")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+
+		// Module (0, 0) is the eye's ring and module (3, 3) its ball
+		assert.Equal(t, red, rgb(img, 0, 0))
+		assert.Equal(t, blue, rgb(img, 3*4+1, 3*4+1))
+		size := img.Bounds().Dx()
+		assert.Equal(t, red, rgb(img, size-1, 0))
+		assert.Equal(t, blue, rgb(img, 3*4+1, size-3*4-2))
+
+		// Data modules keep the foreground color
+		colors := map[color.RGBA]bool{}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				colors[rgb(img, x, y)] = true
+			}
+		}
+		assert.True(t, colors[black])
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", decoded)
+	})
+
+	t.Run("BallDefaultsToEyeColor", func(t *testing.T) {
+		w := generate("&eye_color=%23FF0000")
+		require.Equal(t, http.StatusOK, w.Code)
+		img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, red, rgb(img, 3*4+1, 3*4+1))
+	})
+
+	t.Run("DefaultsToForeground", func(t *testing.T) {
+		w := generate("")
+		require.Equal(t, http.StatusOK, w.Code)
+		img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, black, rgb(img, 0, 0))
+		assert.Equal(t, black, rgb(img, 3*4+1, 3*4+1))
+	})
+
+	t.Run("InvalidColor", func(t *testing.T) {
+		w := generate("&eye_ball_color=blue")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "eye_ball_color")
+	})
+}
+
+func TestGenerateQRCodeErrorCorrectionWithLogo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logo := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(logo, logo.Bounds(), &image.Uniform{color.RGBA{B: 255, A: 255}}, image.Point{}, draw.Src)
+	logoPath := filepath.Join(t.TempDir(), "brand.png")
+	f, err := os.Create(logoPath)
+	require.NoError(t, err)
+	require.NoError(t, png.Encode(f, logo))
+	require.NoError(t, f.Close())
+
+	handler, _, _ := setupTestHandler()
+	handler.config.QRLogos = map[string]string{"brand": logoPath}
+	router := gin.New()
+	router.GET("/qr", handler.GenerateQRCodeGET)
+
+	const longData = "https://example.com/a/much/longer/path/with/more/data?and=query&params=1"
+	generate := func(data, level string) *httptest.ResponseRecorder {
+		query := url.Values{"data": {data}, "logo_name": {"brand"}}
+		if level != "" {
+			query.Set("error_correction", level)
+		}
+		req, _ := http.NewRequest("GET", "/qr?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("DefaultsToHighest", func(t *testing.T) {
+		w := generate("https://example.com", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", decoded)
+	})
+
+	t.Run("LowerLevelAllowedWhenSafe", func(t *testing.T) {
+		w := generate(longData, "medium")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		decoded, err := qrcode.Decode(w.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, longData, decoded)
+	})
+
+	t.Run("CoverageOverCapacity", func(t *testing.T) {
+		w := generate(longData, "low")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "error_correction low only recovers 7%")
+	})
+
+	t.Run("UnreadableRejected", func(t *testing.T) {
+		w := generate("https://example.com", "high")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unreadable at error_correction high")
+	})
+}
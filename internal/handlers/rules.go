@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"url_shortener/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRedirectRules caps the rules on one URL, since every redirect checks them
+const maxRedirectRules = 20
+
+// prepareRules validates redirect rules and applies the https policy to their
+// destinations in place
+func (h *Handler) prepareRules(ctx context.Context, rules []database.RedirectRule) error {
+	if len(rules) > maxRedirectRules {
+		return fmt.Errorf("at most %d rules are allowed", maxRedirectRules)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Destination == "" {
+			return fmt.Errorf("rules[%d].destination is required", i)
+		}
+		if rule.Device == "" && rule.UserAgent == "" && len(rule.Countries) == 0 && len(rule.Languages) == 0 {
+			return fmt.Errorf("rules[%d] needs a device, user_agent, countries or languages condition", i)
+		}
+
+		rule.Device = strings.ToLower(rule.Device)
+		switch rule.Device {
+		case "", database.DeviceMobile, database.DeviceDesktop, database.DeviceIOS, database.DeviceAndroid:
+		default:
+			return fmt.Errorf("invalid rules[%d].device: %s (must be mobile, desktop, ios or android)", i, rule.Device)
+		}
+		if err := validateCountryCodes(fmt.Sprintf("rules[%d].countries", i), rule.Countries); err != nil {
+			return err
+		}
+		for _, language := range rule.Languages {
+			if strings.TrimSpace(language) == "" {
+				return fmt.Errorf("rules[%d].languages must not contain empty entries", i)
+			}
+		}
+
+		if err := h.checkURLLength(fmt.Sprintf("rules[%d].destination", i), rule.Destination); err != nil {
+			return err
+		}
+		destination, err := h.enforceHTTPS(ctx, rule.Destination)
+		if err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		if err := h.checkSelfDestination(destination); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		rule.Destination = destination
+	}
+	return nil
+}
+
+// ruleDestination returns the destination of the first rule the visitor
+// matches, or the URL's own destination when none does
+func (h *Handler) ruleDestination(c *gin.Context, url *database.URL) string {
+	if len(url.Rules) == 0 {
+		return url.Destination
+	}
+
+	// Caches in front of the service must not serve one visitor's target to another
+	vary := "User-Agent, Accept-Language"
+	if h.config.CountryHeader != "" {
+		vary += ", " + h.config.CountryHeader
+	}
+	c.Writer.Header().Add("Vary", vary)
+
+	userAgent := c.Request.UserAgent()
+	device := deviceClass(userAgent)
+	country := h.visitorCountry(c)
+	language := preferredLanguage(c.GetHeader("Accept-Language"))
+
+	for _, rule := range url.Rules {
+		if rule.Device != "" && !deviceMatches(rule.Device, device) {
+			continue
+		}
+		if rule.UserAgent != "" && !strings.Contains(strings.ToLower(userAgent), strings.ToLower(rule.UserAgent)) {
+			continue
+		}
+		if len(rule.Countries) > 0 && (country == "" || !containsFold(rule.Countries, country)) {
+			continue
+		}
+		if len(rule.Languages) > 0 && !languageMatches(rule.Languages, language) {
+			continue
+		}
+		return rule.Destination
+	}
+	return url.Destination
+}
+
+// deviceClass reduces a User-Agent to ios, android or desktop. Other mobile
+// platforms count as mobile.
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return database.DeviceIOS
+	case strings.Contains(ua, "android"):
+		return database.DeviceAndroid
+	case strings.Contains(ua, "mobile"):
+		return database.DeviceMobile
+	default:
+		return database.DeviceDesktop
+	}
+}
+
+func deviceMatches(want, device string) bool {
+	if want == database.DeviceMobile {
+		return device != database.DeviceDesktop
+	}
+	return want == device
+}
+
+// preferredLanguage returns the lowercased tag the Accept-Language header
+// ranks highest, or "" when there is none
+func preferredLanguage(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+
+	// Stable, so equal weights keep the order the client listed them in
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	return tags[0].tag
+}
+
+// languageMatches reports whether language is one of the rule's languages or
+// a regional variant of one, e.g. pt-BR for pt
+func languageMatches(languages []string, language string) bool {
+	if language == "" {
+		return false
+	}
+	for _, want := range languages {
+		want = strings.ToLower(strings.TrimSpace(want))
+		if language == want || strings.HasPrefix(language, want+"-") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"url_shortener/internal/config"
+)
+
+var (
+	// ErrInvalidShortPath is returned for paths with disallowed characters or separators
+	ErrInvalidShortPath = errors.New("invalid short path format")
+
+	// ErrReservedShortPath is returned for paths that are reserved or shadow a route
+	ErrReservedShortPath = errors.New("short path is reserved and cannot be used")
+)
+
+// ValidateShortPath checks a custom short path against the configured length
+// bounds, the allowed characters (letters, digits, '-', '_' and '.', with no
+// separator at either end) and the reserved set. It is shared by every code
+// path that accepts user-chosen short paths.
+func ValidateShortPath(cfg *config.Config, shortPath string) error {
+	if shortPath == "" || len(shortPath) < cfg.ShortPathMinLength || len(shortPath) > cfg.ShortPathMaxLength {
+		return fmt.Errorf("%w: length must be between %d and %d characters",
+			ErrInvalidShortPath, cfg.ShortPathMinLength, cfg.ShortPathMaxLength)
+	}
+
+	for _, char := range shortPath {
+		if !((char >= 'a' && char <= 'z') ||
+			(char >= 'A' && char <= 'Z') ||
+			(char >= '0' && char <= '9') ||
+			isSeparator(char)) {
+			return ErrInvalidShortPath
+		}
+	}
+
+	if isSeparator(rune(shortPath[0])) || isSeparator(rune(shortPath[len(shortPath)-1])) {
+		return fmt.Errorf("%w: must not start or end with '-', '_' or '.'", ErrInvalidShortPath)
+	}
+
+	if isReservedPath(cfg, shortPath) {
+		return ErrReservedShortPath
+	}
+
+	return nil
+}
+
+func isSeparator(char rune) bool {
+	return char == '-' || char == '_' || char == '.'
+}
+
+// normalizeShortPath returns the form a short path is stored and looked up
+// in: lowercase when CASE_INSENSITIVE_PATHS is set, unchanged otherwise
+func normalizeShortPath(cfg *config.Config, shortPath string) string {
+	if cfg.CaseInsensitivePaths {
+		return strings.ToLower(shortPath)
+	}
+	return shortPath
+}
+
+// isReservedPath reports whether a short path is in the configured reserved set
+// or would shadow the API or health routes (case-insensitive)
+func isReservedPath(cfg *config.Config, shortPath string) bool {
+	for _, route := range []string{cfg.APIPrefix, cfg.HealthPath} {
+		if root := rootSegment(route); root != "" && strings.EqualFold(shortPath, root) {
+			return true
+		}
+	}
+	for _, reserved := range cfg.ReservedPaths {
+		if strings.EqualFold(shortPath, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootSegment returns the first path segment of a route prefix
+func rootSegment(prefix string) string {
+	root, _, _ := strings.Cut(strings.TrimPrefix(prefix, "/"), "/")
+	return root
+}
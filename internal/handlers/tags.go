@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxTags caps the tags on one URL
+	maxTags = 20
+
+	// maxTagLength caps the length of a single tag
+	maxTagLength = 50
+)
+
+// normalizeTags lowercases and trims tags and drops duplicates, keeping the
+// first occurrence. Tags must be made of letters, digits, '-' and '_', which
+// also lets the database match them inside their stored JSON array.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("a URL can have at most %d tags", maxTags)
+	}
+
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if err := validateTag(tag); err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// validateTag checks a single lowercase tag
+func validateTag(tag string) error {
+	if tag == "" || len(tag) > maxTagLength {
+		return fmt.Errorf("invalid tag %q: must be 1 to %d characters", tag, maxTagLength)
+	}
+	for _, char := range tag {
+		if !((char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '-' || char == '_') {
+			return fmt.Errorf("invalid tag %q: only letters, digits, '-' and '_' are allowed", tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package jsoncase
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Snake keeps the API's native snake_case keys
+	Snake = "snake"
+
+	// Camel rewrites object keys to camelCase, e.g. short_path to shortPath
+	Camel = "camel"
+)
+
+// Middleware rewrites the keys of JSON response bodies to the given case so
+// every handler's output is converted consistently. Only Camel changes
+// anything; Snake and unknown values leave responses untouched. Request
+// bodies are not converted.
+func Middleware(style string) gin.HandlerFunc {
+	if !strings.EqualFold(style, Camel) {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		w := &camelWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+
+		c.Next()
+
+		if !w.buffering {
+			return
+		}
+		body := w.body.Bytes()
+		if converted, err := Camelize(body); err == nil {
+			body = converted
+		}
+		w.ResponseWriter.Write(body)
+	}
+}
+
+// camelWriter holds back JSON bodies until the handler is done so their keys
+// can be rewritten; other content types pass straight through
+type camelWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	decided   bool
+	buffering bool
+}
+
+func (w *camelWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.buffering = strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+	}
+	if w.buffering {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *camelWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to extend
+// a route's write deadline
+func (w *camelWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// dataKeyedFields are the fields whose object values are maps keyed by data,
+// such as the short paths of a batch resolve or query parameter names,
+// rather than by field names. Their keys are kept as they are.
+var dataKeyedFields = map[string]bool{
+	"urls":          true,
+	"static_params": true,
+}
+
+// Camelize rewrites the object keys of a JSON document to camelCase,
+// preserving key order and number formatting. The keys of dataKeyedFields
+// maps are left alone, though objects nested in them are still converted.
+func Camelize(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var out bytes.Buffer
+	// Each open container records whether it is an object and how many
+	// tokens it has seen, which tells keys from values and where commas go
+	type container struct {
+		object   bool
+		keepKeys bool
+		count    int
+	}
+	var stack []container
+	// dataKeyed is set after a dataKeyedFields key, for the value that follows
+	dataKeyed := false
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && len(stack) == 0 {
+			break
+		}
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			out.WriteRune(rune(delim))
+			continue
+		}
+
+		isKey, keepKey := false, false
+		valueOfDataKeyed := dataKeyed
+		dataKeyed = false
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			switch {
+			case top.object && top.count%2 == 0:
+				isKey, keepKey = true, top.keepKeys
+				if top.count > 0 {
+					out.WriteByte(',')
+				}
+			case top.object:
+				out.WriteByte(':')
+			case top.count > 0:
+				out.WriteByte(',')
+			}
+			top.count++
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(v))
+			stack = append(stack, container{object: v == '{', keepKeys: v == '{' && valueOfDataKeyed})
+		case string:
+			if isKey {
+				if !keepKey {
+					dataKeyed = dataKeyedFields[v]
+					v = toCamel(v)
+				}
+			}
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(encoded)
+		case json.Number:
+			out.WriteString(v.String())
+		case bool:
+			if v {
+				out.WriteString("true")
+			} else {
+				out.WriteString("false")
+			}
+		case nil:
+			out.WriteString("null")
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// toCamel converts a snake_case key to camelCase
+func toCamel(key string) string {
+	if !strings.Contains(key, "_") {
+		return key
+	}
+
+	parts := strings.Split(key, "_")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
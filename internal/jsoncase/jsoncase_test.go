@@ -0,0 +1,95 @@
+package jsoncase
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"url_shortener/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveURL(t *testing.T, style string) map[string]interface{} {
+	gin.SetMode(gin.TestMode)
+
+	title := "Prefeitura"
+	url := database.URL{
+		ID:          uuid.New(),
+		ShortPath:   "abc123",
+		Destination: "https://example.com",
+		Title:       &title,
+		CreatedAt:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		UpdatedAt:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	router := gin.New()
+	router.Use(Middleware(style))
+	router.GET("/url", func(c *gin.Context) { c.JSON(http.StatusCreated, url) })
+
+	req, _ := http.NewRequest("GET", "/url", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("SnakeCase", func(t *testing.T) {
+		body := serveURL(t, Snake)
+		assert.Equal(t, "abc123", body["short_path"])
+		assert.Equal(t, "2024-01-01T12:00:00Z", body["created_at"])
+		assert.Contains(t, body, "use_count")
+		assert.NotContains(t, body, "shortPath")
+	})
+
+	t.Run("CamelCase", func(t *testing.T) {
+		body := serveURL(t, Camel)
+		assert.Equal(t, "abc123", body["shortPath"])
+		assert.Equal(t, "2024-01-01T12:00:00Z", body["createdAt"])
+		assert.Equal(t, "Prefeitura", body["title"])
+		assert.Contains(t, body, "useCount")
+		assert.NotContains(t, body, "short_path")
+	})
+
+	t.Run("NonJSONUntouched", func(t *testing.T) {
+		router := gin.New()
+		router.Use(Middleware(Camel))
+		router.GET("/text", func(c *gin.Context) { c.String(http.StatusOK, `{"short_path":"x"}`) })
+
+		req, _ := http.NewRequest("GET", "/text", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, `{"short_path":"x"}`, w.Body.String())
+	})
+}
+
+func TestCamelize(t *testing.T) {
+	input := `{"urls":[{"short_path":"a","max_uses":1.50,"tags":["snake_value"],"meta":{"next_cursor":null,"has_next":true}}],"total_pages":2}`
+
+	out, err := Camelize([]byte(input))
+	require.NoError(t, err)
+	assert.Equal(t, `{"urls":[{"shortPath":"a","maxUses":1.50,"tags":["snake_value"],"meta":{"nextCursor":null,"hasNext":true}}],"totalPages":2}`, string(out))
+
+	t.Run("KeepsDataKeys", func(t *testing.T) {
+		// Short paths of a batch resolve and query parameter names are data
+		input := `{"urls":{"black_friday":{"short_path":"black_friday","static_params":{"utm_source":"mail"}},"gone_link":null,"urls":{"max_uses":1}}}`
+
+		out, err := Camelize([]byte(input))
+		require.NoError(t, err)
+		assert.Equal(t, `{"urls":{"black_friday":{"shortPath":"black_friday","staticParams":{"utm_source":"mail"}},"gone_link":null,"urls":{"maxUses":1}}}`, string(out))
+	})
+
+	_, err = Camelize([]byte(`{"broken":`))
+	assert.Error(t, err)
+}
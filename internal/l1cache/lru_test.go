@@ -0,0 +1,70 @@
+package l1cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		c := NewLRU[int](2, time.Minute)
+		c.Add("a", 1)
+		c.Add("b", 2)
+
+		// Reading a makes b the least recently used
+		_, ok := c.Get("a")
+		assert.True(t, ok)
+		c.Add("c", 3)
+
+		_, ok = c.Get("b")
+		assert.False(t, ok)
+		value, ok := c.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+		assert.Equal(t, 2, c.Len())
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		now := time.Unix(0, 0)
+		c := NewLRU[int](2, time.Second)
+		c.now = func() time.Time { return now }
+
+		c.Add("a", 1)
+		now = now.Add(999 * time.Millisecond)
+		_, ok := c.Get("a")
+		assert.True(t, ok)
+
+		now = now.Add(time.Millisecond)
+		_, ok = c.Get("a")
+		assert.False(t, ok)
+		assert.Equal(t, 0, c.Len())
+	})
+
+	t.Run("AddReplacesAndRestartsTTL", func(t *testing.T) {
+		now := time.Unix(0, 0)
+		c := NewLRU[int](2, time.Second)
+		c.now = func() time.Time { return now }
+
+		c.Add("a", 1)
+		now = now.Add(900 * time.Millisecond)
+		c.Add("a", 2)
+		now = now.Add(900 * time.Millisecond)
+
+		value, ok := c.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 2, value)
+		assert.Equal(t, 1, c.Len())
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		c := NewLRU[int](2, time.Minute)
+		c.Add("a", 1)
+		c.Remove("a")
+		c.Remove("missing")
+
+		_, ok := c.Get("a")
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,181 @@
+package l1cache
+
+import (
+	"context"
+	"time"
+
+	"url_shortener/internal/database"
+)
+
+// Backend is the shared cache the L1 sits in front of, normally Redis
+type Backend interface {
+	GetURL(ctx context.Context, shortPath string) (*database.URL, error)
+	SetURL(ctx context.Context, shortPath string, url *database.URL) error
+	DeleteURL(ctx context.Context, shortPath string) error
+	SetExpiredURL(ctx context.Context, shortPath string, url *database.URL) error
+	GetURLByID(ctx context.Context, id string) (*database.URL, error)
+	SetURLByID(ctx context.Context, id string, url *database.URL) error
+	DeleteURLByID(ctx context.Context, id string) error
+	IsNotFound(ctx context.Context, shortPath string) (bool, error)
+	SetNotFound(ctx context.Context, shortPath string) error
+	DeleteNotFound(ctx context.Context, shortPath string) error
+	FlushURLs(ctx context.Context) (int64, error)
+	GetQRCode(ctx context.Context, key string) ([]byte, error)
+	SetQRCode(ctx context.Context, key string, data []byte) error
+	Ping(ctx context.Context) error
+}
+
+// Tiered is a two-level cache: a small in-process LRU (L1) in front of a
+// shared backend (L2). Reads check L1, then the backend, copying backend hits
+// into L1; writes and deletes go to both levels. L1 is local to this
+// process, so other replicas only see a change once their L1 entry expires;
+// keep the TTL short to bound that staleness. QR codes bypass L1, as they are
+// large and rarely hot.
+type Tiered struct {
+	next     Backend
+	urls     *LRU[database.URL]
+	notFound *LRU[struct{}]
+}
+
+// NewTiered wraps next with an L1 of up to size URLs (and as many not-found
+// markers), each kept for at most ttl
+func NewTiered(next Backend, size int, ttl time.Duration) *Tiered {
+	return &Tiered{
+		next:     next,
+		urls:     NewLRU[database.URL](size, ttl),
+		notFound: NewLRU[struct{}](size, ttl),
+	}
+}
+
+// hitReportKey carries the flag set by Tiered reads served from L1
+type hitReportKey struct{}
+
+// WithHitReport returns a context in which Tiered URL reads set the returned
+// flag when they are served from L1, so callers can tell the cache levels
+// apart without depending on how the cache is composed
+func WithHitReport(ctx context.Context) (context.Context, *bool) {
+	fromL1 := new(bool)
+	return context.WithValue(ctx, hitReportKey{}, fromL1), fromL1
+}
+
+// reportL1Hit sets the hit report flag of ctx, if it has one
+func reportL1Hit(ctx context.Context) {
+	if fromL1, ok := ctx.Value(hitReportKey{}).(*bool); ok {
+		*fromL1 = true
+	}
+}
+
+func pathKey(shortPath string) string { return "path:" + shortPath }
+func idKey(id string) string          { return "id:" + id }
+
+// get returns a copy of an L1 URL, so callers cannot modify the cached entry
+func (t *Tiered) get(key string) *database.URL {
+	if url, ok := t.urls.Get(key); ok {
+		return &url
+	}
+	return nil
+}
+
+// add caches a copy of url in L1, unless it has already expired
+func (t *Tiered) add(key string, url *database.URL) {
+	if url.ExpiresAt != nil && !url.ExpiresAt.After(time.Now()) {
+		t.urls.Remove(key)
+		return
+	}
+	t.urls.Add(key, *url)
+}
+
+func (t *Tiered) GetURL(ctx context.Context, shortPath string) (*database.URL, error) {
+	if url := t.get(pathKey(shortPath)); url != nil {
+		reportL1Hit(ctx)
+		return url, nil
+	}
+
+	url, err := t.next.GetURL(ctx, shortPath)
+	if err == nil && url != nil {
+		t.add(pathKey(shortPath), url)
+	}
+	return url, err
+}
+
+func (t *Tiered) SetURL(ctx context.Context, shortPath string, url *database.URL) error {
+	t.add(pathKey(shortPath), url)
+	t.notFound.Remove(shortPath)
+	return t.next.SetURL(ctx, shortPath, url)
+}
+
+func (t *Tiered) DeleteURL(ctx context.Context, shortPath string) error {
+	t.urls.Remove(pathKey(shortPath))
+	return t.next.DeleteURL(ctx, shortPath)
+}
+
+// SetExpiredURL only reaches the backend, since L1 never keeps expired URLs
+func (t *Tiered) SetExpiredURL(ctx context.Context, shortPath string, url *database.URL) error {
+	t.urls.Remove(pathKey(shortPath))
+	return t.next.SetExpiredURL(ctx, shortPath, url)
+}
+
+func (t *Tiered) GetURLByID(ctx context.Context, id string) (*database.URL, error) {
+	if url := t.get(idKey(id)); url != nil {
+		reportL1Hit(ctx)
+		return url, nil
+	}
+
+	url, err := t.next.GetURLByID(ctx, id)
+	if err == nil && url != nil {
+		t.add(idKey(id), url)
+	}
+	return url, err
+}
+
+func (t *Tiered) SetURLByID(ctx context.Context, id string, url *database.URL) error {
+	t.add(idKey(id), url)
+	return t.next.SetURLByID(ctx, id, url)
+}
+
+func (t *Tiered) DeleteURLByID(ctx context.Context, id string) error {
+	t.urls.Remove(idKey(id))
+	return t.next.DeleteURLByID(ctx, id)
+}
+
+func (t *Tiered) IsNotFound(ctx context.Context, shortPath string) (bool, error) {
+	if _, ok := t.notFound.Get(shortPath); ok {
+		return true, nil
+	}
+
+	notFound, err := t.next.IsNotFound(ctx, shortPath)
+	if err == nil && notFound {
+		t.notFound.Add(shortPath, struct{}{})
+	}
+	return notFound, err
+}
+
+func (t *Tiered) SetNotFound(ctx context.Context, shortPath string) error {
+	t.notFound.Add(shortPath, struct{}{})
+	return t.next.SetNotFound(ctx, shortPath)
+}
+
+func (t *Tiered) DeleteNotFound(ctx context.Context, shortPath string) error {
+	t.notFound.Remove(shortPath)
+	return t.next.DeleteNotFound(ctx, shortPath)
+}
+
+// FlushURLs empties this process's L1 as well as the backend; other replicas
+// keep their L1 entries until they expire
+func (t *Tiered) FlushURLs(ctx context.Context) (int64, error) {
+	t.urls.Purge()
+	t.notFound.Purge()
+	return t.next.FlushURLs(ctx)
+}
+
+func (t *Tiered) GetQRCode(ctx context.Context, key string) ([]byte, error) {
+	return t.next.GetQRCode(ctx, key)
+}
+
+func (t *Tiered) SetQRCode(ctx context.Context, key string, data []byte) error {
+	return t.next.SetQRCode(ctx, key, data)
+}
+
+func (t *Tiered) Ping(ctx context.Context) error {
+	return t.next.Ping(ctx)
+}
@@ -0,0 +1,218 @@
+package l1cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"url_shortener/internal/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend is a map-backed backend counting URL reads
+type fakeBackend struct {
+	urls     map[string]*database.URL
+	notFound map[string]bool
+	reads    int
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{urls: map[string]*database.URL{}, notFound: map[string]bool{}}
+}
+
+func (f *fakeBackend) GetURL(ctx context.Context, shortPath string) (*database.URL, error) {
+	f.reads++
+	return f.urls["path:"+shortPath], nil
+}
+
+func (f *fakeBackend) SetURL(ctx context.Context, shortPath string, url *database.URL) error {
+	f.urls["path:"+shortPath] = url
+	return nil
+}
+
+func (f *fakeBackend) SetExpiredURL(ctx context.Context, shortPath string, url *database.URL) error {
+	f.urls["path:"+shortPath] = url
+	return nil
+}
+
+func (f *fakeBackend) DeleteURL(ctx context.Context, shortPath string) error {
+	delete(f.urls, "path:"+shortPath)
+	return nil
+}
+
+func (f *fakeBackend) GetURLByID(ctx context.Context, id string) (*database.URL, error) {
+	f.reads++
+	return f.urls["id:"+id], nil
+}
+
+func (f *fakeBackend) SetURLByID(ctx context.Context, id string, url *database.URL) error {
+	f.urls["id:"+id] = url
+	return nil
+}
+
+func (f *fakeBackend) DeleteURLByID(ctx context.Context, id string) error {
+	delete(f.urls, "id:"+id)
+	return nil
+}
+
+func (f *fakeBackend) IsNotFound(ctx context.Context, shortPath string) (bool, error) {
+	f.reads++
+	return f.notFound[shortPath], nil
+}
+
+func (f *fakeBackend) SetNotFound(ctx context.Context, shortPath string) error {
+	f.notFound[shortPath] = true
+	return nil
+}
+
+func (f *fakeBackend) DeleteNotFound(ctx context.Context, shortPath string) error {
+	delete(f.notFound, shortPath)
+	return nil
+}
+
+func (f *fakeBackend) FlushURLs(ctx context.Context) (int64, error) {
+	n := int64(len(f.urls) + len(f.notFound))
+	clear(f.urls)
+	clear(f.notFound)
+	return n, nil
+}
+
+func (f *fakeBackend) GetQRCode(ctx context.Context, key string) ([]byte, error) { return nil, nil }
+
+func (f *fakeBackend) SetQRCode(ctx context.Context, key string, data []byte) error { return nil }
+
+func (f *fakeBackend) Ping(ctx context.Context) error { return nil }
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("PopulatesFromBackend", func(t *testing.T) {
+		backend := newFakeBackend()
+		backend.urls["path:promo"] = &database.URL{ShortPath: "promo", Destination: "https://example.com"}
+		c := NewTiered(backend, 10, time.Minute)
+
+		for i := 0; i < 3; i++ {
+			url, err := c.GetURL(ctx, "promo")
+			require.NoError(t, err)
+			require.NotNil(t, url)
+			assert.Equal(t, "https://example.com", url.Destination)
+		}
+		assert.Equal(t, 1, backend.reads)
+	})
+
+	t.Run("ReturnsCopies", func(t *testing.T) {
+		c := NewTiered(newFakeBackend(), 10, time.Minute)
+		require.NoError(t, c.SetURL(ctx, "promo", &database.URL{Destination: "https://example.com"}))
+
+		url, _ := c.GetURL(ctx, "promo")
+		url.Destination = "https://changed.example.com"
+
+		url, _ = c.GetURL(ctx, "promo")
+		assert.Equal(t, "https://example.com", url.Destination)
+	})
+
+	t.Run("WritesThrough", func(t *testing.T) {
+		backend := newFakeBackend()
+		c := NewTiered(backend, 10, time.Minute)
+
+		require.NoError(t, c.SetURLByID(ctx, "id-1", &database.URL{Destination: "https://example.com"}))
+		assert.NotNil(t, backend.urls["id:id-1"])
+
+		url, err := c.GetURLByID(ctx, "id-1")
+		require.NoError(t, err)
+		require.NotNil(t, url)
+		assert.Equal(t, 0, backend.reads)
+	})
+
+	t.Run("DeletesInvalidateBothLevels", func(t *testing.T) {
+		backend := newFakeBackend()
+		c := NewTiered(backend, 10, time.Minute)
+		require.NoError(t, c.SetURL(ctx, "promo", &database.URL{Destination: "https://example.com"}))
+		require.NoError(t, c.SetURLByID(ctx, "id-1", &database.URL{Destination: "https://example.com"}))
+
+		require.NoError(t, c.DeleteURL(ctx, "promo"))
+		require.NoError(t, c.DeleteURLByID(ctx, "id-1"))
+
+		url, _ := c.GetURL(ctx, "promo")
+		assert.Nil(t, url)
+		url, _ = c.GetURLByID(ctx, "id-1")
+		assert.Nil(t, url)
+		assert.Empty(t, backend.urls)
+	})
+
+	t.Run("UpdatesReplaceL1", func(t *testing.T) {
+		c := NewTiered(newFakeBackend(), 10, time.Minute)
+		require.NoError(t, c.SetURL(ctx, "promo", &database.URL{Destination: "https://example.com/old"}))
+		require.NoError(t, c.SetURL(ctx, "promo", &database.URL{Destination: "https://example.com/new"}))
+
+		url, _ := c.GetURL(ctx, "promo")
+		assert.Equal(t, "https://example.com/new", url.Destination)
+	})
+
+	t.Run("SkipsExpiredURLs", func(t *testing.T) {
+		backend := newFakeBackend()
+		c := NewTiered(backend, 10, time.Minute)
+		past := time.Now().Add(-time.Minute)
+		require.NoError(t, c.SetURL(ctx, "old", &database.URL{Destination: "https://example.com", ExpiresAt: &past}))
+
+		_, _ = c.GetURL(ctx, "old")
+		assert.Equal(t, 1, backend.reads)
+	})
+
+	t.Run("NotFoundMarkers", func(t *testing.T) {
+		backend := newFakeBackend()
+		c := NewTiered(backend, 10, time.Minute)
+		require.NoError(t, c.SetNotFound(ctx, "missing"))
+
+		notFound, err := c.IsNotFound(ctx, "missing")
+		require.NoError(t, err)
+		assert.True(t, notFound)
+		assert.Equal(t, 0, backend.reads)
+
+		// Creating the path clears the marker in both levels
+		require.NoError(t, c.SetURL(ctx, "missing", &database.URL{Destination: "https://example.com"}))
+		require.NoError(t, c.DeleteNotFound(ctx, "missing"))
+		notFound, _ = c.IsNotFound(ctx, "missing")
+		assert.False(t, notFound)
+	})
+
+	t.Run("ReportsL1Hits", func(t *testing.T) {
+		backend := newFakeBackend()
+		backend.urls["path:promo"] = &database.URL{Destination: "https://example.com"}
+		c := NewTiered(backend, 10, time.Minute)
+
+		readCtx, fromL1 := WithHitReport(ctx)
+		_, _ = c.GetURL(readCtx, "promo")
+		assert.False(t, *fromL1)
+
+		readCtx, fromL1 = WithHitReport(ctx)
+		_, _ = c.GetURL(readCtx, "promo")
+		assert.True(t, *fromL1)
+
+		// Reads without a report are unaffected
+		url, _ := c.GetURL(ctx, "promo")
+		assert.NotNil(t, url)
+	})
+
+	t.Run("FlushEmptiesBothLevels", func(t *testing.T) {
+		backend := newFakeBackend()
+		c := NewTiered(backend, 10, time.Minute)
+		require.NoError(t, c.SetURL(ctx, "promo", &database.URL{Destination: "https://example.com"}))
+		require.NoError(t, c.SetURLByID(ctx, "id-1", &database.URL{Destination: "https://example.com"}))
+		require.NoError(t, c.SetNotFound(ctx, "missing"))
+
+		removed, err := c.FlushURLs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), removed)
+
+		url, _ := c.GetURL(ctx, "promo")
+		assert.Nil(t, url)
+		url, _ = c.GetURLByID(ctx, "id-1")
+		assert.Nil(t, url)
+		notFound, _ := c.IsNotFound(ctx, "missing")
+		assert.False(t, notFound)
+		assert.Equal(t, 3, backend.reads)
+	})
+}
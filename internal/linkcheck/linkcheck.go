@@ -0,0 +1,37 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+)
+
+// Status probes url with a HEAD request and returns the HTTP status it
+// answers, or 0 when it cannot be reached. Servers that do not implement HEAD
+// are retried with GET.
+func Status(ctx context.Context, client *http.Client, url string) int {
+	status := probe(ctx, client, http.MethodHead, url)
+	if status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented {
+		status = probe(ctx, client, http.MethodGet, url)
+	}
+	return status
+}
+
+// Broken reports whether a status from Status means the destination is broken
+func Broken(status int) bool {
+	return status == 0 || status >= http.StatusBadRequest
+}
+
+func probe(ctx context.Context, client *http.Client, method, url string) int {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
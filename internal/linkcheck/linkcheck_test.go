@@ -0,0 +1,57 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/get-only":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := server.Client()
+
+	t.Run("OK", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, Status(ctx, client, server.URL+"/ok"))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, Status(ctx, client, server.URL+"/gone"))
+	})
+
+	t.Run("FallsBackToGET", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, Status(ctx, client, server.URL+"/get-only"))
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		assert.Equal(t, 0, Status(ctx, client, closed.URL))
+	})
+}
+
+func TestBroken(t *testing.T) {
+	assert.True(t, Broken(0))
+	assert.True(t, Broken(http.StatusNotFound))
+	assert.True(t, Broken(http.StatusBadGateway))
+	assert.False(t, Broken(http.StatusOK))
+	assert.False(t, Broken(http.StatusMovedPermanently))
+}
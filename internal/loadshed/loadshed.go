@@ -0,0 +1,49 @@
+package loadshed
+
+import (
+	"net/http"
+	"strconv"
+
+	"url_shortener/internal/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RetryAfterSeconds is sent in the Retry-After header of shed requests
+const RetryAfterSeconds = 1
+
+// Middleware caps the number of requests handled at once. Requests beyond
+// limit are rejected immediately with 503 and Retry-After instead of queueing,
+// so the service sheds load rather than collapsing. Requests to exempt paths
+// (e.g. health checks) are always served. A limit below 1 disables shedding.
+func Middleware(limit int, exempt ...string) gin.HandlerFunc {
+	if limit < 1 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	exemptPaths := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		if path != "" {
+			exemptPaths[path] = true
+		}
+	}
+
+	// Counting semaphore: a slot is held for the duration of each request
+	slots := make(chan struct{}, limit)
+
+	return func(c *gin.Context) {
+		if exemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			c.Header("Retry-After", strconv.Itoa(RetryAfterSeconds))
+			apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeOverloaded, "server is overloaded, retry later")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"url_shortener/internal/clientip"
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request correlation ID in and out of the service
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds inbound request IDs so clients cannot bloat logs
+const maxRequestIDLength = 128
+
+// New creates a logger writing to w in the given format ("json" or "text") at
+// the given level ("debug", "info", "warn" or "error"). Unknown values fall back
+// to text and info.
+func New(w io.Writer, format, level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Middleware assigns each request an ID, taken from X-Request-ID when the
+// client sends a usable one, echoes it back in the response, stores it in the
+// request context and logs the request once it completes
+func Middleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := requestID(c.GetHeader(RequestIDHeader))
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(telemetry.ContextWithRequestID(c.Request.Context(), id))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", clientip.IP(c)),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate).String(); errs != "" {
+			attrs = append(attrs, slog.String("error", errs))
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// requestID returns the inbound ID when it is short and printable, otherwise a
+// freshly generated one
+func requestID(inbound string) string {
+	if inbound == "" || len(inbound) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+	for _, r := range inbound {
+		if r < 0x21 || r > 0x7e {
+			return uuid.NewString()
+		}
+	}
+	return inbound
+}
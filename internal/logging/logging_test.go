@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"url_shortener/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRouter(buf *bytes.Buffer, format, level string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(New(buf, format, level)))
+	router.GET("/ok", func(c *gin.Context) {
+		c.String(http.StatusOK, telemetry.RequestIDFromContext(c.Request.Context()))
+	})
+	router.GET("/missing", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+	})
+	return router
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("JSONFields", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := setupRouter(&buf, "json", "info")
+
+		req, _ := http.NewRequest("GET", "/ok", nil)
+		req.Header.Set(RequestIDHeader, "req-123")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, "req-123", w.Header().Get(RequestIDHeader))
+		assert.Equal(t, "req-123", w.Body.String(), "request ID should be in the handler context")
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "INFO", entry["level"])
+		assert.Equal(t, "req-123", entry["request_id"])
+		assert.Equal(t, "GET", entry["method"])
+		assert.Equal(t, "/ok", entry["path"])
+		assert.Equal(t, float64(http.StatusOK), entry["status"])
+		assert.Equal(t, float64(len("req-123")), entry["bytes"])
+		assert.Contains(t, entry, "latency")
+		assert.Contains(t, entry, "client_ip")
+	})
+
+	t.Run("GeneratesRequestID", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := setupRouter(&buf, "json", "info")
+
+		req, _ := http.NewRequest("GET", "/ok", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(RequestIDHeader)
+		_, err := uuid.Parse(id)
+		assert.NoError(t, err)
+		assert.Contains(t, buf.String(), id)
+	})
+
+	t.Run("RejectsUnusableInboundID", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := setupRouter(&buf, "json", "info")
+
+		req, _ := http.NewRequest("GET", "/ok", nil)
+		req.Header.Set(RequestIDHeader, strings.Repeat("x", maxRequestIDLength+1))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		_, err := uuid.Parse(w.Header().Get(RequestIDHeader))
+		assert.NoError(t, err)
+	})
+
+	t.Run("ClientErrorsLogAtWarn", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := setupRouter(&buf, "json", "info")
+
+		req, _ := http.NewRequest("GET", "/missing", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "WARN", entry["level"])
+	})
+
+	t.Run("LevelFiltersRequests", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := setupRouter(&buf, "json", "warn")
+
+		req, _ := http.NewRequest("GET", "/ok", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("TextFormat", func(t *testing.T) {
+		var buf bytes.Buffer
+		router := setupRouter(&buf, "text", "info")
+
+		req, _ := http.NewRequest("GET", "/ok", nil)
+		req.Header.Set(RequestIDHeader, "req-456")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Contains(t, buf.String(), "request_id=req-456")
+		assert.Contains(t, buf.String(), "status=200")
+	})
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(BodyLimit(10, map[string]int64{"/api/qr": 20}))
+	echo := func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		require.NoError(t, err)
+		c.String(http.StatusOK, string(body))
+	}
+	router.POST("/api/urls", echo)
+	router.POST("/api/qr", echo)
+
+	post := func(path string, body io.Reader) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("WithinLimit", func(t *testing.T) {
+		w := post("/api/urls", strings.NewReader("0123456789"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "0123456789", w.Body.String())
+	})
+
+	t.Run("OverLimit", func(t *testing.T) {
+		w := post("/api/urls", strings.NewReader("0123456789a"))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Contains(t, w.Body.String(), "request body too large")
+	})
+
+	t.Run("OverLimitWithoutContentLength", func(t *testing.T) {
+		// A reader of unknown size leaves ContentLength at -1, as with chunked uploads
+		w := post("/api/urls", io.MultiReader(strings.NewReader("01234"), strings.NewReader("56789a")))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("RouteOverride", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post("/api/qr", strings.NewReader(strings.Repeat("x", 20))).Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, post("/api/qr", strings.NewReader(strings.Repeat("x", 21))).Code)
+	})
+}
+
+func TestBodyLimitDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(BodyLimit(0, nil))
+	router.POST("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", 1<<10)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
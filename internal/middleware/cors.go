@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Methods and headers browsers may use when calling the API cross-origin
+const (
+	corsAllowMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type"
+	// corsExposeHeaders lists the non-safelisted response headers scripts may read
+	corsExposeHeaders = "Deprecation, Link, Retry-After, Sunset, Warning, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Short-Link-ID, X-Short-Path, X-Short-URL"
+	// corsMaxAge is how long, in seconds, browsers may cache a preflight result
+	corsMaxAge = 600
+)
+
+// CORS lets browsers on the allowed origins call the API. An origin is
+// allowed if it equals an entry (scheme://host[:port]), matches an entry with
+// a leading wildcard label such as https://*.example.com, or if the list
+// contains "*". Preflight OPTIONS requests from allowed origins are answered
+// with 204 directly. With allowCredentials the matching origin is echoed
+// back, since browsers reject a "*" origin on credentialed requests.
+// Credentials are never allowed together with "*", which would let any site
+// act with the user's cookies. An empty list disables CORS.
+func CORS(origins []string, allowCredentials bool) gin.HandlerFunc {
+	if len(origins) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	allowAll := false
+	exact := make(map[string]bool, len(origins))
+	var suffixes []struct{ scheme, suffix string }
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+		switch {
+		case origin == "*":
+			allowAll = true
+			allowCredentials = false
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://")
+			suffixes = append(suffixes, struct{ scheme, suffix string }{scheme, strings.TrimPrefix(host, "*")})
+		case origin != "":
+			exact[origin] = true
+		}
+	}
+
+	allowed := func(origin string) bool {
+		if allowAll {
+			return true
+		}
+		origin = strings.ToLower(origin)
+		if exact[origin] {
+			return true
+		}
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok {
+			return false
+		}
+		for _, s := range suffixes {
+			// The wildcard stands for at least one label, so the bare domain does not match
+			if scheme == s.scheme && strings.HasSuffix(host, s.suffix) && len(host) > len(s.suffix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+
+		// The response depends on the Origin unless every origin gets "*"
+		if !allowAll {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if origin == "" || !allowed(origin) {
+			c.Next()
+			return
+		}
+
+		if allowAll {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if allowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", corsAllowMethods)
+			c.Header("Access-Control-Allow-Headers", corsAllowHeaders)
+			c.Header("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Header("Access-Control-Expose-Headers", corsExposeHeaders)
+		c.Next()
+	}
+}
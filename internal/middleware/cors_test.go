@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(origins []string, credentials bool) *gin.Engine {
+		router := gin.New()
+		router.Use(CORS(origins, credentials))
+		router.GET("/api/urls", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return router
+	}
+	send := func(router *gin.Engine, method, origin string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/api/urls", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			req.Header.Set("Access-Control-Request-Headers", "authorization, idempotency-key")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("AllowedOrigin", func(t *testing.T) {
+		router := newRouter([]string{"https://admin.example.com"}, false)
+		w := send(router, "GET", "https://admin.example.com")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://admin.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "Origin", w.Header().Get("Vary"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "X-RateLimit-Remaining")
+	})
+
+	t.Run("DisallowedOrigin", func(t *testing.T) {
+		router := newRouter([]string{"https://admin.example.com"}, false)
+		w := send(router, "GET", "https://evil.example.org")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("Preflight", func(t *testing.T) {
+		router := newRouter([]string{"https://admin.example.com"}, false)
+		w := send(router, "OPTIONS", "https://admin.example.com")
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://admin.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "PATCH")
+		assert.Equal(t, "Authorization, Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("PreflightFromDisallowedOrigin", func(t *testing.T) {
+		router := newRouter([]string{"https://admin.example.com"}, false)
+		w := send(router, "OPTIONS", "https://evil.example.org")
+
+		assert.NotEqual(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("SubdomainWildcard", func(t *testing.T) {
+		router := newRouter([]string{"https://*.example.com"}, false)
+
+		assert.Equal(t, "https://admin.example.com", send(router, "GET", "https://admin.example.com").Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, send(router, "GET", "https://example.com").Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, send(router, "GET", "http://admin.example.com").Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, send(router, "GET", "https://admin.example.com.evil.org").Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("AnyOrigin", func(t *testing.T) {
+		router := newRouter([]string{"*"}, false)
+		w := send(router, "GET", "https://anywhere.example.org")
+
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+
+	t.Run("AnyOriginWithCredentials", func(t *testing.T) {
+		router := newRouter([]string{"*"}, true)
+		w := send(router, "GET", "https://anywhere.example.org")
+
+		// Any site could act with the user's cookies, so credentials are refused
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		router := newRouter(nil, false)
+
+		w := send(router, "GET", "https://admin.example.com")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Vary"))
+
+		w = send(router, "OPTIONS", "https://admin.example.com")
+		assert.NotEqual(t, http.StatusNoContent, w.Code)
+	})
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WriteTimeout gives a route d to respond instead of the server's
+// WriteTimeout, for endpoints that legitimately take longer. The connection's
+// write deadline is moved to d from now and the request context ends at the
+// same moment, so work the client can no longer receive is abandoned. A d
+// below 1 leaves the server's deadline in place.
+func WriteTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 {
+			c.Next()
+			return
+		}
+
+		deadline := time.Now().Add(d)
+		// Writers that cannot reach the connection keep the server deadline
+		_ = http.NewResponseController(c.Writer).SetWriteDeadline(deadline)
+
+		ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
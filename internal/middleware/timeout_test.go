@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"url_shortener/internal/jsoncase"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	slowHandler := func(c *gin.Context) {
+		time.Sleep(300 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	}
+
+	router := gin.New()
+	router.Use(jsoncase.Middleware(jsoncase.Camel))
+	router.GET("/default", slowHandler)
+	router.GET("/extended", WriteTimeout(2*time.Second), slowHandler)
+	router.GET("/disabled", WriteTimeout(0), slowHandler)
+	router.GET("/deadline", WriteTimeout(time.Minute), func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+		c.Status(http.StatusNoContent)
+	})
+
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	get := func(path string) (string, error) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("ServerDeadlineCutsSlowResponse", func(t *testing.T) {
+		_, err := get("/default")
+		assert.Error(t, err)
+	})
+
+	t.Run("RouteDeadlineAllowsSlowResponse", func(t *testing.T) {
+		// Also checks the deadline reaches the connection through the
+		// camelCase writer
+		body, err := get("/extended")
+		require.NoError(t, err)
+		assert.Equal(t, "done", body)
+	})
+
+	t.Run("ZeroKeepsServerDeadline", func(t *testing.T) {
+		_, err := get("/disabled")
+		assert.Error(t, err)
+	})
+
+	t.Run("RequestContextEndsAtDeadline", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/deadline")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+}
@@ -0,0 +1,125 @@
+// Package opengraph reads the Open Graph title, description and image a page
+// declares for link previews.
+package opengraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Metadata is what a page declares about itself. Fields it does not declare
+// are empty.
+type Metadata struct {
+	Title       string
+	Description string
+	Image       string
+}
+
+// Fetch downloads url with client and returns its Open Graph metadata. At most
+// maxBytes of the body are read; tags past that point are not seen. Relative
+// image URLs are resolved against the URL the page was finally served from.
+func Fetch(ctx context.Context, client *http.Client, url string, maxBytes int64) (Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("destination answered %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Metadata{}, fmt.Errorf("destination is %s, not HTML", mediaType)
+	}
+
+	meta := Parse(io.LimitReader(resp.Body, maxBytes))
+	if meta.Image != "" {
+		meta.Image = resolve(resp.Request.URL, meta.Image)
+	}
+	if meta == (Metadata{}) {
+		return meta, errors.New("destination declares no Open Graph metadata")
+	}
+	return meta, nil
+}
+
+// Parse reads og:title, og:description and og:image from the head of an HTML
+// document. Parsing stops at <body>, since the tags belong in <head>.
+func Parse(r io.Reader) Metadata {
+	var meta Metadata
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return meta
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			switch token.DataAtom {
+			case atom.Body:
+				return meta
+			case atom.Meta:
+				setProperty(&meta, token.Attr)
+			}
+		}
+	}
+}
+
+// setProperty records an og: meta tag, keeping the first value of each
+// property as the Open Graph protocol prescribes
+func setProperty(meta *Metadata, attrs []html.Attribute) {
+	var property, content string
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "property":
+			property = strings.ToLower(strings.TrimSpace(attr.Val))
+		case "content":
+			content = strings.TrimSpace(attr.Val)
+		}
+	}
+	if content == "" {
+		return
+	}
+
+	var field *string
+	switch property {
+	case "og:title":
+		field = &meta.Title
+	case "og:description":
+		field = &meta.Description
+	case "og:image", "og:image:url", "og:image:secure_url":
+		field = &meta.Image
+	default:
+		return
+	}
+	if *field == "" {
+		*field = content
+	}
+}
+
+// resolve makes image absolute against the page URL, dropping it when it is
+// not an http(s) URL
+func resolve(page *neturl.URL, image string) string {
+	ref, err := neturl.Parse(image)
+	if err != nil {
+		return ""
+	}
+	u := page.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,86 @@
+package opengraph
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("ReadsOpenGraphTags", func(t *testing.T) {
+		meta := Parse(strings.NewReader(`<!doctype html><html><head>
+			<title>Ignored</title>
+			<meta property="og:title" content=" Carnaval 2025 ">
+			<meta property="og:description" content="Programação completa">
+			<meta property="og:image" content="https://example.com/banner.png" />
+			<meta property="og:image" content="https://example.com/second.png" />
+		</head><body></body></html>`))
+
+		assert.Equal(t, Metadata{
+			Title:       "Carnaval 2025",
+			Description: "Programação completa",
+			Image:       "https://example.com/banner.png",
+		}, meta)
+	})
+
+	t.Run("StopsAtBody", func(t *testing.T) {
+		meta := Parse(strings.NewReader(`<html><head><meta property="og:title" content="Head"></head>
+			<body><meta property="og:description" content="Body"></body></html>`))
+
+		assert.Equal(t, Metadata{Title: "Head"}, meta)
+	})
+
+	t.Run("IgnoresOtherAndEmptyTags", func(t *testing.T) {
+		meta := Parse(strings.NewReader(`<head><meta name="description" content="Plain">
+			<meta property="og:title" content=""><meta property="twitter:title" content="Tweet"></head>`))
+
+		assert.Equal(t, Metadata{}, meta)
+	})
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<head><meta property="og:title" content="Page"><meta property="og:image" content="/img/cover.png"></head>`))
+		case "/moved":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<head>` + strings.Repeat(" ", 1024) + `<meta property="og:title" content="Late"></head>`))
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte(`<meta property="og:title" content="Not HTML">`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := server.Client()
+
+	meta, err := Fetch(ctx, client, server.URL+"/page", 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, Metadata{Title: "Page", Image: server.URL + "/img/cover.png"}, meta)
+
+	// Relative images resolve against the page the redirect ended on
+	meta, err = Fetch(ctx, client, server.URL+"/moved", 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/img/cover.png", meta.Image)
+
+	_, err = Fetch(ctx, client, server.URL+"/large", 512)
+	assert.Error(t, err)
+
+	_, err = Fetch(ctx, client, server.URL+"/pdf", 1<<20)
+	assert.Error(t, err)
+
+	_, err = Fetch(ctx, client, server.URL+"/missing", 1<<20)
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqr "github.com/makiuchi-d/gozxing/qrcode"
+	_ "golang.org/x/image/webp"
+)
+
+// Decode reads a QR code image (PNG, JPEG or WebP) and returns the text it encodes
+func Decode(imgData []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return decodeImage(img)
+}
+
+// decodeImage scans a QR code image and returns the text it encodes
+func decodeImage(img image.Image) (string, error) {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare image for scanning: %w", err)
+	}
+
+	result, err := zxingqr.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to scan QR code: %w", err)
+	}
+
+	return result.GetText(), nil
+}
@@ -0,0 +1,77 @@
+package qrcode
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	qrc "github.com/skip2/go-qrcode"
+)
+
+// recoveryLevel is an error correction level and the share of the symbol it
+// can restore when damaged or covered
+type recoveryLevel struct {
+	name     string
+	level    qrc.RecoveryLevel
+	capacity float64
+}
+
+var (
+	levelLow     = recoveryLevel{"low", qrc.Low, 0.07}
+	levelMedium  = recoveryLevel{"medium", qrc.Medium, 0.15}
+	levelHigh    = recoveryLevel{"high", qrc.High, 0.25}
+	levelHighest = recoveryLevel{"highest", qrc.Highest, 0.30}
+)
+
+// errorCorrection resolves the requested level. Without one, codes with a logo
+// use Highest so the logo can cover as much as possible, and others use High.
+func errorCorrection(opts Options) recoveryLevel {
+	switch strings.ToLower(opts.ErrorCorrection) {
+	case "low", "l":
+		return levelLow
+	case "medium", "m":
+		return levelMedium
+	case "high", "q":
+		return levelHigh
+	case "highest", "h":
+		return levelHighest
+	case "":
+		if opts.IncludeLogo {
+			return levelHighest
+		}
+		return levelHigh
+	default:
+		return levelHigh
+	}
+}
+
+// logoCoverage returns the share of the symbol hidden by a logo safe zone.
+// modules is the bitmap width including a quiet zone of quietZone modules,
+// drawn qrWidth pixels wide.
+func logoCoverage(modules, quietZone, qrWidth int, logo image.Point) float64 {
+	modulePx := float64(qrWidth) / float64(modules)
+	symbol := float64(modules - 2*quietZone)
+
+	return (float64(logo.X) / modulePx) * (float64(logo.Y) / modulePx) / (symbol * symbol)
+}
+
+// checkLogoCoverage rejects a logo whose safe zone hides more of the symbol than
+// the error correction level can restore
+func checkLogoCoverage(level recoveryLevel, covered float64) error {
+	if covered > level.capacity {
+		return fmt.Errorf("logo covers %.1f%% of the QR code but error_correction %s only recovers %.0f%%; use a higher level or omit error_correction",
+			covered*100, level.name, level.capacity*100)
+	}
+	return nil
+}
+
+// checkScannable rejects a code with a logo that no longer decodes to data.
+// Hidden modules damage whole codewords, so a logo within the level's nominal
+// capacity can still break codes at the lower levels.
+func checkScannable(img image.Image, data string, level recoveryLevel, covered float64) error {
+	if decoded, err := decodeImage(img); err != nil || decoded != data {
+		return fmt.Errorf("logo covering %.1f%% of the QR code leaves it unreadable at error_correction %s; use a higher level or omit error_correction",
+			covered*100, level.name)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package qrcode
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// DefaultModuleRadius is the corner radius of rounded modules, as a fraction
+// of the module size
+const DefaultModuleRadius = 0.3
+
+// validateModuleShape checks the module shape and the rounded corner radius
+func validateModuleShape(opts Options) error {
+	switch opts.ModuleShape {
+	case "", "square", "circle", "rounded":
+	default:
+		return fmt.Errorf("module_shape must be square, circle or rounded")
+	}
+	if opts.ModuleRadius < 0 || opts.ModuleRadius > 0.5 {
+		return fmt.Errorf("module_radius must be between 0 and 0.5")
+	}
+	return nil
+}
+
+// moduleRadius returns the corner radius, as a fraction of the module size,
+// that draws the given shape
+func moduleRadius(opts Options) float64 {
+	switch opts.ModuleShape {
+	case "circle":
+		return 0.5
+	case "rounded":
+		return opts.ModuleRadius
+	default:
+		return 0
+	}
+}
+
+// MaxBorderWidth is the widest quiet zone, in modules, a code can be drawn with
+const MaxBorderWidth = 10
+
+// validateBorderWidth checks the quiet zone width
+func validateBorderWidth(opts Options) error {
+	if opts.BorderWidth < 0 || opts.BorderWidth > MaxBorderWidth {
+		return fmt.Errorf("border_width must be between 0 and %d modules, got %d", MaxBorderWidth, opts.BorderWidth)
+	}
+	return nil
+}
+
+// withQuietZone surrounds a borderless bitmap with width light modules
+func withQuietZone(bitmap [][]bool, width int) [][]bool {
+	modules := len(bitmap) + 2*width
+	padded := make([][]bool, modules)
+	for y := range padded {
+		padded[y] = make([]bool, modules)
+		if y >= width && y < modules-width {
+			copy(padded[y][width:], bitmap[y-width])
+		}
+	}
+	return padded
+}
+
+// finderSize is the width in modules of the three position detection patterns
+const finderSize = 7
+
+// Palette indexes of the colors a code is drawn in
+const (
+	paletteBackground = iota
+	paletteForeground
+	paletteEye
+	paletteEyeBall
+)
+
+// moduleColors are the colors a code is drawn in. The eyes are the position
+// detection patterns: eye is their outer ring, eyeBall their 3x3 center.
+type moduleColors struct {
+	fg, bg, eye, eyeBall color.Color
+}
+
+// renderModules draws a QR bitmap, surrounded by a quiet zone of quietZone
+// modules, as a size x size image. Each dark module is a rectangle whose corners
+// are rounded by radius times the module size, except in the position detection
+// patterns, which stay square so scanners keep locating the code. Pixels map to
+// modules exactly as in skip2's Image, so a zero radius reproduces its output.
+func renderModules(bitmap [][]bool, quietZone, size int, radius float64, colors moduleColors) *image.Paletted {
+	modules := len(bitmap)
+	symbol := modules - 2*quietZone
+	if size < modules {
+		size = modules
+	}
+
+	palette := color.Palette{colors.bg, colors.fg, colors.eye, colors.eyeBall}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+
+	// edges[i] is the first pixel of module i; module i spans [edges[i], edges[i+1])
+	edges := make([]int, modules+1)
+	for i := range edges {
+		edges[i] = int(math.Ceil(float64(i) * float64(size) / float64(modules)))
+	}
+
+	for my, row := range bitmap {
+		for mx, dark := range row {
+			if !dark {
+				continue
+			}
+			r, index := radius, uint8(paletteForeground)
+			if x, y := mx-quietZone, my-quietZone; inFinder(x, y, symbol) {
+				r, index = 0, paletteEye
+				if inEyeBall(x, y, symbol) {
+					index = paletteEyeBall
+				}
+			}
+			fillModule(img, image.Rect(edges[mx], edges[my], edges[mx+1], edges[my+1]), r, index)
+		}
+	}
+
+	return img
+}
+
+// inFinder reports whether symbol coordinates (x, y) fall in one of the
+// position detection patterns of a symbol modules wide
+func inFinder(x, y, symbol int) bool {
+	near := func(v int) bool { return v >= 0 && v < finderSize }
+	far := func(v int) bool { return v >= symbol-finderSize && v < symbol }
+	return (near(x) && near(y)) || (far(x) && near(y)) || (near(x) && far(y))
+}
+
+// inEyeBall reports whether symbol coordinates (x, y) fall in the 3x3 center
+// of a position detection pattern
+func inEyeBall(x, y, symbol int) bool {
+	near := func(v int) bool { return v >= 2 && v < finderSize-2 }
+	far := func(v int) bool { return v >= symbol-finderSize+2 && v < symbol-2 }
+	return (near(x) && near(y)) || (far(x) && near(y)) || (near(x) && far(y))
+}
+
+// fillModule paints the palette color index over r, leaving out the pixels
+// outside its rounded corners
+func fillModule(img *image.Paletted, r image.Rectangle, radius float64, index uint8) {
+	w, h := float64(r.Dx()), float64(r.Dy())
+	rad := radius * math.Min(w, h)
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		py := float64(y-r.Min.Y) + 0.5
+		for x := r.Min.X; x < r.Max.X; x++ {
+			px := float64(x-r.Min.X) + 0.5
+
+			// Distance from the nearest point of the rectangle shrunk by the radius
+			dx := px - math.Max(rad, math.Min(px, w-rad))
+			dy := py - math.Max(rad, math.Min(py, h-rad))
+			if dx*dx+dy*dy <= rad*rad {
+				img.Pix[img.PixOffset(x, y)] = index
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package qrcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Content types the QR endpoint can format payloads for
+const (
+	ContentURL   = "url"
+	ContentText  = "text"
+	ContentWiFi  = "wifi"
+	ContentVCard = "vcard"
+	ContentGeo   = "geo"
+)
+
+// WiFi describes a network for a WIFI: payload that phones join when scanned
+type WiFi struct {
+	SSID       string `json:"ssid" example:"Prefeitura-Eventos" description:"Network name (required)"`
+	Password   string `json:"password,omitempty" example:"s3cret" description:"Network password (omit for open networks)"`
+	Encryption string `json:"encryption,omitempty" example:"WPA" description:"WPA, WEP or nopass (default: WPA with a password, otherwise nopass)"`
+	Hidden     bool   `json:"hidden,omitempty" example:"false" description:"Whether the network does not broadcast its SSID"`
+}
+
+// VCard describes a contact for a vCard 3.0 payload
+type VCard struct {
+	FirstName    string `json:"first_name,omitempty" example:"Maria" description:"Given name"`
+	LastName     string `json:"last_name,omitempty" example:"Silva" description:"Family name"`
+	Organization string `json:"organization,omitempty" example:"Prefeitura do Rio" description:"Organization"`
+	Title        string `json:"title,omitempty" example:"Coordenadora" description:"Job title"`
+	Phone        string `json:"phone,omitempty" example:"+55 21 1234-5678" description:"Phone number"`
+	Email        string `json:"email,omitempty" example:"maria@rio.rj.gov.br" description:"Email address"`
+	URL          string `json:"url,omitempty" example:"https://prefeitura.rio" description:"Website"`
+}
+
+// Geo describes a location for a geo: URI payload
+type Geo struct {
+	Latitude  *float64 `json:"latitude" example:"-22.9068" description:"Latitude in degrees (required, -90 to 90)"`
+	Longitude *float64 `json:"longitude" example:"-43.1729" description:"Longitude in degrees (required, -180 to 180)"`
+}
+
+// Payload holds the structured fields the QR payload is built from; only the
+// field matching the content type is used
+type Payload struct {
+	ContentType string
+	Data        string
+	WiFi        *WiFi
+	VCard       *VCard
+	Geo         *Geo
+}
+
+// BuildPayload formats the string to encode for the payload's content type.
+// url and text (and an empty content type) encode Data as is.
+func BuildPayload(p Payload) (string, error) {
+	switch strings.ToLower(p.ContentType) {
+	case "", ContentURL, ContentText:
+		return p.Data, nil
+	case ContentWiFi:
+		if p.WiFi == nil {
+			return "", fmt.Errorf("wifi is required for content_type wifi")
+		}
+		return wifiPayload(*p.WiFi)
+	case ContentVCard:
+		if p.VCard == nil {
+			return "", fmt.Errorf("vcard is required for content_type vcard")
+		}
+		return vcardPayload(*p.VCard)
+	case ContentGeo:
+		if p.Geo == nil {
+			return "", fmt.Errorf("geo is required for content_type geo")
+		}
+		return geoPayload(*p.Geo)
+	default:
+		return "", fmt.Errorf("invalid content_type: %s (must be url, text, wifi, vcard or geo)", p.ContentType)
+	}
+}
+
+// wifiPayload formats the de facto WIFI: scheme understood by iOS and Android,
+// e.g. WIFI:T:WPA;S:ssid;P:pass;;
+func wifiPayload(w WiFi) (string, error) {
+	if w.SSID == "" {
+		return "", fmt.Errorf("wifi.ssid is required")
+	}
+
+	encryption := strings.ToUpper(w.Encryption)
+	switch encryption {
+	case "":
+		encryption = "nopass"
+		if w.Password != "" {
+			encryption = "WPA"
+		}
+	case "NOPASS":
+		encryption = "nopass"
+	case "WPA", "WEP":
+	default:
+		return "", fmt.Errorf("invalid wifi.encryption: %s (must be WPA, WEP or nopass)", w.Encryption)
+	}
+	if encryption != "nopass" && w.Password == "" {
+		return "", fmt.Errorf("wifi.password is required for %s networks", encryption)
+	}
+
+	var b strings.Builder
+	b.WriteString("WIFI:T:" + encryption + ";S:" + escapeWiFi(w.SSID) + ";")
+	if encryption != "nopass" {
+		b.WriteString("P:" + escapeWiFi(w.Password) + ";")
+	}
+	if w.Hidden {
+		b.WriteString("H:true;")
+	}
+	b.WriteString(";")
+	return b.String(), nil
+}
+
+// vcardPayload formats a minimal vCard 3.0 with the fields that are set
+func vcardPayload(v VCard) (string, error) {
+	fullName := strings.TrimSpace(v.FirstName + " " + v.LastName)
+	if fullName == "" && v.Organization == "" {
+		return "", fmt.Errorf("vcard needs a first_name, last_name or organization")
+	}
+	if fullName == "" {
+		fullName = v.Organization
+	}
+
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"N:" + escapeVCard(v.LastName) + ";" + escapeVCard(v.FirstName) + ";;;",
+		"FN:" + escapeVCard(fullName),
+	}
+	for _, field := range []struct{ name, value string }{
+		{"ORG", v.Organization},
+		{"TITLE", v.Title},
+		{"TEL", v.Phone},
+		{"EMAIL", v.Email},
+		{"URL", v.URL},
+	} {
+		if field.value != "" {
+			lines = append(lines, field.name+":"+escapeVCard(field.value))
+		}
+	}
+	lines = append(lines, "END:VCARD")
+
+	return strings.Join(lines, "\r\n"), nil
+}
+
+// geoPayload formats an RFC 5870 geo: URI
+func geoPayload(g Geo) (string, error) {
+	if g.Latitude == nil || g.Longitude == nil {
+		return "", fmt.Errorf("geo.latitude and geo.longitude are required")
+	}
+	if *g.Latitude < -90 || *g.Latitude > 90 {
+		return "", fmt.Errorf("geo.latitude must be between -90 and 90")
+	}
+	if *g.Longitude < -180 || *g.Longitude > 180 {
+		return "", fmt.Errorf("geo.longitude must be between -180 and 180")
+	}
+
+	return "geo:" + strconv.FormatFloat(*g.Latitude, 'f', -1, 64) + "," + strconv.FormatFloat(*g.Longitude, 'f', -1, 64), nil
+}
+
+// escapeWiFi backslash-escapes the characters that delimit WIFI: fields
+func escapeWiFi(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`).Replace(s)
+}
+
+// escapeVCard escapes vCard text values per RFC 2426
+func escapeVCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
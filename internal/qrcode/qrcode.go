@@ -2,11 +2,19 @@ package qrcode
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"os"
+	"sync"
+
+	"url_shortener/internal/assets"
 
 	"github.com/yeqown/go-qrcode/v2"
 	"github.com/yeqown/go-qrcode/writer/standard"
@@ -16,23 +24,41 @@ import (
 type Options struct {
 	Data                 string
 	Size                 int
+	Scale                int
 	ErrorCorrection      string
 	ForegroundColor      string
 	BackgroundColor      string
+	EyeColor             string
+	EyeBallColor         string
 	TransparentBackground bool
 	IncludeLogo          bool
 	LogoColor            string
 	LogoShape            string
+	LogoName             string
+	LogoPath             string
+	Logos                map[string]string
 	ModuleShape          string
+	ModuleRadius         float64
 	BorderWidth          int
 	Format               string
 }
 
+// Image size limits. Size bounds the requested pixel size; Scale, used when
+// Size is zero, bounds the pixels per module, and the image it produces must
+// still fit MaxSize.
+const (
+	MinSize  = 64
+	MaxSize  = 2048
+	MaxScale = 32
+)
+
+// ErrLogoNotFound is returned when the logo file does not exist
+var ErrLogoNotFound = errors.New("logo file not found")
+
 // DefaultOptions returns default QR code generation options
 func DefaultOptions() Options {
 	return Options{
 		Size:                 256,
-		ErrorCorrection:      "high",
 		ForegroundColor:      "#000000",
 		BackgroundColor:      "#FFFFFF",
 		TransparentBackground: false,
@@ -40,11 +66,27 @@ func DefaultOptions() Options {
 		LogoColor:            "",
 		LogoShape:            "circle",
 		ModuleShape:          "square",
+		ModuleRadius:         DefaultModuleRadius,
 		BorderWidth:          2,
 		Format:               "png",
 	}
 }
 
+// CacheKey identifies the image Generate produces for opts, so identical
+// requests can share a cached result. Named logos are resolved to their file,
+// and the format prefixes the key so encodings of the same code never collide.
+func CacheKey(opts Options) string {
+	resolved := opts
+	resolved.Logos = nil
+	if path, err := logoPath(opts); err == nil {
+		resolved.LogoName = path
+	}
+
+	data, _ := json.Marshal(resolved)
+	sum := sha256.Sum256(data)
+	return opts.Format + ":" + hex.EncodeToString(sum[:])
+}
+
 // Generate creates a QR code with the given options and returns the image bytes
 func Generate(opts Options) ([]byte, error) {
 	// Use new implementation with skip2/go-qrcode
@@ -82,14 +124,6 @@ func GenerateOld(opts Options) ([]byte, error) {
 		return nil, fmt.Errorf("failed to create QR code: %w", err)
 	}
 
-	// Create a temporary file for output
-	tmpFile, err := os.CreateTemp("", "qr-*.png")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
-
 	// Prepare writer options
 	writerOpts := []standard.ImageOption{
 		standard.WithBgColorRGBHex(opts.BackgroundColor),
@@ -102,8 +136,7 @@ func GenerateOld(opts Options) ([]byte, error) {
 	case "circle":
 		writerOpts = append(writerOpts, standard.WithCircleShape())
 	case "rounded":
-		// Note: rounded rectangles might not be supported in all versions
-		// Fallback to square if not available
+		// The legacy writer has no rounded shape; GenerateWithSkip draws it
 		writerOpts = append(writerOpts, standard.WithCircleShape())
 	}
 
@@ -111,64 +144,50 @@ func GenerateOld(opts Options) ([]byte, error) {
 	qrWidth := calculateQRWidth(opts.Size)
 	writerOpts = append(writerOpts, standard.WithQRWidth(uint8(qrWidth)))
 
-	// Force PNG output format if transparency is requested (JPEG doesn't support transparency)
-	if opts.TransparentBackground {
-		writerOpts = append(writerOpts, standard.WithBuiltinImageEncoder(standard.PNG_FORMAT))
-	}
+	// The writer encodes JPEG unless told otherwise
+	writerOpts = append(writerOpts, standard.WithBuiltinImageEncoder(standard.PNG_FORMAT))
 
 	// Add logo if requested
 	if opts.IncludeLogo {
-		logoPath := "internal/assets/logo.png"
-
-		// Check if logo exists
-		if _, err := os.Stat(logoPath); os.IsNotExist(err) {
-			return nil, fmt.Errorf("logo file not found at %s", logoPath)
+		path, err := logoPath(opts)
+		if err != nil {
+			return nil, err
+		}
+		logo, err := loadLogo(path)
+		if err != nil {
+			return nil, err
 		}
 
 		// Resize logo - 1/4 of QR code for good visibility
 		// The library's safe zone will handle creating blank space in QR data
-		logoSize := opts.Size / 4
-		resizedLogoPath, err := resizeLogo(logoPath, logoSize)
-		if err != nil {
-			return nil, fmt.Errorf("failed to resize logo: %w", err)
-		}
-		defer os.Remove(resizedLogoPath)
+		logo = resizeLogo(logo, opts.Size/4)
 
 		// If logo color is specified, recolor the logo
 		if opts.LogoColor != "" {
-			recoloredLogoPath, err := recolorLogo(resizedLogoPath, opts.LogoColor)
+			logo, err = recolorLogo(logo, opts.LogoColor)
 			if err != nil {
 				return nil, fmt.Errorf("failed to recolor logo: %w", err)
 			}
-			defer os.Remove(recoloredLogoPath)
-			resizedLogoPath = recoloredLogoPath
 		}
 
-		// Load logo and let the library handle the safe zone
+		// Let the library handle the safe zone
 		// WithLogoSafeZone() tells the QR encoder to avoid placing data in the logo area
 		writerOpts = append(writerOpts,
-			standard.WithLogoImageFilePNG(resizedLogoPath),
+			standard.WithLogoImage(logo),
 			standard.WithLogoSizeMultiplier(3), // Make logo area 3x larger for proper safe zone
 			standard.WithBgTransparent(),       // Use transparent background for logo area
 		)
 	}
 
-	// Create writer
-	w, err := standard.New(tmpFile.Name(), writerOpts...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create writer: %w", err)
-	}
+	// Render into memory
+	var buf bytes.Buffer
+	w := standard.NewWithWriter(nopWriteCloser{&buf}, writerOpts...)
 
 	// Save QR code
 	if err := qrc.Save(w); err != nil {
 		return nil, fmt.Errorf("failed to save QR code: %w", err)
 	}
-
-	// Read the generated file
-	imgData, err := os.ReadFile(tmpFile.Name())
-	if err != nil {
-		return nil, fmt.Errorf("failed to read generated QR code: %w", err)
-	}
+	imgData := buf.Bytes()
 
 	// Handle transparent background if requested
 	if opts.TransparentBackground {
@@ -199,25 +218,51 @@ func calculateQRWidth(size int) int {
 	}
 }
 
-// addLogoSafeZone adds a border around the logo with the specified background color
-func addLogoSafeZone(logoPath, bgColorHex string) (string, error) {
-	// Parse background color
-	bgColor, err := parseHexColor(bgColorHex)
-	if err != nil {
-		return "", err
+// nopWriteCloser lets the yeqown writer render into a buffer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// logoCache holds decoded logos by path so they are read from disk only once
+var logoCache sync.Map
+
+// loadLogo returns the decoded PNG logo at path, or the logo built into the
+// binary when path is empty
+func loadLogo(path string) (image.Image, error) {
+	if logo, ok := logoCache.Load(path); ok {
+		return logo.(image.Image), nil
+	}
+
+	var source io.Reader = bytes.NewReader(assets.Logo)
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w at %s", ErrLogoNotFound, path)
+			}
+			return nil, fmt.Errorf("failed to open logo: %w", err)
+		}
+		defer file.Close()
+		source = file
 	}
 
-	// Open the logo
-	file, err := os.Open(logoPath)
+	logo, err := png.Decode(source)
 	if err != nil {
-		return "", fmt.Errorf("failed to open logo: %w", err)
+		return nil, fmt.Errorf("failed to decode logo: %w", err)
 	}
-	defer file.Close()
 
-	// Decode the logo
-	logo, err := png.Decode(file)
+	logoCache.Store(path, logo)
+	return logo, nil
+}
+
+// addLogoSafeZone adds a border around the logo with the specified background color
+func addLogoSafeZone(logo image.Image, bgColorHex string) (image.Image, error) {
+	// Parse background color
+	bgColor, err := parseHexColor(bgColorHex)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode logo: %w", err)
+		return nil, err
 	}
 
 	bounds := logo.Bounds()
@@ -242,40 +287,15 @@ func addLogoSafeZone(logoPath, bgColorHex string) (string, error) {
 	// Draw original logo in center
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			withSafeZone.Set(x+padding, y+padding, logo.At(x, y))
+			withSafeZone.Set(x+padding, y+padding, logo.At(bounds.Min.X+x, bounds.Min.Y+y))
 		}
 	}
 
-	// Save to temp file
-	tmpFile, err := os.CreateTemp("", "logo-safezone-*.png")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer tmpFile.Close()
-
-	if err := png.Encode(tmpFile, withSafeZone); err != nil {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to encode logo with safe zone: %w", err)
-	}
-
-	return tmpFile.Name(), nil
+	return withSafeZone, nil
 }
 
 // resizeLogo resizes a logo to fit within maxSize while maintaining aspect ratio
-func resizeLogo(logoPath string, maxSize int) (string, error) {
-	// Open the original logo
-	file, err := os.Open(logoPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open logo: %w", err)
-	}
-	defer file.Close()
-
-	// Decode the image
-	img, err := png.Decode(file)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode logo: %w", err)
-	}
-
+func resizeLogo(img image.Image, maxSize int) image.Image {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
@@ -306,44 +326,19 @@ func resizeLogo(logoPath string, maxSize int) (string, error) {
 		for x := 0; x < newWidth; x++ {
 			srcX := (x * width) / newWidth
 			srcY := (y * height) / newHeight
-			resized.Set(x, y, img.At(srcX, srcY))
+			resized.Set(x, y, img.At(bounds.Min.X+srcX, bounds.Min.Y+srcY))
 		}
 	}
 
-	// Save resized logo to temp file
-	tmpFile, err := os.CreateTemp("", "logo-resized-*.png")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer tmpFile.Close()
-
-	if err := png.Encode(tmpFile, resized); err != nil {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to encode resized logo: %w", err)
-	}
-
-	return tmpFile.Name(), nil
+	return resized
 }
 
-// recolorLogo recolors a PNG logo to the specified color while preserving transparency
-func recolorLogo(logoPath, hexColor string) (string, error) {
+// recolorLogo recolors a logo to the specified color while preserving transparency
+func recolorLogo(img image.Image, hexColor string) (image.Image, error) {
 	// Parse the target color
 	targetColor, err := parseHexColor(hexColor)
 	if err != nil {
-		return "", err
-	}
-
-	// Open the original logo
-	file, err := os.Open(logoPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open logo: %w", err)
-	}
-	defer file.Close()
-
-	// Decode the image
-	img, err := png.Decode(file)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode logo: %w", err)
+		return nil, err
 	}
 
 	// Create a new RGBA image
@@ -373,19 +368,7 @@ func recolorLogo(logoPath, hexColor string) (string, error) {
 		}
 	}
 
-	// Save recolored logo to temp file
-	tmpFile, err := os.CreateTemp("", "logo-recolored-*.png")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer tmpFile.Close()
-
-	if err := png.Encode(tmpFile, recolored); err != nil {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to encode recolored logo: %w", err)
-	}
-
-	return tmpFile.Name(), nil
+	return recolored, nil
 }
 
 // makeBackgroundTransparent makes the background of a QR code transparent
@@ -2,12 +2,15 @@ package qrcode
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"image/jpeg"
 	"image/png"
-	"os"
+	"log"
+	"strings"
 
 	qrc "github.com/skip2/go-qrcode"
 )
@@ -19,9 +22,13 @@ func GenerateWithSkip(opts Options) ([]byte, error) {
 		return nil, fmt.Errorf("data is required")
 	}
 
-	// Validate size
-	if opts.Size < 64 || opts.Size > 2048 {
-		return nil, fmt.Errorf("size must be between 64 and 2048")
+	// Validate size; without one the image is sized by scale
+	if opts.Size == 0 && opts.Scale != 0 {
+		if opts.Scale < 1 || opts.Scale > MaxScale {
+			return nil, fmt.Errorf("scale must be between 1 and %d pixels per module, got %d", MaxScale, opts.Scale)
+		}
+	} else if opts.Size < MinSize || opts.Size > MaxSize {
+		return nil, fmt.Errorf("size must be between %d and %d pixels, got %d", MinSize, MaxSize, opts.Size)
 	}
 
 	// Validate color formats
@@ -36,30 +43,43 @@ func GenerateWithSkip(opts Options) ([]byte, error) {
 			return nil, fmt.Errorf("invalid logo_color: %w", err)
 		}
 	}
+	if opts.EyeColor != "" {
+		if err := validateHexColor(opts.EyeColor); err != nil {
+			return nil, fmt.Errorf("invalid eye_color: %w", err)
+		}
+	}
+	if opts.EyeBallColor != "" {
+		if err := validateHexColor(opts.EyeBallColor); err != nil {
+			return nil, fmt.Errorf("invalid eye_ball_color: %w", err)
+		}
+	}
 
-	// Map error correction level
-	var ecLevel qrc.RecoveryLevel
+	if _, err := logoPath(opts); err != nil {
+		return nil, err
+	}
+	if err := validateFormat(opts); err != nil {
+		return nil, err
+	}
+	if err := validateModuleShape(opts); err != nil {
+		return nil, err
+	}
+	if err := validateBorderWidth(opts); err != nil {
+		return nil, err
+	}
 
-	// Force Highest error correction when logo is enabled
+	// A missing logo file is a deployment problem, so the QR code is still
+	// served, just as if no logo had been requested
 	if opts.IncludeLogo {
-		ecLevel = qrc.Highest // 30% recovery - required for logo overlay
-	} else {
-		switch opts.ErrorCorrection {
-		case "low", "L":
-			ecLevel = qrc.Low
-		case "medium", "M":
-			ecLevel = qrc.Medium
-		case "high", "Q":
-			ecLevel = qrc.High
-		case "highest", "H":
-			ecLevel = qrc.Highest
-		default:
-			ecLevel = qrc.High
+		path, _ := logoPath(opts)
+		if _, err := loadLogo(path); errors.Is(err, ErrLogoNotFound) {
+			log.Printf("Warning: %v, generating QR code without logo", err)
+			opts.IncludeLogo = false
 		}
 	}
 
 	// Generate QR code
-	q, err := qrc.New(opts.Data, ecLevel)
+	level := errorCorrection(opts)
+	q, err := qrc.New(opts.Data, level.level)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create QR code: %w", err)
 	}
@@ -68,18 +88,51 @@ func GenerateWithSkip(opts Options) ([]byte, error) {
 	fgColor, _ := parseHexColor(opts.ForegroundColor)
 	bgColor, _ := parseHexColor(opts.BackgroundColor)
 
-	q.ForegroundColor = fgColor
-	q.BackgroundColor = bgColor
-
-	// Generate QR code image
-	qrImg := q.Image(opts.Size)
+	// Eyes default to the foreground, and eye balls to the eye color
+	eyeColor := fgColor
+	if opts.EyeColor != "" {
+		eyeColor, _ = parseHexColor(opts.EyeColor)
+	}
+	eyeBallColor := eyeColor
+	if opts.EyeBallColor != "" {
+		eyeBallColor, _ = parseHexColor(opts.EyeBallColor)
+	}
 
-	// If logo is requested, composite it
+	// Draw the modules in the requested shape, with a quiet zone of
+	// border_width modules in place of skip2's fixed one
+	q.DisableBorder = true
+	bitmap := withQuietZone(q.Bitmap(), opts.BorderWidth)
+	size, err := imageSize(opts, len(bitmap))
+	if err != nil {
+		return nil, err
+	}
+	var qrImg image.Image = renderModules(bitmap, opts.BorderWidth, size, moduleRadius(opts), moduleColors{
+		fg:      fgColor,
+		bg:      bgColor,
+		eye:     eyeColor,
+		eyeBall: eyeBallColor,
+	})
+
+	// If logo is requested, composite it where the error correction can
+	// restore the modules it hides
 	if opts.IncludeLogo {
-		qrImg, err = compositeLogoOnQR(qrImg, opts)
+		logo, err := prepareLogo(opts, qrImg.Bounds().Dx())
 		if err != nil {
 			return nil, fmt.Errorf("failed to composite logo: %w", err)
 		}
+		covered := logoCoverage(len(bitmap), opts.BorderWidth, qrImg.Bounds().Dx(), logo.Bounds().Size())
+		if err := checkLogoCoverage(level, covered); err != nil {
+			return nil, err
+		}
+		qrImg = overlayLogo(qrImg, logo)
+
+		// Highest is the default and what logos are designed for; lowered
+		// levels are verified against a scan
+		if level != levelHighest {
+			if err := checkScannable(qrImg, opts.Data, level, covered); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// Handle transparent background if requested
@@ -87,40 +140,78 @@ func GenerateWithSkip(opts Options) ([]byte, error) {
 		qrImg = makeImageTransparent(qrImg, bgColor)
 	}
 
-	// Encode to PNG
+	// Encode in the requested format
 	var buf bytes.Buffer
-	if err := png.Encode(&buf, qrImg); err != nil {
+	switch opts.Format {
+	case "webp":
+		err = encodeWebP(&buf, qrImg)
+	case "jpeg":
+		err = jpeg.Encode(&buf, qrImg, &jpeg.Options{Quality: 95})
+	default:
+		err = png.Encode(&buf, qrImg)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to encode QR code: %w", err)
 	}
 
 	return buf.Bytes(), nil
 }
 
-// compositeLogoOnQR overlays a logo with safe zone onto the QR code
-func compositeLogoOnQR(qrImg image.Image, opts Options) (image.Image, error) {
-	logoPath := "internal/assets/logo.png"
+// imageSize returns the pixel size of a code modules wide, quiet zone
+// included. A scale gives every module the same whole number of pixels, so the
+// code stays crisp when printed or upscaled.
+func imageSize(opts Options, modules int) (int, error) {
+	if opts.Size != 0 || opts.Scale == 0 {
+		return opts.Size, nil
+	}
+	size := modules * opts.Scale
+	if size > MaxSize {
+		return 0, fmt.Errorf("scale %d makes a %d pixel image for this data, above the %d pixel maximum; use a smaller scale", opts.Scale, size, MaxSize)
+	}
+	return size, nil
+}
+
+// validateFormat checks the output format and the options it cannot honor
+func validateFormat(opts Options) error {
+	switch opts.Format {
+	case "", "png", "webp":
+		return nil
+	case "jpeg":
+		if opts.TransparentBackground {
+			return fmt.Errorf("transparent_background is not supported with format jpeg, use png or webp")
+		}
+		return nil
+	default:
+		return fmt.Errorf("format must be png, jpeg or webp")
+	}
+}
 
-	// Check if logo exists
-	if _, err := os.Stat(logoPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("logo file not found at %s", logoPath)
+// ContentType returns the MIME type of images generated in format
+func ContentType(format string) string {
+	switch format {
+	case "webp":
+		return "image/webp"
+	case "jpeg":
+		return "image/jpeg"
+	default:
+		return "image/png"
 	}
+}
 
-	// Load logo
-	logoFile, err := os.Open(logoPath)
+// prepareLogo loads the logo for opts and returns it sized for a QR image
+// qrWidth pixels wide, centered in its safe zone
+func prepareLogo(opts Options, qrWidth int) (image.Image, error) {
+	logoPath, err := logoPath(opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open logo: %w", err)
+		return nil, err
 	}
-	defer logoFile.Close()
 
-	logo, err := png.Decode(logoFile)
+	logo, err := loadLogo(logoPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode logo: %w", err)
+		return nil, err
 	}
 
 	// Calculate logo size - matching reference (20-25% of QR width is standard)
-	qrBounds := qrImg.Bounds()
-	qrWidth := qrBounds.Dx()
-
 	// Logo should be about 18% of QR code width
 	// With 30% padding, total safe zone = 18% * (1 + 0.30*2) = 18% * 1.6 = 28.8% of QR width
 	// Area coverage = (28.8%)^2 ≈ 8.3% which is well under 30% error correction limit
@@ -161,21 +252,38 @@ func compositeLogoOnQR(qrImg image.Image, opts Options) (image.Image, error) {
 		Max: logoOffset.Add(logoBounds.Size()),
 	}, logo, logoBounds.Min, draw.Over)
 
-	// Composite onto QR code (centered)
+	return logoWithSafeZone, nil
+}
+
+// overlayLogo composites a prepared logo onto the center of the QR code
+func overlayLogo(qrImg, logo image.Image) image.Image {
+	qrBounds := qrImg.Bounds()
 	result := image.NewRGBA(qrBounds)
 	draw.Draw(result, qrBounds, qrImg, qrBounds.Min, draw.Src)
 
 	// Calculate center position
-	logoCenterX := (qrWidth - safeZoneWidth) / 2
-	logoCenterY := (qrWidth - safeZoneHeight) / 2
-
-	logoPos := image.Pt(logoCenterX, logoCenterY)
+	size := logo.Bounds().Size()
+	logoPos := image.Pt((qrBounds.Dx()-size.X)/2, (qrBounds.Dy()-size.Y)/2)
 	draw.Draw(result, image.Rectangle{
 		Min: logoPos,
-		Max: logoPos.Add(image.Pt(safeZoneWidth, safeZoneHeight)),
-	}, logoWithSafeZone, image.Point{}, draw.Over)
+		Max: logoPos.Add(size),
+	}, logo, logo.Bounds().Min, draw.Over)
 
-	return result, nil
+	return result
+}
+
+// logoPath resolves the logo file for opts: the registered asset for LogoName,
+// or LogoPath when no name is given. An empty LogoPath stands for the built-in
+// logo.
+func logoPath(opts Options) (string, error) {
+	if opts.LogoName == "" {
+		return opts.LogoPath, nil
+	}
+	path, ok := opts.Logos[strings.ToLower(opts.LogoName)]
+	if !ok {
+		return "", fmt.Errorf("unknown logo_name %q", opts.LogoName)
+	}
+	return path, nil
 }
 
 // resizeImage resizes an image to target dimensions using bilinear interpolation
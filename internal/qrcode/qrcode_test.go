@@ -0,0 +1,152 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	red   = color.NRGBA{R: 0xff, A: 0xff}
+	blue  = color.NRGBA{B: 0xff, A: 0xff}
+	white = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+// nrgbaAt returns the pixel of img at (x, y), relative to its bounds
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	b := img.Bounds()
+	return color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+}
+
+// halves returns a w by h image, red on the left half and blue on the right
+func halves(bounds image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := red
+			if x-bounds.Min.X >= bounds.Dx()/2 {
+				c = blue
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestResizeLogo(t *testing.T) {
+	t.Run("KeepsAspectRatio", func(t *testing.T) {
+		resized := resizeLogo(halves(image.Rect(0, 0, 40, 20)), 20)
+
+		assert.Equal(t, image.Pt(20, 10), resized.Bounds().Size())
+		assert.Equal(t, red, nrgbaAt(resized, 0, 0))
+		assert.Equal(t, red, nrgbaAt(resized, 9, 9))
+		assert.Equal(t, blue, nrgbaAt(resized, 10, 0))
+		assert.Equal(t, blue, nrgbaAt(resized, 19, 9))
+	})
+
+	t.Run("OffsetBounds", func(t *testing.T) {
+		resized := resizeLogo(halves(image.Rect(5, 7, 25, 27)), 10)
+
+		assert.Equal(t, image.Pt(10, 10), resized.Bounds().Size())
+		assert.Equal(t, red, nrgbaAt(resized, 4, 5))
+		assert.Equal(t, blue, nrgbaAt(resized, 5, 5))
+	})
+
+	t.Run("NarrowSideAtLeastTen", func(t *testing.T) {
+		resized := resizeLogo(halves(image.Rect(0, 0, 10, 40)), 20)
+		assert.Equal(t, image.Pt(10, 20), resized.Bounds().Size())
+	})
+}
+
+func TestRecolorLogo(t *testing.T) {
+	logo := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	logo.SetNRGBA(0, 0, color.NRGBA{A: 0xff})
+	logo.SetNRGBA(1, 0, color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0})
+	logo.SetNRGBA(2, 0, white)
+
+	recolored, err := recolorLogo(logo, "#004a80")
+	require.NoError(t, err)
+
+	assert.Equal(t, logo.Bounds(), recolored.Bounds())
+	assert.Equal(t, color.NRGBA{G: 0x4a, B: 0x80, A: 0xff}, nrgbaAt(recolored, 0, 0))
+	assert.Equal(t, color.NRGBA{}, nrgbaAt(recolored, 1, 0))
+	assert.Equal(t, color.NRGBA{G: 0x4a, B: 0x80, A: 0xff}, nrgbaAt(recolored, 2, 0))
+
+	_, err = recolorLogo(logo, "blue")
+	assert.Error(t, err)
+}
+
+func TestAddLogoSafeZone(t *testing.T) {
+	logo := halves(image.Rect(0, 0, 10, 10))
+
+	padded, err := addLogoSafeZone(logo, "#ffffff")
+	require.NoError(t, err)
+
+	// A fifth of the width on every side
+	assert.Equal(t, image.Pt(14, 14), padded.Bounds().Size())
+	for _, p := range []image.Point{{0, 0}, {13, 0}, {0, 13}, {13, 13}, {1, 7}, {12, 7}, {7, 1}, {7, 12}} {
+		assert.Equal(t, white, nrgbaAt(padded, p.X, p.Y), "border at %v", p)
+	}
+	assert.Equal(t, red, nrgbaAt(padded, 2, 2))
+	assert.Equal(t, red, nrgbaAt(padded, 6, 11))
+	assert.Equal(t, blue, nrgbaAt(padded, 7, 2))
+	assert.Equal(t, blue, nrgbaAt(padded, 11, 11))
+
+	_, err = addLogoSafeZone(logo, "#fff")
+	assert.Error(t, err)
+}
+
+// TestGenerateOld checks that the legacy writer renders a readable PNG in
+// memory, without going through temporary files
+func TestGenerateOld(t *testing.T) {
+	base := DefaultOptions()
+	base.Data = "https://pref.rio/carnaval"
+
+	tests := []struct {
+		name   string
+		modify func(*Options)
+	}{
+		{"Plain", func(o *Options) { o.IncludeLogo = false }},
+		{"Logo", func(o *Options) {}},
+		{"RecoloredLogo", func(o *Options) { o.LogoColor = "#004a80" }},
+		{"TransparentBackground", func(o *Options) {
+			o.IncludeLogo = false
+			o.TransparentBackground = true
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			opts := base
+			tt.modify(&opts)
+			data, err := GenerateOld(opts)
+			require.NoError(t, err)
+
+			img, err := png.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			assert.False(t, img.Bounds().Empty())
+
+			text, err := Decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, opts.Data, text)
+
+			entries, err := os.ReadDir(tmp)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+
+	t.Run("RejectsMissingData", func(t *testing.T) {
+		_, err := GenerateOld(DefaultOptions())
+		assert.Error(t, err)
+	})
+}
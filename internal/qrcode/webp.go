@@ -0,0 +1,425 @@
+package qrcode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+// encodeWebP writes img as a lossless WebP (VP8L) image. QR codes are large
+// runs of identical pixels, so the encoder only uses LZ77 references to the
+// pixel on the left and the pixel above with per-channel Huffman codes, which
+// already compresses them well below PNG without transforms or a color cache.
+func encodeWebP(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return fmt.Errorf("webp dimensions must be between 1 and %d", vp8lMaxDimension)
+	}
+
+	// Pixels in VP8L's ARGB order
+	argb := make([]uint32, 0, width*height)
+	hasAlpha := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			argb = append(argb, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+
+	tokens := vp8lTokenize(argb, width)
+
+	// Histograms for the green (literals and lengths), red, blue, alpha and
+	// distance codes
+	hist := [5][]int{
+		make([]int, 256+vp8lLengthCodes),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, vp8lDistanceCodes),
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			hist[0][t.argb>>8&0xff]++
+			hist[1][t.argb>>16&0xff]++
+			hist[2][t.argb&0xff]++
+			hist[3][t.argb>>24]++
+			continue
+		}
+		lengthSymbol, _, _ := vp8lPrefixEncode(t.length)
+		distSymbol, _, _ := vp8lPrefixEncode(t.distCode)
+		hist[0][256+lengthSymbol]++
+		hist[4][distSymbol]++
+	}
+
+	var bw vp8lBitWriter
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // a single prefix code group
+
+	var codes [5]vp8lCode
+	for i := range hist {
+		codes[i] = bw.writePrefixCode(hist[i])
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].write(&bw, int(t.argb>>8&0xff))
+			codes[1].write(&bw, int(t.argb>>16&0xff))
+			codes[2].write(&bw, int(t.argb&0xff))
+			codes[3].write(&bw, int(t.argb>>24))
+			continue
+		}
+		symbol, extraBits, extra := vp8lPrefixEncode(t.length)
+		codes[0].write(&bw, 256+symbol)
+		bw.write(uint32(extra), uint(extraBits))
+		symbol, extraBits, extra = vp8lPrefixEncode(t.distCode)
+		codes[4].write(&bw, symbol)
+		bw.write(uint32(extra), uint(extraBits))
+	}
+
+	data := bw.bytes()
+	padded := len(data) + len(data)&1
+
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+padded))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(data)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padded != len(data) {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	vp8lSignature     = 0x2f
+	vp8lMaxDimension  = 1 << 14
+	vp8lLengthCodes   = 24
+	vp8lDistanceCodes = 40
+	vp8lMaxLength     = 4096
+	vp8lMinMatch      = 3
+
+	// Distance codes 1 and 2 are the first entries of the VP8L neighborhood
+	// table: the pixel above and the pixel on the left
+	vp8lDistanceAbove = 1
+	vp8lDistanceLeft  = 2
+
+	vp8lMaxCodeLength           = 15
+	vp8lMaxCodeLengthCodeLength = 7
+)
+
+// vp8lCodeLengthCodeOrder is the order code length code lengths are written in
+var vp8lCodeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lToken is a literal pixel or, when length is set, a backward reference
+type vp8lToken struct {
+	argb     uint32
+	length   int
+	distCode int
+}
+
+// vp8lTokenize greedily replaces runs matching the row above or the previous
+// pixel with backward references
+func vp8lTokenize(argb []uint32, width int) []vp8lToken {
+	var tokens []vp8lToken
+	for i := 0; i < len(argb); {
+		best, bestCode := 0, 0
+		if i >= width {
+			if n := vp8lMatchLength(argb, i, width); n > best {
+				best, bestCode = n, vp8lDistanceAbove
+			}
+		}
+		if i >= 1 {
+			if n := vp8lMatchLength(argb, i, 1); n > best {
+				best, bestCode = n, vp8lDistanceLeft
+			}
+		}
+
+		if best >= vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{length: best, distCode: bestCode})
+			i += best
+			continue
+		}
+		tokens = append(tokens, vp8lToken{argb: argb[i]})
+		i++
+	}
+	return tokens
+}
+
+// vp8lMatchLength counts the pixels from i that repeat those dist pixels back
+func vp8lMatchLength(argb []uint32, i, dist int) int {
+	n := 0
+	for i+n < len(argb) && n < vp8lMaxLength && argb[i+n] == argb[i+n-dist] {
+		n++
+	}
+	return n
+}
+
+// vp8lPrefixEncode splits a length or distance code (>= 1) into its prefix
+// symbol and extra bits
+func vp8lPrefixEncode(v int) (symbol, extraBits, extra int) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := bits.Len(uint(v)) - 1
+	second := (v >> (high - 1)) & 1
+	extraBits = high - 1
+	return 2*high + second, extraBits, v & (1<<extraBits - 1)
+}
+
+// vp8lCode is a canonical prefix code; symbols with a zero length are written
+// with no bits
+type vp8lCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (c vp8lCode) write(bw *vp8lBitWriter, symbol int) {
+	if n := c.lengths[symbol]; n > 0 {
+		bw.write(c.codes[symbol], uint(n))
+	}
+}
+
+// newVP8LCode assigns canonical codes to lengths, bit-reversed because the
+// stream is read least significant bit first
+func newVP8LCode(lengths []int) vp8lCode {
+	var count [vp8lMaxCodeLength + 1]uint32
+	for _, n := range lengths {
+		count[n]++
+	}
+	count[0] = 0
+
+	var next [vp8lMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for n := 1; n <= vp8lMaxCodeLength; n++ {
+		code = (code + count[n-1]) << 1
+		next[n] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, n := range lengths {
+		if n > 0 {
+			codes[symbol] = bits.Reverse32(next[n]) >> (32 - n)
+			next[n]++
+		}
+	}
+	return vp8lCode{lengths: lengths, codes: codes}
+}
+
+// writePrefixCode writes the prefix code for a histogram and returns it. One or
+// two literal symbols use the compact simple form; anything else is written as
+// code lengths, themselves prefix coded.
+func (bw *vp8lBitWriter) writePrefixCode(hist []int) vp8lCode {
+	var used []int
+	for symbol, n := range hist {
+		if n > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		lengths := make([]int, len(hist))
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newVP8LCode(lengths)
+	}
+
+	lengths := huffmanLengths(hist, vp8lMaxCodeLength)
+	code := newVP8LCode(lengths)
+
+	// Run-length encode the lengths: 17 and 18 repeat zeros
+	type clToken struct{ symbol, extraBits, extra int }
+	var clTokens []clToken
+	clHist := make([]int, len(vp8lCodeLengthCodeOrder))
+	for i := 0; i < len(lengths); {
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == lengths[i] {
+			run++
+		}
+		if lengths[i] != 0 || run < 3 {
+			clTokens = append(clTokens, clToken{symbol: lengths[i]})
+			clHist[lengths[i]]++
+			i++
+			continue
+		}
+		if run > 138 {
+			run = 138
+		}
+		if run >= 11 {
+			clTokens = append(clTokens, clToken{symbol: 18, extraBits: 7, extra: run - 11})
+			clHist[18]++
+		} else {
+			clTokens = append(clTokens, clToken{symbol: 17, extraBits: 3, extra: run - 3})
+			clHist[17]++
+		}
+		i += run
+	}
+
+	clLengths := huffmanLengths(clHist, vp8lMaxCodeLengthCodeLength)
+	clCode := newVP8LCode(clLengths)
+
+	numCodes := 4
+	for i, symbol := range vp8lCodeLengthCodeOrder {
+		if clLengths[symbol] > 0 && i+1 > numCodes {
+			numCodes = i + 1
+		}
+	}
+
+	bw.write(0, 1)
+	bw.write(uint32(numCodes-4), 4)
+	for _, symbol := range vp8lCodeLengthCodeOrder[:numCodes] {
+		bw.write(uint32(clLengths[symbol]), 3)
+	}
+	bw.write(0, 1) // lengths for the whole alphabet follow
+	for _, t := range clTokens {
+		clCode.write(bw, t.symbol)
+		bw.write(uint32(t.extra), uint(t.extraBits))
+	}
+
+	return code
+}
+
+// huffmanLengths returns code lengths for a histogram, none longer than limit.
+// At least two symbols always get a code so the result is a complete prefix
+// code, which every decoder accepts.
+func huffmanLengths(hist []int, limit int) []int {
+	counts := append([]int(nil), hist...)
+	used := 0
+	for _, n := range counts {
+		if n > 0 {
+			used++
+		}
+	}
+	for symbol := 0; used < 2; symbol++ {
+		if counts[symbol] == 0 {
+			counts[symbol] = 1
+			used++
+		}
+	}
+
+	// Flatten the distribution until the tree fits within the limit
+	for floor := 1; ; floor *= 2 {
+		adjusted := make([]int, len(counts))
+		for symbol, n := range counts {
+			if n > 0 {
+				adjusted[symbol] = max(n, floor)
+			}
+		}
+		lengths := huffmanDepths(adjusted)
+		longest := 0
+		for _, n := range lengths {
+			longest = max(longest, n)
+		}
+		if longest <= limit {
+			return lengths
+		}
+	}
+}
+
+// huffmanDepths builds a Huffman tree over the non-zero counts and returns
+// each symbol's depth
+func huffmanDepths(counts []int) []int {
+	type node struct{ weight, parent int }
+	var nodes []node
+	leaves := make(map[int]int) // symbol -> node index
+	for symbol, n := range counts {
+		if n > 0 {
+			leaves[symbol] = len(nodes)
+			nodes = append(nodes, node{weight: n, parent: -1})
+		}
+	}
+
+	roots := len(nodes)
+	for roots > 1 {
+		a, b := -1, -1
+		for i, n := range nodes {
+			if n.parent != -1 {
+				continue
+			}
+			switch {
+			case a == -1 || n.weight < nodes[a].weight:
+				a, b = i, a
+			case b == -1 || n.weight < nodes[b].weight:
+				b = i
+			}
+		}
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+		roots--
+	}
+
+	depths := make([]int, len(counts))
+	for symbol, i := range leaves {
+		for p := nodes[i].parent; p != -1; p = nodes[p].parent {
+			depths[symbol]++
+		}
+	}
+	return depths
+}
+
+// vp8lBitWriter packs values least significant bit first
+type vp8lBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits uint
+}
+
+func (bw *vp8lBitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nBits -= 8
+	}
+}
+
+func (bw *vp8lBitWriter) bytes() []byte {
+	if bw.nBits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nBits = 0, 0
+	}
+	return bw.buf
+}
@@ -0,0 +1,162 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/webp"
+)
+
+// assertSamePixels compares two images pixel by pixel as non-premultiplied
+// colors. Fully transparent pixels match whatever their color, since
+// premultiplied formats cannot keep it.
+func assertSamePixels(t *testing.T, want, got image.Image) {
+	t.Helper()
+	require.Equal(t, want.Bounds().Size(), got.Bounds().Size())
+
+	wb, gb := want.Bounds(), got.Bounds()
+	mismatches := 0
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			w := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			if w == g || (w.A == 0 && g.A == 0) {
+				continue
+			}
+			if mismatches++; mismatches <= 5 {
+				t.Errorf("pixel (%d, %d): want %v, got %v", x, y, w, g)
+			}
+		}
+	}
+	if mismatches > 5 {
+		t.Errorf("%d pixels differ in total", mismatches)
+	}
+}
+
+// roundTripWebP encodes img as WebP and decodes it back
+func roundTripWebP(t *testing.T, img image.Image) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, encodeWebP(&buf, img))
+
+	decoded, err := webp.Decode(&buf)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestEncodeWebP(t *testing.T) {
+	t.Run("SinglePixel", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		img.Set(0, 0, color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff})
+		assertSamePixels(t, img, roundTripWebP(t, img))
+	})
+
+	t.Run("NonSquareWithAlpha", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 37, 13))
+		for y := 0; y < 13; y++ {
+			for x := 0; x < 37; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 19), B: uint8(x ^ y), A: uint8(x*y) | 0x0f})
+			}
+		}
+		assertSamePixels(t, img, roundTripWebP(t, img))
+	})
+
+	t.Run("TallRepeatingRows", func(t *testing.T) {
+		// Long runs and rows copied from above exercise the backward references
+		img := image.NewNRGBA(image.Rect(0, 0, 5, 300))
+		for y := 0; y < 300; y++ {
+			for x := 0; x < 5; x++ {
+				c := color.NRGBA{A: 0xff}
+				if (y/40+x)%2 == 0 {
+					c = color.NRGBA{R: 0xc0, G: 0x10, B: 0x80, A: 0xff}
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		assertSamePixels(t, img, roundTripWebP(t, img))
+	})
+
+	t.Run("Noise", func(t *testing.T) {
+		// Nearly every pixel is a literal, so every prefix code is large
+		rng := rand.New(rand.NewSource(1))
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+		rng.Read(img.Pix)
+		assertSamePixels(t, img, roundTripWebP(t, img))
+	})
+
+	t.Run("OffsetBounds", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(10, 20, 30, 25))
+		for y := 20; y < 25; y++ {
+			for x := 10; x < 30; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0xff, A: 0xff})
+			}
+		}
+		assertSamePixels(t, img, roundTripWebP(t, img))
+	})
+
+	t.Run("RejectsEmptyImage", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.Error(t, encodeWebP(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 10))))
+	})
+}
+
+// TestGenerateWebP checks generated WebP codes against the PNG of the same
+// options, which is lossless too and decoded by the standard library
+func TestGenerateWebP(t *testing.T) {
+	base := DefaultOptions()
+	base.Data = "https://pref.rio/carnaval"
+	base.IncludeLogo = false
+
+	tests := []struct {
+		name   string
+		modify func(*Options)
+	}{
+		{"Plain", func(o *Options) {}},
+		{"TransparentBackground", func(o *Options) { o.TransparentBackground = true }},
+		{"Logo", func(o *Options) { o.IncludeLogo = true }},
+		{"ColoredEyes", func(o *Options) {
+			o.ForegroundColor = "#1a237e"
+			o.EyeColor = "#c62828"
+			o.EyeBallColor = "#2e7d32"
+		}},
+		{"RoundedModules", func(o *Options) { o.ModuleShape = "rounded" }},
+		{"Everything", func(o *Options) {
+			o.TransparentBackground = true
+			o.IncludeLogo = true
+			o.LogoColor = "#004a80"
+			o.EyeColor = "#c62828"
+			o.Size = 333
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := base
+			tt.modify(&opts)
+
+			opts.Format = "png"
+			pngData, err := Generate(opts)
+			require.NoError(t, err)
+			want, err := png.Decode(bytes.NewReader(pngData))
+			require.NoError(t, err)
+
+			opts.Format = "webp"
+			webpData, err := Generate(opts)
+			require.NoError(t, err)
+			got, err := webp.Decode(bytes.NewReader(webpData))
+			require.NoError(t, err)
+
+			assertSamePixels(t, want, got)
+
+			text, err := Decode(webpData)
+			require.NoError(t, err)
+			assert.Equal(t, opts.Data, text)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"url_shortener/internal/apierror"
+	"url_shortener/internal/clientip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IncrementFunc counts a request against key in a fixed window. It opens a
+// window of the given length if none is running and returns the number of
+// requests counted in the window so far and the time until it resets.
+type IncrementFunc func(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+
+// Middleware allows each client limit requests per window. Clients are told
+// apart by IP address, IPv6 ones by their /64 network (see clientip.Key). Every
+// response carries X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (seconds until the window resets); requests beyond the
+// limit are rejected with 429 and Retry-After. Requests to exempt paths are
+// always served. When counting fails the request is let through, so an
+// unavailable store does not take the service down. A limit below 1 or a nil
+// increment disables rate limiting.
+func Middleware(increment IncrementFunc, limit int, window time.Duration, exempt ...string) gin.HandlerFunc {
+	if limit < 1 || window <= 0 || increment == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	exemptPaths := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		if path != "" {
+			exemptPaths[path] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		if exemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		count, ttl, err := increment(c.Request.Context(), clientip.Key(c), window)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		remaining := int64(limit) - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		reset := resetSeconds(ttl, window)
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(reset))
+
+		if count > int64(limit) {
+			c.Header("Retry-After", strconv.Itoa(reset))
+			apierror.Abort(c, http.StatusTooManyRequests, apierror.CodeRateLimited, "rate limit exceeded, retry later")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// resetSeconds rounds the time until the window resets up to whole seconds,
+// falling back to the full window when the store reports no expiry
+func resetSeconds(ttl, window time.Duration) int {
+	if ttl <= 0 {
+		ttl = window
+	}
+	return int((ttl + time.Second - 1) / time.Second)
+}
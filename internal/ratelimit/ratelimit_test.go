@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCounter is an in-memory fixed-window counter with a manual clock
+type fakeCounter struct {
+	mu      sync.Mutex
+	now     time.Time
+	counts  map[string]int64
+	resetAt map[string]time.Time
+}
+
+func newFakeCounter() *fakeCounter {
+	return &fakeCounter{now: time.Unix(0, 0), counts: map[string]int64{}, resetAt: map[string]time.Time{}}
+}
+
+func (f *fakeCounter) increment(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.now.Before(f.resetAt[key]) {
+		f.counts[key] = 0
+		f.resetAt[key] = f.now.Add(window)
+	}
+	f.counts[key]++
+	return f.counts[key], f.resetAt[key].Sub(f.now), nil
+}
+
+func (f *fakeCounter) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	counter := newFakeCounter()
+	router := gin.New()
+	router.Use(Middleware(counter.increment, 3, time.Minute, "/api/health"))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/api/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("HeadersDecrement", func(t *testing.T) {
+		for _, want := range []struct{ remaining, reset string }{{"2", "60"}, {"1", "59"}, {"0", "58"}} {
+			w := get("/")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+			assert.Equal(t, want.remaining, w.Header().Get("X-RateLimit-Remaining"))
+			assert.Equal(t, want.reset, w.Header().Get("X-RateLimit-Reset"))
+			assert.Empty(t, w.Header().Get("Retry-After"))
+			counter.advance(time.Second)
+		}
+	})
+
+	t.Run("RejectsOverLimit", func(t *testing.T) {
+		w := get("/")
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "57", w.Header().Get("X-RateLimit-Reset"))
+		assert.Equal(t, "57", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("HealthAlwaysServed", func(t *testing.T) {
+		w := get("/api/health")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+	})
+
+	t.Run("ResetsAfterWindow", func(t *testing.T) {
+		counter.advance(time.Minute)
+		w := get("/")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "60", w.Header().Get("X-RateLimit-Reset"))
+	})
+}
+
+func TestMiddlewareIPv6(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	counter := newFakeCounter()
+	router := gin.New()
+	router.Use(Middleware(counter.increment, 2, time.Minute))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(remoteAddr string) int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Addresses in one /64 share a counter, so rotating them does not help
+	assert.Equal(t, http.StatusOK, get("[2001:db8:0:1::1]:1234"))
+	assert.Equal(t, http.StatusOK, get("[2001:db8:0:1::2]:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, get("[2001:db8:0:1:ffff::3]:1234"))
+
+	// Another /64 is another client
+	assert.Equal(t, http.StatusOK, get("[2001:db8:0:2::1]:1234"))
+	assert.Equal(t, int64(3), counter.counts["2001:db8:0:1::/64"])
+}
+
+func TestMiddlewareFailsOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	failing := func(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+		return 0, 0, errors.New("redis down")
+	}
+	router := gin.New()
+	router.Use(Middleware(failing, 1, time.Minute))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Middleware(nil, 10, time.Minute))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+}
@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"context"
+	"errors"
+
+	"url_shortener/internal/database"
+)
+
+// ErrUnavailable is reported by NoopCache health checks
+var ErrUnavailable = errors.New("redis unavailable")
+
+// NoopCache satisfies the cache contract without storing anything. It is
+// installed when Redis cannot be reached so the service keeps running DB-only.
+type NoopCache struct{}
+
+func (NoopCache) Ping(ctx context.Context) error {
+	return ErrUnavailable
+}
+
+func (NoopCache) GetURL(ctx context.Context, shortPath string) (*database.URL, error) {
+	return nil, nil
+}
+
+func (NoopCache) SetURL(ctx context.Context, shortPath string, url *database.URL) error {
+	return nil
+}
+
+func (NoopCache) DeleteURL(ctx context.Context, shortPath string) error {
+	return nil
+}
+
+func (NoopCache) SetExpiredURL(ctx context.Context, shortPath string, url *database.URL) error {
+	return nil
+}
+
+func (NoopCache) GetURLByID(ctx context.Context, id string) (*database.URL, error) {
+	return nil, nil
+}
+
+func (NoopCache) SetURLByID(ctx context.Context, id string, url *database.URL) error {
+	return nil
+}
+
+func (NoopCache) DeleteURLByID(ctx context.Context, id string) error {
+	return nil
+}
+
+func (NoopCache) IsNotFound(ctx context.Context, shortPath string) (bool, error) {
+	return false, nil
+}
+
+func (NoopCache) SetNotFound(ctx context.Context, shortPath string) error {
+	return nil
+}
+
+func (NoopCache) DeleteNotFound(ctx context.Context, shortPath string) error {
+	return nil
+}
+
+func (NoopCache) FlushURLs(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (NoopCache) GetQRCode(ctx context.Context, key string) ([]byte, error) {
+	return nil, nil
+}
+
+func (NoopCache) SetQRCode(ctx context.Context, key string, data []byte) error {
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"url_shortener/internal/database"
@@ -11,33 +13,128 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Connection modes
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Connection describes how to reach Redis. Standalone mode connects to URL;
+// sentinel mode asks the sentinels at Addrs for the current master of
+// MasterName; cluster mode discovers the cluster from the nodes at Addrs.
+type Connection struct {
+	Mode             string
+	URL              string
+	Addrs            []string
+	MasterName       string
+	Password         string
+	SentinelPassword string
+}
+
 type Client struct {
-	client *redis.Client
-	ttl    time.Duration
+	client      redis.UniversalClient
+	ttl         time.Duration
+	negativeTTL time.Duration
+	qrTTL       time.Duration
+
+	// keyPrefix is prepended to every key, so services sharing a Redis
+	// instance cannot collide
+	keyPrefix string
 }
 
-func Init(redisURL string, ttl time.Duration) (*Client, error) {
-	opts, err := redis.ParseURL(redisURL)
+func Init(conn Connection, keyPrefix string, ttl, negativeTTL, qrTTL time.Duration) (*Client, error) {
+	client, err := newClient(conn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+		return nil, err
 	}
 
-	client := redis.NewClient(opts)
-
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to ping Redis: %w", err)
 	}
 
 	return &Client{
-		client: client,
-		ttl:    ttl,
+		client:      client,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		qrTTL:       qrTTL,
+		keyPrefix:   keyPrefix,
 	}, nil
 }
 
+// newClient builds the go-redis client for the connection mode
+func newClient(conn Connection) (redis.UniversalClient, error) {
+	switch conn.Mode {
+	case "", ModeStandalone:
+		opts, err := redis.ParseURL(conn.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+		}
+		return redis.NewClient(opts), nil
+	case ModeSentinel:
+		if conn.MasterName == "" || len(conn.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel mode needs a master name and sentinel addresses")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       conn.MasterName,
+			SentinelAddrs:    conn.Addrs,
+			Password:         conn.Password,
+			SentinelPassword: conn.SentinelPassword,
+		}), nil
+	case ModeCluster:
+		if len(conn.Addrs) == 0 {
+			return nil, fmt.Errorf("redis cluster mode needs node addresses")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    conn.Addrs,
+			Password: conn.Password,
+		}), nil
+	default:
+		return nil, fmt.Errorf("invalid Redis mode: %s (must be standalone, sentinel or cluster)", conn.Mode)
+	}
+}
+
+// urlKey is the key of a URL cached by short path
+func (c *Client) urlKey(shortPath string) string {
+	return c.keyPrefix + "url:" + shortPath
+}
+
+// urlIDKey is the key of a URL cached by ID
+func (c *Client) urlIDKey(id string) string {
+	return c.keyPrefix + "url_id:" + id
+}
+
+// notFoundKey is the key of the marker for a short path known not to exist
+func (c *Client) notFoundKey(shortPath string) string {
+	return c.keyPrefix + "url:notfound:" + shortPath
+}
+
+// qrKey is the key of a cached QR code image
+func (c *Client) qrKey(key string) string {
+	return c.keyPrefix + "qr:" + key
+}
+
+// rateLimitKey is the key of a client's rate limit counter
+func (c *Client) rateLimitKey(client string) string {
+	return c.keyPrefix + "ratelimit:" + client
+}
+
+// urlKeyPatterns match every cached URL and not-found marker under the prefix
+func (c *Client) urlKeyPatterns() []string {
+	prefix := escapeGlob(c.keyPrefix)
+	return []string{prefix + "url:*", prefix + "url_id:*"}
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as a pattern
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
 func (c *Client) Close() error {
 	return c.client.Close()
 }
@@ -47,7 +144,7 @@ func (c *Client) Ping(ctx context.Context) error {
 }
 
 func (c *Client) GetURL(ctx context.Context, shortPath string) (*database.URL, error) {
-	key := fmt.Sprintf("url:%s", shortPath)
+	key := c.urlKey(shortPath)
 	
 	data, err := c.client.Get(ctx, key).Bytes()
 	if err != nil {
@@ -66,22 +163,45 @@ func (c *Client) GetURL(ctx context.Context, shortPath string) (*database.URL, e
 }
 
 func (c *Client) SetURL(ctx context.Context, shortPath string, url *database.URL) error {
-	key := fmt.Sprintf("url:%s", shortPath)
+	key := c.urlKey(shortPath)
 	
+	ttl, ok := c.ttlFor(url)
+	if !ok {
+		// Already expired: drop any copy cached before the update expired it
+		return c.DeleteURL(ctx, shortPath)
+	}
+
 	data, err := json.Marshal(url)
 	if err != nil {
 		return fmt.Errorf("failed to marshal URL: %w", err)
 	}
 
-	if err := c.client.Set(ctx, key, data, c.ttl).Err(); err != nil {
+	if err := c.client.Set(ctx, key, data, ttl).Err(); err != nil {
 		return fmt.Errorf("failed to set in Redis: %w", err)
 	}
 
 	return nil
 }
 
+// ttlFor returns the cache TTL for a URL: the configured TTL, capped at the time
+// remaining until the URL expires. It reports false if the URL has already expired.
+func (c *Client) ttlFor(url *database.URL) (time.Duration, bool) {
+	if url.ExpiresAt == nil {
+		return c.ttl, true
+	}
+
+	remaining := time.Until(*url.ExpiresAt)
+	if remaining <= 0 {
+		return 0, false
+	}
+	if remaining < c.ttl {
+		return remaining, true
+	}
+	return c.ttl, true
+}
+
 func (c *Client) DeleteURL(ctx context.Context, shortPath string) error {
-	key := fmt.Sprintf("url:%s", shortPath)
+	key := c.urlKey(shortPath)
 	
 	if err := c.client.Del(ctx, key).Err(); err != nil {
 		return fmt.Errorf("failed to delete from Redis: %w", err)
@@ -90,8 +210,24 @@ func (c *Client) DeleteURL(ctx context.Context, shortPath string) error {
 	return nil
 }
 
+// SetExpiredURL caches a URL that has already expired for the negative cache
+// TTL, so redirects keep answering 410 without querying the database. Any
+// update to the URL replaces or deletes the entry.
+func (c *Client) SetExpiredURL(ctx context.Context, shortPath string, url *database.URL) error {
+	data, err := json.Marshal(url)
+	if err != nil {
+		return fmt.Errorf("failed to marshal URL: %w", err)
+	}
+
+	if err := c.client.Set(ctx, c.urlKey(shortPath), data, c.negativeTTL).Err(); err != nil {
+		return fmt.Errorf("failed to set in Redis: %w", err)
+	}
+
+	return nil
+}
+
 func (c *Client) GetURLByID(ctx context.Context, id string) (*database.URL, error) {
-	key := fmt.Sprintf("url_id:%s", id)
+	key := c.urlIDKey(id)
 	
 	data, err := c.client.Get(ctx, key).Bytes()
 	if err != nil {
@@ -110,14 +246,20 @@ func (c *Client) GetURLByID(ctx context.Context, id string) (*database.URL, erro
 }
 
 func (c *Client) SetURLByID(ctx context.Context, id string, url *database.URL) error {
-	key := fmt.Sprintf("url_id:%s", id)
+	key := c.urlIDKey(id)
 	
+	ttl, ok := c.ttlFor(url)
+	if !ok {
+		// Already expired: drop any copy cached before the update expired it
+		return c.DeleteURLByID(ctx, id)
+	}
+
 	data, err := json.Marshal(url)
 	if err != nil {
 		return fmt.Errorf("failed to marshal URL: %w", err)
 	}
 
-	if err := c.client.Set(ctx, key, data, c.ttl).Err(); err != nil {
+	if err := c.client.Set(ctx, key, data, ttl).Err(); err != nil {
 		return fmt.Errorf("failed to set in Redis: %w", err)
 	}
 
@@ -125,11 +267,159 @@ func (c *Client) SetURLByID(ctx context.Context, id string, url *database.URL) e
 }
 
 func (c *Client) DeleteURLByID(ctx context.Context, id string) error {
-	key := fmt.Sprintf("url_id:%s", id)
+	key := c.urlIDKey(id)
 	
 	if err := c.client.Del(ctx, key).Err(); err != nil {
 		return fmt.Errorf("failed to delete from Redis: %w", err)
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
+
+// IsNotFound reports whether a short path was recently looked up and not found
+func (c *Client) IsNotFound(ctx context.Context, shortPath string) (bool, error) {
+	key := c.notFoundKey(shortPath)
+
+	n, err := c.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check Redis: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+// SetNotFound records a short-lived marker for a short path that does not exist
+func (c *Client) SetNotFound(ctx context.Context, shortPath string) error {
+	key := c.notFoundKey(shortPath)
+
+	if err := c.client.Set(ctx, key, 1, c.negativeTTL).Err(); err != nil {
+		return fmt.Errorf("failed to set in Redis: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteNotFound removes the not-found marker for a short path
+func (c *Client) DeleteNotFound(ctx context.Context, shortPath string) error {
+	key := c.notFoundKey(shortPath)
+
+	if err := c.client.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to delete from Redis: %w", err)
+	}
+
+	return nil
+}
+
+// GetQRCode returns a cached QR code image, or nil on a cache miss
+func (c *Client) GetQRCode(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.client.Get(ctx, c.qrKey(key)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil // Cache miss
+		}
+		return nil, fmt.Errorf("failed to get from Redis: %w", err)
+	}
+
+	return data, nil
+}
+
+// SetQRCode caches an encoded QR code image
+func (c *Client) SetQRCode(ctx context.Context, key string, data []byte) error {
+	if err := c.client.Set(ctx, c.qrKey(key), data, c.qrTTL).Err(); err != nil {
+		return fmt.Errorf("failed to set in Redis: %w", err)
+	}
+
+	return nil
+}
+
+// flushBatchSize is the SCAN page size and the number of keys unlinked per
+// round trip when flushing
+const flushBatchSize = 500
+
+// FlushURLs removes every cached URL and not-found marker, leaving QR codes
+// and rate limit counters alone, and returns how many keys were removed. Keys
+// are found with SCAN and removed with UNLINK in batches, so Redis is never
+// blocked for long. In cluster mode each master is flushed in turn.
+func (c *Client) FlushURLs(ctx context.Context) (int64, error) {
+	var removed atomic.Int64
+	flush := func(ctx context.Context, node redis.UniversalClient) error {
+		for _, pattern := range c.urlKeyPatterns() {
+			n, err := flushNode(ctx, node, pattern)
+			removed.Add(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return flush(ctx, node)
+		})
+	} else {
+		err = flush(ctx, c.client)
+	}
+	if err != nil {
+		return removed.Load(), fmt.Errorf("failed to flush Redis: %w", err)
+	}
+	return removed.Load(), nil
+}
+
+// flushNode unlinks the keys of one node matching pattern. Keys are unlinked
+// one by one in a pipeline, since cluster keys in different slots cannot share
+// a command.
+func flushNode(ctx context.Context, node redis.UniversalClient, pattern string) (int64, error) {
+	var removed int64
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, flushBatchSize).Result()
+		if err != nil {
+			return removed, err
+		}
+
+		if len(keys) > 0 {
+			pipe := node.Pipeline()
+			cmds := make([]*redis.IntCmd, len(keys))
+			for i, key := range keys {
+				cmds[i] = pipe.Unlink(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return removed, err
+			}
+			for _, cmd := range cmds {
+				removed += cmd.Val()
+			}
+		}
+
+		if next == 0 {
+			return removed, nil
+		}
+		cursor = next
+	}
+}
+
+// rateLimitScript increments a fixed-window counter, setting its expiry only
+// when the window opens, and returns the count and remaining TTL in ms
+var rateLimitScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// IncrementRateLimit counts a request for a client in its current rate limit
+// window, starting a window if none is running, and returns the count and the
+// time until the window resets
+func (c *Client) IncrementRateLimit(ctx context.Context, client string, window time.Duration) (int64, time.Duration, error) {
+	key := c.rateLimitKey(client)
+
+	result, err := rateLimitScript.Run(ctx, c.client, []string{key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to increment in Redis: %w", err)
+	}
+
+	return result[0], time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"url_shortener/internal/database"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLFor(t *testing.T) {
+	c := &Client{ttl: time.Hour}
+
+	t.Run("NoExpiration", func(t *testing.T) {
+		ttl, ok := c.ttlFor(&database.URL{})
+		assert.True(t, ok)
+		assert.Equal(t, time.Hour, ttl)
+	})
+
+	t.Run("ExpiresBeforeConfiguredTTL", func(t *testing.T) {
+		expiresAt := time.Now().Add(5 * time.Minute)
+		ttl, ok := c.ttlFor(&database.URL{ExpiresAt: &expiresAt})
+		assert.True(t, ok)
+		assert.LessOrEqual(t, ttl, 5*time.Minute)
+		assert.Greater(t, ttl, 4*time.Minute)
+	})
+
+	t.Run("ExpiresAfterConfiguredTTL", func(t *testing.T) {
+		expiresAt := time.Now().Add(24 * time.Hour)
+		ttl, ok := c.ttlFor(&database.URL{ExpiresAt: &expiresAt})
+		assert.True(t, ok)
+		assert.Equal(t, time.Hour, ttl)
+	})
+
+	t.Run("AlreadyExpired", func(t *testing.T) {
+		expiresAt := time.Now().Add(-time.Minute)
+		_, ok := c.ttlFor(&database.URL{ExpiresAt: &expiresAt})
+		assert.False(t, ok)
+	})
+}
+
+// recordingHook captures the commands a Client sends instead of sending them
+type recordingHook struct {
+	commands [][]interface{}
+}
+
+func (h *recordingHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *recordingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.commands = append(h.commands, cmd.Args())
+		return nil
+	}
+}
+
+func (h *recordingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestSetExpiredURL(t *testing.T) {
+	ctx := context.Background()
+
+	newRecordingClient := func() (*Client, *recordingHook) {
+		hook := &recordingHook{}
+		client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+		client.AddHook(hook)
+		t.Cleanup(func() { client.Close() })
+		return &Client{client: client, ttl: time.Hour, negativeTTL: 30 * time.Second}, hook
+	}
+
+	// Updating a cached link to an already-past expiry removes the old copy,
+	// which would otherwise keep redirecting until the cache TTL
+	past := time.Now().Add(-time.Minute)
+	expired := &database.URL{Destination: "https://example.com", ExpiresAt: &past}
+
+	t.Run("ByShortPath", func(t *testing.T) {
+		c, hook := newRecordingClient()
+		require.NoError(t, c.SetURL(ctx, "promo", &database.URL{Destination: "https://example.com"}))
+		require.NoError(t, c.SetURL(ctx, "promo", expired))
+
+		require.Len(t, hook.commands, 2)
+		assert.Equal(t, "set", hook.commands[0][0])
+		assert.Equal(t, []interface{}{"del", "url:promo"}, hook.commands[1])
+	})
+
+	t.Run("ByID", func(t *testing.T) {
+		c, hook := newRecordingClient()
+		require.NoError(t, c.SetURLByID(ctx, "id-1", &database.URL{Destination: "https://example.com"}))
+		require.NoError(t, c.SetURLByID(ctx, "id-1", expired))
+
+		require.Len(t, hook.commands, 2)
+		assert.Equal(t, "set", hook.commands[0][0])
+		assert.Equal(t, []interface{}{"del", "url_id:id-1"}, hook.commands[1])
+	})
+
+	t.Run("KeptForNegativeTTL", func(t *testing.T) {
+		c, hook := newRecordingClient()
+		require.NoError(t, c.SetExpiredURL(ctx, "promo", expired))
+
+		require.Len(t, hook.commands, 1)
+		cmd := hook.commands[0]
+		require.Len(t, cmd, 5)
+		assert.Equal(t, []interface{}{"set", "url:promo"}, cmd[:2])
+		assert.Equal(t, []interface{}{"ex", int64(30)}, cmd[3:])
+	})
+}
+
+func TestKeyPrefix(t *testing.T) {
+	keys := func(c *Client) []string {
+		return []string{c.urlKey("promo"), c.urlIDKey("id-1"), c.notFoundKey("missing"), c.qrKey("png:abc"), c.rateLimitKey("192.0.2.1")}
+	}
+
+	t.Run("Unprefixed", func(t *testing.T) {
+		assert.Equal(t, []string{"url:promo", "url_id:id-1", "url:notfound:missing", "qr:png:abc", "ratelimit:192.0.2.1"}, keys(&Client{}))
+	})
+
+	t.Run("Prefixed", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"shortener:url:promo",
+			"shortener:url_id:id-1",
+			"shortener:url:notfound:missing",
+			"shortener:qr:png:abc",
+			"shortener:ratelimit:192.0.2.1",
+		}, keys(&Client{keyPrefix: "shortener:"}))
+	})
+}
+
+func TestURLKeyPatterns(t *testing.T) {
+	assert.Equal(t, []string{"url:*", "url_id:*"}, (&Client{}).urlKeyPatterns())
+	assert.Equal(t, []string{"shortener:url:*", "shortener:url_id:*"}, (&Client{keyPrefix: "shortener:"}).urlKeyPatterns())
+	// Glob characters in the prefix match literally
+	assert.Equal(t, []string{`app\[1\]:url:*`, `app\[1\]:url_id:*`}, (&Client{keyPrefix: "app[1]:"}).urlKeyPatterns())
+}
+
+func TestNewClient(t *testing.T) {
+	t.Run("Standalone", func(t *testing.T) {
+		client, err := newClient(Connection{URL: "redis://localhost:6379"})
+		require.NoError(t, err)
+		defer client.Close()
+		assert.IsType(t, &redis.Client{}, client)
+	})
+
+	t.Run("Sentinel", func(t *testing.T) {
+		client, err := newClient(Connection{Mode: ModeSentinel, MasterName: "mymaster", Addrs: []string{"sentinel-1:26379", "sentinel-2:26379"}})
+		require.NoError(t, err)
+		defer client.Close()
+		assert.IsType(t, &redis.Client{}, client)
+	})
+
+	t.Run("Cluster", func(t *testing.T) {
+		client, err := newClient(Connection{Mode: ModeCluster, Addrs: []string{"node-1:6379", "node-2:6379"}})
+		require.NoError(t, err)
+		defer client.Close()
+		assert.IsType(t, &redis.ClusterClient{}, client)
+	})
+
+	t.Run("InvalidConfigurations", func(t *testing.T) {
+		for _, conn := range []Connection{
+			{URL: "not-a-url"},
+			{Mode: ModeSentinel, Addrs: []string{"sentinel-1:26379"}},
+			{Mode: ModeSentinel, MasterName: "mymaster"},
+			{Mode: ModeCluster},
+			{Mode: "replicated", URL: "redis://localhost:6379"},
+		} {
+			_, err := newClient(conn)
+			assert.Error(t, err, "%+v", conn)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+// Package singleflight suppresses duplicate concurrent calls: while a call
+// for a key is running, later calls for the same key wait for it and share
+// its result instead of running again. It follows the interface of
+// golang.org/x/sync/singleflight, typed with generics.
+package singleflight
+
+import (
+	"fmt"
+	"sync"
+)
+
+// call is an in-flight or completed Do call
+type call[T any] struct {
+	wg    sync.WaitGroup
+	val   T
+	err   error
+	dups  int
+	panic interface{}
+}
+
+// Group runs calls for distinct keys independently. The zero value is ready
+// to use and a Group must not be copied after first use.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+// Do runs fn for key unless a call for key is already running, in which case
+// it waits for that call and returns its result. shared reports whether the
+// result was given to more than one caller. Results, including errors, are
+// only shared with calls that overlap; the next call after one completes runs
+// fn again. If fn panics, the panic is propagated to every waiting caller.
+func (g *Group[T]) Do(key string, fn func() (T, error)) (v T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		if c.panic != nil {
+			panic(c.panic)
+		}
+		return c.val, c.err, true
+	}
+	c := &call[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.run(key, c, fn)
+	return c.val, c.err, c.dups > 0
+}
+
+// run calls fn and releases the waiters, even when fn panics
+func (g *Group[T]) run(key string, c *call[T], fn func() (T, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panic = fmt.Errorf("singleflight: call for %q panicked: %v", key, r)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+
+		if c.panic != nil {
+			panic(c.panic)
+		}
+	}()
+
+	c.val, c.err = fn()
+}
+
+// waiting returns how many callers are waiting on the in-flight call for key
+func (g *Group[T]) waiting(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c.dups
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package singleflight
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+	var g Group[string]
+
+	v, err, shared := g.Do("key", func() (string, error) { return "value", nil })
+
+	require.NoError(t, err)
+	assert.Equal(t, "value", v)
+	assert.False(t, shared)
+}
+
+func TestDoDeduplicatesConcurrentCalls(t *testing.T) {
+	var g Group[int]
+	const callers = 10
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func() (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan int, callers)
+	sharedCount := atomic.Int32{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("key", fn)
+			assert.NoError(t, err)
+			if shared {
+				sharedCount.Add(1)
+			}
+			results <- v
+		}()
+	}
+
+	// Hold the first call until every other caller is waiting on it
+	deadline := time.Now().Add(5 * time.Second)
+	for g.waiting("key") < callers-1 {
+		if time.Now().After(deadline) {
+			t.Fatal("callers did not join the in-flight call")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, int32(callers), sharedCount.Load())
+	for v := range results {
+		assert.Equal(t, 42, v)
+	}
+}
+
+func TestDoDoesNotKeepErrors(t *testing.T) {
+	var g Group[int]
+	boom := errors.New("boom")
+
+	_, err, _ := g.Do("key", func() (int, error) { return 0, boom })
+	assert.ErrorIs(t, err, boom)
+
+	// The failed result is not reused by the next call
+	v, err, _ := g.Do("key", func() (int, error) { return 1, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+}
+
+func TestDoDistinctKeys(t *testing.T) {
+	var g Group[string]
+
+	a, _, _ := g.Do("a", func() (string, error) { return "a", nil })
+	b, _, _ := g.Do("b", func() (string, error) { return "b", nil })
+
+	assert.Equal(t, "a", a)
+	assert.Equal(t, "b", b)
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group[int]
+
+	assert.Panics(t, func() {
+		g.Do("key", func() (int, error) { panic("boom") })
+	})
+
+	// The key is released, so later calls run normally
+	v, err, _ := g.Do("key", func() (int, error) { return 1, nil })
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+}
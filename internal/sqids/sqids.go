@@ -0,0 +1,177 @@
+// Package sqids encodes sequence numbers as short, reversible IDs following
+// the Sqids algorithm (https://sqids.org), with the alphabet additionally
+// shuffled by a salt so deployments sharing an alphabet produce different IDs.
+package sqids
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrInvalidID is returned when decoding a string this encoder did not produce
+var ErrInvalidID = errors.New("invalid id")
+
+// Encoder converts between numbers and IDs for one alphabet, salt and minimum
+// length. It is safe for concurrent use.
+type Encoder struct {
+	alphabet  []rune
+	minLength int
+}
+
+// New creates an encoder. The alphabet needs at least 3 distinct characters
+// and IDs are padded to minLength.
+func New(alphabet, salt string, minLength int) (*Encoder, error) {
+	chars := []rune(alphabet)
+	if len(chars) < 3 {
+		return nil, fmt.Errorf("sqids alphabet must contain at least 3 characters")
+	}
+	seen := make(map[rune]bool, len(chars))
+	for _, char := range chars {
+		if seen[char] {
+			return nil, fmt.Errorf("sqids alphabet contains duplicate character %q", char)
+		}
+		seen[char] = true
+	}
+	if minLength < 0 {
+		return nil, fmt.Errorf("sqids minimum length must not be negative")
+	}
+
+	if salt != "" {
+		saltShuffle(chars, []rune(salt))
+	}
+	shuffle(chars)
+
+	return &Encoder{alphabet: chars, minLength: minLength}, nil
+}
+
+// Encode returns the ID for n
+func (e *Encoder) Encode(n uint64) string {
+	size := len(e.alphabet)
+
+	// The number picks the rotation, so consecutive IDs look unrelated
+	offset := (int(e.alphabet[n%uint64(size)]) + 1) % size
+
+	alphabet := make([]rune, 0, size)
+	alphabet = append(alphabet, e.alphabet[offset:]...)
+	alphabet = append(alphabet, e.alphabet[:offset]...)
+	prefix := alphabet[0]
+	reverse(alphabet)
+
+	var id strings.Builder
+	id.WriteRune(prefix)
+	id.WriteString(toID(n, alphabet[1:]))
+
+	length := len([]rune(id.String()))
+	if length < e.minLength {
+		// The separator marks where the number ends and padding begins
+		id.WriteRune(alphabet[0])
+		length++
+		for length < e.minLength {
+			shuffle(alphabet)
+			take := min(e.minLength-length, size)
+			id.WriteString(string(alphabet[:take]))
+			length += take
+		}
+	}
+
+	return id.String()
+}
+
+// Decode returns the number an ID encodes. IDs that are not exactly what
+// Encode produces, including differently padded ones, are rejected.
+func (e *Encoder) Decode(id string) (uint64, error) {
+	chars := []rune(id)
+	if len(chars) < 2 {
+		return 0, ErrInvalidID
+	}
+
+	offset := indexRune(e.alphabet, chars[0])
+	if offset < 0 {
+		return 0, ErrInvalidID
+	}
+
+	alphabet := make([]rune, 0, len(e.alphabet))
+	alphabet = append(alphabet, e.alphabet[offset:]...)
+	alphabet = append(alphabet, e.alphabet[:offset]...)
+	reverse(alphabet)
+
+	body := chars[1:]
+	if end := indexRune(body, alphabet[0]); end >= 0 {
+		body = body[:end]
+	}
+
+	n, ok := toNumber(body, alphabet[1:])
+	if !ok || e.Encode(n) != id {
+		return 0, ErrInvalidID
+	}
+	return n, nil
+}
+
+// toID writes n in the base of the alphabet, most significant digit first
+func toID(n uint64, alphabet []rune) string {
+	base := uint64(len(alphabet))
+	var digits []rune
+	for {
+		digits = append([]rune{alphabet[n%base]}, digits...)
+		n /= base
+		if n == 0 {
+			return string(digits)
+		}
+	}
+}
+
+// toNumber reverses toID, reporting false for characters outside the
+// alphabet and values that overflow
+func toNumber(id []rune, alphabet []rune) (uint64, bool) {
+	if len(id) == 0 {
+		return 0, false
+	}
+
+	base := uint64(len(alphabet))
+	var n uint64
+	for _, char := range id {
+		digit := indexRune(alphabet, char)
+		if digit < 0 || n > (math.MaxUint64-uint64(digit))/base {
+			return 0, false
+		}
+		n = n*base + uint64(digit)
+	}
+	return n, true
+}
+
+// shuffle is the deterministic Sqids shuffle
+func shuffle(chars []rune) {
+	size := len(chars)
+	for i, j := 0, size-1; j > 0; i, j = i+1, j-1 {
+		r := (i*j + int(chars[i]) + int(chars[j])) % size
+		chars[i], chars[r] = chars[r], chars[i]
+	}
+}
+
+// saltShuffle is the Hashids consistent shuffle, keyed by the salt
+func saltShuffle(chars, salt []rune) {
+	for i, v, p := len(chars)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		p += int(salt[v])
+		j := (int(salt[v]) + v + p) % i
+		chars[i], chars[j] = chars[j], chars[i]
+		v++
+	}
+}
+
+func reverse(chars []rune) {
+	for i, j := 0, len(chars)-1; i < j; i, j = i+1, j-1 {
+		chars[i], chars[j] = chars[j], chars[i]
+	}
+}
+
+func indexRune(chars []rune, r rune) int {
+	for i, char := range chars {
+		if char == r {
+			return i
+		}
+	}
+	return -1
+}
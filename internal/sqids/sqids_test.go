@@ -0,0 +1,96 @@
+package sqids
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func TestRoundTrip(t *testing.T) {
+	encoder, err := New(alphabet, "prefeitura", 6)
+	require.NoError(t, err)
+
+	for _, n := range []uint64{0, 1, 2, 61, 62, 1000, 123456789, math.MaxUint64} {
+		id := encoder.Encode(n)
+		assert.GreaterOrEqual(t, len(id), 6, "id %q should be padded", id)
+
+		decoded, err := encoder.Decode(id)
+		require.NoError(t, err, "decoding %q", id)
+		assert.Equal(t, n, decoded)
+	}
+}
+
+func TestUniqueness(t *testing.T) {
+	encoder, err := New(alphabet, "", 4)
+	require.NoError(t, err)
+
+	seen := make(map[string]uint64)
+	for n := uint64(0); n < 100000; n++ {
+		id := encoder.Encode(n)
+		if previous, ok := seen[id]; ok {
+			t.Fatalf("%d and %d both encode to %q", previous, n, id)
+		}
+		seen[id] = n
+	}
+}
+
+func TestSalt(t *testing.T) {
+	plain, err := New(alphabet, "", 6)
+	require.NoError(t, err)
+	salted, err := New(alphabet, "prefeitura", 6)
+	require.NoError(t, err)
+	other, err := New(alphabet, "rio", 6)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, plain.Encode(42), salted.Encode(42))
+	assert.NotEqual(t, salted.Encode(42), other.Encode(42))
+
+	// Same settings encode deterministically
+	again, err := New(alphabet, "prefeitura", 6)
+	require.NoError(t, err)
+	assert.Equal(t, salted.Encode(42), again.Encode(42))
+}
+
+func TestCustomAlphabet(t *testing.T) {
+	unambiguous := "abcdefghjkmnpqrstuvwxyz23456789"
+	encoder, err := New(unambiguous, "salt", 5)
+	require.NoError(t, err)
+
+	for n := uint64(0); n < 1000; n++ {
+		id := encoder.Encode(n)
+		for _, char := range id {
+			assert.Contains(t, unambiguous, string(char))
+		}
+		decoded, err := encoder.Decode(id)
+		require.NoError(t, err)
+		assert.Equal(t, n, decoded)
+	}
+}
+
+func TestDecodeRejectsForeignIDs(t *testing.T) {
+	encoder, err := New(alphabet, "salt", 6)
+	require.NoError(t, err)
+
+	for _, id := range []string{"", "a", "abc-de", "zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz"} {
+		_, err := encoder.Decode(id)
+		assert.ErrorIs(t, err, ErrInvalidID, id)
+	}
+
+	// An ID that decodes to a number but is not its canonical encoding
+	id := encoder.Encode(7)
+	_, err = encoder.Decode(id + "a")
+	assert.ErrorIs(t, err, ErrInvalidID)
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := New("ab", "", 0)
+	assert.Error(t, err)
+	_, err = New("abca", "", 0)
+	assert.Error(t, err)
+	_, err = New(alphabet, "", -1)
+	assert.Error(t, err)
+}
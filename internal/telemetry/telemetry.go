@@ -2,9 +2,14 @@ package telemetry
 
 import (
 	"context"
+	"net/url"
 	"time"
 
+	"url_shortener/internal/config"
+
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
@@ -12,19 +17,25 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-func InitTracer(exporterURL string) (*sdktrace.TracerProvider, error) {
-	if exporterURL == "" {
+// InitTracer installs the global tracer provider. Traces are exported over OTLP
+// gRPC (TLS unless cfg.OTELInsecure is set) and sampled at cfg.OTELSampleRatio,
+// following the parent's decision for propagated traces.
+func InitTracer(cfg *config.Config) (*sdktrace.TracerProvider, error) {
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.OTELSampleRatio))
+
+	if cfg.OTELExporterURL == "" {
 		// Return a no-op tracer if no exporter URL is provided
-		return sdktrace.NewTracerProvider(), nil
+		return sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler)), nil
 	}
 
 	ctx := context.Background()
 
 	// Create OTLP gRPC exporter
-	exporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(exporterURL),
-		otlptracegrpc.WithInsecure(),
-	)
+	exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.OTELExporterURL)}
+	if cfg.OTELInsecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -32,8 +43,8 @@ func InitTracer(exporterURL string) (*sdktrace.TracerProvider, error) {
 	// Create resource with service information
 	res, err := resource.New(ctx,
 		resource.WithAttributes(
-			semconv.ServiceName("url-shortener"),
-			semconv.ServiceVersion("1.0.0"),
+			semconv.ServiceName(cfg.OTELServiceName),
+			semconv.ServiceVersion(cfg.OTELServiceVersion),
 		),
 	)
 	if err != nil {
@@ -46,6 +57,7 @@ func InitTracer(exporterURL string) (*sdktrace.TracerProvider, error) {
 			sdktrace.WithBatchTimeout(5*time.Second),
 		),
 		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
 	)
 
 	// Set global tracer provider
@@ -59,7 +71,45 @@ func GetTracer(name string) trace.Tracer {
 	return otel.Tracer(name)
 }
 
-// StartSpan starts a new span with the given name and options
+// StartSpan starts a new span with the given name and options, tagged with the
+// request ID when ctx carries one
 func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		opts = append(opts, trace.WithAttributes(attribute.String("request.id", id)))
+	}
 	return otel.Tracer("url-shortener").Start(ctx, name, opts...)
-} 
\ No newline at end of file
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns ctx carrying the request correlation ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request correlation ID, or "" when unset
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithBaggage returns ctx with the attributes added as baggage members so they
+// propagate to downstream services. Empty values and members that are not valid
+// W3C baggage are skipped.
+func WithBaggage(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	bag := baggage.FromContext(ctx)
+	for _, attr := range attrs {
+		value := attr.Value.Emit()
+		if value == "" {
+			continue
+		}
+		member, err := baggage.NewMember(string(attr.Key), url.PathEscape(value))
+		if err != nil {
+			continue
+		}
+		if updated, err := bag.SetMember(member); err == nil {
+			bag = updated
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
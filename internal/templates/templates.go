@@ -0,0 +1,10 @@
+// Package templates embeds the default HTML pages, so the binary can render
+// them without the template files on disk.
+package templates
+
+import "embed"
+
+// FS holds the default page templates by file name
+//
+//go:embed *.html
+var FS embed.FS
@@ -0,0 +1,159 @@
+// Package webhook delivers JSON events to an HTTP endpoint in the background,
+// so the request that produced an event never waits on the receiver.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body, keyed by the
+// shared secret and prefixed with "sha256="
+const SignatureHeader = "X-Webhook-Signature"
+
+// Delivery defaults
+const (
+	DefaultAttempts = 3
+	DefaultBackoff  = 500 * time.Millisecond
+	DefaultTimeout  = 5 * time.Second
+)
+
+// Dispatcher posts events to one URL from a fixed pool of workers. Events
+// are queued and dropped when the queue is full; failed deliveries are
+// retried with exponential backoff. It is safe for concurrent use.
+type Dispatcher struct {
+	url    string
+	secret []byte
+	client *http.Client
+	queue  chan []byte
+	wg     sync.WaitGroup
+
+	// attempts and backoff control retries; backoff doubles after each failure
+	attempts int
+	backoff  time.Duration
+}
+
+// New starts a dispatcher with workers goroutines and room for queueSize
+// pending events. An empty secret sends events unsigned.
+func New(url, secret string, workers, queueSize int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	d := &Dispatcher{
+		url:      url,
+		secret:   []byte(secret),
+		client:   &http.Client{Timeout: DefaultTimeout},
+		queue:    make(chan []byte, queueSize),
+		attempts: DefaultAttempts,
+		backoff:  DefaultBackoff,
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Send queues event for delivery without blocking. It reports false when the
+// event could not be encoded or the queue is full and the event was dropped.
+func (d *Dispatcher) Send(event any) bool {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return false
+	}
+
+	select {
+	case d.queue <- body:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting events and waits for queued ones to be delivered
+// or for ctx to end. Send must not be called after Close.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	close(d.queue)
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for body := range d.queue {
+		d.deliver(body)
+	}
+}
+
+// deliver posts body until the receiver accepts it or the attempts run out.
+// Client errors other than 429 are not retried, since resending won't help.
+func (d *Dispatcher) deliver(body []byte) {
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= d.attempts {
+			log.Printf("Webhook delivery failed after %d attempts: %v", attempt, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt, reporting whether a failure is worth retrying
+func (d *Dispatcher) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(d.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(d.secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook answered %d", resp.StatusCode)
+}
+
+// Sign returns the signature header value of body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
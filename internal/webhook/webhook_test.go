@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type event struct {
+	ShortPath string `json:"short_path"`
+}
+
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, d.Close(ctx))
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("SignedDelivery", func(t *testing.T) {
+		received := make(chan *http.Request, 1)
+		bodies := make(chan string, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- r
+			bodies <- string(body)
+		}))
+		defer server.Close()
+
+		d := New(server.URL, "secret", 1, 10)
+		assert.True(t, d.Send(event{ShortPath: "promo"}))
+		closeDispatcher(t, d)
+
+		r := <-received
+		body := <-bodies
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"short_path":"promo"}`, body)
+		assert.Equal(t, Sign([]byte("secret"), []byte(body)), r.Header.Get(SignatureHeader))
+	})
+
+	t.Run("UnsignedWithoutSecret", func(t *testing.T) {
+		signatures := make(chan string, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			signatures <- r.Header.Get(SignatureHeader)
+		}))
+		defer server.Close()
+
+		d := New(server.URL, "", 1, 10)
+		d.Send(event{ShortPath: "promo"})
+		closeDispatcher(t, d)
+
+		assert.Empty(t, <-signatures)
+	})
+
+	t.Run("RetriesServerErrors", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		d := New(server.URL, "", 1, 10)
+		d.backoff = time.Millisecond
+		d.Send(event{ShortPath: "promo"})
+		closeDispatcher(t, d)
+
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("GivesUpAfterAttempts", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		d := New(server.URL, "", 1, 10)
+		d.backoff = time.Millisecond
+		d.Send(event{ShortPath: "promo"})
+		closeDispatcher(t, d)
+
+		assert.Equal(t, int32(DefaultAttempts), calls.Load())
+	})
+
+	t.Run("ClientErrorsNotRetried", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		d := New(server.URL, "", 1, 10)
+		d.backoff = time.Millisecond
+		d.Send(event{ShortPath: "promo"})
+		closeDispatcher(t, d)
+
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("DropsWhenQueueFull", func(t *testing.T) {
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entered <- struct{}{}
+			<-release
+		}))
+		defer server.Close()
+
+		d := New(server.URL, "", 1, 1)
+		require.True(t, d.Send(event{ShortPath: "first"}))
+		<-entered // the worker is busy with the first event
+
+		assert.True(t, d.Send(event{ShortPath: "queued"}))
+		assert.False(t, d.Send(event{ShortPath: "dropped"}))
+
+		close(release)
+		go func() { <-entered }()
+		closeDispatcher(t, d)
+	})
+}
@@ -23,26 +23,40 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"time"
 
+	"url_shortener/internal/clientip"
 	"url_shortener/internal/config"
 	"url_shortener/internal/database"
 	"url_shortener/internal/handlers"
+	"url_shortener/internal/jsoncase"
+	"url_shortener/internal/l1cache"
+	"url_shortener/internal/linkcheck"
+	"url_shortener/internal/loadshed"
+	"url_shortener/internal/logging"
+	"url_shortener/internal/middleware"
+	"url_shortener/internal/ratelimit"
 	"url_shortener/internal/redis"
 	"url_shortener/internal/telemetry"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
-	_ "url_shortener/docs"
+	"url_shortener/docs"
 )
 
 func main() {
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.LoadAndValidate()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
 
 	// Initialize telemetry
-	tp, err := telemetry.InitTracer(cfg.OTELExporterURL)
+	tp, err := telemetry.InitTracer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize tracer: %v", err)
 	}
@@ -53,18 +67,54 @@ func main() {
 	}()
 
 	// Initialize database
-	db, err := database.Init(cfg.DatabaseURL)
+	db, err := database.Init(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
-	// Initialize Redis
-	redisClient, err := redis.Init(cfg.RedisURL, cfg.RedisCacheTTL)
+	// Start background purge of expired URLs
+	if cfg.ExpirySweepInterval > 0 {
+		go runExpirySweep(db, cfg.ExpirySweepInterval, cfg.ExpirySweepBatchSize, cfg.ExpirySweepBatchPause)
+	}
+
+	// Start background destination health checks
+	if cfg.DestinationCheckInterval > 0 {
+		go runDestinationChecks(db, cfg.DestinationCheckInterval, cfg.DestinationCheckBatchSize)
+	}
+
+	// Initialize Redis, falling back to running DB-only unless it is required
+	var cache handlers.Cache
+	var rateLimitIncrement ratelimit.IncrementFunc
+	redisClient, err := redis.Init(redis.Connection{
+		Mode:             cfg.RedisMode,
+		URL:              cfg.RedisURL,
+		Addrs:            cfg.RedisAddrs,
+		MasterName:       cfg.RedisMasterName,
+		Password:         cfg.RedisPassword,
+		SentinelPassword: cfg.RedisSentinelPassword,
+	}, cfg.RedisKeyPrefix, cfg.RedisCacheTTL, cfg.NegativeCacheTTL, cfg.QRCacheTTL)
 	if err != nil {
-		log.Fatalf("Failed to initialize Redis: %v", err)
+		if cfg.RedisRequired {
+			log.Fatalf("Failed to initialize Redis: %v", err)
+		}
+		log.Printf("Warning: Redis unavailable, running without cache: %v", err)
+		cache = redis.NoopCache{}
+		if cfg.RateLimit > 0 {
+			log.Printf("Warning: rate limiting needs Redis and is disabled")
+		}
+	} else {
+		defer redisClient.Close()
+		cache = redisClient
+		rateLimitIncrement = redisClient.IncrementRateLimit
+	}
+	if cfg.L1CacheSize > 0 {
+		cache = l1cache.NewTiered(cache, cfg.L1CacheSize, cfg.L1CacheTTL)
+	}
+
+	if cfg.PasswordCookieSecret == "" {
+		log.Printf("Warning: PASSWORD_COOKIE_SECRET is not set; password unlocks use a random key and do not survive restarts or carry across replicas")
 	}
-	defer redisClient.Close()
 
 	// Set Gin mode
 	if os.Getenv("GIN_MODE") == "" {
@@ -73,41 +123,145 @@ func main() {
 
 	// Initialize router
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	if err := clientip.Configure(router, cfg.TrustedProxies); err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	logger := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	slog.SetDefault(logger)
+	router.Use(logging.Middleware(logger), gin.Recovery())
+	router.Use(middleware.CORS(cfg.CORSOrigins, cfg.CORSAllowCredentials))
+	router.Use(loadshed.Middleware(cfg.MaxConcurrentRequests, cfg.APIPrefix+"/health", cfg.HealthPath))
+	router.Use(ratelimit.Middleware(rateLimitIncrement, cfg.RateLimit, cfg.RateLimitWindow, cfg.APIPrefix+"/health", cfg.HealthPath))
+	router.Use(middleware.BodyLimit(int64(cfg.MaxBodyBytes), map[string]int64{
+		cfg.APIPrefix + "/qr":          int64(cfg.MaxQRBodyBytes),
+		cfg.APIPrefix + "/urls/import": int64(cfg.MaxImportBodyBytes),
+	}))
+	router.Use(jsoncase.Middleware(cfg.JSONCase))
 
 	// Initialize handlers
-	h := handlers.New(db, redisClient, cfg)
+	h := handlers.New(db, cache, cfg)
 
-	// Setup routes
-	setupRoutes(router, h)
+	// Setup routes, keeping the generated swagger base path in sync with the prefix
+	docs.SwaggerInfo.BasePath = cfg.APIPrefix
+	setupRoutes(router, h, cfg.APIPrefix, cfg.HealthPath, cfg.SlowWriteTimeout)
 
-	// Start server
+	// Start server, with timeouts so slow or stalled clients cannot hold connections
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      router,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
 	log.Printf("Starting server on port %s", cfg.Port)
-	if err := router.Run(":" + cfg.Port); err != nil {
+	if err := server.ListenAndServe(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
-func setupRoutes(router *gin.Engine, h *handlers.Handler) {
+// runExpirySweep periodically deletes expired URLs in bounded batches, pausing
+// between them
+func runExpirySweep(db *database.DB, interval time.Duration, batchSize int, pause time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := db.DeleteExpiredURLs(context.Background(), batchSize, pause)
+		if err != nil {
+			log.Printf("Error purging expired URLs: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Purged %d expired URLs", deleted)
+		}
+	}
+}
+
+// runDestinationChecks periodically probes destinations in bounded batches,
+// each at most once per interval, and records the status they answer
+func runDestinationChecks(db *database.DB, interval time.Duration, batchSize int) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		urls, err := db.URLsDueForCheck(ctx, time.Now().Add(-interval), batchSize)
+		if err != nil {
+			log.Printf("Error listing URLs to check: %v", err)
+			continue
+		}
+
+		broken := 0
+		for _, url := range urls {
+			status := linkcheck.Status(ctx, client, url.Destination)
+			if linkcheck.Broken(status) {
+				broken++
+			}
+			if err := db.RecordDestinationCheck(ctx, url.ID, status, time.Now()); err != nil {
+				log.Printf("Error recording destination check for %s: %v", url.ShortPath, err)
+			}
+		}
+		if broken > 0 {
+			log.Printf("Checked %d destinations, %d broken", len(urls), broken)
+		}
+	}
+}
+
+func setupRoutes(router *gin.Engine, h *handlers.Handler, apiPrefix, healthPath string, slowWriteTimeout time.Duration) {
+	// Endpoints that may take longer than WRITE_TIMEOUT to respond
+	slow := middleware.WriteTimeout(slowWriteTimeout)
+
 	// Swagger documentation
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Top-level health alias for probes that don't know the API prefix
+	if healthPath != "" && healthPath != apiPrefix+"/health" {
+		router.GET(healthPath, h.HealthCheck)
+	}
+
 	// API routes
-	api := router.Group("/api")
+	api := router.Group(apiPrefix)
 	{
 		api.GET("/health", h.HealthCheck)
+
+		// Raw spec for client generators, alongside the Swagger UI
+		api.GET("/openapi.json", h.OpenAPIJSON)
+		api.GET("/openapi.yaml", h.OpenAPIYAML)
+
 		api.POST("/urls", h.CreateURL)
+		api.POST("/urls/reserve", h.ReserveURL)
 		api.GET("/urls", h.ListURLs)
+		api.GET("/urls/broken", h.BrokenURLs)
+		api.GET("/urls/export", slow, h.ExportURLs)
+		api.POST("/urls/import", slow, h.ImportURLs)
+		api.POST("/urls/resolve-batch", h.ResolveBatch)
+		api.POST("/urls/batch-get", h.BatchGetURLs)
 		api.GET("/urls/:id", h.GetURL)
 		api.PUT("/urls/:id", h.UpdateURL)
 		api.PATCH("/urls/:id", h.PatchURL)
 		api.DELETE("/urls/:id", h.DeleteURL)
+		api.POST("/urls/:id/restore", h.RestoreURL)
+		api.GET("/urls/:id/clicks/timeseries", h.ClickTimeSeries)
+		api.GET("/urls/:id/stats", h.ClickStats)
 
 		// QR code generation endpoints
-		api.POST("/qr", h.GenerateQRCodePOST)
-		api.GET("/qr", h.GenerateQRCodeGET)
+		api.POST("/qr", slow, h.GenerateQRCodePOST)
+		api.GET("/qr", slow, h.GenerateQRCodeGET)
+
+		// Operator diagnostics
+		api.GET("/debug/shortpath-capacity", h.ShortPathCapacity)
+		api.GET("/debug/cache-stats", h.CacheStats)
+
+		// Admin endpoints, guarded by ADMIN_TOKEN
+		admin := api.Group("/admin", h.RequireAdmin)
+		admin.POST("/templates/reload", h.ReloadTemplates)
+		admin.POST("/cache/flush", h.FlushCache)
+		admin.DELETE("/cache/:shortPath", h.InvalidateCachedURL)
 	}
 
 	// Redirect route (must be last to avoid conflicts with API routes)
 	router.GET("/:shortPath", h.Redirect)
+	router.HEAD("/:shortPath", h.Redirect)
+	router.POST("/:shortPath", h.UnlockURL)
 } 
\ No newline at end of file
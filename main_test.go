@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"url_shortener/internal/config"
+	"url_shortener/internal/database"
+	"url_shortener/internal/handlers"
+	"url_shortener/internal/redis"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupRoutesWithPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	routes := func(prefix string) map[string]bool {
+		router := gin.New()
+		h := handlers.NewWithTemplate(nil, nil, &config.Config{APIPrefix: prefix}, nil)
+		setupRoutes(router, h, prefix, "/health", 0)
+
+		registered := make(map[string]bool)
+		for _, r := range router.Routes() {
+			registered[r.Method+" "+r.Path] = true
+		}
+		return registered
+	}
+
+	t.Run("DefaultPrefix", func(t *testing.T) {
+		registered := routes("/api")
+		assert.True(t, registered["GET /api/health"])
+		assert.True(t, registered["POST /api/urls"])
+		assert.True(t, registered["GET /api/openapi.json"])
+		assert.True(t, registered["GET /api/openapi.yaml"])
+		assert.True(t, registered["GET /:shortPath"])
+		assert.True(t, registered["HEAD /:shortPath"])
+	})
+
+	t.Run("CustomPrefix", func(t *testing.T) {
+		registered := routes("/link/api")
+		assert.True(t, registered["GET /link/api/health"])
+		assert.True(t, registered["POST /link/api/urls"])
+		assert.True(t, registered["GET /link/api/qr"])
+		assert.False(t, registered["POST /api/urls"])
+		assert.True(t, registered["GET /:shortPath"])
+	})
+}
+
+func TestHealthPathAlias(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.InitSQLiteDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	router := gin.New()
+	cfg := &config.Config{APIPrefix: "/api", HealthPath: "/health"}
+	h := handlers.NewWithTemplate(db, redis.NoopCache{}, cfg, nil)
+	setupRoutes(router, h, cfg.APIPrefix, cfg.HealthPath, cfg.SlowWriteTimeout)
+
+	for _, path := range []string{"/health", "/api/health"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		var body handlers.HealthResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), path)
+		assert.Contains(t, []string{"healthy", "degraded"}, body.Status, path)
+	}
+
+	t.Run("SameAsAPIHealthIsNotDuplicated", func(t *testing.T) {
+		router := gin.New()
+		assert.NotPanics(t, func() { setupRoutes(router, h, "", "/health", 0) })
+	})
+}